@@ -0,0 +1,75 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"nextya-sync/models"
+)
+
+// Backend storage operations required for synchronization
+type Backend interface {
+	ListFiles(ctx context.Context, folderPath string) ([]models.FileInfo, error)
+	DownloadFile(ctx context.Context, path string) (io.ReadCloser, error)
+	UploadFile(ctx context.Context, path string, content io.Reader, size int64) error
+	CreateFolder(ctx context.Context, path string) error
+	GetFileInfo(ctx context.Context, path string) (*models.FileInfo, error)
+}
+
+// Authenticator is implemented by backends able to check credentials before use
+type Authenticator interface {
+	Authenticate(ctx context.Context) error
+}
+
+// Deleter is implemented by backends supporting deletion
+type Deleter interface {
+	Delete(ctx context.Context, path string, permanently bool) error
+}
+
+// Mover is implemented by backends supporting server-side move
+type Mover interface {
+	Move(ctx context.Context, from, to string) error
+}
+
+// Copier is implemented by backends supporting server-side copy
+type Copier interface {
+	Copy(ctx context.Context, from, to string) error
+}
+
+// Hasher is implemented by backends reporting content hashes of files
+type Hasher interface {
+	// Hash returns hex-encoded hash of file content and name of the algorithm, e.g. "md5"
+	Hash(ctx context.Context, path string) (string, string, error)
+}
+
+// RangeReader is implemented by backends able to download part of file
+type RangeReader interface {
+	DownloadRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error)
+}
+
+// Quota is implemented by backends reporting storage space
+type Quota interface {
+	GetQuota(ctx context.Context) (*models.Quota, error)
+}
+
+// PropertySetter is implemented by backends supporting custom properties on resources
+type PropertySetter interface {
+	SetProperties(ctx context.Context, path string, props map[string]string) error
+}
+
+// ModTimeSetter is implemented by backends able to set modification time of uploaded files
+type ModTimeSetter interface {
+	SetModTime(ctx context.Context, path string, modTime time.Time) error
+}
+
+// EscapedPaths is implemented by backends returning URL-escaped paths in listings
+type EscapedPaths interface {
+	EscapedPaths() bool
+}
+
+// IsEscaped reports whether backend returns URL-escaped paths
+func IsEscaped(b Backend) bool {
+	e, ok := b.(EscapedPaths)
+	return ok && e.EscapedPaths()
+}
@@ -0,0 +1,420 @@
+// Package backendtest provides conformance tests every storage backend must pass.
+package backendtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/models"
+)
+
+// specialNames file names exercising path encoding
+var specialNames = []string{
+	"with space.txt",
+	"unicode-файл-文件.txt",
+	"percent%20sign.txt",
+	"hash#and?query.txt",
+	"plus+sign.txt",
+	"ampersand&semi;colon.txt",
+	"quote'and\"double.txt",
+	"brackets[1](2){3}.txt",
+}
+
+// Run runs conformance tests against backend.
+// Root is existing empty folder in the backend's own path format, e.g. URL-escaped for WebDAV.
+func Run(t *testing.T, b backend.Backend, root string) {
+	s := suite{b: b, root: root, escaped: backend.IsEscaped(b)}
+
+	t.Run("EmptyFolder", s.testEmptyFolder)
+	t.Run("Upload", s.testUpload)
+	t.Run("UploadUnknownSize", s.testUploadUnknownSize)
+	t.Run("Overwrite", s.testOverwrite)
+	t.Run("CreateFolder", s.testCreateFolder)
+	t.Run("Listing", s.testListing)
+	t.Run("PathEncoding", s.testPathEncoding)
+	t.Run("NotFound", s.testNotFound)
+	t.Run("ModTime", s.testModTime)
+	t.Run("Hash", s.testHash)
+	t.Run("Delete", s.testDelete)
+	t.Run("Move", s.testMove)
+}
+
+// suite conformance tests of single backend
+type suite struct {
+	b       backend.Backend
+	root    string
+	escaped bool
+}
+
+// join joins folder path and name, escaping name if backend uses escaped paths
+func (s suite) join(folder, name string) string {
+	if s.escaped {
+		name = url.PathEscape(name)
+	}
+	return strings.TrimSuffix(folder, "/") + "/" + name
+}
+
+// name returns decoded last element of path
+func (s suite) name(t *testing.T, filePath string) string {
+	name := path.Base(filePath)
+	if !s.escaped {
+		return name
+	}
+	decoded, err := url.PathUnescape(name)
+	if err != nil {
+		t.Fatalf("path %s is not properly escaped: %v", filePath, err)
+	}
+	return decoded
+}
+
+// folder creates folder for single test
+func (s suite) folder(t *testing.T, name string) string {
+	t.Helper()
+	folder := s.join(s.root, name)
+	if err := s.b.CreateFolder(context.Background(), folder); err != nil {
+		t.Fatalf("CreateFolder(%s): %v", folder, err)
+	}
+	return folder
+}
+
+// upload uploads content to path
+func (s suite) upload(t *testing.T, filePath string, content []byte) {
+	t.Helper()
+	if err := s.b.UploadFile(context.Background(), filePath, bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatalf("UploadFile(%s): %v", filePath, err)
+	}
+}
+
+// download returns content of file
+func (s suite) download(t *testing.T, filePath string) []byte {
+	t.Helper()
+	reader, err := s.b.DownloadFile(context.Background(), filePath)
+	if err != nil {
+		t.Fatalf("DownloadFile(%s): %v", filePath, err)
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading %s: %v", filePath, err)
+	}
+	return content
+}
+
+// list returns listing of folder by decoded names
+func (s suite) list(t *testing.T, folder string) map[string]models.FileInfo {
+	t.Helper()
+	files, err := s.b.ListFiles(context.Background(), folder)
+	if err != nil {
+		t.Fatalf("ListFiles(%s): %v", folder, err)
+	}
+
+	byName := make(map[string]models.FileInfo)
+	for _, file := range files {
+		name := s.name(t, file.Path)
+		if _, ok := byName[name]; ok {
+			t.Errorf("ListFiles(%s) returned %s twice", folder, name)
+		}
+		byName[name] = file
+	}
+	return byName
+}
+
+// names returns sorted names of listing
+func names(files map[string]models.FileInfo) []string {
+	var result []string
+	for name := range files {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// requireNotFound checks that error reports missing path
+func requireNotFound(t *testing.T, call string, err error) {
+	t.Helper()
+	if err == nil {
+		t.Errorf("%s succeeded for missing path", call)
+	} else if !errors.Is(err, backend.ErrNotFound) {
+		t.Errorf("%s error doesn't match backend.ErrNotFound: %v", call, err)
+	}
+}
+
+func (s suite) testEmptyFolder(t *testing.T) {
+	folder := s.folder(t, "empty")
+	if files := s.list(t, folder); len(files) != 0 {
+		t.Errorf("new folder is not empty: %v", names(files))
+	}
+}
+
+func (s suite) testUpload(t *testing.T) {
+	folder := s.folder(t, "upload")
+	filePath := s.join(folder, "file.txt")
+	s.upload(t, filePath, []byte("hello world"))
+
+	info, err := s.b.GetFileInfo(context.Background(), filePath)
+	if err != nil {
+		t.Fatalf("GetFileInfo: %v", err)
+	}
+	if info.IsDir || info.Size != 11 || s.name(t, info.Path) != "file.txt" {
+		t.Errorf("GetFileInfo = dir %v, size %d, name %s; want file of 11 bytes named file.txt",
+			info.IsDir, info.Size, s.name(t, info.Path))
+	}
+	if got := s.download(t, filePath); string(got) != "hello world" {
+		t.Errorf("downloaded %q, want %q", got, "hello world")
+	}
+
+	emptyPath := s.join(folder, "empty.txt")
+	s.upload(t, emptyPath, nil)
+	if got := s.download(t, emptyPath); len(got) != 0 {
+		t.Errorf("downloaded %q from empty file", got)
+	}
+}
+
+func (s suite) testUploadUnknownSize(t *testing.T) {
+	folder := s.folder(t, "unknown-size")
+	filePath := s.join(folder, "stream.bin")
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+
+	// Hide Len method of bytes.Reader so size can't be sniffed
+	reader := struct{ io.Reader }{bytes.NewReader(content)}
+	if err := s.b.UploadFile(context.Background(), filePath, reader, -1); err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if got := s.download(t, filePath); !bytes.Equal(got, content) {
+		t.Errorf("downloaded %d bytes, want %d", len(got), len(content))
+	}
+}
+
+func (s suite) testOverwrite(t *testing.T) {
+	folder := s.folder(t, "overwrite")
+	filePath := s.join(folder, "file.txt")
+	s.upload(t, filePath, []byte("first version"))
+	s.upload(t, filePath, []byte("second"))
+
+	if got := s.download(t, filePath); string(got) != "second" {
+		t.Errorf("downloaded %q after overwrite, want %q", got, "second")
+	}
+	files := s.list(t, folder)
+	if len(files) != 1 || files["file.txt"].Size != 6 {
+		t.Errorf("listing after overwrite = %v, want single file.txt of 6 bytes", files)
+	}
+}
+
+func (s suite) testCreateFolder(t *testing.T) {
+	folder := s.folder(t, "create")
+	sub := s.join(folder, "sub")
+	if err := s.b.CreateFolder(context.Background(), sub); err != nil {
+		t.Fatalf("CreateFolder: %v", err)
+	}
+
+	info, err := s.b.GetFileInfo(context.Background(), sub)
+	if err != nil {
+		t.Fatalf("GetFileInfo: %v", err)
+	}
+	if !info.IsDir {
+		t.Errorf("created folder is reported as file")
+	}
+
+	files := s.list(t, folder)
+	if len(files) != 1 || !files["sub"].IsDir {
+		t.Errorf("listing = %v, want folder sub", names(files))
+	}
+	if files := s.list(t, sub); len(files) != 0 {
+		t.Errorf("created folder is not empty: %v", names(files))
+	}
+}
+
+func (s suite) testListing(t *testing.T) {
+	folder := s.folder(t, "listing")
+	s.upload(t, s.join(folder, "a.txt"), []byte("a"))
+	s.upload(t, s.join(folder, "b.txt"), []byte("bb"))
+	sub := s.join(folder, "sub")
+	if err := s.b.CreateFolder(context.Background(), sub); err != nil {
+		t.Fatalf("CreateFolder: %v", err)
+	}
+	s.upload(t, s.join(sub, "c.txt"), []byte("ccc"))
+
+	// Only direct children are listed
+	files := s.list(t, folder)
+	if got := strings.Join(names(files), ","); got != "a.txt,b.txt,sub" {
+		t.Fatalf("listing = %s, want a.txt,b.txt,sub", got)
+	}
+	if files["a.txt"].IsDir || files["a.txt"].Size != 1 || files["b.txt"].Size != 2 {
+		t.Errorf("wrong file details in listing: %+v, %+v", files["a.txt"], files["b.txt"])
+	}
+	if !files["sub"].IsDir {
+		t.Errorf("sub is reported as file")
+	}
+
+	// Paths from listing are accepted back by the backend
+	if got := s.download(t, files["b.txt"].Path); string(got) != "bb" {
+		t.Errorf("downloaded %q by listed path, want %q", got, "bb")
+	}
+	subFiles := s.list(t, files["sub"].Path)
+	if len(subFiles) != 1 || subFiles["c.txt"].Size != 3 {
+		t.Errorf("listing of sub by listed path = %v, want c.txt", names(subFiles))
+	}
+}
+
+func (s suite) testPathEncoding(t *testing.T) {
+	folder := s.folder(t, "encoding")
+	sub := s.join(folder, "folder with space & ü")
+	if err := s.b.CreateFolder(context.Background(), sub); err != nil {
+		t.Fatalf("CreateFolder: %v", err)
+	}
+	for _, name := range specialNames {
+		s.upload(t, s.join(sub, name), []byte(name))
+	}
+
+	if files := s.list(t, folder); len(files) != 1 || !files["folder with space & ü"].IsDir {
+		t.Errorf("listing = %q, want folder with special name", names(files))
+	}
+
+	files := s.list(t, sub)
+	want := append([]string(nil), specialNames...)
+	sort.Strings(want)
+	if got := names(files); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("listing = %q, want %q", got, want)
+	}
+	for name, file := range files {
+		if got := s.download(t, file.Path); string(got) != name {
+			t.Errorf("downloaded %q from %s, want %q", got, file.Path, name)
+		}
+	}
+}
+
+func (s suite) testNotFound(t *testing.T) {
+	ctx := context.Background()
+	folder := s.folder(t, "not-found")
+	missing := s.join(folder, "missing")
+
+	_, err := s.b.GetFileInfo(ctx, missing)
+	requireNotFound(t, "GetFileInfo", err)
+
+	reader, err := s.b.DownloadFile(ctx, missing)
+	if err == nil {
+		reader.Close()
+	}
+	requireNotFound(t, "DownloadFile", err)
+
+	_, err = s.b.ListFiles(ctx, missing)
+	requireNotFound(t, "ListFiles", err)
+}
+
+func (s suite) testModTime(t *testing.T) {
+	setter, ok := s.b.(backend.ModTimeSetter)
+	if !ok {
+		t.Skip("backend doesn't set modification time")
+	}
+
+	folder := s.folder(t, "modtime")
+	filePath := s.join(folder, "file.txt")
+	s.upload(t, filePath, []byte("content"))
+
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	if err := setter.SetModTime(context.Background(), filePath, modTime); err != nil {
+		t.Fatalf("SetModTime: %v", err)
+	}
+
+	info, err := s.b.GetFileInfo(context.Background(), filePath)
+	if err != nil {
+		t.Fatalf("GetFileInfo: %v", err)
+	}
+	if !info.ModTime.Truncate(time.Second).Equal(modTime) {
+		t.Errorf("GetFileInfo ModTime = %v, want %v", info.ModTime, modTime)
+	}
+	if listed := s.list(t, folder)["file.txt"]; !listed.ModTime.Truncate(time.Second).Equal(modTime) {
+		t.Errorf("listed ModTime = %v, want %v", listed.ModTime, modTime)
+	}
+}
+
+func (s suite) testHash(t *testing.T) {
+	hasher, ok := s.b.(backend.Hasher)
+	if !ok {
+		t.Skip("backend doesn't report hashes")
+	}
+
+	folder := s.folder(t, "hash")
+	filePath := s.join(folder, "file.txt")
+	content := []byte("hashed content")
+	s.upload(t, filePath, content)
+
+	sum, algo, err := hasher.Hash(context.Background(), filePath)
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if algo == "" || sum == "" {
+		t.Fatalf("Hash returned empty sum %q of algorithm %q", sum, algo)
+	}
+	if algo == "md5" {
+		want := md5.Sum(content)
+		if !strings.EqualFold(sum, hex.EncodeToString(want[:])) {
+			t.Errorf("Hash = %s, want %s", sum, hex.EncodeToString(want[:]))
+		}
+	}
+}
+
+func (s suite) testDelete(t *testing.T) {
+	deleter, ok := s.b.(backend.Deleter)
+	if !ok {
+		t.Skip("backend doesn't support deletion")
+	}
+
+	ctx := context.Background()
+	folder := s.folder(t, "delete")
+	filePath := s.join(folder, "file.txt")
+	s.upload(t, filePath, []byte("content"))
+	sub := s.join(folder, "sub")
+	if err := s.b.CreateFolder(ctx, sub); err != nil {
+		t.Fatalf("CreateFolder: %v", err)
+	}
+	s.upload(t, s.join(sub, "nested.txt"), []byte("nested"))
+
+	if err := deleter.Delete(ctx, filePath, true); err != nil {
+		t.Fatalf("Delete file: %v", err)
+	}
+	_, err := s.b.GetFileInfo(ctx, filePath)
+	requireNotFound(t, "GetFileInfo of deleted file", err)
+
+	if err := deleter.Delete(ctx, sub, true); err != nil {
+		t.Fatalf("Delete folder: %v", err)
+	}
+	if files := s.list(t, folder); len(files) != 0 {
+		t.Errorf("folder is not empty after deletion: %v", names(files))
+	}
+}
+
+func (s suite) testMove(t *testing.T) {
+	mover, ok := s.b.(backend.Mover)
+	if !ok {
+		t.Skip("backend doesn't support moving")
+	}
+
+	ctx := context.Background()
+	folder := s.folder(t, "move")
+	from := s.join(folder, "from.txt")
+	to := s.join(folder, "to.txt")
+	s.upload(t, from, []byte("moved"))
+	s.upload(t, to, []byte("overwritten"))
+
+	if err := mover.Move(ctx, from, to); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	_, err := s.b.GetFileInfo(ctx, from)
+	requireNotFound(t, "GetFileInfo of moved file", err)
+	if got := s.download(t, to); string(got) != "moved" {
+		t.Errorf("downloaded %q from destination, want %q", got, "moved")
+	}
+}
@@ -0,0 +1,27 @@
+package backend
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// Errors returned by backends, possibly wrapped, so callers can branch with errors.Is
+var (
+	// ErrNotFound path doesn't exist.
+	// It is fs.ErrNotExist so errors of the os package match it as well.
+	ErrNotFound = fs.ErrNotExist
+	// ErrForbidden access to path is denied. It is fs.ErrPermission for the same reason.
+	ErrForbidden = fs.ErrPermission
+	// ErrUnauthorized credentials are missing, invalid or expired
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrQuotaExceeded storage is full or file is too big
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrRateLimited too many requests, retrying later may succeed
+	ErrRateLimited = errors.New("rate limited")
+	// ErrConflict path already exists, its parent is missing or it is locked
+	ErrConflict = errors.New("conflict")
+	// ErrServer storage server failed to handle request
+	ErrServer = errors.New("server error")
+	// ErrNetwork storage is unreachable or connection broke
+	ErrNetwork = errors.New("network error")
+)
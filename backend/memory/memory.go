@@ -0,0 +1,483 @@
+// Package memory provides in-memory storage backend for tests.
+// Failures and latency can be injected per operation to exercise error handling.
+package memory
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/models"
+)
+
+// Op names backend operation for failure injection and call counting
+type Op string
+
+// Operations of the backend
+const (
+	OpList          Op = "list"
+	OpDownload      Op = "download"
+	OpUpload        Op = "upload"
+	OpCreateFolder  Op = "create_folder"
+	OpGetFileInfo   Op = "get_file_info"
+	OpDelete        Op = "delete"
+	OpMove          Op = "move"
+	OpCopy          Op = "copy"
+	OpHash          Op = "hash"
+	OpSetModTime    Op = "set_mod_time"
+	OpSetProperties Op = "set_properties"
+)
+
+// entry file or folder stored in memory
+type entry struct {
+	isDir   bool
+	data    []byte
+	modTime time.Time
+	props   map[string]string
+}
+
+// failure error injected into operation
+type failure struct {
+	op   Op
+	path string
+	err  error
+	// times how many calls fail, negative for all calls
+	times int
+}
+
+// Backend in-memory storage backend.
+// Paths are slash-separated and always treated as absolute, the root folder always exists.
+type Backend struct {
+	mu       sync.Mutex
+	entries  map[string]*entry
+	failures []*failure
+	calls    map[Op]int
+	latency  time.Duration
+	now      func() time.Time
+}
+
+// New creates empty in-memory backend
+func New() *Backend {
+	return &Backend{
+		entries: map[string]*entry{"/": {isDir: true}},
+		calls:   make(map[Op]int),
+		now:     time.Now,
+	}
+}
+
+// Fail makes all calls of operation on path fail with err, empty path matches any path
+func (b *Backend) Fail(op Op, filePath string, err error) {
+	b.FailTimes(op, filePath, err, -1)
+}
+
+// FailTimes makes next n calls of operation on path fail with err, empty path matches any path
+func (b *Backend) FailTimes(op Op, filePath string, err error, n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if filePath != "" {
+		filePath = clean(filePath)
+	}
+	b.failures = append(b.failures, &failure{op: op, path: filePath, err: err, times: n})
+}
+
+// ClearFailures removes all injected failures
+func (b *Backend) ClearFailures() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = nil
+}
+
+// SetLatency delays every operation by d
+func (b *Backend) SetLatency(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.latency = d
+}
+
+// SetClock sets function returning modification time of uploaded files
+func (b *Backend) SetClock(now func() time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.now = now
+}
+
+// Calls returns number of calls of operation, including failed ones
+func (b *Backend) Calls(op Op) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.calls[op]
+}
+
+// WriteFile stores file creating missing parent folders
+func (b *Backend) WriteFile(filePath string, data []byte, modTime time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	filePath = clean(filePath)
+	b.mkdirAll(path.Dir(filePath))
+	b.entries[filePath] = &entry{data: bytes.Clone(data), modTime: modTime}
+}
+
+// ReadFile returns content of file, false if it doesn't exist
+func (b *Backend) ReadFile(filePath string) ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.entries[clean(filePath)]
+	if !ok || e.isDir {
+		return nil, false
+	}
+	return bytes.Clone(e.data), true
+}
+
+// MkdirAll creates folder with all missing parents
+func (b *Backend) MkdirAll(folderPath string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.mkdirAll(clean(folderPath))
+}
+
+// Paths returns sorted paths of all files and folders except root
+func (b *Backend) Paths() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var paths []string
+	for p := range b.entries {
+		if p != "/" {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// mkdirAll creates folder chain, caller must hold the lock
+func (b *Backend) mkdirAll(folderPath string) {
+	for p := folderPath; ; p = path.Dir(p) {
+		if _, ok := b.entries[p]; ok {
+			return
+		}
+		b.entries[p] = &entry{isDir: true, modTime: b.now()}
+	}
+}
+
+// begin counts call, waits for latency and returns injected failure if any
+func (b *Backend) begin(ctx context.Context, op Op, filePath string) error {
+	b.mu.Lock()
+	b.calls[op]++
+	latency := b.latency
+	var err error
+	for _, f := range b.failures {
+		if f.op != op || f.times == 0 || (f.path != "" && f.path != filePath) {
+			continue
+		}
+		if f.times > 0 {
+			f.times--
+		}
+		err = f.err
+		break
+	}
+	b.mu.Unlock()
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// lookup returns existing entry, caller must hold the lock
+func (b *Backend) lookup(filePath string) (*entry, error) {
+	e, ok := b.entries[filePath]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", filePath, backend.ErrNotFound)
+	}
+	return e, nil
+}
+
+// parent checks that parent folder of path exists, caller must hold the lock
+func (b *Backend) parent(filePath string) error {
+	parent, err := b.lookup(path.Dir(filePath))
+	if err != nil {
+		return fmt.Errorf("parent folder doesn't exist: %w", err)
+	}
+	if !parent.isDir {
+		return fmt.Errorf("parent of %s is not a folder", filePath)
+	}
+	return nil
+}
+
+// ListFiles gets list of files in folder sorted by name
+func (b *Backend) ListFiles(ctx context.Context, folderPath string) ([]models.FileInfo, error) {
+	folderPath = clean(folderPath)
+	if err := b.begin(ctx, OpList, folderPath); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	folder, err := b.lookup(folderPath)
+	if err != nil {
+		return nil, err
+	}
+	if !folder.isDir {
+		return nil, fmt.Errorf("%s is not a folder", folderPath)
+	}
+
+	files := []models.FileInfo{}
+	for p, e := range b.entries {
+		if p != "/" && path.Dir(p) == folderPath {
+			files = append(files, fileInfo(p, e))
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// DownloadFile returns reader of file content
+func (b *Backend) DownloadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	filePath = clean(filePath)
+	if err := b.begin(ctx, OpDownload, filePath); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, err := b.lookup(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if e.isDir {
+		return nil, fmt.Errorf("%s is a folder", filePath)
+	}
+	return io.NopCloser(bytes.NewReader(e.data)), nil
+}
+
+// UploadFile stores file, existing file is overwritten and nothing is stored if reading fails
+func (b *Backend) UploadFile(ctx context.Context, filePath string, content io.Reader, size int64) error {
+	filePath = clean(filePath)
+	if err := b.begin(ctx, OpUpload, filePath); err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return fmt.Errorf("failed to read content: %w", err)
+	}
+	if size >= 0 && int64(len(data)) != size {
+		return fmt.Errorf("size mismatch: expected %d bytes, got %d", size, len(data))
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.parent(filePath); err != nil {
+		return err
+	}
+	if e, ok := b.entries[filePath]; ok && e.isDir {
+		return fmt.Errorf("%s is a folder", filePath)
+	}
+	b.entries[filePath] = &entry{data: data, modTime: b.now()}
+	return nil
+}
+
+// CreateFolder creates folder, parent folder must exist
+func (b *Backend) CreateFolder(ctx context.Context, folderPath string) error {
+	folderPath = clean(folderPath)
+	if err := b.begin(ctx, OpCreateFolder, folderPath); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.entries[folderPath]; ok {
+		return fmt.Errorf("%s already exists", folderPath)
+	}
+	if err := b.parent(folderPath); err != nil {
+		return err
+	}
+	b.entries[folderPath] = &entry{isDir: true, modTime: b.now()}
+	return nil
+}
+
+// GetFileInfo gets file information
+func (b *Backend) GetFileInfo(ctx context.Context, filePath string) (*models.FileInfo, error) {
+	filePath = clean(filePath)
+	if err := b.begin(ctx, OpGetFileInfo, filePath); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, err := b.lookup(filePath)
+	if err != nil {
+		return nil, err
+	}
+	info := fileInfo(filePath, e)
+	return &info, nil
+}
+
+// Delete deletes file or folder recursively, there is no trash so it is always permanent
+func (b *Backend) Delete(ctx context.Context, filePath string, permanently bool) error {
+	filePath = clean(filePath)
+	if err := b.begin(ctx, OpDelete, filePath); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if filePath == "/" {
+		return fmt.Errorf("can't delete root folder")
+	}
+	if _, err := b.lookup(filePath); err != nil {
+		return err
+	}
+	for p := range b.entries {
+		if p == filePath || strings.HasPrefix(p, filePath+"/") {
+			delete(b.entries, p)
+		}
+	}
+	return nil
+}
+
+// Move moves file or folder, overwriting destination
+func (b *Backend) Move(ctx context.Context, from, to string) error {
+	return b.transfer(ctx, OpMove, from, to)
+}
+
+// Copy copies file or folder, overwriting destination
+func (b *Backend) Copy(ctx context.Context, from, to string) error {
+	return b.transfer(ctx, OpCopy, from, to)
+}
+
+// transfer moves or copies entry with all its children
+func (b *Backend) transfer(ctx context.Context, op Op, from, to string) error {
+	from, to = clean(from), clean(to)
+	if err := b.begin(ctx, op, from); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, err := b.lookup(from); err != nil {
+		return err
+	}
+	if err := b.parent(to); err != nil {
+		return err
+	}
+	if from == "/" || from == to || strings.HasPrefix(to, from+"/") {
+		return fmt.Errorf("can't %s %s to %s", op, from, to)
+	}
+
+	for p := range b.entries {
+		if p == to || strings.HasPrefix(p, to+"/") {
+			delete(b.entries, p)
+		}
+	}
+	for p, e := range b.entries {
+		if p != from && !strings.HasPrefix(p, from+"/") {
+			continue
+		}
+		copied := *e
+		copied.props = maps.Clone(e.props)
+		b.entries[to+strings.TrimPrefix(p, from)] = &copied
+		if op == OpMove {
+			delete(b.entries, p)
+		}
+	}
+	return nil
+}
+
+// Hash returns MD5 of file content
+func (b *Backend) Hash(ctx context.Context, filePath string) (string, string, error) {
+	filePath = clean(filePath)
+	if err := b.begin(ctx, OpHash, filePath); err != nil {
+		return "", "", err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, err := b.lookup(filePath)
+	if err != nil {
+		return "", "", err
+	}
+	if e.isDir {
+		return "", "", fmt.Errorf("%s is a folder", filePath)
+	}
+	sum := md5.Sum(e.data)
+	return hex.EncodeToString(sum[:]), "md5", nil
+}
+
+// SetModTime sets modification time of file
+func (b *Backend) SetModTime(ctx context.Context, filePath string, modTime time.Time) error {
+	filePath = clean(filePath)
+	if err := b.begin(ctx, OpSetModTime, filePath); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, err := b.lookup(filePath)
+	if err != nil {
+		return err
+	}
+	e.modTime = modTime
+	return nil
+}
+
+// SetProperties merges custom properties of resource
+func (b *Backend) SetProperties(ctx context.Context, filePath string, props map[string]string) error {
+	filePath = clean(filePath)
+	if err := b.begin(ctx, OpSetProperties, filePath); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, err := b.lookup(filePath)
+	if err != nil {
+		return err
+	}
+	if e.props == nil {
+		e.props = make(map[string]string)
+	}
+	maps.Copy(e.props, props)
+	return nil
+}
+
+// clean converts path to absolute slash-separated path
+func clean(filePath string) string {
+	return path.Clean("/" + filePath)
+}
+
+// fileInfo converts entry to models.FileInfo
+func fileInfo(filePath string, e *entry) models.FileInfo {
+	info := models.FileInfo{
+		Name:       path.Base(filePath),
+		Path:       filePath,
+		IsDir:      e.isDir,
+		ModTime:    e.modTime,
+		Properties: maps.Clone(e.props),
+	}
+	if !e.isDir {
+		info.Size = int64(len(e.data))
+		info.ContentType = mime.TypeByExtension(path.Ext(filePath))
+	}
+	return info
+}
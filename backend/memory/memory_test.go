@@ -0,0 +1,125 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/backend/backendtest"
+)
+
+func TestConformance(t *testing.T) {
+	backendtest.Run(t, New(), "/")
+}
+
+func TestFail(t *testing.T) {
+	ctx := context.Background()
+	b := New()
+	b.WriteFile("/a/file.txt", []byte("content"), time.Now())
+	injected := errors.New("injected")
+
+	b.Fail(OpDownload, "/a/file.txt", injected)
+	if _, err := b.DownloadFile(ctx, "a/file.txt"); !errors.Is(err, injected) {
+		t.Fatalf("DownloadFile error = %v, want injected", err)
+	}
+	if _, err := b.DownloadFile(ctx, "/a/file.txt"); !errors.Is(err, injected) {
+		t.Fatalf("second DownloadFile error = %v, want injected", err)
+	}
+	if _, err := b.GetFileInfo(ctx, "/a/file.txt"); err != nil {
+		t.Fatalf("GetFileInfo failed: %v", err)
+	}
+
+	b.ClearFailures()
+	if _, err := b.DownloadFile(ctx, "/a/file.txt"); err != nil {
+		t.Fatalf("DownloadFile after ClearFailures: %v", err)
+	}
+	if got := b.Calls(OpDownload); got != 3 {
+		t.Errorf("Calls(OpDownload) = %d, want 3", got)
+	}
+}
+
+func TestFailTimes(t *testing.T) {
+	ctx := context.Background()
+	b := New()
+	injected := errors.New("injected")
+	b.FailTimes(OpUpload, "", injected, 2)
+
+	for i := range 3 {
+		err := b.UploadFile(ctx, "/file.txt", bytes.NewReader([]byte("x")), 1)
+		if i < 2 && !errors.Is(err, injected) {
+			t.Fatalf("upload %d error = %v, want injected", i, err)
+		}
+		if i == 2 && err != nil {
+			t.Fatalf("upload %d failed: %v", i, err)
+		}
+	}
+	if _, ok := b.ReadFile("/file.txt"); !ok {
+		t.Errorf("file is missing after successful upload")
+	}
+}
+
+func TestUploadReadFailure(t *testing.T) {
+	b := New()
+	b.WriteFile("/file.txt", []byte("old"), time.Now())
+
+	content := io.MultiReader(bytes.NewReader([]byte("partial")), errReader{})
+	if err := b.UploadFile(context.Background(), "/file.txt", content, -1); err == nil {
+		t.Fatalf("UploadFile succeeded with failing reader")
+	}
+	if data, _ := b.ReadFile("/file.txt"); string(data) != "old" {
+		t.Errorf("file content = %q after failed upload, want old content", data)
+	}
+}
+
+func TestUploadMissingParent(t *testing.T) {
+	err := New().UploadFile(context.Background(), "/missing/file.txt", bytes.NewReader(nil), 0)
+	if !errors.Is(err, backend.ErrNotFound) {
+		t.Errorf("UploadFile error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	b := New()
+	b.SetLatency(50 * time.Millisecond)
+
+	start := time.Now()
+	if _, err := b.ListFiles(context.Background(), "/"); err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("ListFiles took %v, want at least 50ms", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := b.ListFiles(ctx, "/"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ListFiles error = %v, want context deadline", err)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	b := New()
+	b.WriteFile("/src/a.txt", []byte("a"), time.Now())
+	b.WriteFile("/src/sub/b.txt", []byte("b"), time.Now())
+
+	if err := b.Copy(ctx, "/src", "/dst"); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	for _, p := range []string{"/src/a.txt", "/dst/a.txt", "/dst/sub/b.txt"} {
+		if _, ok := b.ReadFile(p); !ok {
+			t.Errorf("%s is missing after copy", p)
+		}
+	}
+}
+
+// errReader reader always failing
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("read failed")
+}
@@ -0,0 +1,127 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Remote parsed storage location like "nextcloud://user@host/path" or "yandex:disk:/path"
+type Remote struct {
+	Scheme string
+	User   string
+	// Password is set only if remote contains it, factories fall back to options
+	Password string
+	Host     string
+	Path     string
+}
+
+// String returns remote without password
+func (r Remote) String() string {
+	if r.Host == "" {
+		return r.Scheme + ":" + r.Path
+	}
+
+	user := ""
+	if r.User != "" {
+		user = r.User + "@"
+	}
+	return r.Scheme + "://" + user + r.Host + r.Path
+}
+
+// Options provides backend settings not included in remote, e.g. credentials
+type Options interface {
+	GetString(key string) string
+}
+
+// Factory creates backend for remote
+type Factory func(ctx context.Context, remote Remote, opts Options) (Backend, error)
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes backend factory available for scheme, it panics if scheme is registered twice
+func Register(scheme string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := factories[scheme]; exists {
+		panic("backend: Register called twice for scheme " + scheme)
+	}
+	factories[scheme] = factory
+}
+
+// Schemes returns sorted list of registered schemes
+func Schemes() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	schemes := make([]string, 0, len(factories))
+	for scheme := range factories {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Parse parses remote string
+func Parse(remote string) (Remote, error) {
+	scheme, rest, ok := strings.Cut(remote, ":")
+	if !ok || scheme == "" {
+		return Remote{}, fmt.Errorf("invalid remote %q: scheme is missing", remote)
+	}
+
+	// Opaque remotes like "yandex:disk:/path" keep everything after scheme as path
+	if !strings.HasPrefix(rest, "//") {
+		return Remote{Scheme: scheme, Path: rest}, nil
+	}
+
+	u, err := url.Parse(remote)
+	if err != nil {
+		return Remote{}, fmt.Errorf("invalid remote %q: %w", remote, err)
+	}
+
+	r := Remote{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   u.Path,
+	}
+	if u.User != nil {
+		r.User = u.User.Username()
+		r.Password, _ = u.User.Password()
+	}
+	return r, nil
+}
+
+// New creates backend for remote string and checks its credentials if supported
+func New(ctx context.Context, remote string, opts Options) (Backend, Remote, error) {
+	r, err := Parse(remote)
+	if err != nil {
+		return nil, Remote{}, err
+	}
+
+	mu.RLock()
+	factory, ok := factories[r.Scheme]
+	mu.RUnlock()
+	if !ok {
+		return nil, Remote{}, fmt.Errorf("unknown backend %q, available: %s", r.Scheme, strings.Join(Schemes(), ", "))
+	}
+
+	b, err := factory(ctx, r, opts)
+	if err != nil {
+		return nil, Remote{}, fmt.Errorf("failed to create %s backend: %w", r.Scheme, err)
+	}
+
+	if auth, ok := b.(Authenticator); ok {
+		if err := auth.Authenticate(ctx); err != nil {
+			return nil, Remote{}, fmt.Errorf("failed to authenticate with %s: %w", r, err)
+		}
+	}
+
+	return b, r, nil
+}
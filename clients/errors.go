@@ -0,0 +1,144 @@
+package clients
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"nextya-sync/backend"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// StatusError unexpected HTTP response, it matches backend error of its kind, e.g. backend.ErrQuotaExceeded
+type StatusError struct {
+	StatusCode int
+	// Code error code reported by server, e.g. DiskNotFoundError, Sabre\DAV\Exception\Locked or NoSuchKey
+	Code    string
+	Message string
+	kind    error
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("status %d", e.StatusCode)
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Unwrap returns backend error of the kind, nil if unknown
+func (e *StatusError) Unwrap() error {
+	return e.kind
+}
+
+// errorCodes backend errors of error codes of Yandex Disk, Sabre DAV (Nextcloud) and S3,
+// Sabre exceptions are matched by class name without namespace
+var errorCodes = map[string]error{
+	"UnauthorizedError":              backend.ErrUnauthorized,
+	"DiskNotFoundError":              backend.ErrNotFound,
+	"DiskPathDoesntExistsError":      backend.ErrConflict,
+	"DiskResourceAlreadyExistsError": backend.ErrConflict,
+	"DiskStorageQuotaExhaustedError": backend.ErrQuotaExceeded,
+	"TooManyRequestsError":           backend.ErrRateLimited,
+
+	"NotAuthenticated":    backend.ErrUnauthorized,
+	"NotFound":            backend.ErrNotFound,
+	"Forbidden":           backend.ErrForbidden,
+	"InsufficientStorage": backend.ErrQuotaExceeded,
+	"EntityTooLarge":      backend.ErrQuotaExceeded,
+	"Conflict":            backend.ErrConflict,
+	"Locked":              backend.ErrConflict,
+	"FileLocked":          backend.ErrConflict,
+	"TooManyRequests":     backend.ErrRateLimited,
+
+	"NoSuchKey":             backend.ErrNotFound,
+	"NoSuchBucket":          backend.ErrNotFound,
+	"AccessDenied":          backend.ErrForbidden,
+	"InvalidAccessKeyId":    backend.ErrUnauthorized,
+	"SignatureDoesNotMatch": backend.ErrUnauthorized,
+	"ExpiredToken":          backend.ErrUnauthorized,
+	"QuotaExceeded":         backend.ErrQuotaExceeded,
+	"SlowDown":              backend.ErrRateLimited,
+	"InternalError":         backend.ErrServer,
+	"ServiceUnavailable":    backend.ErrServer,
+}
+
+// statusKind returns backend error of HTTP status, nil if unknown
+func statusKind(statusCode int) error {
+	switch statusCode {
+	case http.StatusUnauthorized:
+		return backend.ErrUnauthorized
+	case http.StatusForbidden:
+		return backend.ErrForbidden
+	case http.StatusNotFound, http.StatusGone:
+		return backend.ErrNotFound
+	case http.StatusConflict, http.StatusPreconditionFailed, http.StatusLocked:
+		return backend.ErrConflict
+	case http.StatusRequestEntityTooLarge, http.StatusInsufficientStorage:
+		return backend.ErrQuotaExceeded
+	case http.StatusTooManyRequests:
+		return backend.ErrRateLimited
+	}
+	if statusCode >= 500 {
+		return backend.ErrServer
+	}
+	return nil
+}
+
+// responseError returns error for unexpected response, error code and message are taken
+// from Yandex Disk JSON, WebDAV and S3 XML error bodies
+func responseError(resp *resty.Response) error {
+	e := &StatusError{StatusCode: resp.StatusCode()}
+
+	var jsonBody struct {
+		Error       string `json:"error"`
+		Message     string `json:"message"`
+		Description string `json:"description"`
+	}
+	var xmlBody struct {
+		// Code and Message of S3, exception and message of Sabre DAV
+		Code         string `xml:"Code"`
+		Message      string `xml:"Message"`
+		Exception    string `xml:"exception"`
+		SabreMessage string `xml:"message"`
+	}
+	if body := resp.Body(); json.Unmarshal(body, &jsonBody) == nil {
+		e.Code = jsonBody.Error
+		e.Message = jsonBody.Description
+		if e.Message == "" {
+			e.Message = jsonBody.Message
+		}
+	} else if xml.Unmarshal(body, &xmlBody) == nil {
+		e.Code, e.Message = xmlBody.Code, xmlBody.Message
+		if xmlBody.Exception != "" {
+			e.Code, e.Message = xmlBody.Exception, xmlBody.SabreMessage
+		}
+	}
+
+	name := e.Code[strings.LastIndex(e.Code, `\`)+1:]
+	if kind, ok := errorCodes[name]; ok {
+		e.kind = kind
+	} else {
+		e.kind = statusKind(e.StatusCode)
+	}
+	return e
+}
+
+// networkTransport marks failures of sending requests with backend.ErrNetwork
+type networkTransport struct {
+	next http.RoundTripper
+}
+
+func (t networkTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil && req.Context().Err() == nil {
+		err = fmt.Errorf("%w: %w", backend.ErrNetwork, err)
+	}
+	return resp, err
+}
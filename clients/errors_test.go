@@ -0,0 +1,99 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"nextya-sync/backend"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// errorResponse sends request to server replying with status and body
+func errorResponse(t *testing.T, status int, body string) *resty.Response {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	resp, err := resty.New().R().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestResponseError(t *testing.T) {
+	for _, tc := range []struct {
+		status  int
+		body    string
+		want    error
+		message string
+	}{
+		{http.StatusUnauthorized, "", backend.ErrUnauthorized, "status 401"},
+		{http.StatusNotFound, "", backend.ErrNotFound, "status 404"},
+		{http.StatusLocked, "", backend.ErrConflict, "status 423"},
+		{http.StatusTooManyRequests, "", backend.ErrRateLimited, "status 429"},
+		{http.StatusBadGateway, "<html>Bad Gateway</html>", backend.ErrServer, "status 502"},
+		{
+			http.StatusInsufficientStorage,
+			`{"message":"Недостаточно свободного места","description":"Insufficient storage","error":"DiskStorageQuotaExhaustedError"}`,
+			backend.ErrQuotaExceeded,
+			"status 507: DiskStorageQuotaExhaustedError: Insufficient storage",
+		},
+		{
+			http.StatusConflict,
+			`{"description":"Specified path doesn't exist","error":"DiskPathDoesntExistsError"}`,
+			backend.ErrConflict,
+			"DiskPathDoesntExistsError",
+		},
+		{
+			http.StatusServiceUnavailable,
+			`<?xml version="1.0" encoding="utf-8"?>
+<d:error xmlns:d="DAV:" xmlns:s="http://sabredav.org/ns">
+  <s:exception>Sabre\DAV\Exception\InsufficientStorage</s:exception>
+  <s:message>Insufficient space in /files/admin</s:message>
+</d:error>`,
+			backend.ErrQuotaExceeded,
+			`Sabre\DAV\Exception\InsufficientStorage: Insufficient space in /files/admin`,
+		},
+		{
+			http.StatusForbidden,
+			`<?xml version="1.0" encoding="UTF-8"?><Error><Code>SignatureDoesNotMatch</Code><Message>Check your key</Message></Error>`,
+			backend.ErrUnauthorized,
+			"SignatureDoesNotMatch: Check your key",
+		},
+	} {
+		err := responseError(errorResponse(t, tc.status, tc.body))
+		if !errors.Is(err, tc.want) {
+			t.Errorf("status %d, body %q: error %v doesn't match %v", tc.status, tc.body, err, tc.want)
+		}
+		if !strings.Contains(err.Error(), tc.message) {
+			t.Errorf("error %q doesn't contain %q", err, tc.message)
+		}
+	}
+}
+
+func TestNetworkErrors(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	client := resty.New()
+	instrument(client, "test", webdavEndpoint)
+	if _, err := client.R().Get(url); !errors.Is(err, backend.ErrNetwork) {
+		t.Errorf("error %v doesn't match backend.ErrNetwork", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.R().SetContext(ctx).Get(url); errors.Is(err, backend.ErrNetwork) {
+		t.Errorf("cancelled request is reported as network error: %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+package clients
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/models"
+)
+
+// LocalClient client for working with local filesystem.
+// Paths are slash-separated and always treated as absolute.
+type LocalClient struct{}
+
+// NewLocalClient creates a new local filesystem client
+func NewLocalClient() *LocalClient {
+	return &LocalClient{}
+}
+
+func init() {
+	backend.Register("local", func(ctx context.Context, remote backend.Remote, opts backend.Options) (backend.Backend, error) {
+		return NewLocalClient(), nil
+	})
+}
+
+// resolve converts path to native absolute path
+func (lc *LocalClient) resolve(filePath string) string {
+	return filepath.FromSlash(path.Clean("/" + filePath))
+}
+
+// ListFiles gets list of files in folder, symlinks are followed
+func (lc *LocalClient) ListFiles(ctx context.Context, folderPath string) ([]models.FileInfo, error) {
+	entries, err := os.ReadDir(lc.resolve(folderPath))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]models.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		filePath := path.Join("/", folderPath, entry.Name())
+		info, err := os.Stat(lc.resolve(filePath))
+		if err != nil {
+			slog.Warn("Skipping unreadable file", "path", filePath, "error", err)
+			continue
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, localFileInfo(filePath, info))
+	}
+
+	return files, nil
+}
+
+// DownloadFile opens file for reading
+func (lc *LocalClient) DownloadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	file, err := os.Open(lc.resolve(filePath))
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, fmt.Errorf("%s is a directory", filePath)
+	}
+
+	return file, nil
+}
+
+// UploadFile writes file atomically via temporary file in the same folder
+func (lc *LocalClient) UploadFile(ctx context.Context, filePath string, content io.Reader, size int64) error {
+	target := lc.resolve(filePath)
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".nextya-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, content)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if size >= 0 && written != size {
+		tmp.Close()
+		return fmt.Errorf("size mismatch: expected %d bytes, got %d", size, written)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+
+	return nil
+}
+
+// CreateFolder creates folder
+func (lc *LocalClient) CreateFolder(ctx context.Context, folderPath string) error {
+	return os.Mkdir(lc.resolve(folderPath), 0o755)
+}
+
+// GetFileInfo gets file information
+func (lc *LocalClient) GetFileInfo(ctx context.Context, filePath string) (*models.FileInfo, error) {
+	info, err := os.Stat(lc.resolve(filePath))
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo := localFileInfo(path.Clean("/"+filePath), info)
+	return &fileInfo, nil
+}
+
+// SetModTime sets modification time of file
+func (lc *LocalClient) SetModTime(ctx context.Context, filePath string, modTime time.Time) error {
+	return os.Chtimes(lc.resolve(filePath), time.Time{}, modTime)
+}
+
+// Hash returns MD5 of file content
+func (lc *LocalClient) Hash(ctx context.Context, filePath string) (string, string, error) {
+	file, err := os.Open(lc.resolve(filePath))
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), "md5", nil
+}
+
+// Move moves file or folder
+func (lc *LocalClient) Move(ctx context.Context, from, to string) error {
+	return os.Rename(lc.resolve(from), lc.resolve(to))
+}
+
+// Delete deletes file or folder recursively, local filesystem has no trash so it is always permanent
+func (lc *LocalClient) Delete(ctx context.Context, filePath string, permanently bool) error {
+	return os.RemoveAll(lc.resolve(filePath))
+}
+
+// localFileInfo converts os.FileInfo to models.FileInfo
+func localFileInfo(filePath string, info os.FileInfo) models.FileInfo {
+	fileInfo := models.FileInfo{
+		Name:    info.Name(),
+		Path:    filePath,
+		IsDir:   info.IsDir(),
+		ModTime: info.ModTime(),
+	}
+	if !info.IsDir() {
+		fileInfo.Size = info.Size()
+		fileInfo.ContentType = mime.TypeByExtension(path.Ext(filePath))
+	}
+	return fileInfo
+}
@@ -0,0 +1,11 @@
+package clients
+
+import (
+	"testing"
+
+	"nextya-sync/backend/backendtest"
+)
+
+func TestLocalConformance(t *testing.T) {
+	backendtest.Run(t, NewLocalClient(), t.TempDir())
+}
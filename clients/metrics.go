@@ -0,0 +1,69 @@
+package clients
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"nextya-sync/metrics"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// instrument records metrics of API requests sent by client and marks failed requests with backend.ErrNetwork
+func instrument(client *resty.Client, name string, endpoint func(*http.Request) string) {
+	next := client.GetClient().Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.SetTransport(metrics.Transport(name, endpoint, networkTransport{next: next}))
+}
+
+// webdavEndpoint names all WebDAV requests alike, their paths contain file names
+func webdavEndpoint(*http.Request) string {
+	return "dav"
+}
+
+// nextcloudEndpoint names Nextcloud requests by DAV collection (files, versions, trashbin) or OCS API
+func nextcloudEndpoint(req *http.Request) string {
+	p := req.URL.Path
+	if _, rest, ok := strings.Cut(p, "/remote.php/dav/"); ok {
+		collection, _, _ := strings.Cut(rest, "/")
+		return collection
+	}
+	if strings.Contains(p, "/ocs/") {
+		return "ocs"
+	}
+	return "other"
+}
+
+// s3Endpoint names S3 requests by kind of operation
+func s3Endpoint(req *http.Request) string {
+	query := req.URL.Query()
+	switch {
+	case query.Has("uploads") || query.Has("uploadId"):
+		return "multipart"
+	case query.Has("list-type"):
+		return "list"
+	default:
+		return "object"
+	}
+}
+
+// yandexEndpoint names requests by REST API route relative to apiURL,
+// requests to upload and download hosts are named by transfer direction
+func yandexEndpoint(apiURL func() string) func(*http.Request) string {
+	return func(req *http.Request) string {
+		api, err := url.Parse(apiURL())
+		if err != nil || req.URL.Host != api.Host || !strings.HasPrefix(req.URL.Path, api.Path) {
+			if req.Method == http.MethodPut {
+				return "upload"
+			}
+			return "download"
+		}
+		if route := strings.TrimPrefix(req.URL.Path, api.Path); route != "" {
+			return route
+		}
+		return "/"
+	}
+}
@@ -0,0 +1,34 @@
+package clients
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestYandexEndpoint(t *testing.T) {
+	endpoint := yandexEndpoint(func() string { return "https://cloud-api.yandex.net/v1/disk" })
+	for _, tc := range []struct{ method, url, want string }{
+		{"GET", "https://cloud-api.yandex.net/v1/disk/resources?path=disk%3A%2Fa.txt", "/resources"},
+		{"GET", "https://cloud-api.yandex.net/v1/disk/resources/upload?path=disk%3A%2Fa.txt", "/resources/upload"},
+		{"GET", "https://cloud-api.yandex.net/v1/disk", "/"},
+		{"PUT", "https://uploader1.disk.yandex.net/upload-target/123", "upload"},
+		{"GET", "https://downloader.disk.yandex.ru/disk/abc", "download"},
+	} {
+		if got := endpoint(httptest.NewRequest(tc.method, tc.url, nil)); got != tc.want {
+			t.Errorf("%s %s endpoint = %q, want %q", tc.method, tc.url, got, tc.want)
+		}
+	}
+}
+
+func TestNextcloudEndpoint(t *testing.T) {
+	for url, want := range map[string]string{
+		"https://cloud.example.com/remote.php/dav/files/admin/Documents/a.txt": "files",
+		"https://cloud.example.com/remote.php/dav/versions/admin/versions/42":  "versions",
+		"https://cloud.example.com/remote.php/dav/trashbin/admin/trash":        "trashbin",
+		"https://cloud.example.com/ocs/v1.php/cloud/capabilities":              "ocs",
+	} {
+		if got := nextcloudEndpoint(httptest.NewRequest("PROPFIND", url, nil)); got != want {
+			t.Errorf("%s endpoint = %q, want %q", url, got, want)
+		}
+	}
+}
@@ -2,89 +2,73 @@ package clients
 
 import (
 	"context"
-	"encoding/xml"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"path"
-	"strconv"
 	"strings"
 	"time"
 
+	"nextya-sync/backend"
 	"nextya-sync/models"
 
 	"github.com/go-resty/resty/v2"
 )
 
-// RFC1123Time custom time type to handle RFC1123 format from WebDAV
-type RFC1123Time struct {
-	time.Time
-}
-
-// UnmarshalXML implements xml.Unmarshaler interface
-func (t *RFC1123Time) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
-	var v string
-	if err := d.DecodeElement(&v, &start); err != nil {
-		return err
-	}
-
-	if v == "" {
-		t.Time = time.Time{}
-		return nil
-	}
-
-	// Parse RFC1123 format: "Mon, 02 Jan 2006 15:04:05 MST"
-	parsed, err := time.Parse(time.RFC1123, v)
-	if err != nil {
-		return err
-	}
-
-	t.Time = parsed
-	return nil
-}
-
-// NextcloudClient client for working with Nextcloud API
+// NextcloudClient client for working with Nextcloud API.
+// Files are accessed with generic WebDAV client, Nextcloud adds versions and trashbin.
 type NextcloudClient struct {
+	*WebDAVClient
 	BaseURL  string
 	Username string
 	Password string
-	client   *resty.Client
-}
-
-// NextcloudFileInfo structure for WebDAV response
-type NextcloudFileInfo struct {
-	XMLName xml.Name `xml:"response"`
-	Href    string   `xml:"href"`
-	Props   struct {
-		DisplayName      string      `xml:"displayname"`
-		GetLastModified  RFC1123Time `xml:"getlastmodified"`
-		GetContentType   string      `xml:"getcontenttype"`
-		GetContentLength int64       `xml:"getcontentlength"`
-		GetETag          string      `xml:"getetag"`
-		ResourceType     struct {
-			Collection *struct{} `xml:"collection"`
-		} `xml:"resourcetype"`
-	} `xml:"propstat>prop"`
-}
-
-// MultiStatus structure for WebDAV PROPFIND response
-type MultiStatus struct {
-	XMLName   xml.Name            `xml:"multistatus"`
-	Responses []NextcloudFileInfo `xml:"response"`
 }
 
 // NewNextcloudClient creates a new Nextcloud client
 func NewNextcloudClient(baseURL, username, password string) *NextcloudClient {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
 	client := resty.New()
 	client.SetDisableWarn(true)
 	client.SetBasicAuth(username, password)
 	client.SetHeader("OCS-APIRequest", "true")
+	instrument(client, "nextcloud", nextcloudEndpoint)
 
 	return &NextcloudClient{
-		BaseURL:  strings.TrimSuffix(baseURL, "/"),
-		Username: username,
-		Password: password,
-		client:   client,
+		WebDAVClient: newWebDAVClient(baseURL+"/remote.php/dav/files/"+url.PathEscape(username), client),
+		BaseURL:      baseURL,
+		Username:     username,
+		Password:     password,
+	}
+}
+
+func init() {
+	backend.Register("nextcloud", nextcloudFactory("https"))
+	backend.Register("nextcloud+http", nextcloudFactory("http"))
+}
+
+// nextcloudFactory creates Nextcloud backends for remotes like nextcloud://user@host/path,
+// credentials missing in remote are taken from nextcloud.username and nextcloud.password options
+func nextcloudFactory(scheme string) backend.Factory {
+	return func(ctx context.Context, remote backend.Remote, opts backend.Options) (backend.Backend, error) {
+		if remote.Host == "" {
+			return nil, fmt.Errorf("Nextcloud host is required")
+		}
+
+		username := remote.User
+		if username == "" {
+			username = opts.GetString("nextcloud.username")
+		}
+		password := remote.Password
+		if password == "" {
+			password = opts.GetString("nextcloud.password")
+		}
+		if username == "" || password == "" {
+			return nil, fmt.Errorf("Nextcloud username and password are required")
+		}
+
+		return NewNextcloudClient(scheme+"://"+remote.Host, username, password), nil
 	}
 }
 
@@ -98,133 +82,197 @@ func (nc *NextcloudClient) Authenticate(ctx context.Context) error {
 	}
 
 	if resp.StatusCode() != http.StatusOK {
-		return fmt.Errorf("authentication failed: status %d", resp.StatusCode())
+		return fmt.Errorf("authentication failed: %w", responseError(resp))
 	}
 
 	return nil
 }
 
-// ListFiles gets list of files in folder
-func (nc *NextcloudClient) ListFiles(ctx context.Context, folderPath string) ([]models.FileInfo, error) {
-	webdavURL := nc.BaseURL + "/remote.php/dav/files/" + nc.Username + "/" + strings.TrimPrefix(folderPath, "/")
+// NotifyPushEndpoint returns websocket endpoint of notify_push app, empty if the app isn't installed
+func (nc *NextcloudClient) NotifyPushEndpoint(ctx context.Context) (string, error) {
+	var capabilities struct {
+		OCS struct {
+			Data struct {
+				Capabilities struct {
+					NotifyPush struct {
+						Endpoints struct {
+							Websocket string `json:"websocket"`
+						} `json:"endpoints"`
+					} `json:"notify_push"`
+				} `json:"capabilities"`
+			} `json:"data"`
+		} `json:"ocs"`
+	}
 
 	resp, err := nc.client.R().
 		SetContext(ctx).
-		SetHeader("Depth", "1").
-		SetBody(`<?xml version="1.0"?>
+		SetQueryParam("format", "json").
+		SetResult(&capabilities).
+		Get(nc.BaseURL + "/ocs/v2.php/cloud/capabilities")
+	if err != nil {
+		return "", fmt.Errorf("failed to get capabilities: %w", err)
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		return "", fmt.Errorf("get capabilities failed: %w", responseError(resp))
+	}
+
+	return capabilities.OCS.Data.Capabilities.NotifyPush.Endpoints.Websocket, nil
+}
+
+// ListVersions gets list of previous versions of file
+func (nc *NextcloudClient) ListVersions(ctx context.Context, fileID string) ([]models.FileInfo, error) {
+	versionsPath := "/remote.php/dav/versions/" + url.PathEscape(nc.Username) + "/versions/" + fileID
+
+	multiStatus, err := nc.propfind(ctx, nc.BaseURL+versionsPath, "1", `<?xml version="1.0"?>
 <d:propfind xmlns:d="DAV:">
   <d:prop>
-    <d:displayname/>
     <d:getlastmodified/>
-    <d:getcontenttype/>
     <d:getcontentlength/>
+    <d:getcontenttype/>
     <d:getetag/>
-    <d:resourcetype/>
   </d:prop>
-</d:propfind>`).
-		Execute("PROPFIND", webdavURL)
+</d:propfind>`)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list files: %w", err)
-	}
-
-	var multiStatus MultiStatus
-	if err := xml.Unmarshal(resp.Body(), &multiStatus); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+		return nil, fmt.Errorf("failed to list versions: %w", err)
 	}
 
-	var files []models.FileInfo
+	var versions []models.FileInfo
 	for i, response := range multiStatus.Responses {
 		if i == 0 {
-			continue // Skip first element (the folder itself)
+			continue // Skip first element (the versions folder itself)
 		}
 
-		isDir := response.Props.ResourceType.Collection != nil
-
-		files = append(files, models.FileInfo{
-			Name:        response.Props.DisplayName,
-			Path:        strings.TrimPrefix(response.Href, "/remote.php/dav/files/"+nc.Username),
+		versions = append(versions, models.FileInfo{
+			Name:        path.Base(response.Href),
+			Path:        response.Href,
 			Size:        response.Props.GetContentLength,
-			IsDir:       isDir,
 			ModTime:     response.Props.GetLastModified.Time,
 			ETag:        strings.Trim(response.Props.GetETag, `"`),
 			ContentType: response.Props.GetContentType,
+			ID:          fileID,
 		})
 	}
 
-	return files, nil
+	return versions, nil
 }
 
-// UploadFile uploads file
-func (nc *NextcloudClient) UploadFile(ctx context.Context, filePath string, content io.Reader, size int64) error {
-	webdavURL := nc.BaseURL + "/remote.php/dav/files/" + nc.Username + "/" + strings.TrimPrefix(filePath, "/")
+// DownloadVersion downloads previous version of file
+func (nc *NextcloudClient) DownloadVersion(ctx context.Context, fileID, version string) (io.ReadCloser, error) {
+	versionURL := nc.BaseURL + "/remote.php/dav/versions/" + url.PathEscape(nc.Username) + "/versions/" + fileID + "/" + version
 
 	resp, err := nc.client.R().
 		SetContext(ctx).
-		SetBody(content).
-		SetHeader("Content-Length", strconv.FormatInt(size, 10)).
-		Put(webdavURL)
+		SetDoNotParseResponse(true).
+		Get(versionURL)
 	if err != nil {
-		return fmt.Errorf("failed to upload file: %w", err)
+		return nil, fmt.Errorf("failed to download version: %w", err)
 	}
 
-	if resp.StatusCode() != http.StatusCreated && resp.StatusCode() != http.StatusNoContent {
-		return fmt.Errorf("upload failed: status %d", resp.StatusCode())
+	if resp.StatusCode() != http.StatusOK {
+		resp.RawBody().Close()
+		return nil, fmt.Errorf("download version failed: %w", responseError(resp))
 	}
 
-	return nil
+	return resp.RawBody(), nil
 }
 
-// DownloadFile downloads file
-func (nc *NextcloudClient) DownloadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
-	webdavURL := nc.BaseURL + "/remote.php/dav/files/" + nc.Username + "/" + strings.TrimPrefix(filePath, "/")
+// trashbinPath returns escaped path of user's trashbin in WebDAV
+func (nc *NextcloudClient) trashbinPath() string {
+	return "/remote.php/dav/trashbin/" + url.PathEscape(nc.Username) + "/trash"
+}
 
-	resp, err := nc.client.R().
-		SetContext(ctx).
-		SetDoNotParseResponse(true).
-		Get(webdavURL)
+// trashbinRelativePath converts trashbin href to path relative to the trashbin,
+// Nextcloud may be installed under a subpath of the host
+func (nc *NextcloudClient) trashbinRelativePath(href string) string {
+	rootPath := nc.trashbinPath()
+	if u, err := url.Parse(nc.BaseURL + rootPath); err == nil {
+		rootPath = u.EscapedPath()
+	}
+	return hrefRelativeTo(rootPath, href)
+}
+
+// ListTrash gets list of items in trashbin
+func (nc *NextcloudClient) ListTrash(ctx context.Context) ([]models.TrashItem, error) {
+	multiStatus, err := nc.propfind(ctx, nc.BaseURL+nc.trashbinPath(), "1", `<?xml version="1.0"?>
+<d:propfind xmlns:d="DAV:" xmlns:nc="http://nextcloud.org/ns">
+  <d:prop>
+    <d:getcontentlength/>
+    <d:resourcetype/>
+    <nc:trashbin-filename/>
+    <nc:trashbin-original-location/>
+    <nc:trashbin-deletion-time/>
+  </d:prop>
+</d:propfind>`)
 	if err != nil {
-		return nil, fmt.Errorf("failed to download file: %w", err)
+		return nil, fmt.Errorf("failed to list trashbin: %w", err)
 	}
 
-	if resp.StatusCode() != http.StatusOK {
-		resp.RawBody().Close()
-		return nil, fmt.Errorf("download failed: status %d", resp.StatusCode())
+	var items []models.TrashItem
+	for i, response := range multiStatus.Responses {
+		if i == 0 {
+			continue // Skip first element (the trashbin itself)
+		}
+
+		items = append(items, models.TrashItem{
+			Name:       response.Props.TrashbinFilename,
+			Path:       nc.trashbinRelativePath(response.Href),
+			OriginPath: response.Props.TrashbinOriginalLocation,
+			Size:       response.Props.GetContentLength,
+			IsDir:      response.Props.ResourceType.Collection != nil,
+			Deleted:    time.Unix(response.Props.TrashbinDeletionTime, 0),
+		})
 	}
 
-	return resp.RawBody(), nil
+	return items, nil
 }
 
-// CreateFolder creates folder
-func (nc *NextcloudClient) CreateFolder(ctx context.Context, folderPath string) error {
-	webdavURL := nc.BaseURL + "/remote.php/dav/files/" + nc.Username + "/" + strings.TrimPrefix(folderPath, "/")
-
-	resp, err := nc.client.R().
-		SetContext(ctx).
-		Execute("MKCOL", webdavURL)
+// ListTrashFolder gets list of files in deleted folder
+func (nc *NextcloudClient) ListTrashFolder(ctx context.Context, folderPath string) ([]models.FileInfo, error) {
+	multiStatus, err := nc.propfind(ctx, nc.BaseURL+nc.trashbinPath()+"/"+strings.TrimPrefix(folderPath, "/"), "1", `<?xml version="1.0"?>
+<d:propfind xmlns:d="DAV:">
+  <d:prop>
+    <d:getlastmodified/>
+    <d:getcontentlength/>
+    <d:resourcetype/>
+  </d:prop>
+</d:propfind>`)
 	if err != nil {
-		return fmt.Errorf("failed to create folder: %w", err)
+		return nil, fmt.Errorf("failed to list trashbin folder: %w", err)
 	}
 
-	if resp.StatusCode() != http.StatusCreated {
-		return fmt.Errorf("create folder failed: status %d", resp.StatusCode())
+	var files []models.FileInfo
+	for i, response := range multiStatus.Responses {
+		if i == 0 {
+			continue // Skip first element (the folder itself)
+		}
+
+		files = append(files, models.FileInfo{
+			Name:    path.Base(response.Href),
+			Path:    nc.trashbinRelativePath(response.Href),
+			Size:    response.Props.GetContentLength,
+			IsDir:   response.Props.ResourceType.Collection != nil,
+			ModTime: response.Props.GetLastModified.Time,
+		})
 	}
 
-	return nil
+	return files, nil
 }
 
-// GetFileInfo gets file information
-func (nc *NextcloudClient) GetFileInfo(ctx context.Context, filePath string) (*models.FileInfo, error) {
-	files, err := nc.ListFiles(ctx, path.Dir(filePath))
+// DownloadTrashFile downloads file from trashbin
+func (nc *NextcloudClient) DownloadTrashFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	resp, err := nc.client.R().
+		SetContext(ctx).
+		SetDoNotParseResponse(true).
+		Get(nc.BaseURL + nc.trashbinPath() + "/" + strings.TrimPrefix(filePath, "/"))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to download file from trashbin: %w", err)
 	}
 
-	fileName := path.Base(filePath)
-	for _, file := range files {
-		if path.Base(file.Path) == fileName {
-			return &file, nil
-		}
+	if resp.StatusCode() != http.StatusOK {
+		resp.RawBody().Close()
+		return nil, fmt.Errorf("download from trashbin failed: %w", responseError(resp))
 	}
 
-	return nil, fmt.Errorf("file not found: %s", filePath)
+	return resp.RawBody(), nil
 }
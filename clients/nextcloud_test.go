@@ -0,0 +1,222 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/backend/backendtest"
+	"nextya-sync/internal/fakenextcloud"
+	"nextya-sync/models"
+)
+
+func TestNextcloudConformance(t *testing.T) {
+	server := fakenextcloud.New("admin", "secret", t.TempDir())
+	defer server.Close()
+
+	backendtest.Run(t, NewNextcloudClient(server.URL, "admin", "secret"), "/")
+}
+
+func TestNextcloudAuthenticate(t *testing.T) {
+	server := fakenextcloud.New("admin", "secret", t.TempDir())
+	defer server.Close()
+
+	ctx := context.Background()
+	if err := NewNextcloudClient(server.URL, "admin", "secret").Authenticate(ctx); err != nil {
+		t.Errorf("Authenticate with valid credentials: %v", err)
+	}
+	if err := NewNextcloudClient(server.URL, "admin", "wrong").Authenticate(ctx); err == nil {
+		t.Errorf("Authenticate succeeded with wrong password")
+	}
+}
+
+func TestNextcloudNotifyPushEndpoint(t *testing.T) {
+	server := fakenextcloud.New("admin", "secret", t.TempDir())
+	defer server.Close()
+
+	ctx := context.Background()
+	client := NewNextcloudClient(server.URL, "admin", "secret")
+	endpoint, err := client.NotifyPushEndpoint(ctx)
+	if err != nil || endpoint != "" {
+		t.Errorf("NotifyPushEndpoint without the app = %q, %v, want empty", endpoint, err)
+	}
+
+	server.PushURL = "wss://cloud.example.com/push/ws"
+	endpoint, err = client.NotifyPushEndpoint(ctx)
+	if err != nil || endpoint != server.PushURL {
+		t.Errorf("NotifyPushEndpoint = %q, %v, want %q", endpoint, err, server.PushURL)
+	}
+}
+
+func TestNextcloudListFiles(t *testing.T) {
+	// User names with characters escaped differently by client and server must not break paths
+	server := fakenextcloud.New("john+doe@example.com", "secret", t.TempDir())
+	defer server.Close()
+
+	modTime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	if err := server.WriteFile("/Docs/report 2024 (final).txt", []byte("report"), modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewNextcloudClient(server.URL, "john+doe@example.com", "secret")
+	files, err := client.ListFiles(context.Background(), "/Docs")
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("ListFiles returned %d files, want 1", len(files))
+	}
+
+	file := files[0]
+	if file.Path != "/Docs/report%202024%20(final).txt" {
+		t.Errorf("Path = %q, want escaped path relative to user root", file.Path)
+	}
+	if file.Name != "report 2024 (final).txt" {
+		t.Errorf("Name = %q, want decoded name", file.Name)
+	}
+	if file.Size != 6 || file.IsDir || file.ID == "" || file.ETag == "" {
+		t.Errorf("wrong file details: %+v", file)
+	}
+	if !file.ModTime.Equal(modTime) {
+		t.Errorf("ModTime = %v, want %v", file.ModTime, modTime)
+	}
+}
+
+func TestNextcloudVersions(t *testing.T) {
+	server := fakenextcloud.New("admin", "secret", t.TempDir())
+	defer server.Close()
+
+	v1 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	v2 := v1.Add(time.Hour)
+	if err := server.WriteFile("/Docs/notes.txt", []byte("v3"), v2.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	client := NewNextcloudClient(server.URL, "admin", "secret")
+	info, err := client.GetFileInfo(ctx, "/Docs/notes.txt")
+	if err != nil || info.ID == "" {
+		t.Fatalf("GetFileInfo = %+v, %v, want file with ID", info, err)
+	}
+
+	if versions, err := client.ListVersions(ctx, info.ID); err != nil || len(versions) != 0 {
+		t.Errorf("ListVersions of file without versions = %v, %v", versions, err)
+	}
+	server.AddVersion("/Docs/notes.txt", []byte("v1"), v1)
+	server.AddVersion("/Docs/notes.txt", []byte("v2 longer"), v2)
+
+	versions, err := client.ListVersions(ctx, info.ID)
+	if err != nil {
+		t.Fatalf("ListVersions: %v", err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("ListVersions returned %d versions, want 2", len(versions))
+	}
+	// Newest version first, named by its modification time
+	if !versions[0].ModTime.Equal(v2) || versions[0].Size != 9 || versions[0].Name != "1704106800" || versions[0].ID != info.ID {
+		t.Errorf("newest version = %+v, want v2 of 9 bytes", versions[0])
+	}
+	if !versions[1].ModTime.Equal(v1) || versions[1].Size != 2 {
+		t.Errorf("oldest version = %+v, want v1 of 2 bytes", versions[1])
+	}
+
+	for content, version := range map[string]models.FileInfo{"v1": versions[1], "v2 longer": versions[0]} {
+		reader, err := client.DownloadVersion(ctx, version.ID, version.Name)
+		if err != nil {
+			t.Fatalf("DownloadVersion(%s): %v", version.Name, err)
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil || string(data) != content {
+			t.Errorf("DownloadVersion(%s) = %q, %v, want %q", version.Name, data, err, content)
+		}
+	}
+
+	// Overwriting file keeps its previous content as version
+	if err := client.UploadFile(ctx, "/Docs/notes.txt", strings.NewReader("v4"), 2); err != nil {
+		t.Fatal(err)
+	}
+	if versions, err := client.ListVersions(ctx, info.ID); err != nil || len(versions) != 3 {
+		t.Errorf("ListVersions after overwrite returned %d versions, %v, want 3", len(versions), err)
+	}
+
+	if _, err := client.ListVersions(ctx, "999"); !errors.Is(err, backend.ErrNotFound) {
+		t.Errorf("ListVersions of unknown file = %v, want ErrNotFound", err)
+	}
+	if _, err := client.DownloadVersion(ctx, info.ID, "123"); !errors.Is(err, backend.ErrNotFound) {
+		t.Errorf("DownloadVersion of unknown version = %v, want ErrNotFound", err)
+	}
+}
+
+func TestNextcloudTrashbin(t *testing.T) {
+	t.Run("root install", func(t *testing.T) { testNextcloudTrashbin(t, "admin", "") })
+	// Server escapes + in user name, hrefs carry the subpath
+	t.Run("subpath install", func(t *testing.T) { testNextcloudTrashbin(t, "john+doe@example.com", "/nextcloud") })
+}
+
+func testNextcloudTrashbin(t *testing.T, username, basePath string) {
+	server := fakenextcloud.New(username, "secret", t.TempDir())
+	defer server.Close()
+	server.BasePath = basePath
+
+	modTime := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	deleted := modTime.Add(time.Hour)
+	for filePath, content := range map[string]string{"/Docs/a b.txt": "a", "/Docs/dir/c.txt": "c", "/Docs/dir/sub/d.txt": "dd"} {
+		if err := server.WriteFile(filePath, []byte(content), modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, filePath := range []string{"/Docs/a b.txt", "/Docs/dir"} {
+		if err := server.Trash(filePath, deleted); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	client := NewNextcloudClient(server.URL+basePath, username, "secret")
+	items, err := client.ListTrash(ctx)
+	if err != nil {
+		t.Fatalf("ListTrash: %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("ListTrash returned %d items, want 2", len(items))
+	}
+	file, folder := items[0], items[1]
+	if file.Name != "a b.txt" || file.OriginPath != "Docs/a b.txt" || file.Size != 1 || file.IsDir || !file.Deleted.Equal(deleted) {
+		t.Errorf("deleted file = %+v", file)
+	}
+	if folder.Name != "dir" || folder.OriginPath != "Docs/dir" || !folder.IsDir || !folder.Deleted.Equal(deleted) {
+		t.Errorf("deleted folder = %+v", folder)
+	}
+	if !strings.HasPrefix(file.Path, "/a%20b.txt.d") || !strings.HasPrefix(folder.Path, "/dir.d") {
+		t.Errorf("trashbin paths = %q, %q, want escaped paths relative to trashbin", file.Path, folder.Path)
+	}
+
+	reader, err := client.DownloadTrashFile(ctx, file.Path)
+	if err != nil {
+		t.Fatalf("DownloadTrashFile: %v", err)
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil || string(data) != "a" {
+		t.Errorf("DownloadTrashFile = %q, %v", data, err)
+	}
+
+	files, err := client.ListTrashFolder(ctx, folder.Path)
+	if err != nil {
+		t.Fatalf("ListTrashFolder: %v", err)
+	}
+	if len(files) != 2 || files[0].Name != "c.txt" || files[0].Size != 1 || !files[1].IsDir {
+		t.Fatalf("ListTrashFolder = %+v, want c.txt and sub folder", files)
+	}
+	nested, err := client.ListTrashFolder(ctx, files[1].Path)
+	if err != nil || len(nested) != 1 || nested[0].Size != 2 {
+		t.Fatalf("ListTrashFolder of nested folder = %+v, %v", nested, err)
+	}
+	if _, err := server.ReadFile("/Docs/dir/c.txt"); err == nil {
+		t.Error("deleted folder is left in user files")
+	}
+}
@@ -0,0 +1,649 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/models"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	// s3DefaultPartSize size of parts of multipart uploads
+	s3DefaultPartSize = 16 * 1024 * 1024
+	// s3MaxParts maximum number of parts in multipart upload
+	s3MaxParts = 10000
+)
+
+// S3Config configuration of S3-compatible storage
+type S3Config struct {
+	// Endpoint storage URL, e.g. https://storage.yandexcloud.net or http://localhost:9000 for MinIO
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses bucket in path instead of host name, required by most local stand-ins
+	PathStyle bool
+	// PartSize size of parts of multipart uploads, files bigger than that are uploaded in parts
+	PartSize int64
+}
+
+// S3Client client for working with S3-compatible object storage.
+// Folders are emulated by key prefixes, empty folders are kept as "folder/" marker objects.
+type S3Client struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *resty.Client
+}
+
+// S3Object structure for object in bucket listing
+type S3Object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+}
+
+// S3ListBucketResult structure for ListObjectsV2 response
+type S3ListBucketResult struct {
+	XMLName               xml.Name   `xml:"ListBucketResult"`
+	Contents              []S3Object `xml:"Contents"`
+	CommonPrefixes        []string   `xml:"CommonPrefixes>Prefix"`
+	IsTruncated           bool       `xml:"IsTruncated"`
+	NextContinuationToken string     `xml:"NextContinuationToken"`
+}
+
+// S3InitiateMultipartUploadResult structure for multipart upload creation response
+type S3InitiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+// S3CompletedPart structure for uploaded part of multipart upload
+type S3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// S3CompleteMultipartUpload structure for multipart upload completion request
+type S3CompleteMultipartUpload struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []S3CompletedPart `xml:"Part"`
+}
+
+// NewS3Client creates a new S3 client
+func NewS3Client(config S3Config) (*S3Client, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(config.Endpoint, "/"))
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", config.Endpoint)
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.PartSize <= 0 {
+		config.PartSize = s3DefaultPartSize
+	}
+
+	client := resty.New()
+	client.SetPreRequestHook(func(_ *resty.Client, req *http.Request) error {
+		// Streamed bodies are sent with explicit length, S3 doesn't accept chunked uploads
+		if length := req.Header.Get("Content-Length"); length != "" {
+			req.ContentLength, _ = strconv.ParseInt(length, 10, 64)
+			if req.ContentLength == 0 {
+				req.Body = http.NoBody
+			}
+		}
+		signV4(req, config.AccessKey, config.SecretKey, config.Region, time.Now())
+		return nil
+	})
+	instrument(client, "s3", s3Endpoint)
+
+	return &S3Client{
+		config:   config,
+		endpoint: endpoint,
+		client:   client,
+	}, nil
+}
+
+func init() {
+	backend.Register("s3", newS3Backend)
+}
+
+// newS3Backend creates S3 backend for remotes like s3://bucket/prefix using s3.* options
+func newS3Backend(ctx context.Context, remote backend.Remote, opts backend.Options) (backend.Backend, error) {
+	if remote.Host == "" {
+		return nil, fmt.Errorf("S3 bucket is required")
+	}
+
+	config := S3Config{
+		Endpoint:  opts.GetString("s3.endpoint"),
+		Region:    opts.GetString("s3.region"),
+		Bucket:    remote.Host,
+		AccessKey: opts.GetString("s3.access_key_id"),
+		SecretKey: opts.GetString("s3.secret_access_key"),
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://s3.amazonaws.com"
+	}
+	if config.AccessKey == "" || config.SecretKey == "" {
+		return nil, fmt.Errorf("S3 access key and secret key are required")
+	}
+	if value := opts.GetString("s3.path_style"); value != "" {
+		pathStyle, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid s3.path_style: %w", err)
+		}
+		config.PathStyle = pathStyle
+	}
+	if value := opts.GetString("s3.part_size"); value != "" {
+		partSize, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid s3.part_size: %w", err)
+		}
+		config.PartSize = partSize
+	}
+
+	return NewS3Client(config)
+}
+
+// Authenticate checks access to the bucket
+func (sc *S3Client) Authenticate(ctx context.Context) error {
+	resp, err := sc.client.R().
+		SetContext(ctx).
+		Head(sc.objectURL("", nil))
+	if err != nil {
+		return fmt.Errorf("failed to connect to S3: %w", err)
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		return fmt.Errorf("authentication failed: %w", responseError(resp))
+	}
+
+	return nil
+}
+
+// objectURL returns URL of object with key and query
+func (sc *S3Client) objectURL(key string, query url.Values) string {
+	u := *sc.endpoint
+	if sc.config.PathStyle {
+		u.Path += "/" + sc.config.Bucket + "/" + key
+	} else {
+		u.Host = sc.config.Bucket + "." + u.Host
+		u.Path += "/" + key
+	}
+	u.RawPath = ""
+
+	result := u.Scheme + "://" + u.Host + s3Escape(u.Path, false)
+	if len(query) > 0 {
+		result += "?" + s3Query(query)
+	}
+	return result
+}
+
+// objectKey converts path to object key
+func objectKey(filePath string) string {
+	return strings.TrimPrefix(path.Clean("/"+filePath), "/")
+}
+
+// ListFiles gets list of files in folder
+func (sc *S3Client) ListFiles(ctx context.Context, folderPath string) ([]models.FileInfo, error) {
+	prefix := objectKey(folderPath)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var files []models.FileInfo
+	token := ""
+	for {
+		result, err := sc.listObjects(ctx, prefix, "/", token)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, dir := range result.CommonPrefixes {
+			dirPath := "/" + strings.TrimSuffix(dir, "/")
+			files = append(files, models.FileInfo{
+				Name:  path.Base(dirPath),
+				Path:  dirPath,
+				IsDir: true,
+			})
+		}
+		for _, object := range result.Contents {
+			// Skip marker of the folder itself
+			if object.Key == prefix {
+				continue
+			}
+			files = append(files, s3FileInfo(object))
+		}
+
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	if len(files) == 0 && prefix != "" {
+		// Listing of missing folder is empty, tell it from existing empty folder
+		if _, err := sc.GetFileInfo(ctx, folderPath); err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// listObjects lists one page of objects with prefix
+func (sc *S3Client) listObjects(ctx context.Context, prefix, delimiter, token string) (*S3ListBucketResult, error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+
+	resp, err := sc.client.R().
+		SetContext(ctx).
+		Get(sc.objectURL("", query))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		return nil, s3ResponseError("list objects", resp)
+	}
+
+	var result S3ListBucketResult
+	if err := xml.Unmarshal(resp.Body(), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// listAll lists keys of all objects with prefix
+func (sc *S3Client) listAll(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		result, err := sc.listObjects(ctx, prefix, "", token)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !result.IsTruncated {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// UploadFile uploads file, big files and files of unknown size are uploaded in parts
+func (sc *S3Client) UploadFile(ctx context.Context, filePath string, content io.Reader, size int64) error {
+	key := objectKey(filePath)
+	if size < 0 || size > sc.config.PartSize {
+		return sc.uploadMultipart(ctx, key, content, size)
+	}
+
+	resp, err := sc.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Length", strconv.FormatInt(size, 10)).
+		SetHeader("Content-Type", contentType(key)).
+		SetHeader("X-Amz-Content-Sha256", s3UnsignedPayload).
+		SetBody(content).
+		Put(sc.objectURL(key, nil))
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		return s3ResponseError("upload", resp)
+	}
+
+	return nil
+}
+
+// uploadMultipart uploads file in parts, unfinished upload is aborted on failure
+func (sc *S3Client) uploadMultipart(ctx context.Context, key string, content io.Reader, size int64) error {
+	partSize := sc.config.PartSize
+	if size > partSize*s3MaxParts {
+		partSize = (size + s3MaxParts - 1) / s3MaxParts
+	}
+
+	resp, err := sc.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", contentType(key)).
+		Post(sc.objectURL(key, url.Values{"uploads": {""}}))
+	if err != nil {
+		return fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return s3ResponseError("start multipart upload", resp)
+	}
+
+	var upload S3InitiateMultipartUploadResult
+	if err := xml.Unmarshal(resp.Body(), &upload); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	if err := sc.uploadParts(ctx, key, upload.UploadID, content, partSize); err != nil {
+		abortResp, abortErr := sc.client.R().
+			SetContext(context.WithoutCancel(ctx)).
+			Delete(sc.objectURL(key, url.Values{"uploadId": {upload.UploadID}}))
+		if abortErr == nil && abortResp.StatusCode() != http.StatusNoContent {
+			abortErr = s3ResponseError("abort multipart upload", abortResp)
+		}
+		if abortErr != nil {
+			return fmt.Errorf("%w (abort failed: %v)", err, abortErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// uploadParts uploads content part by part and completes multipart upload
+func (sc *S3Client) uploadParts(ctx context.Context, key, uploadID string, content io.Reader, partSize int64) error {
+	var parts []S3CompletedPart
+	buf := make([]byte, partSize)
+	for {
+		n, readErr := io.ReadFull(content, buf)
+		if readErr != nil && readErr != io.ErrUnexpectedEOF && readErr != io.EOF {
+			return fmt.Errorf("failed to read file: %w", readErr)
+		}
+		// Empty content still needs one part
+		if n == 0 && len(parts) > 0 {
+			break
+		}
+
+		partNumber := len(parts) + 1
+		part := buf[:n]
+		resp, err := sc.client.R().
+			SetContext(ctx).
+			SetHeader("X-Amz-Content-Sha256", hexSHA256(part)).
+			SetHeader("Content-MD5", contentMD5(part)).
+			SetBody(part).
+			Put(sc.objectURL(key, url.Values{
+				"partNumber": {strconv.Itoa(partNumber)},
+				"uploadId":   {uploadID},
+			}))
+		if err != nil {
+			return fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+		}
+		if resp.StatusCode() != http.StatusOK {
+			return s3ResponseError(fmt.Sprintf("upload part %d", partNumber), resp)
+		}
+		parts = append(parts, S3CompletedPart{PartNumber: partNumber, ETag: resp.Header().Get("ETag")})
+
+		if readErr != nil {
+			break
+		}
+	}
+
+	body, err := xml.Marshal(S3CompleteMultipartUpload{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	resp, err := sc.client.R().
+		SetContext(ctx).
+		SetHeader("X-Amz-Content-Sha256", hexSHA256(body)).
+		SetBody(body).
+		Post(sc.objectURL(key, url.Values{"uploadId": {uploadID}}))
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	// Completion may fail after 200 status was sent, the error is reported in the body then
+	if resp.StatusCode() != http.StatusOK || bytes.Contains(resp.Body(), []byte("<Error>")) {
+		return s3ResponseError("complete multipart upload", resp)
+	}
+
+	return nil
+}
+
+// DownloadFile downloads file
+func (sc *S3Client) DownloadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	resp, err := sc.client.R().
+		SetContext(ctx).
+		SetDoNotParseResponse(true).
+		Get(sc.objectURL(objectKey(filePath), nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		resp.RawBody().Close()
+		return nil, fmt.Errorf("download failed: %w", responseError(resp))
+	}
+
+	return resp.RawBody(), nil
+}
+
+// DownloadRange downloads part of file
+func (sc *S3Client) DownloadRange(ctx context.Context, filePath string, offset, length int64) (io.ReadCloser, error) {
+	resp, err := sc.client.R().
+		SetContext(ctx).
+		SetDoNotParseResponse(true).
+		SetHeader("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)).
+		Get(sc.objectURL(objectKey(filePath), nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+
+	if resp.StatusCode() != http.StatusPartialContent {
+		resp.RawBody().Close()
+		return nil, fmt.Errorf("download failed: %w", responseError(resp))
+	}
+
+	return resp.RawBody(), nil
+}
+
+// CreateFolder creates folder marker object
+func (sc *S3Client) CreateFolder(ctx context.Context, folderPath string) error {
+	resp, err := sc.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Length", "0").
+		Put(sc.objectURL(objectKey(folderPath)+"/", nil))
+	if err != nil {
+		return fmt.Errorf("failed to create folder: %w", err)
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		return s3ResponseError("create folder", resp)
+	}
+
+	return nil
+}
+
+// GetFileInfo gets file information, folders exist if any object has their prefix
+func (sc *S3Client) GetFileInfo(ctx context.Context, filePath string) (*models.FileInfo, error) {
+	key := objectKey(filePath)
+	if key == "" {
+		return &models.FileInfo{Name: "/", Path: "/", IsDir: true}, nil
+	}
+
+	resp, err := sc.client.R().
+		SetContext(ctx).
+		Head(sc.objectURL(key, nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	if resp.StatusCode() == http.StatusOK {
+		modified, _ := http.ParseTime(resp.Header().Get("Last-Modified"))
+		size, _ := strconv.ParseInt(resp.Header().Get("Content-Length"), 10, 64)
+		info := s3FileInfo(S3Object{
+			Key:          key,
+			LastModified: modified,
+			ETag:         resp.Header().Get("ETag"),
+			Size:         size,
+		})
+		info.ContentType = resp.Header().Get("Content-Type")
+		return &info, nil
+	}
+	if resp.StatusCode() != http.StatusNotFound {
+		return nil, fmt.Errorf("get file info failed: %w", responseError(resp))
+	}
+
+	result, err := sc.listObjects(ctx, key+"/", "/", "")
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Contents) == 0 && len(result.CommonPrefixes) == 0 {
+		return nil, fmt.Errorf("get file info failed: %s: %w", filePath, backend.ErrNotFound)
+	}
+
+	return &models.FileInfo{
+		Name:  path.Base(key),
+		Path:  "/" + key,
+		IsDir: true,
+	}, nil
+}
+
+// Hash returns MD5 of file taken from its ETag, objects uploaded in parts have no MD5
+func (sc *S3Client) Hash(ctx context.Context, filePath string) (string, string, error) {
+	info, err := sc.GetFileInfo(ctx, filePath)
+	if err != nil {
+		return "", "", err
+	}
+	if info.IsDir || info.ETag == "" || strings.Contains(info.ETag, "-") {
+		return "", "", fmt.Errorf("MD5 of %s is unknown", filePath)
+	}
+	return info.ETag, "md5", nil
+}
+
+// Copy copies file on the server side
+func (sc *S3Client) Copy(ctx context.Context, from, to string) error {
+	return sc.copyObject(ctx, objectKey(from), objectKey(to))
+}
+
+// copyObject copies single object
+func (sc *S3Client) copyObject(ctx context.Context, fromKey, toKey string) error {
+	resp, err := sc.client.R().
+		SetContext(ctx).
+		SetHeader("X-Amz-Copy-Source", s3Escape("/"+sc.config.Bucket+"/"+fromKey, false)).
+		Put(sc.objectURL(toKey, nil))
+	if err != nil {
+		return fmt.Errorf("failed to copy: %w", err)
+	}
+
+	if resp.StatusCode() != http.StatusOK || bytes.Contains(resp.Body(), []byte("<Error>")) {
+		return s3ResponseError("copy", resp)
+	}
+
+	return nil
+}
+
+// Move moves file or folder by copying and deleting objects
+func (sc *S3Client) Move(ctx context.Context, from, to string) error {
+	info, err := sc.GetFileInfo(ctx, from)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir {
+		if err := sc.Copy(ctx, from, to); err != nil {
+			return err
+		}
+		return sc.deleteObject(ctx, objectKey(from))
+	}
+
+	fromPrefix := objectKey(from) + "/"
+	toPrefix := objectKey(to) + "/"
+	keys, err := sc.listAll(ctx, fromPrefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := sc.copyObject(ctx, key, toPrefix+strings.TrimPrefix(key, fromPrefix)); err != nil {
+			return err
+		}
+		if err := sc.deleteObject(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete deletes file or folder recursively, S3 has no trash so it is always permanent
+func (sc *S3Client) Delete(ctx context.Context, filePath string, permanently bool) error {
+	key := objectKey(filePath)
+	keys, err := sc.listAll(ctx, key+"/")
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		keys = []string{key}
+	}
+
+	for _, key := range keys {
+		if err := sc.deleteObject(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteObject deletes single object
+func (sc *S3Client) deleteObject(ctx context.Context, key string) error {
+	resp, err := sc.client.R().
+		SetContext(ctx).
+		Delete(sc.objectURL(key, nil))
+	if err != nil {
+		return fmt.Errorf("failed to delete: %w", err)
+	}
+
+	if resp.StatusCode() != http.StatusNoContent && resp.StatusCode() != http.StatusOK {
+		return s3ResponseError("delete", resp)
+	}
+
+	return nil
+}
+
+// s3FileInfo converts listed object to models.FileInfo
+func s3FileInfo(object S3Object) models.FileInfo {
+	return models.FileInfo{
+		Name:        path.Base(object.Key),
+		Path:        "/" + object.Key,
+		Size:        object.Size,
+		ModTime:     object.LastModified,
+		ETag:        strings.Trim(object.ETag, `"`),
+		ContentType: contentType(object.Key),
+	}
+}
+
+// contentType guesses content type by file extension
+func contentType(key string) string {
+	if ct := mime.TypeByExtension(path.Ext(key)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// s3ResponseError builds error from S3 error response
+func s3ResponseError(operation string, resp *resty.Response) error {
+	return fmt.Errorf("%s failed: %w", operation, responseError(resp))
+}
+
+// contentMD5 returns base64-encoded MD5 of data for Content-MD5 header
+func contentMD5(data []byte) string {
+	sum := md5.Sum(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
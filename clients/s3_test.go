@@ -0,0 +1,199 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"nextya-sync/backend"
+	"nextya-sync/backend/backendtest"
+	"nextya-sync/internal/fakes3"
+)
+
+// newFakeS3 starts fake S3 and returns client of its bucket uploading in parts of partSize
+func newFakeS3(t *testing.T, partSize int64) (*fakes3.Server, *S3Client) {
+	server := fakes3.New("bucket", "access", "secret")
+	t.Cleanup(server.Close)
+
+	client, err := NewS3Client(S3Config{
+		Endpoint:  server.URL,
+		Bucket:    "bucket",
+		AccessKey: "access",
+		SecretKey: "secret",
+		PathStyle: true,
+		PartSize:  partSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+func TestS3Conformance(t *testing.T) {
+	server, client := newFakeS3(t, 32*1024)
+	// Small pages make listings follow continuation tokens
+	server.MaxKeys = 3
+
+	backendtest.Run(t, client, "/")
+	if server.Requests("POST complete") == 0 {
+		t.Error("upload of unknown size wasn't done in parts")
+	}
+}
+
+func TestS3Signature(t *testing.T) {
+	server, client := newFakeS3(t, 0)
+	ctx := context.Background()
+	if err := client.Authenticate(ctx); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	// Signed payload hash of parts and escaped keys with query must match what the server sees
+	key := "/folder with space/ü+&=?.txt"
+	if err := client.UploadFile(ctx, key, strings.NewReader("signed"), 6); err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if got, _ := server.ReadFile(key); string(got) != "signed" {
+		t.Errorf("stored %q, want %q", got, "signed")
+	}
+
+	server.Region = "eu-central-1"
+	if _, err := client.ListFiles(ctx, "/"); !errors.Is(err, backend.ErrUnauthorized) {
+		t.Errorf("ListFiles in wrong region = %v, want ErrUnauthorized", err)
+	}
+	server.Region = "us-east-1"
+
+	wrong, err := NewS3Client(S3Config{Endpoint: server.URL, Bucket: "bucket", AccessKey: "access", SecretKey: "wrong", PathStyle: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// HEAD responses carry no error code, only the status
+	if err := wrong.Authenticate(ctx); err == nil {
+		t.Error("Authenticate with wrong secret succeeded")
+	}
+	if err := wrong.UploadFile(ctx, "/denied.txt", strings.NewReader("x"), 1); !errors.Is(err, backend.ErrUnauthorized) {
+		t.Errorf("UploadFile with wrong secret = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestS3Multipart(t *testing.T) {
+	server, client := newFakeS3(t, 1024)
+	ctx := context.Background()
+	content := bytes.Repeat([]byte("0123456789abcdef"), 160)
+
+	if err := client.UploadFile(ctx, "/big.bin", bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if got, _ := server.ReadFile("/big.bin"); !bytes.Equal(got, content) {
+		t.Errorf("stored %d bytes, want %d", len(got), len(content))
+	}
+	if got := server.Requests("PUT part"); got != 3 {
+		t.Errorf("uploaded %d parts, want 3", got)
+	}
+	if server.Uploads() != 0 {
+		t.Errorf("%d multipart uploads left unfinished", server.Uploads())
+	}
+
+	// Size known in advance fits into single request
+	if err := client.UploadFile(ctx, "/small.bin", bytes.NewReader(content[:1024]), 1024); err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if got := server.Requests("PUT object"); got != 1 {
+		t.Errorf("small file uploaded with %d single requests, want 1", got)
+	}
+
+	// Failed upload is aborted
+	failing := &failingReader{data: content, failAt: 1500}
+	if err := client.UploadFile(ctx, "/failed.bin", failing, -1); err == nil {
+		t.Fatal("UploadFile of failing reader succeeded")
+	}
+	if server.Requests("DELETE abort") != 1 || server.Uploads() != 0 {
+		t.Errorf("failed multipart upload wasn't aborted")
+	}
+	if _, ok := server.ReadFile("/failed.bin"); ok {
+		t.Errorf("failed upload created object")
+	}
+}
+
+// failingReader returns error after failAt bytes
+type failingReader struct {
+	data   []byte
+	failAt int
+	offset int
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.offset >= r.failAt {
+		return 0, errors.New("read failed")
+	}
+	n := copy(p, r.data[r.offset:r.failAt])
+	r.offset += n
+	return n, nil
+}
+
+func TestS3Hash(t *testing.T) {
+	server, client := newFakeS3(t, 1024)
+	ctx := context.Background()
+	content := []byte("hashed by etag")
+	if err := client.UploadFile(ctx, "/file.txt", bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+
+	sum, algo, err := client.Hash(ctx, "/file.txt")
+	want := md5.Sum(content)
+	if err != nil || algo != "md5" || sum != hex.EncodeToString(want[:]) {
+		t.Errorf("Hash = %s, %s, %v; want md5 %x", sum, algo, err, want)
+	}
+	if etag := server.ETag("/file.txt"); etag != `"`+sum+`"` {
+		t.Errorf("hash %s isn't taken from ETag %s", sum, etag)
+	}
+
+	// ETag of objects uploaded in parts isn't MD5 of content
+	big := bytes.Repeat(content, 100)
+	if err := client.UploadFile(ctx, "/big.txt", bytes.NewReader(big), int64(len(big))); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(server.ETag("/big.txt"), "-") {
+		t.Fatalf("ETag %s of multipart object has no part count", server.ETag("/big.txt"))
+	}
+	if sum, _, err := client.Hash(ctx, "/big.txt"); err == nil {
+		t.Errorf("Hash of multipart object = %s, want error", sum)
+	}
+}
+
+func TestS3Move(t *testing.T) {
+	server, client := newFakeS3(t, 0)
+	ctx := context.Background()
+	for _, key := range []string{"/a.txt", "/dir/one.txt", "/dir/sub/two.txt"} {
+		if err := client.UploadFile(ctx, key, strings.NewReader(key), int64(len(key))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := client.Move(ctx, "/a.txt", "/b.txt"); err != nil {
+		t.Fatalf("Move file: %v", err)
+	}
+	if server.Requests("PUT copy") != 1 || server.Requests("DELETE object") != 1 {
+		t.Errorf("file was moved with %d copies and %d deletions, want 1 and 1",
+			server.Requests("PUT copy"), server.Requests("DELETE object"))
+	}
+
+	// Folders are moved object by object
+	if err := client.Move(ctx, "/dir", "/moved"); err != nil {
+		t.Fatalf("Move folder: %v", err)
+	}
+	for from, to := range map[string]string{"/a.txt": "/b.txt", "/dir/one.txt": "/moved/one.txt", "/dir/sub/two.txt": "/moved/sub/two.txt"} {
+		if _, ok := server.ReadFile(from); ok {
+			t.Errorf("%s is left after move", from)
+		}
+		if got, _ := server.ReadFile(to); string(got) != from {
+			t.Errorf("%s = %q, want %q", to, got, from)
+		}
+	}
+	if _, err := client.GetFileInfo(ctx, "/dir"); !errors.Is(err, backend.ErrNotFound) {
+		t.Errorf("GetFileInfo of moved folder = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,286 @@
+package clients
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/models"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPConfig configuration of SFTP server
+type SFTPConfig struct {
+	// Address host with optional port, 22 is used by default
+	Address  string
+	Username string
+	Password string
+	// KeyFile private key file, used together with password if both are set
+	KeyFile       string
+	KeyPassphrase string
+	// KnownHostsFile file with trusted host keys, ~/.ssh/known_hosts by default
+	KnownHostsFile string
+}
+
+// SFTPClient client for working with files over SFTP.
+// Paths are absolute paths on the server.
+type SFTPClient struct {
+	ssh    *ssh.Client
+	client *sftp.Client
+}
+
+// NewSFTPClient connects to SFTP server, host key must be present in known hosts file
+func NewSFTPClient(config SFTPConfig) (*SFTPClient, error) {
+	var auth []ssh.AuthMethod
+	if config.KeyFile != "" {
+		signer, err := loadPrivateKey(config.KeyFile, config.KeyPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if config.Password != "" {
+		auth = append(auth, ssh.Password(config.Password))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("SFTP key file or password is required")
+	}
+
+	knownHostsFile := config.KnownHostsFile
+	if knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts: %w", err)
+	}
+
+	address := config.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "22")
+	}
+
+	sshClient, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            config.Username,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		// ssh reports rejected credentials only by message
+		kind := backend.ErrNetwork
+		if strings.Contains(err.Error(), "unable to authenticate") {
+			kind = backend.ErrUnauthorized
+		}
+		return nil, fmt.Errorf("failed to connect to %s: %w: %w", address, kind, err)
+	}
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, fmt.Errorf("failed to start SFTP session: %w", err)
+	}
+
+	return &SFTPClient{
+		ssh:    sshClient,
+		client: client,
+	}, nil
+}
+
+// loadPrivateKey reads private key optionally protected by passphrase
+func loadPrivateKey(keyFile, passphrase string) (ssh.Signer, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	var signer ssh.Signer
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key file: %w", err)
+	}
+	return signer, nil
+}
+
+func init() {
+	backend.Register("sftp", newSFTPBackend)
+}
+
+// newSFTPBackend creates SFTP backend for remotes like sftp://user@host:port/path using sftp.* options
+func newSFTPBackend(ctx context.Context, remote backend.Remote, opts backend.Options) (backend.Backend, error) {
+	if remote.Host == "" {
+		return nil, fmt.Errorf("SFTP host is required")
+	}
+
+	config := SFTPConfig{
+		Address:        remote.Host,
+		Username:       remote.User,
+		Password:       remote.Password,
+		KeyFile:        opts.GetString("sftp.key_file"),
+		KeyPassphrase:  opts.GetString("sftp.key_passphrase"),
+		KnownHostsFile: opts.GetString("sftp.known_hosts"),
+	}
+	if config.Username == "" {
+		config.Username = opts.GetString("sftp.username")
+	}
+	if config.Password == "" {
+		config.Password = opts.GetString("sftp.password")
+	}
+	if config.Username == "" {
+		return nil, fmt.Errorf("SFTP username is required")
+	}
+
+	return NewSFTPClient(config)
+}
+
+// Close closes SFTP session and SSH connection
+func (sc *SFTPClient) Close() error {
+	sc.client.Close()
+	return sc.ssh.Close()
+}
+
+// ListFiles gets list of files in folder, symlinks are followed
+func (sc *SFTPClient) ListFiles(ctx context.Context, folderPath string) ([]models.FileInfo, error) {
+	entries, err := sc.client.ReadDirContext(ctx, sftpPath(folderPath))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]models.FileInfo, 0, len(entries))
+	for _, info := range entries {
+		filePath := path.Join(sftpPath(folderPath), info.Name())
+		if info.Mode()&os.ModeSymlink != 0 {
+			if info, err = sc.client.Stat(filePath); err != nil {
+				continue
+			}
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, sftpFileInfo(filePath, info))
+	}
+
+	return files, nil
+}
+
+// DownloadFile opens file for reading
+func (sc *SFTPClient) DownloadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	return sc.client.Open(sftpPath(filePath))
+}
+
+// UploadFile writes file atomically via temporary file in the same folder
+func (sc *SFTPClient) UploadFile(ctx context.Context, filePath string, content io.Reader, size int64) error {
+	target := sftpPath(filePath)
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	tmpPath := path.Join(path.Dir(target), ".nextya-"+hex.EncodeToString(suffix)+".tmp")
+
+	file, err := sc.client.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+
+	written, err := io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size >= 0 && written != size {
+		err = fmt.Errorf("size mismatch: expected %d bytes, got %d", size, written)
+	}
+	if err == nil {
+		err = sc.client.PosixRename(tmpPath, target)
+	}
+	if err != nil {
+		sc.client.Remove(tmpPath)
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	return nil
+}
+
+// CreateFolder creates folder
+func (sc *SFTPClient) CreateFolder(ctx context.Context, folderPath string) error {
+	return sc.client.Mkdir(sftpPath(folderPath))
+}
+
+// GetFileInfo gets file information
+func (sc *SFTPClient) GetFileInfo(ctx context.Context, filePath string) (*models.FileInfo, error) {
+	info, err := sc.client.Stat(sftpPath(filePath))
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo := sftpFileInfo(sftpPath(filePath), info)
+	return &fileInfo, nil
+}
+
+// SetModTime sets modification time of file
+func (sc *SFTPClient) SetModTime(ctx context.Context, filePath string, modTime time.Time) error {
+	return sc.client.Chtimes(sftpPath(filePath), modTime, modTime)
+}
+
+// Move moves file or folder
+func (sc *SFTPClient) Move(ctx context.Context, from, to string) error {
+	return sc.client.PosixRename(sftpPath(from), sftpPath(to))
+}
+
+// Delete deletes file or folder recursively, SFTP has no trash so it is always permanent
+func (sc *SFTPClient) Delete(ctx context.Context, filePath string, permanently bool) error {
+	return sc.client.RemoveAll(sftpPath(filePath))
+}
+
+// GetQuota gets space of the filesystem holding home directory
+func (sc *SFTPClient) GetQuota(ctx context.Context) (*models.Quota, error) {
+	stat, err := sc.client.StatVFS(".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get filesystem stats: %w", err)
+	}
+
+	return &models.Quota{
+		Total: int64(stat.TotalSpace()),
+		Used:  int64(stat.TotalSpace() - stat.FreeSpace()),
+	}, nil
+}
+
+// sftpPath converts path to absolute path on the server
+func sftpPath(filePath string) string {
+	return path.Clean("/" + filePath)
+}
+
+// sftpFileInfo converts os.FileInfo to models.FileInfo
+func sftpFileInfo(filePath string, info os.FileInfo) models.FileInfo {
+	fileInfo := models.FileInfo{
+		Name:    info.Name(),
+		Path:    filePath,
+		IsDir:   info.IsDir(),
+		ModTime: info.ModTime(),
+	}
+	if !info.IsDir() {
+		fileInfo.Size = info.Size()
+		fileInfo.ContentType = mime.TypeByExtension(path.Ext(filePath))
+	}
+	return fileInfo
+}
@@ -0,0 +1,430 @@
+package clients
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/models"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// RFC1123Time custom time type to handle RFC1123 format from WebDAV
+type RFC1123Time struct {
+	time.Time
+}
+
+// UnmarshalXML implements xml.Unmarshaler interface
+func (t *RFC1123Time) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var v string
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+
+	if v == "" {
+		t.Time = time.Time{}
+		return nil
+	}
+
+	// Parse RFC1123 format: "Mon, 02 Jan 2006 15:04:05 MST"
+	parsed, err := time.Parse(time.RFC1123, v)
+	if err != nil {
+		return err
+	}
+
+	t.Time = parsed
+	return nil
+}
+
+// WebDAVProps properties of resource in WebDAV response
+type WebDAVProps struct {
+	DisplayName      string      `xml:"displayname"`
+	GetLastModified  RFC1123Time `xml:"getlastmodified"`
+	GetContentType   string      `xml:"getcontenttype"`
+	GetContentLength int64       `xml:"getcontentlength"`
+	GetETag          string      `xml:"getetag"`
+	FileID           string      `xml:"fileid"`
+
+	// Trashbin only properties
+	TrashbinFilename         string `xml:"trashbin-filename"`
+	TrashbinOriginalLocation string `xml:"trashbin-original-location"`
+	TrashbinDeletionTime     int64  `xml:"trashbin-deletion-time"`
+
+	ResourceType struct {
+		Collection *struct{} `xml:"collection"`
+	} `xml:"resourcetype"`
+}
+
+// WebDAVPropstat group of properties sharing the same status
+type WebDAVPropstat struct {
+	Props  WebDAVProps `xml:"prop"`
+	Status string      `xml:"status"`
+}
+
+// WebDAVResponse structure for single resource in WebDAV response
+type WebDAVResponse struct {
+	XMLName   xml.Name         `xml:"response"`
+	Href      string           `xml:"href"`
+	Propstats []WebDAVPropstat `xml:"propstat"`
+	// Props found properties, filled from the successful propstat
+	Props WebDAVProps `xml:"-"`
+}
+
+// MultiStatus structure for WebDAV PROPFIND response
+type MultiStatus struct {
+	XMLName   xml.Name         `xml:"multistatus"`
+	Responses []WebDAVResponse `xml:"response"`
+}
+
+// UnmarshalXML implements xml.Unmarshaler interface.
+// Servers report missing properties as empty elements in a separate propstat with 404 status,
+// only properties of the successful propstat are kept so they are not overwritten.
+func (r *WebDAVResponse) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type response WebDAVResponse
+	if err := d.DecodeElement((*response)(r), &start); err != nil {
+		return err
+	}
+
+	for _, propstat := range r.Propstats {
+		if propstat.Status == "" || strings.Contains(propstat.Status, " 200 ") {
+			r.Props = propstat.Props
+			break
+		}
+	}
+	return nil
+}
+
+// filePropfind PROPFIND request body for listing files, servers ignore unknown properties
+const filePropfind = `<?xml version="1.0"?>
+<d:propfind xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns">
+  <d:prop>
+    <d:displayname/>
+    <d:getlastmodified/>
+    <d:getcontenttype/>
+    <d:getcontentlength/>
+    <d:getetag/>
+    <d:resourcetype/>
+    <oc:fileid/>
+  </d:prop>
+</d:propfind>`
+
+// WebDAVConfig configuration of WebDAV server
+type WebDAVConfig struct {
+	// URL root of the WebDAV tree, e.g. https://host/remote.php/dav/files/user
+	URL      string
+	Username string
+	Password string
+	// BearerToken is used instead of basic authentication when set
+	BearerToken string
+}
+
+// WebDAVClient client for working with generic WebDAV servers.
+// Paths are relative to the root URL and URL-escaped the way the server returns them.
+type WebDAVClient struct {
+	RootURL string
+	// rootPath escaped path of root URL, trimmed from hrefs
+	rootPath string
+	client   *resty.Client
+}
+
+// NewWebDAVClient creates a new WebDAV client
+func NewWebDAVClient(config WebDAVConfig) (*WebDAVClient, error) {
+	root, err := url.Parse(strings.TrimSuffix(config.URL, "/"))
+	if err != nil || root.Scheme == "" || root.Host == "" {
+		return nil, fmt.Errorf("invalid WebDAV URL %q", config.URL)
+	}
+
+	client := resty.New()
+	client.SetDisableWarn(true)
+	if config.BearerToken != "" {
+		client.SetAuthToken(config.BearerToken)
+	} else if config.Username != "" {
+		client.SetBasicAuth(config.Username, config.Password)
+	}
+	instrument(client, "webdav", webdavEndpoint)
+
+	return newWebDAVClient(config.URL, client), nil
+}
+
+// newWebDAVClient creates WebDAV client with configured HTTP client
+func newWebDAVClient(rootURL string, client *resty.Client) *WebDAVClient {
+	rootURL = strings.TrimSuffix(rootURL, "/")
+
+	rootPath := ""
+	if root, err := url.Parse(rootURL); err == nil {
+		rootPath = root.EscapedPath()
+	}
+
+	return &WebDAVClient{
+		RootURL:  rootURL,
+		rootPath: rootPath,
+		client:   client,
+	}
+}
+
+func init() {
+	backend.Register("webdav", webdavFactory("https"))
+	backend.Register("webdav+http", webdavFactory("http"))
+}
+
+// webdavFactory creates WebDAV backends for remotes like webdav://user@host/path,
+// the root of the WebDAV tree on the server is set with webdav.root option
+func webdavFactory(scheme string) backend.Factory {
+	return func(ctx context.Context, remote backend.Remote, opts backend.Options) (backend.Backend, error) {
+		if remote.Host == "" {
+			return nil, fmt.Errorf("WebDAV host is required")
+		}
+
+		config := WebDAVConfig{
+			URL:         scheme + "://" + remote.Host + "/" + strings.Trim(opts.GetString("webdav.root"), "/"),
+			Username:    remote.User,
+			Password:    remote.Password,
+			BearerToken: opts.GetString("webdav.bearer_token"),
+		}
+		if config.Username == "" {
+			config.Username = opts.GetString("webdav.username")
+		}
+		if config.Password == "" {
+			config.Password = opts.GetString("webdav.password")
+		}
+
+		return NewWebDAVClient(config)
+	}
+}
+
+// EscapedPaths reports that paths returned by WebDAV are URL-escaped hrefs
+func (wd *WebDAVClient) EscapedPaths() bool {
+	return true
+}
+
+// url returns URL of resource
+func (wd *WebDAVClient) url(filePath string) string {
+	return wd.RootURL + "/" + strings.TrimPrefix(filePath, "/")
+}
+
+// relativePath converts href to path relative to root URL
+func (wd *WebDAVClient) relativePath(href string) string {
+	return hrefRelativeTo(wd.rootPath, href)
+}
+
+// hrefRelativeTo converts href to path relative to escaped root path, href is returned as is if it's outside of root
+func hrefRelativeTo(rootPath, href string) string {
+	// Some servers return absolute URLs instead of paths
+	if u, err := url.Parse(href); err == nil && u.Scheme != "" {
+		href = u.EscapedPath()
+	}
+	if strings.HasPrefix(href, rootPath) {
+		return strings.TrimPrefix(href, rootPath)
+	}
+
+	// Servers escape root differently, e.g. @ in user name as %40, so compare decoded segments
+	rootSegments := strings.Split(rootPath, "/")
+	hrefSegments := strings.SplitN(href, "/", len(rootSegments)+1)
+	if len(hrefSegments) <= len(rootSegments) {
+		return href
+	}
+	for i, segment := range rootSegments {
+		root, err1 := url.PathUnescape(segment)
+		decoded, err2 := url.PathUnescape(hrefSegments[i])
+		if err1 != nil || err2 != nil || root != decoded {
+			return href
+		}
+	}
+	return "/" + hrefSegments[len(rootSegments)]
+}
+
+// propfind requests properties of resource and its children up to depth
+func (wd *WebDAVClient) propfind(ctx context.Context, resourceURL, depth, body string) (*MultiStatus, error) {
+	resp, err := wd.client.R().
+		SetContext(ctx).
+		SetHeader("Depth", depth).
+		SetHeader("Content-Type", "application/xml").
+		SetBody(body).
+		Execute("PROPFIND", resourceURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode() != http.StatusMultiStatus {
+		return nil, responseError(resp)
+	}
+
+	var multiStatus MultiStatus
+	if err := xml.Unmarshal(resp.Body(), &multiStatus); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return &multiStatus, nil
+}
+
+// Authenticate checks connection to the server
+func (wd *WebDAVClient) Authenticate(ctx context.Context) error {
+	if _, err := wd.propfind(ctx, wd.RootURL+"/", "0", filePropfind); err != nil {
+		return fmt.Errorf("failed to connect to WebDAV server: %w", err)
+	}
+	return nil
+}
+
+// ListFiles gets list of files in folder
+func (wd *WebDAVClient) ListFiles(ctx context.Context, folderPath string) ([]models.FileInfo, error) {
+	multiStatus, err := wd.propfind(ctx, wd.url(folderPath), "1", filePropfind)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	var files []models.FileInfo
+	for i, response := range multiStatus.Responses {
+		if i == 0 {
+			continue // Skip first element (the folder itself)
+		}
+		files = append(files, wd.fileInfo(response))
+	}
+
+	return files, nil
+}
+
+// fileInfo converts WebDAV response to models.FileInfo
+func (wd *WebDAVClient) fileInfo(response WebDAVResponse) models.FileInfo {
+	filePath := wd.relativePath(response.Href)
+	name := response.Props.DisplayName
+	if name == "" {
+		// Display name is optional, Nextcloud doesn't report it
+		name = path.Base(strings.TrimSuffix(filePath, "/"))
+		if decoded, err := url.PathUnescape(name); err == nil {
+			name = decoded
+		}
+	}
+
+	return models.FileInfo{
+		Name:        name,
+		Path:        filePath,
+		Size:        response.Props.GetContentLength,
+		IsDir:       response.Props.ResourceType.Collection != nil,
+		ModTime:     response.Props.GetLastModified.Time,
+		ETag:        strings.Trim(response.Props.GetETag, `"`),
+		ContentType: response.Props.GetContentType,
+		ID:          response.Props.FileID,
+	}
+}
+
+// UploadFile uploads file
+func (wd *WebDAVClient) UploadFile(ctx context.Context, filePath string, content io.Reader, size int64) error {
+	req := wd.client.R().
+		SetContext(ctx).
+		SetBody(content)
+	if size >= 0 {
+		req.SetHeader("Content-Length", strconv.FormatInt(size, 10))
+	}
+	resp, err := req.Put(wd.url(filePath))
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	if resp.StatusCode() != http.StatusCreated && resp.StatusCode() != http.StatusNoContent &&
+		resp.StatusCode() != http.StatusOK {
+		return fmt.Errorf("upload failed: %w", responseError(resp))
+	}
+
+	return nil
+}
+
+// DownloadFile downloads file
+func (wd *WebDAVClient) DownloadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	resp, err := wd.client.R().
+		SetContext(ctx).
+		SetDoNotParseResponse(true).
+		Get(wd.url(filePath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		resp.RawBody().Close()
+		return nil, fmt.Errorf("download failed: %w", responseError(resp))
+	}
+
+	return resp.RawBody(), nil
+}
+
+// CreateFolder creates folder
+func (wd *WebDAVClient) CreateFolder(ctx context.Context, folderPath string) error {
+	resp, err := wd.client.R().
+		SetContext(ctx).
+		Execute("MKCOL", wd.url(folderPath))
+	if err != nil {
+		return fmt.Errorf("failed to create folder: %w", err)
+	}
+
+	if resp.StatusCode() != http.StatusCreated {
+		return fmt.Errorf("create folder failed: %w", responseError(resp))
+	}
+
+	return nil
+}
+
+// GetFileInfo gets file information
+func (wd *WebDAVClient) GetFileInfo(ctx context.Context, filePath string) (*models.FileInfo, error) {
+	multiStatus, err := wd.propfind(ctx, wd.url(filePath), "0", filePropfind)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+	if len(multiStatus.Responses) == 0 {
+		return nil, fmt.Errorf("file not found: %s: %w", filePath, backend.ErrNotFound)
+	}
+
+	info := wd.fileInfo(multiStatus.Responses[0])
+	return &info, nil
+}
+
+// Delete deletes file or folder, trash handling is up to the server
+func (wd *WebDAVClient) Delete(ctx context.Context, filePath string, permanently bool) error {
+	resp, err := wd.client.R().
+		SetContext(ctx).
+		Delete(wd.url(filePath))
+	if err != nil {
+		return fmt.Errorf("failed to delete: %w", err)
+	}
+
+	if resp.StatusCode() != http.StatusNoContent && resp.StatusCode() != http.StatusOK {
+		return fmt.Errorf("delete failed: %w", responseError(resp))
+	}
+
+	return nil
+}
+
+// Move moves file or folder on the server side
+func (wd *WebDAVClient) Move(ctx context.Context, from, to string) error {
+	return wd.transfer(ctx, "MOVE", from, to)
+}
+
+// Copy copies file or folder on the server side
+func (wd *WebDAVClient) Copy(ctx context.Context, from, to string) error {
+	return wd.transfer(ctx, "COPY", from, to)
+}
+
+// transfer executes MOVE or COPY request overwriting destination
+func (wd *WebDAVClient) transfer(ctx context.Context, method, from, to string) error {
+	resp, err := wd.client.R().
+		SetContext(ctx).
+		SetHeader("Destination", wd.url(to)).
+		SetHeader("Overwrite", "T").
+		Execute(method, wd.url(from))
+	if err != nil {
+		return fmt.Errorf("failed to %s: %w", strings.ToLower(method), err)
+	}
+
+	if resp.StatusCode() != http.StatusCreated && resp.StatusCode() != http.StatusNoContent {
+		return fmt.Errorf("%s failed: %w", strings.ToLower(method), responseError(resp))
+	}
+
+	return nil
+}
@@ -0,0 +1,25 @@
+package clients
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"nextya-sync/backend/backendtest"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAVConformance(t *testing.T) {
+	server := httptest.NewServer(&webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.Dir(t.TempDir()),
+		LockSystem: webdav.NewMemLS(),
+	})
+	defer server.Close()
+
+	client, err := NewWebDAVClient(WebDAVConfig{URL: server.URL + "/dav"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backendtest.Run(t, client, "/")
+}
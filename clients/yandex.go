@@ -7,17 +7,28 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"nextya-sync/backend"
 	"nextya-sync/models"
 
 	"github.com/go-resty/resty/v2"
 )
 
+// YandexAPIURL default base URL of Yandex Disk REST API
+const YandexAPIURL = "https://cloud-api.yandex.net/v1/disk"
+
+// yandexPageSize number of resources requested per page of listing
+const yandexPageSize = 1000
+
 // YandexDiskClient client for working with Yandex Disk API
 type YandexDiskClient struct {
-	Token  string
-	client *resty.Client
+	Token     string
+	overwrite bool
+	// pollInterval delay between checks of asynchronous operation status
+	pollInterval time.Duration
+	client       *resty.Client
 }
 
 // YandexDiskResource structure for file/folder in Yandex Disk
@@ -29,6 +40,12 @@ type YandexDiskResource struct {
 	Modified time.Time `json:"modified"`
 	MimeType string    `json:"mime_type"`
 	File     string    `json:"file,omitempty"`
+
+	CustomProperties map[string]string `json:"custom_properties,omitempty"`
+
+	// Trash only fields
+	OriginPath string    `json:"origin_path,omitempty"`
+	Deleted    time.Time `json:"deleted,omitempty"`
 }
 
 // YandexDiskResourceList structure for file list
@@ -37,6 +54,19 @@ type YandexDiskResourceList struct {
 	Total int                  `json:"total"`
 }
 
+// YandexDiskInfo structure for disk information
+type YandexDiskInfo struct {
+	TotalSpace        int64 `json:"total_space"`
+	UsedSpace         int64 `json:"used_space"`
+	TrashSize         int64 `json:"trash_size"`
+	MaxFileUploadSize int64 `json:"max_file_upload_size"`
+}
+
+// YandexDiskOperation structure for asynchronous operation status
+type YandexDiskOperation struct {
+	Status string `json:"status"`
+}
+
 // YandexDiskLink structure for upload/download links
 type YandexDiskLink struct {
 	Href      string `json:"href"`
@@ -51,72 +81,113 @@ func NewYandexDiskClient(token string) *YandexDiskClient {
 	}
 
 	client := resty.New()
+	client.SetBaseURL(YandexAPIURL)
 	client.SetHeader("Authorization", "OAuth "+token)
 	client.SetHeader("Content-Type", "application/json")
+	instrument(client, "yandex", yandexEndpoint(func() string { return client.BaseURL }))
 
 	return &YandexDiskClient{
-		Token:  token,
-		client: client,
+		Token:        token,
+		overwrite:    true,
+		pollInterval: time.Second,
+		client:       client,
 	}
 }
 
+func init() {
+	backend.Register("yandex", newYandexBackend)
+}
+
+// newYandexBackend creates Yandex Disk backend for remotes like yandex:disk:/path
+// using yandex.token and optional yandex.api_url options
+func newYandexBackend(ctx context.Context, remote backend.Remote, opts backend.Options) (backend.Backend, error) {
+	token := opts.GetString("yandex.token")
+	if token == "" {
+		return nil, fmt.Errorf("Yandex token is required")
+	}
+
+	client := NewYandexDiskClient(token)
+	if apiURL := opts.GetString("yandex.api_url"); apiURL != "" {
+		client.SetAPIURL(apiURL)
+	}
+	return client, nil
+}
+
+// SetAPIURL sets base URL of the REST API, e.g. of a proxy or a test server
+func (yd *YandexDiskClient) SetAPIURL(apiURL string) {
+	yd.client.SetBaseURL(strings.TrimSuffix(apiURL, "/"))
+}
+
+// SetOverwrite allows or forbids overwriting existing files on upload
+func (yd *YandexDiskClient) SetOverwrite(overwrite bool) {
+	yd.overwrite = overwrite
+}
+
 // Authenticate checks token validity
 func (yd *YandexDiskClient) Authenticate(ctx context.Context) error {
 	resp, err := yd.client.R().
 		SetContext(ctx).
-		Get("https://cloud-api.yandex.net/v1/disk/")
+		Get("/")
 	if err != nil {
 		return fmt.Errorf("failed to connect to Yandex Disk: %w", err)
 	}
 
 	if resp.StatusCode() != http.StatusOK {
-		return fmt.Errorf("authentication failed: status %d", resp.StatusCode())
+		return fmt.Errorf("authentication failed: %w", responseError(resp))
 	}
 
 	return nil
 }
 
-// ListFiles gets list of files in folder
+// ListFiles gets list of files in folder, reading all pages of listing
 func (yd *YandexDiskClient) ListFiles(ctx context.Context, folderPath string) ([]models.FileInfo, error) {
 	if folderPath == "" {
 		folderPath = "/"
 	}
 
-	resp, err := yd.client.R().
-		SetContext(ctx).
-		SetQueryParam("path", folderPath).
-		SetQueryParam("limit", "1000").
-		Get("https://cloud-api.yandex.net/v1/disk/resources")
-	if err != nil {
-		return nil, fmt.Errorf("failed to list files: %w", err)
-	}
-
-	if resp.StatusCode() != http.StatusOK {
-		return nil, fmt.Errorf("list files failed: status %d", resp.StatusCode())
-	}
-
-	var folderInfo struct {
-		Embedded YandexDiskResourceList `json:"_embedded"`
-	}
-
-	if err := json.Unmarshal(resp.Body(), &folderInfo); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
-	}
-
 	var files []models.FileInfo
-	for _, item := range folderInfo.Embedded.Items {
-		files = append(files, models.FileInfo{
-			Name:        item.Name,
-			Path:        item.Path,
-			Size:        item.Size,
-			IsDir:       item.Type == "dir",
-			ModTime:     item.Modified,
-			ContentType: item.MimeType,
-			DownloadURL: item.File,
-		})
-	}
-
-	return files, nil
+	for offset := 0; ; {
+		resp, err := yd.client.R().
+			SetContext(ctx).
+			SetQueryParam("path", folderPath).
+			SetQueryParam("limit", strconv.Itoa(yandexPageSize)).
+			SetQueryParam("offset", strconv.Itoa(offset)).
+			Get("/resources")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files: %w", err)
+		}
+
+		if resp.StatusCode() != http.StatusOK {
+			return nil, fmt.Errorf("list files failed: %w", responseError(resp))
+		}
+
+		var folderInfo struct {
+			Embedded YandexDiskResourceList `json:"_embedded"`
+		}
+
+		if err := json.Unmarshal(resp.Body(), &folderInfo); err != nil {
+			return nil, fmt.Errorf("failed to parse response: %w", err)
+		}
+
+		for _, item := range folderInfo.Embedded.Items {
+			files = append(files, models.FileInfo{
+				Name:        item.Name,
+				Path:        item.Path,
+				Size:        item.Size,
+				IsDir:       item.Type == "dir",
+				ModTime:     item.Modified,
+				ContentType: item.MimeType,
+				DownloadURL: item.File,
+				Properties:  item.CustomProperties,
+			})
+		}
+
+		// Server may return fewer items than requested, the total tells whether more pages follow
+		offset += len(folderInfo.Embedded.Items)
+		if len(folderInfo.Embedded.Items) == 0 || offset >= folderInfo.Embedded.Total {
+			return files, nil
+		}
+	}
 }
 
 // UploadFile uploads file
@@ -127,18 +198,20 @@ func (yd *YandexDiskClient) UploadFile(ctx context.Context, filePath string, con
 		return fmt.Errorf("failed to get upload URL: %w", err)
 	}
 
-	// Upload file using the obtained URL
-	resp, err := yd.client.R().
+	// Upload file using the obtained URL, size is unknown for streamed content
+	req := yd.client.R().
 		SetContext(ctx).
-		SetBody(content).
-		SetHeader("Content-Length", strconv.FormatInt(size, 10)).
-		Put(uploadURL)
+		SetBody(content)
+	if size >= 0 {
+		req.SetHeader("Content-Length", strconv.FormatInt(size, 10))
+	}
+	resp, err := req.Put(uploadURL)
 	if err != nil {
 		return fmt.Errorf("failed to upload file: %w", err)
 	}
 
 	if resp.StatusCode() != http.StatusCreated {
-		return fmt.Errorf("upload failed: status %d", resp.StatusCode())
+		return fmt.Errorf("upload failed: %w", responseError(resp))
 	}
 
 	return nil
@@ -149,14 +222,14 @@ func (yd *YandexDiskClient) getUploadURL(ctx context.Context, filePath string) (
 	resp, err := yd.client.R().
 		SetContext(ctx).
 		SetQueryParam("path", filePath).
-		SetQueryParam("overwrite", "true").
-		Get("https://cloud-api.yandex.net/v1/disk/resources/upload")
+		SetQueryParam("overwrite", strconv.FormatBool(yd.overwrite)).
+		Get("/resources/upload")
 	if err != nil {
 		return "", err
 	}
 
 	if resp.StatusCode() != http.StatusOK {
-		return "", fmt.Errorf("get upload URL failed: status %d", resp.StatusCode())
+		return "", fmt.Errorf("get upload URL failed: %w", responseError(resp))
 	}
 
 	var link YandexDiskLink
@@ -186,7 +259,7 @@ func (yd *YandexDiskClient) DownloadFile(ctx context.Context, filePath string) (
 
 	if resp.StatusCode() != http.StatusOK {
 		resp.RawBody().Close()
-		return nil, fmt.Errorf("download failed: status %d", resp.StatusCode())
+		return nil, fmt.Errorf("download failed: %w", responseError(resp))
 	}
 
 	return resp.RawBody(), nil
@@ -197,13 +270,13 @@ func (yd *YandexDiskClient) getDownloadURL(ctx context.Context, filePath string)
 	resp, err := yd.client.R().
 		SetContext(ctx).
 		SetQueryParam("path", filePath).
-		Get("https://cloud-api.yandex.net/v1/disk/resources/download")
+		Get("/resources/download")
 	if err != nil {
 		return "", err
 	}
 
 	if resp.StatusCode() != http.StatusOK {
-		return "", fmt.Errorf("get download URL failed: status %d", resp.StatusCode())
+		return "", fmt.Errorf("get download URL failed: %w", responseError(resp))
 	}
 
 	var link YandexDiskLink
@@ -219,13 +292,13 @@ func (yd *YandexDiskClient) CreateFolder(ctx context.Context, folderPath string)
 	resp, err := yd.client.R().
 		SetContext(ctx).
 		SetQueryParam("path", folderPath).
-		Put("https://cloud-api.yandex.net/v1/disk/resources")
+		Put("/resources")
 	if err != nil {
 		return fmt.Errorf("failed to create folder: %w", err)
 	}
 
 	if resp.StatusCode() != http.StatusCreated {
-		return fmt.Errorf("create folder failed: status %d", resp.StatusCode())
+		return fmt.Errorf("create folder failed: %w", responseError(resp))
 	}
 
 	return nil
@@ -236,13 +309,13 @@ func (yd *YandexDiskClient) GetFileInfo(ctx context.Context, filePath string) (*
 	resp, err := yd.client.R().
 		SetContext(ctx).
 		SetQueryParam("path", filePath).
-		Get("https://cloud-api.yandex.net/v1/disk/resources")
+		Get("/resources")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get file info: %w", err)
 	}
 
 	if resp.StatusCode() != http.StatusOK {
-		return nil, fmt.Errorf("get file info failed: status %d", resp.StatusCode())
+		return nil, fmt.Errorf("get file info failed: %w", responseError(resp))
 	}
 
 	var resource YandexDiskResource
@@ -258,5 +331,215 @@ func (yd *YandexDiskClient) GetFileInfo(ctx context.Context, filePath string) (*
 		ModTime:     resource.Modified,
 		ContentType: resource.MimeType,
 		DownloadURL: resource.File,
+		Properties:  resource.CustomProperties,
 	}, nil
 }
+
+// SetProperties sets custom properties of resource
+func (yd *YandexDiskClient) SetProperties(ctx context.Context, path string, props map[string]string) error {
+	resp, err := yd.client.R().
+		SetContext(ctx).
+		SetQueryParam("path", path).
+		SetBody(map[string]any{"custom_properties": props}).
+		Patch("/resources")
+	if err != nil {
+		return fmt.Errorf("failed to set properties: %w", err)
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		return fmt.Errorf("set properties failed: %w", responseError(resp))
+	}
+
+	return nil
+}
+
+// Move moves resource on the server side, overwriting destination
+func (yd *YandexDiskClient) Move(ctx context.Context, from, to string) error {
+	resp, err := yd.client.R().
+		SetContext(ctx).
+		SetQueryParam("from", from).
+		SetQueryParam("path", to).
+		SetQueryParam("overwrite", "true").
+		Post("/resources/move")
+	if err != nil {
+		return fmt.Errorf("failed to move resource: %w", err)
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusCreated:
+		return nil
+	case http.StatusAccepted:
+		return yd.waitOperation(ctx, resp.Body())
+	default:
+		return fmt.Errorf("move failed: %w", responseError(resp))
+	}
+}
+
+// waitOperation polls asynchronous operation referenced by link until it finishes
+func (yd *YandexDiskClient) waitOperation(ctx context.Context, body []byte) error {
+	var link YandexDiskLink
+	if err := json.Unmarshal(body, &link); err != nil {
+		return fmt.Errorf("failed to parse operation link: %w", err)
+	}
+
+	for {
+		resp, err := yd.client.R().
+			SetContext(ctx).
+			Get(link.Href)
+		if err != nil {
+			return fmt.Errorf("failed to get operation status: %w", err)
+		}
+
+		if resp.StatusCode() != http.StatusOK {
+			return fmt.Errorf("get operation status failed: %w", responseError(resp))
+		}
+
+		var operation YandexDiskOperation
+		if err := json.Unmarshal(resp.Body(), &operation); err != nil {
+			return fmt.Errorf("failed to parse operation status: %w", err)
+		}
+
+		switch operation.Status {
+		case "success":
+			return nil
+		case "failed":
+			return fmt.Errorf("operation failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(yd.pollInterval):
+		}
+	}
+}
+
+// Delete deletes resource, moving it to trash unless permanently is set
+func (yd *YandexDiskClient) Delete(ctx context.Context, path string, permanently bool) error {
+	resp, err := yd.client.R().
+		SetContext(ctx).
+		SetQueryParam("path", path).
+		SetQueryParam("permanently", strconv.FormatBool(permanently)).
+		Delete("/resources")
+	if err != nil {
+		return fmt.Errorf("failed to delete resource: %w", err)
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusNoContent:
+		return nil
+	case http.StatusAccepted:
+		return yd.waitOperation(ctx, resp.Body())
+	default:
+		return fmt.Errorf("delete failed: %w", responseError(resp))
+	}
+}
+
+// GetQuota gets disk space information
+func (yd *YandexDiskClient) GetQuota(ctx context.Context) (*models.Quota, error) {
+	resp, err := yd.client.R().
+		SetContext(ctx).
+		Get("/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disk info: %w", err)
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("get disk info failed: %w", responseError(resp))
+	}
+
+	var info YandexDiskInfo
+	if err := json.Unmarshal(resp.Body(), &info); err != nil {
+		return nil, fmt.Errorf("failed to parse disk info response: %w", err)
+	}
+
+	return &models.Quota{
+		Total:       info.TotalSpace,
+		Used:        info.UsedSpace,
+		Trash:       info.TrashSize,
+		MaxFileSize: info.MaxFileUploadSize,
+	}, nil
+}
+
+// ListTrash gets list of all resources in trash
+func (yd *YandexDiskClient) ListTrash(ctx context.Context) ([]models.TrashItem, error) {
+	var items []models.TrashItem
+	for offset := 0; ; {
+		resp, err := yd.client.R().
+			SetContext(ctx).
+			SetQueryParam("path", "trash:/").
+			SetQueryParam("limit", strconv.Itoa(yandexPageSize)).
+			SetQueryParam("offset", strconv.Itoa(offset)).
+			Get("/trash/resources")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list trash: %w", err)
+		}
+
+		if resp.StatusCode() != http.StatusOK {
+			return nil, fmt.Errorf("list trash failed: %w", responseError(resp))
+		}
+
+		var trash struct {
+			Embedded YandexDiskResourceList `json:"_embedded"`
+		}
+		if err := json.Unmarshal(resp.Body(), &trash); err != nil {
+			return nil, fmt.Errorf("failed to parse response: %w", err)
+		}
+
+		for _, item := range trash.Embedded.Items {
+			items = append(items, models.TrashItem{
+				Name:       item.Name,
+				Path:       item.Path,
+				OriginPath: item.OriginPath,
+				Size:       item.Size,
+				IsDir:      item.Type == "dir",
+				Deleted:    item.Deleted,
+			})
+		}
+
+		offset += len(trash.Embedded.Items)
+		if len(trash.Embedded.Items) == 0 || offset >= trash.Embedded.Total {
+			return items, nil
+		}
+	}
+}
+
+// RestoreTrash restores resource from trash to its original location
+func (yd *YandexDiskClient) RestoreTrash(ctx context.Context, trashPath string) error {
+	resp, err := yd.client.R().
+		SetContext(ctx).
+		SetQueryParam("path", trashPath).
+		Put("/trash/resources/restore")
+	if err != nil {
+		return fmt.Errorf("failed to restore from trash: %w", err)
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusCreated:
+		return nil
+	case http.StatusAccepted:
+		return yd.waitOperation(ctx, resp.Body())
+	default:
+		return fmt.Errorf("restore from trash failed: %w", responseError(resp))
+	}
+}
+
+// DeleteTrash permanently deletes resource from trash
+func (yd *YandexDiskClient) DeleteTrash(ctx context.Context, trashPath string) error {
+	resp, err := yd.client.R().
+		SetContext(ctx).
+		SetQueryParam("path", trashPath).
+		Delete("/trash/resources")
+	if err != nil {
+		return fmt.Errorf("failed to delete from trash: %w", err)
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusNoContent:
+		return nil
+	case http.StatusAccepted:
+		return yd.waitOperation(ctx, resp.Body())
+	default:
+		return fmt.Errorf("delete from trash failed: %w", responseError(resp))
+	}
+}
@@ -0,0 +1,128 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"nextya-sync/backend/backendtest"
+	"nextya-sync/internal/fakeyandex"
+)
+
+// newFakeYandex starts fake Yandex Disk and returns client connected to it
+func newFakeYandex(t *testing.T) (*fakeyandex.Server, *YandexDiskClient) {
+	server := fakeyandex.New("token", t.TempDir())
+	t.Cleanup(server.Close)
+
+	client := NewYandexDiskClient("token")
+	client.SetAPIURL(server.APIURL())
+	client.pollInterval = time.Millisecond
+	return server, client
+}
+
+func TestYandexConformance(t *testing.T) {
+	_, client := newFakeYandex(t)
+	backendtest.Run(t, client, "disk:/")
+}
+
+func TestYandexAuthenticate(t *testing.T) {
+	server, client := newFakeYandex(t)
+	if err := client.Authenticate(context.Background()); err != nil {
+		t.Errorf("Authenticate with valid token: %v", err)
+	}
+
+	wrong := NewYandexDiskClient("wrong")
+	wrong.SetAPIURL(server.APIURL())
+	if err := wrong.Authenticate(context.Background()); err == nil {
+		t.Errorf("Authenticate succeeded with wrong token")
+	}
+}
+
+func TestYandexListFilesPagination(t *testing.T) {
+	server, client := newFakeYandex(t)
+	server.MaxLimit = 2
+	for i := range 5 {
+		if err := server.WriteFile(fmt.Sprintf("/folder/file%d.txt", i), []byte("x"), time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := client.ListFiles(context.Background(), "disk:/folder")
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	if len(files) != 5 {
+		t.Errorf("ListFiles returned %d files, want 5", len(files))
+	}
+	if got := server.Requests("GET /resources"); got != 3 {
+		t.Errorf("ListFiles made %d requests, want 3 pages", got)
+	}
+}
+
+func TestYandexOverwrite(t *testing.T) {
+	_, client := newFakeYandex(t)
+	ctx := context.Background()
+	upload := func(content string) error {
+		return client.UploadFile(ctx, "disk:/file.txt", bytes.NewReader([]byte(content)), int64(len(content)))
+	}
+
+	if err := upload("first"); err != nil {
+		t.Fatal(err)
+	}
+	client.SetOverwrite(false)
+	if err := upload("second"); err == nil {
+		t.Errorf("upload overwrote existing file with overwriting disabled")
+	}
+}
+
+func TestYandexTrash(t *testing.T) {
+	server, client := newFakeYandex(t)
+	server.PendingPolls = 2
+	ctx := context.Background()
+	if err := server.WriteFile("/docs/a.txt", []byte("a"), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.WriteFile("/old/b.txt", []byte("b"), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Delete(ctx, "disk:/docs/a.txt", false); err != nil {
+		t.Fatalf("Delete file: %v", err)
+	}
+	// Folders are deleted by asynchronous operation
+	if err := client.Delete(ctx, "disk:/old", false); err != nil {
+		t.Fatalf("Delete folder: %v", err)
+	}
+
+	items, err := client.ListTrash(ctx)
+	if err != nil {
+		t.Fatalf("ListTrash: %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("ListTrash returned %d items, want 2", len(items))
+	}
+
+	for _, item := range items {
+		switch item.OriginPath {
+		case "disk:/docs/a.txt":
+			if err := client.RestoreTrash(ctx, item.Path); err != nil {
+				t.Errorf("RestoreTrash: %v", err)
+			}
+		case "disk:/old":
+			if err := client.DeleteTrash(ctx, item.Path); err != nil {
+				t.Errorf("DeleteTrash: %v", err)
+			}
+		default:
+			t.Errorf("unexpected trash item %+v", item)
+		}
+	}
+
+	if data, err := server.ReadFile("/docs/a.txt"); err != nil || string(data) != "a" {
+		t.Errorf("restored file = %q, %v", data, err)
+	}
+	if paths := server.TrashPaths(); len(paths) != 0 {
+		t.Errorf("trash is not empty: %v", paths)
+	}
+}
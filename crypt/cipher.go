@@ -0,0 +1,295 @@
+package crypt
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// chunkSize size of plaintext chunk sealed as one AEAD message
+	chunkSize = 64 * 1024
+	// tagSize size of GCM authentication tag appended to every chunk
+	tagSize = 16
+	// saltSize size of random per-file salt stored in header
+	saltSize = 16
+	// magicSize size of magic prefix identifying encrypted content
+	magicSize = 8
+	// headerSize size of encrypted file header (magic + salt)
+	headerSize = magicSize + saltSize
+	// keySize size of AES-256 key
+	keySize = 32
+
+	// pbkdf2Iterations number of PBKDF2 iterations for passphrase derivation
+	pbkdf2Iterations = 600000
+)
+
+var (
+	magic = []byte("NYSCRPT1")
+
+	// defaultSalt fixed salt for passphrase derivation, names must be deterministic across runs
+	defaultSalt = []byte("nextya-sync/crypt/v1")
+
+	nameEncoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+	// ErrBadHeader returned when content doesn't start with the expected header
+	ErrBadHeader = errors.New("crypt: bad file header")
+	// ErrBadName returned when name can't be decrypted
+	ErrBadName = errors.New("crypt: bad encrypted name")
+)
+
+// Cipher encrypts file content and names with keys derived from a master key
+type Cipher struct {
+	contentKey   []byte
+	nameKey      []byte
+	nameAEAD     cipher.AEAD
+	encryptNames bool
+}
+
+// KeyFromPassphrase derives master key from passphrase
+func KeyFromPassphrase(passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	return pbkdf2.Key(sha256.New, passphrase, defaultSalt, pbkdf2Iterations, keySize)
+}
+
+// KeyFromFile derives master key from key file content
+func KeyFromFile(keyFile string) ([]byte, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	if len(data) < keySize {
+		return nil, fmt.Errorf("key file must contain at least %d bytes", keySize)
+	}
+	return hkdf.Key(sha256.New, data, defaultSalt, "master", keySize)
+}
+
+// NewCipher creates a new cipher from master key
+func NewCipher(masterKey []byte, encryptNames bool) (*Cipher, error) {
+	contentKey, err := hkdf.Key(sha256.New, masterKey, nil, "content", keySize)
+	if err != nil {
+		return nil, err
+	}
+	nameKey, err := hkdf.Key(sha256.New, masterKey, nil, "name", keySize)
+	if err != nil {
+		return nil, err
+	}
+	nameAEAD, err := newGCM(nameKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cipher{
+		contentKey:   contentKey,
+		nameKey:      nameKey,
+		nameAEAD:     nameAEAD,
+		encryptNames: encryptNames,
+	}, nil
+}
+
+// EncryptName encrypts single path component.
+// Nonce is derived from the name itself so the same name always maps to the same ciphertext.
+func (c *Cipher) EncryptName(name string) string {
+	if !c.encryptNames || name == "" {
+		return name
+	}
+
+	mac := hmac.New(sha256.New, c.nameKey)
+	mac.Write([]byte(name))
+	nonce := mac.Sum(nil)[:c.nameAEAD.NonceSize()]
+
+	sealed := c.nameAEAD.Seal(nonce, nonce, []byte(name), nil)
+	return strings.ToLower(nameEncoding.EncodeToString(sealed))
+}
+
+// DecryptName decrypts single path component
+func (c *Cipher) DecryptName(name string) (string, error) {
+	if !c.encryptNames || name == "" {
+		return name, nil
+	}
+
+	sealed, err := nameEncoding.DecodeString(strings.ToUpper(name))
+	if err != nil || len(sealed) < c.nameAEAD.NonceSize()+tagSize {
+		return "", ErrBadName
+	}
+
+	nonceSize := c.nameAEAD.NonceSize()
+	plain, err := c.nameAEAD.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", ErrBadName
+	}
+	return string(plain), nil
+}
+
+// EncryptedSize returns size of encrypted content for plaintext of given size
+func EncryptedSize(size int64) int64 {
+	if size < 0 {
+		return -1
+	}
+	chunks := (size + chunkSize - 1) / chunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return int64(headerSize) + size + chunks*tagSize
+}
+
+// DecryptedSize returns size of plaintext for encrypted content of given size
+func DecryptedSize(size int64) (int64, error) {
+	body := size - int64(headerSize)
+	if body < tagSize {
+		return 0, fmt.Errorf("crypt: encrypted size %d is too small", size)
+	}
+	chunks := (body + chunkSize + tagSize - 1) / (chunkSize + tagSize)
+	plain := body - chunks*tagSize
+	if plain < 0 {
+		return 0, fmt.Errorf("crypt: invalid encrypted size %d", size)
+	}
+	return plain, nil
+}
+
+// EncryptReader returns reader producing encrypted stream of r
+func (c *Cipher) EncryptReader(r io.Reader) (io.Reader, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	aead, err := c.fileAEAD(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, magic...)
+	header = append(header, salt...)
+
+	return &streamReader{
+		src:     bufio.NewReaderSize(r, chunkSize+1),
+		aead:    aead,
+		inSize:  chunkSize,
+		pending: header,
+		seal:    true,
+	}, nil
+}
+
+// DecryptReader returns reader producing plaintext of encrypted stream r
+func (c *Cipher) DecryptReader(r io.Reader) (io.Reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrBadHeader
+	}
+	if !hmac.Equal(header[:magicSize], magic) {
+		return nil, ErrBadHeader
+	}
+
+	aead, err := c.fileAEAD(header[magicSize:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &streamReader{
+		src:    bufio.NewReaderSize(r, chunkSize+tagSize+1),
+		aead:   aead,
+		inSize: chunkSize + tagSize,
+	}, nil
+}
+
+// fileAEAD creates AEAD with per-file key derived from salt
+func (c *Cipher) fileAEAD(salt []byte) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, c.contentKey, salt, "file", keySize)
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(key)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// streamReader seals or opens stream chunk by chunk.
+// Chunk nonce is the chunk counter with the last byte marking the final chunk,
+// so truncation and reordering are detected.
+type streamReader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	inSize  int
+	seal    bool
+	counter uint64
+	pending []byte
+	done    bool
+	err     error
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		s.err = s.next()
+	}
+
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// next processes the following chunk of the source stream
+func (s *streamReader) next() error {
+	buf := make([]byte, s.inSize)
+	n, err := io.ReadFull(s.src, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	buf = buf[:n]
+
+	final := n < s.inSize
+	if !final {
+		if _, err := s.src.Peek(1); err == io.EOF {
+			final = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], s.counter)
+	if final {
+		nonce[len(nonce)-1] = 1
+	}
+	s.counter++
+
+	if s.seal {
+		s.pending = s.aead.Seal(nil, nonce, buf, nil)
+	} else {
+		plain, err := s.aead.Open(nil, nonce, buf, nil)
+		if err != nil {
+			return fmt.Errorf("crypt: failed to decrypt chunk %d: %w", s.counter-1, err)
+		}
+		s.pending = plain
+	}
+
+	s.done = final
+	return nil
+}
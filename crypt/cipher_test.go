@@ -0,0 +1,225 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// newTestCipher returns cipher of fixed master key filled with seed
+func newTestCipher(t *testing.T, seed byte, encryptNames bool) *Cipher {
+	t.Helper()
+	c, err := NewCipher(bytes.Repeat([]byte{seed}, keySize), encryptNames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// encrypt returns encrypted stream of plain
+func encrypt(t *testing.T, c *Cipher, plain []byte) []byte {
+	t.Helper()
+	reader, err := c.EncryptReader(bytes.NewReader(plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("encrypting: %v", err)
+	}
+	return encrypted
+}
+
+// decrypt returns plaintext of encrypted stream
+func decrypt(c *Cipher, encrypted []byte) ([]byte, error) {
+	reader, err := c.DecryptReader(bytes.NewReader(encrypted))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+// sealChunk seals chunk the way streamReader does, final sets the final-chunk flag of the nonce
+func sealChunk(t *testing.T, c *Cipher, salt []byte, counter uint64, final bool, plain []byte) []byte {
+	t.Helper()
+	aead, err := c.fileAEAD(salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], counter)
+	if final {
+		nonce[len(nonce)-1] = 1
+	}
+	return aead.Seal(nil, nonce, plain, nil)
+}
+
+func TestContentRoundTrip(t *testing.T) {
+	c := newTestCipher(t, 1, false)
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		encrypted := encrypt(t, c, plain)
+		if int64(len(encrypted)) != EncryptedSize(int64(size)) {
+			t.Errorf("size %d: encrypted %d bytes, EncryptedSize = %d", size, len(encrypted), EncryptedSize(int64(size)))
+		}
+		if decryptedSize, err := DecryptedSize(int64(len(encrypted))); err != nil || decryptedSize != int64(size) {
+			t.Errorf("size %d: DecryptedSize = %d, %v", size, decryptedSize, err)
+		}
+
+		got, err := decrypt(c, encrypted)
+		if err != nil {
+			t.Fatalf("size %d: decrypting: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("size %d: decrypted %d bytes differ from plaintext", size, len(got))
+		}
+	}
+}
+
+func TestEncryptionIsRandomized(t *testing.T) {
+	c := newTestCipher(t, 1, false)
+	plain := []byte("same content")
+	if bytes.Equal(encrypt(t, c, plain), encrypt(t, c, plain)) {
+		t.Error("same content encrypted to the same stream twice")
+	}
+}
+
+func TestEncryptedSizeMatchesDecryptedSize(t *testing.T) {
+	for _, size := range []int64{0, 1, 100, chunkSize - 1, chunkSize, chunkSize + 1, 2 * chunkSize, 10*chunkSize + 7, 1 << 30} {
+		decrypted, err := DecryptedSize(EncryptedSize(size))
+		if err != nil || decrypted != size {
+			t.Errorf("DecryptedSize(EncryptedSize(%d)) = %d, %v", size, decrypted, err)
+		}
+	}
+	if EncryptedSize(-1) != -1 {
+		t.Errorf("EncryptedSize(-1) = %d, unknown size must stay unknown", EncryptedSize(-1))
+	}
+	for _, size := range []int64{0, headerSize, headerSize + tagSize - 1} {
+		if _, err := DecryptedSize(size); err == nil {
+			t.Errorf("DecryptedSize(%d) accepted size smaller than header and tag", size)
+		}
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	c := newTestCipher(t, 1, false)
+	plain := make([]byte, 2*chunkSize+100)
+	rand.Read(plain)
+	encrypted := encrypt(t, c, plain)
+	sealed := chunkSize + tagSize
+	chunk := func(i int) []byte {
+		start := headerSize + i*sealed
+		return encrypted[start:min(start+sealed, len(encrypted))]
+	}
+	salt := encrypted[magicSize:headerSize]
+
+	tests := map[string][]byte{
+		// Whole final chunk is dropped, the new last chunk isn't marked final
+		"truncated at chunk boundary": encrypted[:headerSize+2*sealed],
+		"truncated inside chunk":      encrypted[:len(encrypted)-10],
+		"reordered chunks":            concat(encrypted[:headerSize], chunk(1), chunk(0), chunk(2)),
+		"flipped bit":                 flipBit(encrypted, headerSize+sealed+5),
+		"appended chunk":              concat(encrypted, chunk(2)),
+		"final chunk not marked final": concat(encrypted[:headerSize+2*sealed],
+			sealChunk(t, c, salt, 2, false, plain[2*chunkSize:])),
+		"inner chunk marked final": concat(encrypted[:headerSize],
+			sealChunk(t, c, salt, 0, true, plain[:chunkSize]), chunk(1), chunk(2)),
+	}
+	for name, tampered := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := decrypt(c, tampered); err == nil {
+				t.Error("tampered stream was decrypted")
+			}
+		})
+	}
+
+	// Single chunk sealed without final flag is truncation of a longer stream
+	short := concat(encrypted[:headerSize], sealChunk(t, c, salt, 0, false, []byte("short")))
+	if _, err := decrypt(c, short); err == nil {
+		t.Error("stream without final chunk was decrypted")
+	}
+}
+
+func TestDecryptRejectsWrongKeyAndHeader(t *testing.T) {
+	encrypted := encrypt(t, newTestCipher(t, 1, false), []byte("secret"))
+
+	if _, err := decrypt(newTestCipher(t, 2, false), encrypted); err == nil {
+		t.Error("stream was decrypted with another key")
+	}
+	if _, err := decrypt(newTestCipher(t, 1, false), flipBit(encrypted, 0)); !errors.Is(err, ErrBadHeader) {
+		t.Errorf("stream with bad magic: %v, want ErrBadHeader", err)
+	}
+	if _, err := decrypt(newTestCipher(t, 1, false), encrypted[:headerSize-1]); !errors.Is(err, ErrBadHeader) {
+		t.Errorf("stream shorter than header: %v, want ErrBadHeader", err)
+	}
+}
+
+func TestNameRoundTrip(t *testing.T) {
+	c := newTestCipher(t, 1, true)
+	for _, name := range []string{"a", "report.pdf", "with space & ü.txt", strings.Repeat("long", 50)} {
+		encrypted := c.EncryptName(name)
+		if encrypted == name || encrypted != strings.ToLower(encrypted) || strings.ContainsAny(encrypted, "/=") {
+			t.Errorf("EncryptName(%q) = %q, want lowercase base32 without padding", name, encrypted)
+		}
+		got, err := c.DecryptName(encrypted)
+		if err != nil || got != name {
+			t.Errorf("DecryptName(EncryptName(%q)) = %q, %v", name, got, err)
+		}
+	}
+	if c.EncryptName("") != "" {
+		t.Error("empty name was encrypted")
+	}
+}
+
+func TestNameEncryptionIsDeterministic(t *testing.T) {
+	c := newTestCipher(t, 1, true)
+	if c.EncryptName("notes.txt") != c.EncryptName("notes.txt") {
+		t.Error("same name encrypted differently")
+	}
+	// Cipher of the same key maps names the same way across runs
+	if newTestCipher(t, 1, true).EncryptName("notes.txt") != c.EncryptName("notes.txt") {
+		t.Error("same name encrypted differently by cipher of the same key")
+	}
+	if c.EncryptName("notes.txt") == c.EncryptName("notes.txT") {
+		t.Error("different names encrypted to the same name")
+	}
+	if newTestCipher(t, 2, true).EncryptName("notes.txt") == c.EncryptName("notes.txt") {
+		t.Error("different keys encrypted name the same way")
+	}
+}
+
+func TestDecryptNameRejectsForeignNames(t *testing.T) {
+	c := newTestCipher(t, 1, true)
+	foreign := newTestCipher(t, 2, true).EncryptName("notes.txt")
+	for _, name := range []string{"plain.txt", "abc", foreign} {
+		if _, err := c.DecryptName(name); !errors.Is(err, ErrBadName) {
+			t.Errorf("DecryptName(%q) = %v, want ErrBadName", name, err)
+		}
+	}
+
+	plainNames := newTestCipher(t, 1, false)
+	if got := plainNames.EncryptName("notes.txt"); got != "notes.txt" {
+		t.Errorf("EncryptName without name encryption = %q", got)
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	var result []byte
+	for _, part := range parts {
+		result = append(result, part...)
+	}
+	return result
+}
+
+// flipBit returns copy of data with lowest bit of byte at offset flipped
+func flipBit(data []byte, offset int) []byte {
+	result := bytes.Clone(data)
+	result[offset] ^= 1
+	return result
+}
@@ -0,0 +1,245 @@
+package crypt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/models"
+)
+
+// Client encrypts everything stored below roots in the wrapped backend.
+// Callers keep working with plaintext paths, names and sizes.
+type Client struct {
+	backend backend.Backend
+	cipher  *Cipher
+	roots   []string
+}
+
+// NewClient creates a new encrypting client over backend
+func NewClient(inner backend.Backend, cipher *Cipher, roots ...string) *Client {
+	return &Client{
+		backend: inner,
+		cipher:  cipher,
+		roots:   roots,
+	}
+}
+
+// ListFiles gets list of files in folder with decrypted names and sizes
+func (c *Client) ListFiles(ctx context.Context, folderPath string) ([]models.FileInfo, error) {
+	files, err := c.backend.ListFiles(ctx, c.encryptPath(folderPath))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]models.FileInfo, 0, len(files))
+	for _, file := range files {
+		decrypted, err := c.decryptInfo(file)
+		if err != nil {
+			slog.Warn("Skipping file which can't be decrypted", "path", file.Path, "error", err)
+			continue
+		}
+		result = append(result, decrypted)
+	}
+
+	return result, nil
+}
+
+// DownloadFile downloads and decrypts file
+func (c *Client) DownloadFile(ctx context.Context, path string) (io.ReadCloser, error) {
+	reader, err := c.backend.DownloadFile(ctx, c.encryptPath(path))
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := c.cipher.DecryptReader(reader)
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("failed to decrypt %s: %w", path, err)
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{plain, reader}, nil
+}
+
+// UploadFile encrypts and uploads file
+func (c *Client) UploadFile(ctx context.Context, path string, content io.Reader, size int64) error {
+	encrypted, err := c.cipher.EncryptReader(content)
+	if err != nil {
+		return err
+	}
+	return c.backend.UploadFile(ctx, c.encryptPath(path), encrypted, EncryptedSize(size))
+}
+
+// CreateFolder creates folder with encrypted name
+func (c *Client) CreateFolder(ctx context.Context, path string) error {
+	return c.backend.CreateFolder(ctx, c.encryptPath(path))
+}
+
+// GetFileInfo gets file information with decrypted name and size
+func (c *Client) GetFileInfo(ctx context.Context, path string) (*models.FileInfo, error) {
+	info, err := c.backend.GetFileInfo(ctx, c.encryptPath(path))
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted, err := c.decryptInfo(*info)
+	if err != nil {
+		return nil, err
+	}
+	return &decrypted, nil
+}
+
+// SetProperties sets custom properties of resource if backend supports them
+func (c *Client) SetProperties(ctx context.Context, path string, props map[string]string) error {
+	setter, ok := c.backend.(backend.PropertySetter)
+	if !ok {
+		return fmt.Errorf("backend doesn't support custom properties")
+	}
+	return setter.SetProperties(ctx, c.encryptPath(path), props)
+}
+
+// Move moves resource on the server side if backend supports it
+func (c *Client) Move(ctx context.Context, from, to string) error {
+	mover, ok := c.backend.(backend.Mover)
+	if !ok {
+		return fmt.Errorf("backend doesn't support moving")
+	}
+	return mover.Move(ctx, c.encryptPath(from), c.encryptPath(to))
+}
+
+// SetModTime sets modification time of file, it does nothing if backend doesn't support it
+func (c *Client) SetModTime(ctx context.Context, path string, modTime time.Time) error {
+	setter, ok := c.backend.(backend.ModTimeSetter)
+	if !ok {
+		return nil
+	}
+	return setter.SetModTime(ctx, c.encryptPath(path), modTime)
+}
+
+// Delete deletes resource if backend supports it
+func (c *Client) Delete(ctx context.Context, path string, permanently bool) error {
+	deleter, ok := c.backend.(backend.Deleter)
+	if !ok {
+		return fmt.Errorf("backend doesn't support deleting")
+	}
+	return deleter.Delete(ctx, c.encryptPath(path), permanently)
+}
+
+// GetQuota gets disk space information if backend supports it
+func (c *Client) GetQuota(ctx context.Context) (*models.Quota, error) {
+	quota, ok := c.backend.(backend.Quota)
+	if !ok {
+		return nil, fmt.Errorf("backend doesn't support quota")
+	}
+	return quota.GetQuota(ctx)
+}
+
+// decryptInfo translates backend file information to plaintext
+func (c *Client) decryptInfo(file models.FileInfo) (models.FileInfo, error) {
+	plainPath, err := c.decryptPath(file.Path)
+	if err != nil {
+		return models.FileInfo{}, err
+	}
+	file.Path = plainPath
+	file.Name = plainPath[strings.LastIndex(plainPath, "/")+1:]
+
+	if !file.IsDir {
+		size, err := DecryptedSize(file.Size)
+		if err != nil {
+			return models.FileInfo{}, err
+		}
+		file.Size = size
+		file.ETag = ""
+		file.ContentType = ""
+		file.DownloadURL = ""
+	}
+
+	return file, nil
+}
+
+// encryptPath encrypts every component of path below root
+func (c *Client) encryptPath(path string) string {
+	prefix, rel, ok := c.split(path)
+	if !ok || rel == "" {
+		return path
+	}
+
+	parts := strings.Split(rel, "/")
+	for i, part := range parts {
+		parts[i] = c.cipher.EncryptName(part)
+	}
+	return prefix + "/" + strings.Join(parts, "/")
+}
+
+// DecryptPath decrypts path stored in backend, paths outside of roots are returned unchanged
+func (c *Client) DecryptPath(path string) (string, error) {
+	return c.decryptPath(path)
+}
+
+// decryptPath decrypts every component of path below root
+func (c *Client) decryptPath(path string) (string, error) {
+	prefix, rel, ok := c.split(path)
+	if !ok || rel == "" {
+		return path, nil
+	}
+
+	parts := strings.Split(rel, "/")
+	for i, part := range parts {
+		name, err := c.cipher.DecryptName(part)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = name
+	}
+	return prefix + "/" + strings.Join(parts, "/"), nil
+}
+
+// split splits path into root prefix in the caller's notation and relative part.
+// The most specific root containing path is used.
+func (c *Client) split(path string) (string, string, bool) {
+	var (
+		prefix, rel string
+		found       bool
+	)
+	for _, root := range c.roots {
+		p, r, ok := splitRoot(path, root)
+		if ok && (!found || len(p) > len(prefix)) {
+			prefix, rel, found = p, r, true
+		}
+	}
+	return prefix, rel, found
+}
+
+// splitRoot splits path into root prefix and part relative to root
+func splitRoot(path, root string) (string, string, bool) {
+	normPath := normalize(path)
+	normRoot := normalize(root)
+
+	if normPath == normRoot {
+		return path, "", true
+	}
+	if normRoot == "/" {
+		normRoot = ""
+	}
+	if !strings.HasPrefix(normPath, normRoot+"/") {
+		return "", "", false
+	}
+
+	rel := normPath[len(normRoot)+1:]
+	prefix := strings.TrimSuffix(strings.TrimSuffix(path, "/"), rel)
+	return strings.TrimSuffix(prefix, "/"), rel, true
+}
+
+// normalize brings Yandex-style and plain paths to the same form
+func normalize(path string) string {
+	path = strings.TrimPrefix(path, "disk:")
+	path = "/" + strings.Trim(path, "/")
+	return path
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"nextya-sync/processor"
+	"nextya-sync/schedule"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run synchronization on schedule",
+	Long: `Daemon runs jobs defined in daemon.jobs of the configuration file, or
+synchronization on --schedule, until it is stopped. Schedules are cron
+expressions like "0 3 * * *" or intervals like "@every 6h". Clients stay
+connected between runs and a run is skipped while another one is active.`,
+	Args: cobra.NoArgs,
+	Run:  daemon,
+}
+
+func init() {
+	daemonCmd.Flags().String("schedule", "", `Schedule of synchronization, e.g. "0 3 * * *" or "@every 6h"`)
+	viper.BindPFlag("daemon.schedule", daemonCmd.Flags().Lookup("schedule"))
+	viper.BindEnv("daemon.schedule", "DAEMON_SCHEDULE")
+
+	rootCmd.AddCommand(daemonCmd)
+}
+
+// daemonJobs returns configured jobs, synchronization on daemon.schedule if none are configured
+func daemonJobs() []schedule.Job {
+	var jobs []schedule.Job
+	if err := viper.UnmarshalKey("daemon.jobs", &jobs); err != nil {
+		fatalConfig("Invalid daemon jobs", "error", err)
+	}
+	defaultSchedule := viper.GetString("daemon.schedule")
+	if len(jobs) == 0 && defaultSchedule == "" {
+		fatalConfig("Daemon requires --schedule or daemon.jobs in configuration file")
+	}
+	jobs, err := schedule.ParseJobs(jobs, defaultSchedule)
+	if err != nil {
+		fatalConfig("Invalid daemon jobs", "error", err)
+	}
+	return jobs
+}
+
+// runJob runs command of daemon job with shared processor, failures are notified
+func runJob(proc *processor.Processor) schedule.Runner {
+	return func(ctx context.Context, job schedule.Job) error {
+		var err error
+		switch job.Command {
+		case schedule.CommandPrune:
+			err = proc.Prune(ctx, pruneConfig())
+		default:
+			err = runSync(ctx, proc)
+		}
+		if err != nil && err != notifiedErr {
+			notifyFatal("Job failed", "job", job.Name, "error", err)
+		}
+		return err
+	}
+}
+
+func daemon(cmd *cobra.Command, args []string) {
+	jobs := daemonJobs()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	startMetrics()
+	schedule.New(runJob(newProcessor(ctx))).Serve(ctx, jobs)
+}
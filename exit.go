@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+
+	"nextya-sync/backend"
+	"nextya-sync/processor"
+)
+
+// Exit codes of commands
+const (
+	exitSuccess = 0
+	// exitFailure run failed
+	exitFailure = 1
+	// exitPartial run completed, but some files or sync paths failed
+	exitPartial = 2
+	// exitAuth credentials were rejected by source or destination
+	exitAuth = 3
+	// exitConfig settings or command line are invalid
+	exitConfig = 4
+)
+
+// exitCode returns exit code of command finished with err
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitSuccess
+	case errors.Is(err, backend.ErrUnauthorized):
+		return exitAuth
+	case errors.Is(err, processor.ErrPartial):
+		return exitPartial
+	default:
+		return exitFailure
+	}
+}
+
+// fatal logs error with attributes and exits, exit code depends on the error among attributes
+func fatal(msg string, args ...any) {
+	code := exitFailure
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			code = exitCode(err)
+		}
+	}
+	exit(code, msg, args...)
+}
+
+// fatalConfig logs invalid settings and exits
+func fatalConfig(msg string, args ...any) {
+	exit(exitConfig, msg, args...)
+}
+
+func exit(code int, msg string, args ...any) {
+	slog.Error(msg, args...)
+	notifyFatal(msg, args...)
+	os.Exit(code)
+}
+
+// checkErr exits if command failed, errors already sent by processor notifier are not sent again
+func checkErr(err error) {
+	if err == nil {
+		return
+	}
+	if errors.Is(err, processor.ErrPartial) {
+		slog.Warn("Command completed with errors", "error", err)
+	} else {
+		slog.Error("Command failed", "error", err)
+	}
+	if err != notifiedErr {
+		notifyFatal("Command failed", "error", err)
+	}
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,562 @@
+// Package fakenextcloud provides Nextcloud stand-in for integration tests.
+// Files of a single user are served over WebDAV from a local folder, previous versions of
+// overwritten files and deleted files are kept in memory and served like versions and trashbin
+// apps do. Responses mimic real Nextcloud: multistatus with separate propstat for missing
+// properties, hrefs escaped the way Sabre does it and RFC1123 dates.
+package fakenextcloud
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Namespaces of WebDAV properties
+const (
+	nsDAV       = "DAV:"
+	nsOwnCloud  = "http://owncloud.org/ns"
+	nsNextcloud = "http://nextcloud.org/ns"
+)
+
+// Server fake Nextcloud server
+type Server struct {
+	*httptest.Server
+	Username string
+	Password string
+	// Root local folder holding user files
+	Root string
+	// BasePath path Nextcloud is installed under, e.g. /nextcloud, empty for host root
+	BasePath string
+	// PushURL websocket endpoint of notify_push app reported in capabilities, the app is missing if empty
+	PushURL string
+
+	mu       sync.Mutex
+	fileIDs  map[string]int
+	versions map[int][]version
+	trash    []*trashItem
+	requests map[string]int
+}
+
+// New starts fake Nextcloud serving files of user from root folder
+func New(username, password, root string) *Server {
+	s := &Server{
+		Username: username,
+		Password: password,
+		Root:     root,
+		fileIDs:  make(map[string]int),
+		versions: make(map[int][]version),
+		requests: make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Requests returns number of served requests with method, e.g. PUT
+func (s *Server) Requests(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[method]
+}
+
+// WriteFile stores user file creating missing parent folders
+func (s *Server) WriteFile(filePath string, data []byte, modTime time.Time) error {
+	localPath := s.localPath(filePath)
+	if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(localPath, data, 0o644); err != nil {
+		return err
+	}
+	return os.Chtimes(localPath, modTime, modTime)
+}
+
+// ReadFile returns content of user file
+func (s *Server) ReadFile(filePath string) ([]byte, error) {
+	return os.ReadFile(s.localPath(filePath))
+}
+
+// localPath converts user path to path in root folder
+func (s *Server) localPath(filePath string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(path.Clean("/"+filePath)))
+}
+
+// filesPrefix decoded path of user files in WebDAV
+func (s *Server) filesPrefix() string {
+	return s.BasePath + "/remote.php/dav/files/" + s.Username
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests[r.Method]++
+	s.mu.Unlock()
+
+	username, password, ok := r.BasicAuth()
+	if !ok || username != s.Username || password != s.Password {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Nextcloud"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.URL.Path == s.BasePath+"/ocs/v1.php/cloud/capabilities" || r.URL.Path == s.BasePath+"/ocs/v2.php/cloud/capabilities" {
+		s.serveCapabilities(w, r)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, s.versionsPrefix()+"/") {
+		s.serveVersions(w, r)
+		return
+	}
+	if r.URL.Path == s.trashbinPrefix() || strings.HasPrefix(r.URL.Path, s.trashbinPrefix()+"/") {
+		s.serveTrashbin(w, r)
+		return
+	}
+
+	prefix := s.filesPrefix()
+	if r.URL.Path != prefix && !strings.HasPrefix(r.URL.Path, prefix+"/") {
+		http.NotFound(w, r)
+		return
+	}
+	filePath := path.Clean("/" + strings.TrimPrefix(r.URL.Path, prefix))
+
+	switch r.Method {
+	case "PROPFIND":
+		s.servePropfind(w, r, filePath)
+	case http.MethodGet:
+		s.serveGet(w, r, filePath)
+	case http.MethodPut:
+		s.servePut(w, r, filePath)
+	case "MKCOL":
+		s.serveMkcol(w, filePath)
+	case http.MethodDelete:
+		s.serveDelete(w, filePath)
+	case "MOVE":
+		s.serveMove(w, r, filePath)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveCapabilities answers OCS capabilities request
+func (s *Server) serveCapabilities(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("OCS-APIRequest") != "true" {
+		http.Error(w, "CSRF check failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		capabilities := map[string]any{
+			"files": map[string]any{"versioning": true, "undelete": true},
+		}
+		if s.PushURL != "" {
+			capabilities["notify_push"] = map[string]any{
+				"type":      []string{"files", "activities", "notifications"},
+				"endpoints": map[string]string{"websocket": s.PushURL, "pre_auth": s.URL + "/apps/notify_push/pre_auth"},
+			}
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(map[string]any{
+			"ocs": map[string]any{
+				"meta": map[string]any{"status": "ok", "statuscode": 200, "message": "OK"},
+				"data": map[string]any{"capabilities": capabilities},
+			},
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml; charset=UTF-8")
+	io.WriteString(w, `<?xml version="1.0"?>
+<ocs>
+ <meta>
+  <status>ok</status>
+  <statuscode>100</statuscode>
+  <message>OK</message>
+ </meta>
+ <data>
+  <version><major>29</major><minor>0</minor><micro>0</micro><string>29.0.0</string></version>
+  <capabilities><files><versioning>1</versioning><undelete>1</undelete></files></capabilities>
+ </data>
+</ocs>`)
+}
+
+// servePropfind lists properties of resource and its children for Depth 1
+func (s *Server) servePropfind(w http.ResponseWriter, r *http.Request, filePath string) {
+	info, err := os.Stat(s.localPath(filePath))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	requested, err := requestedProps(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type resource struct {
+		path string
+		info os.FileInfo
+	}
+	resources := []resource{{filePath, info}}
+	if info.IsDir() && r.Header.Get("Depth") != "0" {
+		entries, err := os.ReadDir(s.localPath(filePath))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+		for _, entry := range entries {
+			entryInfo, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			resources = append(resources, resource{path.Join(filePath, entry.Name()), entryInfo})
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?>` + "\n")
+	b.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:s="http://sabredav.org/ns" xmlns:oc="http://owncloud.org/ns" xmlns:nc="http://nextcloud.org/ns">`)
+	for _, res := range resources {
+		s.writeResponse(&b, res.path, res.info, requested)
+	}
+	b.WriteString(`</d:multistatus>`)
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, b.String())
+}
+
+// writeResponse writes response element with found properties and 404 propstat for missing ones
+func (s *Server) writeResponse(b *strings.Builder, filePath string, info os.FileInfo, requested []xml.Name) {
+	href := s.filesPrefix() + filePath
+	if info.IsDir() && !strings.HasSuffix(href, "/") {
+		href += "/"
+	}
+
+	var found, missing strings.Builder
+	for _, name := range requested {
+		value, ok := s.property(filePath, info, name)
+		element := elementName(name)
+		if !ok {
+			missing.WriteString("<" + element + "/>")
+		} else if value == "" {
+			found.WriteString("<" + element + "/>")
+		} else {
+			found.WriteString("<" + element + ">" + value + "</" + element + ">")
+		}
+	}
+
+	b.WriteString("<d:response><d:href>" + escapeHref(href) + "</d:href>")
+	if found.Len() > 0 {
+		b.WriteString("<d:propstat><d:prop>" + found.String() + "</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>")
+	}
+	if missing.Len() > 0 {
+		b.WriteString("<d:propstat><d:prop>" + missing.String() + "</d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>")
+	}
+	b.WriteString("</d:response>")
+}
+
+// property returns escaped XML value of property, false if resource doesn't have it
+func (s *Server) property(filePath string, info os.FileInfo, name xml.Name) (string, bool) {
+	switch name {
+	case xml.Name{Space: nsDAV, Local: "getlastmodified"}:
+		return info.ModTime().UTC().Format(http.TimeFormat), true
+	case xml.Name{Space: nsDAV, Local: "getetag"}:
+		return "&quot;" + s.folderETag(filePath, info) + "&quot;", true
+	case xml.Name{Space: nsDAV, Local: "resourcetype"}:
+		if info.IsDir() {
+			return "<d:collection/>", true
+		}
+		return "", true
+	case xml.Name{Space: nsDAV, Local: "getcontentlength"}:
+		if info.IsDir() {
+			return "", false
+		}
+		return strconv.FormatInt(info.Size(), 10), true
+	case xml.Name{Space: nsDAV, Local: "getcontenttype"}:
+		if info.IsDir() {
+			return "", false
+		}
+		contentType := mime.TypeByExtension(path.Ext(filePath))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		return xmlEscape(contentType), true
+	case xml.Name{Space: nsOwnCloud, Local: "fileid"}:
+		return strconv.Itoa(s.fileID(filePath)), true
+	case xml.Name{Space: nsOwnCloud, Local: "size"}:
+		return strconv.FormatInt(info.Size(), 10), true
+	}
+	// Nextcloud doesn't report displayname and other unknown properties
+	return "", false
+}
+
+// fileID returns stable identifier of path
+func (s *Server) fileID(filePath string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.fileIDs[filePath]
+	if !ok {
+		id = len(s.fileIDs) + 1
+		s.fileIDs[filePath] = id
+	}
+	return id
+}
+
+// serveGet downloads file
+func (s *Server) serveGet(w http.ResponseWriter, r *http.Request, filePath string) {
+	file, err := os.Open(s.localPath(filePath))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if info.IsDir() {
+		http.Error(w, "Not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+etag(info)+`"`)
+	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, file)
+}
+
+// servePut uploads file, parent folder must exist
+func (s *Server) servePut(w http.ResponseWriter, r *http.Request, filePath string) {
+	localPath := s.localPath(filePath)
+	if info, err := os.Stat(filepath.Dir(localPath)); err != nil || !info.IsDir() {
+		http.Error(w, "Parent folder doesn't exist", http.StatusConflict)
+		return
+	}
+	existing, err := os.Stat(localPath)
+	if err == nil && existing.IsDir() {
+		http.Error(w, "Resource is a folder", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.keepVersion(filePath)
+	tmp, err := os.CreateTemp(filepath.Dir(localPath), ".upload-*")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, r.Body)
+	tmp.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.ContentLength >= 0 && written != r.ContentLength {
+		http.Error(w, "Content length mismatch", http.StatusBadRequest)
+		return
+	}
+	if err := os.Rename(tmp.Name(), localPath); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Nextcloud keeps modification time sent by sync clients
+	if mtime, err := strconv.ParseInt(r.Header.Get("X-OC-MTime"), 10, 64); err == nil {
+		os.Chtimes(localPath, time.Unix(mtime, 0), time.Unix(mtime, 0))
+		w.Header().Set("X-OC-MTime", "accepted")
+	}
+	if info, err := os.Stat(localPath); err == nil {
+		w.Header().Set("ETag", `"`+etag(info)+`"`)
+		w.Header().Set("OC-FileId", strconv.Itoa(s.fileID(filePath)))
+	}
+
+	if existing != nil {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// serveMkcol creates folder, parent folder must exist
+func (s *Server) serveMkcol(w http.ResponseWriter, filePath string) {
+	localPath := s.localPath(filePath)
+	if _, err := os.Stat(localPath); err == nil {
+		http.Error(w, "The resource you tried to create already exists", http.StatusMethodNotAllowed)
+		return
+	}
+	if info, err := os.Stat(filepath.Dir(localPath)); err != nil || !info.IsDir() {
+		http.Error(w, "Parent folder doesn't exist", http.StatusConflict)
+		return
+	}
+	if err := os.Mkdir(localPath, 0o755); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// serveDelete deletes file or folder
+func (s *Server) serveDelete(w http.ResponseWriter, filePath string) {
+	localPath := s.localPath(filePath)
+	if _, err := os.Stat(localPath); err != nil || filePath == "/" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if err := os.RemoveAll(localPath); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveMove moves file or folder to Destination
+func (s *Server) serveMove(w http.ResponseWriter, r *http.Request, filePath string) {
+	destination, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || !strings.HasPrefix(destination.Path, s.filesPrefix()+"/") {
+		http.Error(w, "Invalid destination", http.StatusBadRequest)
+		return
+	}
+	from := s.localPath(filePath)
+	to := s.localPath(strings.TrimPrefix(destination.Path, s.filesPrefix()))
+
+	if _, err := os.Stat(from); err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	_, err = os.Stat(to)
+	existed := err == nil
+	if existed {
+		if r.Header.Get("Overwrite") == "F" {
+			http.Error(w, "Destination exists", http.StatusPreconditionFailed)
+			return
+		}
+		os.RemoveAll(to)
+	}
+	if err := os.Rename(from, to); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	if existed {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// requestedProps parses names of properties in PROPFIND body, all known properties if body is empty
+func requestedProps(body io.Reader) ([]xml.Name, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return []xml.Name{
+			{Space: nsDAV, Local: "getlastmodified"},
+			{Space: nsDAV, Local: "getetag"},
+			{Space: nsDAV, Local: "resourcetype"},
+			{Space: nsDAV, Local: "getcontentlength"},
+			{Space: nsDAV, Local: "getcontenttype"},
+			{Space: nsOwnCloud, Local: "fileid"},
+		}, nil
+	}
+
+	var propfind struct {
+		Prop struct {
+			Props []struct {
+				XMLName xml.Name
+			} `xml:",any"`
+		} `xml:"prop"`
+	}
+	if err := xml.Unmarshal(data, &propfind); err != nil {
+		return nil, fmt.Errorf("invalid PROPFIND body: %w", err)
+	}
+
+	names := make([]xml.Name, 0, len(propfind.Prop.Props))
+	for _, prop := range propfind.Prop.Props {
+		names = append(names, prop.XMLName)
+	}
+	return names, nil
+}
+
+// elementName returns prefixed element name of property
+func elementName(name xml.Name) string {
+	switch name.Space {
+	case nsDAV:
+		return "d:" + name.Local
+	case nsOwnCloud:
+		return "oc:" + name.Local
+	case nsNextcloud:
+		return "nc:" + name.Local
+	}
+	return "x:" + name.Local + ` xmlns:x="` + xmlEscape(name.Space) + `"`
+}
+
+// escapeHref escapes path the way Sabre does, keeping only unreserved characters and a few delimiters
+func escapeHref(href string) string {
+	var b strings.Builder
+	for i := 0; i < len(href); i++ {
+		c := href[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			strings.IndexByte("_-.~()/:@", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// etag returns entity tag of file derived from its size and modification time
+func etag(info os.FileInfo) string {
+	sum := md5.Sum([]byte(fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano())))
+	return hex.EncodeToString(sum[:])
+}
+
+// folderETag returns entity tag of resource, folder tags change with any file below them as in Nextcloud
+func (s *Server) folderETag(filePath string, info os.FileInfo) string {
+	if !info.IsDir() {
+		return etag(info)
+	}
+	entries, err := os.ReadDir(s.localPath(filePath))
+	if err != nil {
+		return etag(info)
+	}
+	sum := md5.New()
+	for _, entry := range entries {
+		child, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(sum, "%s:%s\n", entry.Name(), s.folderETag(path.Join(filePath, entry.Name()), child))
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// xmlEscape escapes text for XML
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
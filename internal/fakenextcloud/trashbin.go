@@ -0,0 +1,264 @@
+package fakenextcloud
+
+import (
+	"encoding/xml"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// trashItem file or folder deleted by user and kept by trashbin app
+type trashItem struct {
+	// name name of item in trashbin, original name with deletion time suffix
+	name string
+	// origin original location relative to user files
+	origin  string
+	deleted time.Time
+	isDir   bool
+	// files content of deleted files by path relative to the item, empty for file item itself
+	files map[string]trashFile
+}
+
+// trashFile content of deleted file
+type trashFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// trashResource file or folder in trashbin listing
+type trashResource struct {
+	href    string
+	isDir   bool
+	size    int64
+	modTime time.Time
+	// item deleted item, set only for top level resources
+	item *trashItem
+}
+
+// Trash deletes user file or folder moving it into trashbin the way trashbin app does
+func (s *Server) Trash(filePath string, deleted time.Time) error {
+	filePath = path.Clean("/" + filePath)
+	localPath := s.localPath(filePath)
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+
+	item := &trashItem{
+		name:    path.Base(filePath) + ".d" + strconv.FormatInt(deleted.Unix(), 10),
+		origin:  strings.TrimPrefix(filePath, "/"),
+		deleted: deleted,
+		isDir:   info.IsDir(),
+		files:   make(map[string]trashFile),
+	}
+	err = filepath.WalkDir(localPath, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localPath, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			rel = ""
+		}
+		item.files[filepath.ToSlash(rel)] = trashFile{data: data, modTime: info.ModTime()}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(localPath); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trash = append(s.trash, item)
+	return nil
+}
+
+// trashbinPrefix decoded path of user trashbin in WebDAV
+func (s *Server) trashbinPrefix() string {
+	return s.BasePath + "/remote.php/dav/trashbin/" + s.Username + "/trash"
+}
+
+// serveTrashbin lists deleted items and files of deleted folders with PROPFIND and downloads files with GET
+func (s *Server) serveTrashbin(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, s.trashbinPrefix()), "/")
+	name, sub, _ := strings.Cut(rest, "/")
+
+	s.mu.Lock()
+	var item *trashItem
+	items := append([]*trashItem(nil), s.trash...)
+	for _, candidate := range items {
+		if candidate.name == name {
+			item = candidate
+		}
+	}
+	s.mu.Unlock()
+
+	switch {
+	case r.Method == "PROPFIND" && rest == "":
+		resources := []trashResource{{href: s.trashbinPrefix() + "/", isDir: true}}
+		for _, item := range items {
+			resources = append(resources, item.resource(s.trashbinPrefix()))
+		}
+		s.writeTrashbin(w, r, resources)
+	case r.Method == "PROPFIND" && item != nil && item.isDir:
+		resources, ok := item.children(s.trashbinPrefix(), sub)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		s.writeTrashbin(w, r, resources)
+	case r.Method == http.MethodGet && item != nil:
+		file, ok := item.files[sub]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(file.data)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(file.data)
+	case item == nil:
+		http.NotFound(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// resource returns top level trashbin resource of item
+func (item *trashItem) resource(prefix string) trashResource {
+	res := trashResource{href: prefix + "/" + item.name, isDir: item.isDir, modTime: item.deleted, item: item}
+	if item.isDir {
+		res.href += "/"
+	} else {
+		res.size = int64(len(item.files[""].data))
+		res.modTime = item.files[""].modTime
+	}
+	return res
+}
+
+// children returns folder of deleted item at sub path followed by its direct children, false if there is no such folder
+func (item *trashItem) children(prefix, sub string) ([]trashResource, bool) {
+	folderHref := prefix + "/" + path.Join(item.name, sub) + "/"
+	resources := []trashResource{{href: folderHref, isDir: true, modTime: item.deleted}}
+	if sub == "" {
+		resources[0] = item.resource(prefix)
+	}
+
+	found := sub == ""
+	folders := make(map[string]bool)
+	var names []string
+	for rel := range item.files {
+		names = append(names, rel)
+	}
+	sort.Strings(names)
+	for _, rel := range names {
+		if sub != "" {
+			var ok bool
+			if rel, ok = strings.CutPrefix(rel, sub+"/"); !ok {
+				continue
+			}
+		}
+		found = true
+		child, _, nested := strings.Cut(rel, "/")
+		if nested {
+			if !folders[child] {
+				folders[child] = true
+				resources = append(resources, trashResource{href: folderHref + child + "/", isDir: true, modTime: item.deleted})
+			}
+			continue
+		}
+		file := item.files[path.Join(sub, child)]
+		resources = append(resources, trashResource{href: folderHref + child, size: int64(len(file.data)), modTime: file.modTime})
+	}
+	return resources, found
+}
+
+// writeTrashbin writes multistatus of trashbin resources with requested properties
+func (s *Server) writeTrashbin(w http.ResponseWriter, r *http.Request, resources []trashResource) {
+	requested, err := requestedProps(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?>` + "\n")
+	b.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:s="http://sabredav.org/ns" xmlns:oc="http://owncloud.org/ns" xmlns:nc="http://nextcloud.org/ns">`)
+	for _, res := range resources {
+		var found, missing strings.Builder
+		for _, name := range requested {
+			value, ok := res.property(name)
+			element := elementName(name)
+			if !ok {
+				missing.WriteString("<" + element + "/>")
+			} else if value == "" {
+				found.WriteString("<" + element + "/>")
+			} else {
+				found.WriteString("<" + element + ">" + value + "</" + element + ">")
+			}
+		}
+
+		b.WriteString("<d:response><d:href>" + escapeHref(res.href) + "</d:href>")
+		if found.Len() > 0 {
+			b.WriteString("<d:propstat><d:prop>" + found.String() + "</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>")
+		}
+		if missing.Len() > 0 {
+			b.WriteString("<d:propstat><d:prop>" + missing.String() + "</d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>")
+		}
+		b.WriteString("</d:response>")
+	}
+	b.WriteString(`</d:multistatus>`)
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write([]byte(b.String()))
+}
+
+// property returns escaped XML value of trashbin resource property, false if resource doesn't have it
+func (res trashResource) property(name xml.Name) (string, bool) {
+	switch name {
+	case xml.Name{Space: nsDAV, Local: "getlastmodified"}:
+		return res.modTime.UTC().Format(http.TimeFormat), true
+	case xml.Name{Space: nsDAV, Local: "resourcetype"}:
+		if res.isDir {
+			return "<d:collection/>", true
+		}
+		return "", true
+	case xml.Name{Space: nsDAV, Local: "getcontentlength"}:
+		if res.isDir {
+			return "", false
+		}
+		return strconv.FormatInt(res.size, 10), true
+	}
+
+	if res.item == nil {
+		return "", false
+	}
+	switch name {
+	case xml.Name{Space: nsNextcloud, Local: "trashbin-filename"}:
+		return xmlEscape(path.Base(res.item.origin)), true
+	case xml.Name{Space: nsNextcloud, Local: "trashbin-original-location"}:
+		return xmlEscape(res.item.origin), true
+	case xml.Name{Space: nsNextcloud, Local: "trashbin-deletion-time"}:
+		return strconv.FormatInt(res.item.deleted.Unix(), 10), true
+	}
+	return "", false
+}
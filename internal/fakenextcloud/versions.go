@@ -0,0 +1,125 @@
+package fakenextcloud
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// version previous content of file kept by versions app
+type version struct {
+	data    []byte
+	modTime time.Time
+}
+
+// AddVersion stores previous version of user file, the file must exist
+func (s *Server) AddVersion(filePath string, data []byte, modTime time.Time) error {
+	if _, err := os.Stat(s.localPath(filePath)); err != nil {
+		return err
+	}
+	id := s.fileID(path.Clean("/" + filePath))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.versions[id] = append(s.versions[id], version{data: data, modTime: modTime.Truncate(time.Second)})
+	return nil
+}
+
+// keepVersion stores current content of file as version before it is overwritten, like versions app does
+func (s *Server) keepVersion(filePath string) {
+	localPath := s.localPath(filePath)
+	info, err := os.Stat(localPath)
+	if err != nil || info.IsDir() {
+		return
+	}
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return
+	}
+	s.AddVersion(filePath, data, info.ModTime())
+}
+
+// versionsPrefix decoded path of versions of user files in WebDAV, followed by file ID and version name
+func (s *Server) versionsPrefix() string {
+	return s.BasePath + "/remote.php/dav/versions/" + s.Username + "/versions"
+}
+
+// serveVersions lists versions of file with PROPFIND and downloads single version with GET.
+// Versions are named by Unix time of their modification.
+func (s *Server) serveVersions(w http.ResponseWriter, r *http.Request) {
+	fileID, name, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, s.versionsPrefix()), "/"), "/")
+	id, err := strconv.Atoi(fileID)
+	if err != nil || !s.knownID(id) {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	versions := append([]version(nil), s.versions[id]...)
+	s.mu.Unlock()
+	sort.Slice(versions, func(i, j int) bool { return versions[i].modTime.After(versions[j].modTime) })
+
+	switch {
+	case r.Method == "PROPFIND" && name == "":
+		s.writeVersions(w, fileID, versions)
+	case r.Method == http.MethodGet && name != "":
+		for _, v := range versions {
+			if strconv.FormatInt(v.modTime.Unix(), 10) == name {
+				w.Header().Set("Content-Length", strconv.Itoa(len(v.data)))
+				w.WriteHeader(http.StatusOK)
+				w.Write(v.data)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// knownID reports whether file ID was given out
+func (s *Server) knownID(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, known := range s.fileIDs {
+		if known == id {
+			return true
+		}
+	}
+	return false
+}
+
+// writeVersions writes multistatus of versions folder of file and its versions
+func (s *Server) writeVersions(w http.ResponseWriter, fileID string, versions []version) {
+	folder := s.versionsPrefix() + "/" + fileID + "/"
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?>` + "\n")
+	b.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:s="http://sabredav.org/ns" xmlns:oc="http://owncloud.org/ns" xmlns:nc="http://nextcloud.org/ns">`)
+	b.WriteString("<d:response><d:href>" + escapeHref(folder) + "</d:href>" +
+		"<d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>")
+	for _, v := range versions {
+		name := strconv.FormatInt(v.modTime.Unix(), 10)
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		fmt.Fprintf(&b, "<d:response><d:href>%s</d:href><d:propstat><d:prop>"+
+			"<d:getlastmodified>%s</d:getlastmodified><d:getcontentlength>%d</d:getcontentlength>"+
+			"<d:getcontenttype>%s</d:getcontenttype><d:getetag>&quot;%s&quot;</d:getetag>"+
+			"</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>",
+			escapeHref(folder+name), v.modTime.UTC().Format(http.TimeFormat), len(v.data), contentType, fileID+"-"+name)
+	}
+	b.WriteString(`</d:multistatus>`)
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, b.String())
+}
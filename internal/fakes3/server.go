@@ -0,0 +1,465 @@
+// Package fakes3 provides S3 API stand-in for integration tests.
+// Objects of a single bucket are kept in memory and addressed path-style, every request
+// must carry valid AWS Signature Version 4 and multipart uploads are assembled like in the real API.
+package fakes3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server fake S3 server
+type Server struct {
+	*httptest.Server
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// Region expected in credential scope of signatures
+	Region string
+	// MaxKeys caps number of keys and prefixes per page of listings
+	MaxKeys int
+
+	mu       sync.Mutex
+	objects  map[string]object
+	uploads  map[string]*multipartUpload
+	requests map[string]int
+}
+
+// object stored object
+type object struct {
+	data        []byte
+	etag        string
+	modified    time.Time
+	contentType string
+}
+
+// multipartUpload unfinished multipart upload
+type multipartUpload struct {
+	key         string
+	contentType string
+	parts       map[int][]byte
+}
+
+// listResult ListObjectsV2 response
+type listResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	KeyCount              int            `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	Contents              []listObject   `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type listObject struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// completeRequest CompleteMultipartUpload request body
+type completeRequest struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+// New starts fake S3 serving bucket to requests signed with the keys in us-east-1 region
+func New(bucket, accessKey, secretKey string) *Server {
+	s := &Server{
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Region:    "us-east-1",
+		MaxKeys:   1000,
+		objects:   make(map[string]object),
+		uploads:   make(map[string]*multipartUpload),
+		requests:  make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Requests returns number of served requests by method and operation, e.g. "PUT object",
+// "PUT part", "PUT copy", "POST uploads", "POST complete", "DELETE abort", "DELETE object", "GET list"
+func (s *Server) Requests(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[key]
+}
+
+// ReadFile returns content of object
+func (s *Server) ReadFile(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[strings.TrimPrefix(key, "/")]
+	return obj.data, ok
+}
+
+// ETag returns quoted ETag of object, empty if it doesn't exist
+func (s *Server) ETag(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects[strings.TrimPrefix(key, "/")].etag
+}
+
+// Uploads returns number of unfinished multipart uploads
+func (s *Server) Uploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
+
+// s3Error writes error in the format of S3 API, HEAD responses have no body
+func s3Error(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	writeXML(w, status, struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+}
+
+// writeXML writes XML response with status
+func writeXML(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(value)
+}
+
+// quotedMD5 returns ETag of single-part object
+func quotedMD5(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// newID returns random identifier of multipart upload
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if code, message := s.verify(r); code != "" {
+		s3Error(w, r, http.StatusForbidden, code, message)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.Bucket {
+		s3Error(w, r, http.StatusNotFound, "NoSuchBucket", "bucket "+bucket+" doesn't exist")
+		return
+	}
+	query := r.URL.Query()
+
+	op := operation(r, key, query)
+	s.mu.Lock()
+	s.requests[op]++
+	s.mu.Unlock()
+
+	switch op {
+	case "HEAD bucket":
+		w.WriteHeader(http.StatusOK)
+	case "GET list":
+		s.serveList(w, query)
+	case "POST uploads":
+		s.serveCreateUpload(w, key, r.Header.Get("Content-Type"))
+	case "POST complete":
+		s.serveComplete(w, r, key, query.Get("uploadId"))
+	case "PUT part":
+		s.servePart(w, r, query.Get("uploadId"), query.Get("partNumber"))
+	case "PUT copy":
+		s.serveCopy(w, r, key)
+	case "PUT object":
+		s.servePut(w, r, key)
+	case "DELETE abort":
+		s.serveAbort(w, r, query.Get("uploadId"))
+	case "DELETE object":
+		s.mu.Lock()
+		delete(s.objects, key)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case "GET object", "HEAD object":
+		s.serveGet(w, r, key)
+	default:
+		s3Error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method+" is not allowed")
+	}
+}
+
+// operation returns method and name of S3 operation requested, e.g. "PUT part"
+func operation(r *http.Request, key string, query url.Values) string {
+	switch {
+	case key == "" && (r.Method == http.MethodHead || r.Method == http.MethodGet):
+		if r.Method == http.MethodHead {
+			return "HEAD bucket"
+		}
+		return "GET list"
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		return "POST uploads"
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		return "POST complete"
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		return "PUT part"
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		return "PUT copy"
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		return "DELETE abort"
+	}
+	return r.Method + " object"
+}
+
+// serveList lists objects with prefix, keys behind delimiter are grouped into common prefixes
+func (s *Server) serveList(w http.ResponseWriter, query url.Values) {
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	token := query.Get("continuation-token")
+
+	s.mu.Lock()
+	// entries keys and common prefixes in listing order
+	var entries []string
+	prefixes := make(map[string]bool)
+	for key := range s.objects {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		i := strings.Index(rest, delimiter)
+		if delimiter == "" || i < 0 {
+			entries = append(entries, key)
+			continue
+		}
+		group := prefix + rest[:i+len(delimiter)]
+		if !prefixes[group] {
+			prefixes[group] = true
+			entries = append(entries, group)
+		}
+	}
+	sort.Strings(entries)
+
+	result := listResult{Name: s.Bucket, Prefix: prefix, MaxKeys: s.MaxKeys}
+	for _, entry := range entries {
+		if token != "" && entry <= token {
+			continue
+		}
+		if result.KeyCount == s.MaxKeys {
+			result.IsTruncated = true
+			break
+		}
+		result.KeyCount++
+		result.NextContinuationToken = entry
+
+		if prefixes[entry] {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: entry})
+			continue
+		}
+		obj := s.objects[entry]
+		result.Contents = append(result.Contents, listObject{
+			Key:          entry,
+			LastModified: obj.modified.UTC().Format("2006-01-02T15:04:05.000Z"),
+			ETag:         obj.etag,
+			Size:         len(obj.data),
+		})
+	}
+	s.mu.Unlock()
+
+	if !result.IsTruncated {
+		result.NextContinuationToken = ""
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+func (s *Server) serveCreateUpload(w http.ResponseWriter, key, contentType string) {
+	id := newID()
+	s.mu.Lock()
+	s.uploads[id] = &multipartUpload{key: key, contentType: contentType, parts: make(map[int][]byte)}
+	s.mu.Unlock()
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		UploadID string   `xml:"UploadId"`
+	}{Bucket: s.Bucket, Key: key, UploadID: id})
+}
+
+func (s *Server) servePart(w http.ResponseWriter, r *http.Request, uploadID, partNumber string) {
+	number, err := strconv.Atoi(partNumber)
+	if err != nil || number < 1 || number > 10000 {
+		s3Error(w, r, http.StatusBadRequest, "InvalidArgument", "invalid part number "+partNumber)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		s3Error(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if digest := r.Header.Get("Content-MD5"); digest != "" {
+		sum := md5.Sum(data)
+		if digest != base64.StdEncoding.EncodeToString(sum[:]) {
+			s3Error(w, r, http.StatusBadRequest, "BadDigest", "Content-MD5 doesn't match part")
+			return
+		}
+	}
+
+	s.mu.Lock()
+	upload, ok := s.uploads[uploadID]
+	if ok {
+		upload.parts[number] = data
+	}
+	s.mu.Unlock()
+	if !ok {
+		s3Error(w, r, http.StatusNotFound, "NoSuchUpload", "upload "+uploadID+" doesn't exist")
+		return
+	}
+
+	w.Header().Set("ETag", quotedMD5(data))
+	w.WriteHeader(http.StatusOK)
+}
+
+// serveComplete assembles object from listed parts, its ETag is MD5 of part MD5s with number of parts
+func (s *Server) serveComplete(w http.ResponseWriter, r *http.Request, key, uploadID string) {
+	var req completeRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Parts) == 0 {
+		s3Error(w, r, http.StatusBadRequest, "MalformedXML", "invalid part list")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[uploadID]
+	if !ok || upload.key != key {
+		s3Error(w, r, http.StatusNotFound, "NoSuchUpload", "upload "+uploadID+" doesn't exist")
+		return
+	}
+
+	var data, sums []byte
+	for i, part := range req.Parts {
+		partData, ok := upload.parts[part.PartNumber]
+		if !ok || part.ETag != quotedMD5(partData) {
+			s3Error(w, r, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d wasn't uploaded", part.PartNumber))
+			return
+		}
+		if i > 0 && part.PartNumber <= req.Parts[i-1].PartNumber {
+			s3Error(w, r, http.StatusBadRequest, "InvalidPartOrder", "parts must be listed in ascending order")
+			return
+		}
+		data = append(data, partData...)
+		sum := md5.Sum(partData)
+		sums = append(sums, sum[:]...)
+	}
+
+	sum := md5.Sum(sums)
+	etag := fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sum[:]), len(req.Parts))
+	s.objects[key] = object{data: data, etag: etag, modified: time.Now(), contentType: upload.contentType}
+	delete(s.uploads, uploadID)
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string   `xml:"Bucket"`
+		Key     string   `xml:"Key"`
+		ETag    string   `xml:"ETag"`
+	}{Bucket: s.Bucket, Key: key, ETag: etag})
+}
+
+func (s *Server) serveAbort(w http.ResponseWriter, r *http.Request, uploadID string) {
+	s.mu.Lock()
+	_, ok := s.uploads[uploadID]
+	delete(s.uploads, uploadID)
+	s.mu.Unlock()
+	if !ok {
+		s3Error(w, r, http.StatusNotFound, "NoSuchUpload", "upload "+uploadID+" doesn't exist")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) serveCopy(w http.ResponseWriter, r *http.Request, key string) {
+	source, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		s3Error(w, r, http.StatusBadRequest, "InvalidArgument", "invalid copy source")
+		return
+	}
+	bucket, sourceKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+
+	s.mu.Lock()
+	obj, ok := s.objects[sourceKey]
+	if ok && bucket == s.Bucket {
+		obj.modified = time.Now()
+		s.objects[key] = obj
+	}
+	s.mu.Unlock()
+	if !ok || bucket != s.Bucket {
+		s3Error(w, r, http.StatusNotFound, "NoSuchKey", "copy source "+source+" doesn't exist")
+		return
+	}
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		ETag         string   `xml:"ETag"`
+		LastModified string   `xml:"LastModified"`
+	}{ETag: obj.etag, LastModified: obj.modified.UTC().Format("2006-01-02T15:04:05.000Z")})
+}
+
+func (s *Server) servePut(w http.ResponseWriter, r *http.Request, key string) {
+	if r.ContentLength < 0 {
+		s3Error(w, r, http.StatusLengthRequired, "MissingContentLength", "Content-Length is required")
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		s3Error(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	obj := object{data: data, etag: quotedMD5(data), modified: time.Now(), contentType: r.Header.Get("Content-Type")}
+	s.mu.Lock()
+	s.objects[key] = obj
+	s.mu.Unlock()
+
+	w.Header().Set("ETag", obj.etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+// serveGet serves object content, single byte range is supported
+func (s *Server) serveGet(w http.ResponseWriter, r *http.Request, key string) {
+	s.mu.Lock()
+	obj, ok := s.objects[key]
+	s.mu.Unlock()
+	if !ok {
+		s3Error(w, r, http.StatusNotFound, "NoSuchKey", "key "+key+" doesn't exist")
+		return
+	}
+
+	w.Header().Set("ETag", obj.etag)
+	if obj.contentType != "" {
+		w.Header().Set("Content-Type", obj.contentType)
+	}
+	// ServeContent handles HEAD and Range requests
+	http.ServeContent(w, r, "", obj.modified, bytes.NewReader(obj.data))
+}
@@ -0,0 +1,184 @@
+package fakes3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// signAlgorithm the only signature algorithm accepted
+	signAlgorithm = "AWS4-HMAC-SHA256"
+	// unsignedPayload payload hash of streamed uploads
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// maxClockSkew maximum difference between request date and server time
+	maxClockSkew = 15 * time.Minute
+)
+
+// authorization parsed Authorization header of SigV4 request
+type authorization struct {
+	accessKey     string
+	date          string
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+}
+
+// parseAuthorization parses header like
+// AWS4-HMAC-SHA256 Credential=key/20240101/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-date, Signature=hex
+func parseAuthorization(header string) (authorization, error) {
+	var auth authorization
+	params, ok := strings.CutPrefix(header, signAlgorithm+" ")
+	if !ok {
+		return auth, fmt.Errorf("unsupported authorization %q", header)
+	}
+
+	for _, param := range strings.Split(params, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch name {
+		case "Credential":
+			parts := strings.Split(value, "/")
+			if len(parts) != 5 || parts[4] != "aws4_request" {
+				return auth, fmt.Errorf("malformed credential %q", value)
+			}
+			auth.accessKey, auth.date, auth.region, auth.service = parts[0], parts[1], parts[2], parts[3]
+		case "SignedHeaders":
+			auth.signedHeaders = strings.Split(value, ";")
+		case "Signature":
+			auth.signature = value
+		}
+	}
+	if auth.accessKey == "" || len(auth.signedHeaders) == 0 || auth.signature == "" {
+		return auth, fmt.Errorf("incomplete authorization %q", header)
+	}
+	return auth, nil
+}
+
+// verify checks SigV4 signature of request, signed payload hash is checked against the body
+// which is replaced by its buffered copy. Returns S3 error code and message on failure.
+func (s *Server) verify(r *http.Request) (string, string) {
+	auth, err := parseAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		return "AccessDenied", err.Error()
+	}
+	if auth.accessKey != s.AccessKey {
+		return "InvalidAccessKeyId", "unknown access key " + auth.accessKey
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	requestTime, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil {
+		return "AccessDenied", "invalid X-Amz-Date " + amzDate
+	}
+	if skew := time.Since(requestTime); skew > maxClockSkew || skew < -maxClockSkew {
+		return "RequestTimeTooSkewed", "request time is too far from server time"
+	}
+	if auth.date != amzDate[:8] || auth.region != s.Region || auth.service != "s3" {
+		return "SignatureDoesNotMatch", "credential scope doesn't match request"
+	}
+	if !sort.StringsAreSorted(auth.signedHeaders) || !slices.Contains(auth.signedHeaders, "host") ||
+		!slices.Contains(auth.signedHeaders, "x-amz-date") || !slices.Contains(auth.signedHeaders, "x-amz-content-sha256") {
+		return "SignatureDoesNotMatch", "host, x-amz-date and x-amz-content-sha256 must be signed"
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash != unsignedPayload {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return "IncompleteBody", err.Error()
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if hexSHA256(body) != payloadHash {
+			return "XAmzContentSHA256Mismatch", "payload hash doesn't match body"
+		}
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range auth.signedHeaders {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	// Path is taken as sent, the client must escape it the way it signed it
+	rawPath, rawQuery, _ := strings.Cut(r.RequestURI, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "AuthorizationQueryParametersError", err.Error()
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		rawPath,
+		canonicalQuery(query),
+		canonicalHeaders.String(),
+		strings.Join(auth.signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := auth.date + "/" + auth.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{signAlgorithm, amzDate, scope, hexSHA256([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), auth.date)
+	key = hmacSHA256(key, auth.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	if !hmac.Equal([]byte(hex.EncodeToString(hmacSHA256(key, stringToSign))), []byte(auth.signature)) {
+		return "SignatureDoesNotMatch", "signature doesn't match canonical request:\n" + canonicalRequest
+	}
+	return "", ""
+}
+
+// canonicalQuery sorts query by names and values, escaping everything but unreserved characters
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, uriEncode(name)+"="+uriEncode(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode escapes string the way SigV4 requires
+func uriEncode(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,770 @@
+// Package fakeyandex provides Yandex Disk REST API stand-in for integration tests.
+// Disk and trash are kept in local folders, uploads and downloads go through links
+// issued for a separate file host, and moving or deleting folders runs as asynchronous
+// operation like in the real API.
+package fakeyandex
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiPrefix path of REST API on the server
+const apiPrefix = "/v1/disk"
+
+// Server fake Yandex Disk server
+type Server struct {
+	*httptest.Server
+	Token string
+	// Root local folder holding disk and trash
+	Root string
+	// MaxLimit caps number of items per page of listings when positive
+	MaxLimit int
+	// PendingPolls number of status checks reporting operation as in progress
+	PendingPolls int
+	// TotalSpace reported size of the disk
+	TotalSpace int64
+	// MaxFileUploadSize reported limit of uploaded file size
+	MaxFileUploadSize int64
+
+	files *httptest.Server
+
+	mu         sync.Mutex
+	links      map[string]link
+	operations map[string]int
+	properties map[string]map[string]string
+	trash      map[string]trashEntry
+	requests   map[string]int
+}
+
+// link upload or download link, upload links can be used once
+type link struct {
+	upload   bool
+	diskPath string
+}
+
+// trashEntry resource moved to trash
+type trashEntry struct {
+	originPath string
+	deleted    time.Time
+}
+
+// resource JSON representation of disk or trash resource
+type resource struct {
+	Type             string            `json:"type"`
+	Name             string            `json:"name"`
+	Path             string            `json:"path"`
+	Size             int64             `json:"size,omitempty"`
+	Created          string            `json:"created"`
+	Modified         string            `json:"modified"`
+	MimeType         string            `json:"mime_type,omitempty"`
+	MD5              string            `json:"md5,omitempty"`
+	File             string            `json:"file,omitempty"`
+	CustomProperties map[string]string `json:"custom_properties,omitempty"`
+	OriginPath       string            `json:"origin_path,omitempty"`
+	Deleted          string            `json:"deleted,omitempty"`
+	Embedded         *resourceList     `json:"_embedded,omitempty"`
+}
+
+// resourceList page of folder listing
+type resourceList struct {
+	Items  []resource `json:"items"`
+	Path   string     `json:"path"`
+	Limit  int        `json:"limit"`
+	Offset int        `json:"offset"`
+	Total  int        `json:"total"`
+}
+
+// New starts fake Yandex Disk accepting token, disk and trash are kept in root folder
+func New(token, root string) *Server {
+	s := &Server{
+		Token:             token,
+		Root:              root,
+		TotalSpace:        10 << 30,
+		MaxFileUploadSize: 1 << 30,
+		links:             make(map[string]link),
+		operations:        make(map[string]int),
+		properties:        make(map[string]map[string]string),
+		trash:             make(map[string]trashEntry),
+		requests:          make(map[string]int),
+	}
+	os.MkdirAll(s.diskDir(), 0o755)
+	os.MkdirAll(s.trashDir(), 0o755)
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveAPI))
+	s.files = httptest.NewServer(http.HandlerFunc(s.serveFiles))
+	return s
+}
+
+// Close shuts down API and file hosts
+func (s *Server) Close() {
+	s.Server.Close()
+	s.files.Close()
+}
+
+// APIURL returns base URL of REST API
+func (s *Server) APIURL() string {
+	return s.URL + apiPrefix
+}
+
+// Requests returns number of served requests, key is method and path without API prefix,
+// e.g. "GET /resources", uploads to the file host are counted as "PUT upload"
+func (s *Server) Requests(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[key]
+}
+
+// WriteFile stores file on disk creating missing parent folders
+func (s *Server) WriteFile(diskPath string, data []byte, modTime time.Time) error {
+	localPath := s.localPath(diskPath)
+	if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(localPath, data, 0o644); err != nil {
+		return err
+	}
+	return os.Chtimes(localPath, modTime, modTime)
+}
+
+// ReadFile returns content of file on disk
+func (s *Server) ReadFile(diskPath string) ([]byte, error) {
+	return os.ReadFile(s.localPath(diskPath))
+}
+
+// Properties returns custom properties of resource
+func (s *Server) Properties(diskPath string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.properties[cleanPath(diskPath)]
+}
+
+// TrashPaths returns sorted original paths of resources in trash
+func (s *Server) TrashPaths() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var paths []string
+	for _, entry := range s.trash {
+		paths = append(paths, entry.originPath)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (s *Server) diskDir() string {
+	return filepath.Join(s.Root, "disk")
+}
+
+func (s *Server) trashDir() string {
+	return filepath.Join(s.Root, "trash")
+}
+
+// localPath converts disk path to path in disk folder
+func (s *Server) localPath(diskPath string) string {
+	return filepath.Join(s.diskDir(), filepath.FromSlash(cleanPath(diskPath)))
+}
+
+// cleanPath converts disk:/a, /a and a to /a
+func cleanPath(diskPath string) string {
+	return path.Clean("/" + strings.TrimPrefix(diskPath, "disk:"))
+}
+
+// diskPath converts clean path to path reported by API
+func diskPath(cleanPath string) string {
+	if cleanPath == "/" {
+		return "disk:/"
+	}
+	return "disk:" + cleanPath
+}
+
+// apiError writes error in the format of Yandex Disk API
+func apiError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":       code,
+		"description": description,
+		"message":     description,
+	})
+}
+
+// writeJSON writes JSON response with status
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// newID returns random identifier for links and operations
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	route := strings.TrimPrefix(r.URL.Path, apiPrefix)
+	if route == "" {
+		route = "/"
+	}
+
+	s.mu.Lock()
+	s.requests[r.Method+" "+route]++
+	s.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "OAuth "+s.Token {
+		apiError(w, http.StatusUnauthorized, "UnauthorizedError", "Unauthorized")
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, apiPrefix+"/") {
+		apiError(w, http.StatusNotFound, "NotFoundError", "Resource not found")
+		return
+	}
+
+	query := r.URL.Query()
+	switch {
+	case route == "/" && r.Method == http.MethodGet:
+		s.serveDiskInfo(w)
+	case route == "/resources" && r.Method == http.MethodGet:
+		s.serveResource(w, query.Get("path"), query)
+	case route == "/resources" && r.Method == http.MethodPut:
+		s.serveCreateFolder(w, query.Get("path"))
+	case route == "/resources" && r.Method == http.MethodPatch:
+		s.servePatch(w, r, query.Get("path"))
+	case route == "/resources" && r.Method == http.MethodDelete:
+		s.serveDelete(w, query.Get("path"), query.Get("permanently") == "true")
+	case route == "/resources/upload" && r.Method == http.MethodGet:
+		s.serveUploadLink(w, query.Get("path"), query.Get("overwrite") == "true")
+	case route == "/resources/download" && r.Method == http.MethodGet:
+		s.serveDownloadLink(w, query.Get("path"))
+	case route == "/resources/move" && r.Method == http.MethodPost:
+		s.serveMove(w, query.Get("from"), query.Get("path"), query.Get("overwrite") == "true")
+	case strings.HasPrefix(route, "/operations/") && r.Method == http.MethodGet:
+		s.serveOperation(w, strings.TrimPrefix(route, "/operations/"))
+	case route == "/trash/resources" && r.Method == http.MethodGet:
+		s.serveTrash(w, query)
+	case route == "/trash/resources" && r.Method == http.MethodDelete:
+		s.serveDeleteTrash(w, query.Get("path"))
+	case route == "/trash/resources/restore" && r.Method == http.MethodPut:
+		s.serveRestore(w, query.Get("path"))
+	default:
+		apiError(w, http.StatusMethodNotAllowed, "MethodNotAllowedError", "Method not allowed")
+	}
+}
+
+// serveDiskInfo reports disk space
+func (s *Server) serveDiskInfo(w http.ResponseWriter) {
+	used, _ := dirSize(s.diskDir())
+	trash, _ := dirSize(s.trashDir())
+	writeJSON(w, http.StatusOK, map[string]any{
+		"total_space":          s.TotalSpace,
+		"used_space":           used + trash,
+		"trash_size":           trash,
+		"max_file_upload_size": s.MaxFileUploadSize,
+	})
+}
+
+// serveResource returns resource with a page of its children if it is a folder
+func (s *Server) serveResource(w http.ResponseWriter, filePath string, query map[string][]string) {
+	filePath = cleanPath(filePath)
+	info, err := os.Stat(s.localPath(filePath))
+	if err != nil {
+		apiError(w, http.StatusNotFound, "DiskNotFoundError", "Resource not found.")
+		return
+	}
+
+	res := s.resource(filePath, info)
+	if info.IsDir() {
+		entries, err := os.ReadDir(s.localPath(filePath))
+		if err != nil {
+			apiError(w, http.StatusInternalServerError, "InternalServerError", err.Error())
+			return
+		}
+		var items []resource
+		for _, entry := range entries {
+			if entryInfo, err := entry.Info(); err == nil {
+				items = append(items, s.resource(path.Join(filePath, entry.Name()), entryInfo))
+			}
+		}
+		res.Embedded = s.page(diskPath(filePath), items, query)
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// page returns page of items selected by limit and offset query parameters
+func (s *Server) page(listPath string, items []resource, query map[string][]string) *resourceList {
+	limit, offset := 20, 0
+	if values := query["limit"]; len(values) > 0 {
+		limit, _ = strconv.Atoi(values[0])
+	}
+	if values := query["offset"]; len(values) > 0 {
+		offset, _ = strconv.Atoi(values[0])
+	}
+	if s.MaxLimit > 0 && limit > s.MaxLimit {
+		limit = s.MaxLimit
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	list := &resourceList{Items: []resource{}, Path: listPath, Limit: limit, Offset: offset, Total: len(items)}
+	if offset < len(items) {
+		list.Items = items[offset:min(offset+limit, len(items))]
+	}
+	return list
+}
+
+// resource converts file information to API resource
+func (s *Server) resource(filePath string, info os.FileInfo) resource {
+	res := resource{
+		Type:     "dir",
+		Name:     info.Name(),
+		Path:     diskPath(filePath),
+		Created:  info.ModTime().UTC().Format(time.RFC3339),
+		Modified: info.ModTime().UTC().Format(time.RFC3339),
+	}
+	if filePath == "/" {
+		res.Name = "disk"
+	}
+
+	s.mu.Lock()
+	if props := s.properties[filePath]; len(props) > 0 {
+		res.CustomProperties = make(map[string]string, len(props))
+		for key, value := range props {
+			res.CustomProperties[key] = value
+		}
+	}
+	s.mu.Unlock()
+
+	if !info.IsDir() {
+		res.Type = "file"
+		res.Size = info.Size()
+		res.MimeType = mime.TypeByExtension(path.Ext(filePath))
+		if res.MimeType == "" {
+			res.MimeType = "application/octet-stream"
+		}
+		if data, err := os.ReadFile(s.localPath(filePath)); err == nil {
+			sum := md5.Sum(data)
+			res.MD5 = hex.EncodeToString(sum[:])
+		}
+		res.File = s.files.URL + "/download/" + s.newLink(link{diskPath: filePath})
+	}
+	return res
+}
+
+// serveCreateFolder creates folder, parent folder must exist
+func (s *Server) serveCreateFolder(w http.ResponseWriter, filePath string) {
+	filePath = cleanPath(filePath)
+	localPath := s.localPath(filePath)
+	if _, err := os.Stat(localPath); err == nil {
+		apiError(w, http.StatusConflict, "DiskPathPointsToExistentDirectoryError", "Specified path points to existent directory.")
+		return
+	}
+	if info, err := os.Stat(filepath.Dir(localPath)); err != nil || !info.IsDir() {
+		apiError(w, http.StatusConflict, "DiskPathDoesntExistsError", "Specified path doesn't exist.")
+		return
+	}
+	if err := os.Mkdir(localPath, 0o755); err != nil {
+		apiError(w, http.StatusInternalServerError, "InternalServerError", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"href":      s.APIURL() + "/resources?path=" + diskPath(filePath),
+		"method":    "GET",
+		"templated": false,
+	})
+}
+
+// servePatch merges custom properties, properties with null value are removed
+func (s *Server) servePatch(w http.ResponseWriter, r *http.Request, filePath string) {
+	filePath = cleanPath(filePath)
+	info, err := os.Stat(s.localPath(filePath))
+	if err != nil {
+		apiError(w, http.StatusNotFound, "DiskNotFoundError", "Resource not found.")
+		return
+	}
+
+	var body struct {
+		CustomProperties map[string]*string `json:"custom_properties"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiError(w, http.StatusBadRequest, "FieldValidationError", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	props := s.properties[filePath]
+	if props == nil {
+		props = make(map[string]string)
+		s.properties[filePath] = props
+	}
+	for key, value := range body.CustomProperties {
+		if value == nil {
+			delete(props, key)
+		} else {
+			props[key] = *value
+		}
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, s.resource(filePath, info))
+}
+
+// serveDelete moves resource to trash or deletes it permanently, folders are deleted asynchronously
+func (s *Server) serveDelete(w http.ResponseWriter, filePath string, permanently bool) {
+	filePath = cleanPath(filePath)
+	localPath := s.localPath(filePath)
+	info, err := os.Stat(localPath)
+	if err != nil || filePath == "/" {
+		apiError(w, http.StatusNotFound, "DiskNotFoundError", "Resource not found.")
+		return
+	}
+
+	if permanently {
+		err = os.RemoveAll(localPath)
+	} else {
+		trashName := path.Base(filePath) + "_" + newID()[:8]
+		err = os.Rename(localPath, filepath.Join(s.trashDir(), trashName))
+		if err == nil {
+			s.mu.Lock()
+			s.trash[trashName] = trashEntry{originPath: filePath, deleted: time.Now()}
+			s.mu.Unlock()
+		}
+	}
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "InternalServerError", err.Error())
+		return
+	}
+	s.moveProperties(filePath, "")
+
+	if info.IsDir() {
+		s.acceptOperation(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveUploadLink issues link for uploading file to the file host
+func (s *Server) serveUploadLink(w http.ResponseWriter, filePath string, overwrite bool) {
+	filePath = cleanPath(filePath)
+	localPath := s.localPath(filePath)
+	if info, err := os.Stat(filepath.Dir(localPath)); err != nil || !info.IsDir() {
+		apiError(w, http.StatusConflict, "DiskPathDoesntExistsError", "Specified path doesn't exist.")
+		return
+	}
+	if _, err := os.Stat(localPath); err == nil && !overwrite {
+		apiError(w, http.StatusConflict, "DiskResourceAlreadyExistsError", "Resource already exists.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"operation_id": newID(),
+		"href":         s.files.URL + "/upload/" + s.newLink(link{upload: true, diskPath: filePath}),
+		"method":       "PUT",
+		"templated":    false,
+	})
+}
+
+// serveDownloadLink issues link for downloading file from the file host
+func (s *Server) serveDownloadLink(w http.ResponseWriter, filePath string) {
+	filePath = cleanPath(filePath)
+	if _, err := os.Stat(s.localPath(filePath)); err != nil {
+		apiError(w, http.StatusNotFound, "DiskNotFoundError", "Resource not found.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"href":      s.files.URL + "/download/" + s.newLink(link{diskPath: filePath}),
+		"method":    "GET",
+		"templated": false,
+	})
+}
+
+// newLink registers one-time link and returns its token
+func (s *Server) newLink(l link) string {
+	token := newID()
+	s.mu.Lock()
+	s.links[token] = l
+	s.mu.Unlock()
+	return token
+}
+
+// serveMove moves resource, folders are moved asynchronously
+func (s *Server) serveMove(w http.ResponseWriter, from, to string, overwrite bool) {
+	from, to = cleanPath(from), cleanPath(to)
+	fromLocal, toLocal := s.localPath(from), s.localPath(to)
+
+	info, err := os.Stat(fromLocal)
+	if err != nil {
+		apiError(w, http.StatusNotFound, "DiskNotFoundError", "Resource not found.")
+		return
+	}
+	if parent, err := os.Stat(filepath.Dir(toLocal)); err != nil || !parent.IsDir() {
+		apiError(w, http.StatusConflict, "DiskPathDoesntExistsError", "Specified path doesn't exist.")
+		return
+	}
+	if _, err := os.Stat(toLocal); err == nil {
+		if !overwrite {
+			apiError(w, http.StatusConflict, "DiskResourceAlreadyExistsError", "Resource already exists.")
+			return
+		}
+		os.RemoveAll(toLocal)
+		s.moveProperties(to, "")
+	}
+	if err := os.Rename(fromLocal, toLocal); err != nil {
+		apiError(w, http.StatusInternalServerError, "InternalServerError", err.Error())
+		return
+	}
+	s.moveProperties(from, to)
+
+	if info.IsDir() {
+		s.acceptOperation(w)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"href":      s.APIURL() + "/resources?path=" + diskPath(to),
+		"method":    "GET",
+		"templated": false,
+	})
+}
+
+// moveProperties moves custom properties of resource and its children, empty to drops them
+func (s *Server) moveProperties(from, to string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for p, props := range s.properties {
+		if p != from && !strings.HasPrefix(p, from+"/") {
+			continue
+		}
+		delete(s.properties, p)
+		if to != "" {
+			s.properties[to+strings.TrimPrefix(p, from)] = props
+		}
+	}
+}
+
+// acceptOperation responds with link to asynchronous operation
+func (s *Server) acceptOperation(w http.ResponseWriter) {
+	id := newID()
+	s.mu.Lock()
+	s.operations[id] = s.PendingPolls
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"href":      s.APIURL() + "/operations/" + id,
+		"method":    "GET",
+		"templated": false,
+	})
+}
+
+// serveOperation reports status of asynchronous operation, the work itself is already done
+func (s *Server) serveOperation(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	pending, ok := s.operations[id]
+	if ok && pending > 0 {
+		s.operations[id] = pending - 1
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		apiError(w, http.StatusNotFound, "NotFoundError", "Operation not found.")
+		return
+	}
+	status := "success"
+	if pending > 0 {
+		status = "in-progress"
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": status})
+}
+
+// serveTrash lists resources in trash
+func (s *Server) serveTrash(w http.ResponseWriter, query map[string][]string) {
+	s.mu.Lock()
+	entries := make(map[string]trashEntry, len(s.trash))
+	for name, entry := range s.trash {
+		entries[name] = entry
+	}
+	s.mu.Unlock()
+
+	var items []resource
+	for name, entry := range entries {
+		info, err := os.Stat(filepath.Join(s.trashDir(), name))
+		if err != nil {
+			continue
+		}
+		res := resource{
+			Type:       "dir",
+			Name:       path.Base(entry.originPath),
+			Path:       "trash:/" + name,
+			Created:    info.ModTime().UTC().Format(time.RFC3339),
+			Modified:   info.ModTime().UTC().Format(time.RFC3339),
+			OriginPath: diskPath(entry.originPath),
+			Deleted:    entry.deleted.UTC().Format(time.RFC3339),
+		}
+		if !info.IsDir() {
+			res.Type = "file"
+			res.Size = info.Size()
+		}
+		items = append(items, res)
+	}
+
+	writeJSON(w, http.StatusOK, resource{
+		Type:     "dir",
+		Name:     "trash",
+		Path:     "trash:/",
+		Embedded: s.page("trash:/", items, query),
+	})
+}
+
+// trashName converts trash:/name to name of resource in trash
+func trashName(trashPath string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(trashPath, "trash:")), "/")
+}
+
+// serveRestore restores resource from trash to its original location
+func (s *Server) serveRestore(w http.ResponseWriter, trashPath string) {
+	name := trashName(trashPath)
+	s.mu.Lock()
+	entry, ok := s.trash[name]
+	s.mu.Unlock()
+	if !ok {
+		apiError(w, http.StatusNotFound, "DiskNotFoundError", "Resource not found.")
+		return
+	}
+
+	localPath := s.localPath(entry.originPath)
+	if _, err := os.Stat(localPath); err == nil {
+		apiError(w, http.StatusConflict, "DiskResourceAlreadyExistsError", "Resource already exists.")
+		return
+	}
+	os.MkdirAll(filepath.Dir(localPath), 0o755)
+	if err := os.Rename(filepath.Join(s.trashDir(), name), localPath); err != nil {
+		apiError(w, http.StatusInternalServerError, "InternalServerError", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	delete(s.trash, name)
+	s.mu.Unlock()
+
+	if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+		s.acceptOperation(w)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"href":      s.APIURL() + "/resources?path=" + diskPath(entry.originPath),
+		"method":    "GET",
+		"templated": false,
+	})
+}
+
+// serveDeleteTrash deletes resource from trash, empty path empties the whole trash
+func (s *Server) serveDeleteTrash(w http.ResponseWriter, trashPath string) {
+	name := trashName(trashPath)
+
+	s.mu.Lock()
+	var names []string
+	if name == "" {
+		for n := range s.trash {
+			names = append(names, n)
+		}
+	} else if _, ok := s.trash[name]; ok {
+		names = append(names, name)
+	}
+	for _, n := range names {
+		delete(s.trash, n)
+	}
+	s.mu.Unlock()
+
+	if name != "" && len(names) == 0 {
+		apiError(w, http.StatusNotFound, "DiskNotFoundError", "Resource not found.")
+		return
+	}
+	for _, n := range names {
+		os.RemoveAll(filepath.Join(s.trashDir(), n))
+	}
+
+	if name == "" {
+		s.acceptOperation(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveFiles serves one-time upload and download links
+func (s *Server) serveFiles(w http.ResponseWriter, r *http.Request) {
+	kind, token, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	s.mu.Lock()
+	s.requests[r.Method+" "+kind]++
+	l, ok := s.links[token]
+	if ok && l.upload {
+		// Upload links can be used once, download links stay valid like the real ones for a while
+		delete(s.links, token)
+	}
+	s.mu.Unlock()
+
+	switch {
+	case !ok:
+		http.Error(w, "Link expired", http.StatusNotFound)
+	case kind == "upload" && l.upload && r.Method == http.MethodPut:
+		s.serveUpload(w, r, l.diskPath)
+	case kind == "download" && !l.upload && r.Method == http.MethodGet:
+		http.ServeFile(w, r, s.localPath(l.diskPath))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveUpload stores uploaded file
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request, filePath string) {
+	localPath := s.localPath(filePath)
+	tmp, err := os.CreateTemp(s.Root, ".upload-*")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, r.Body)
+	tmp.Close()
+	if err != nil || (r.ContentLength >= 0 && written != r.ContentLength) {
+		http.Error(w, "Incomplete upload", http.StatusBadRequest)
+		return
+	}
+	if err := os.Rename(tmp.Name(), localPath); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.moveProperties(filePath, "")
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// dirSize returns total size of files in folder
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// newLogHandler creates log handler writing records of at least level in format
+func newLogHandler(w io.Writer, format string, level slog.Level) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+}
+
+// logLevel returns configured log level, --verbose and --quiet take precedence over --log-level
+func logLevel() (slog.Level, error) {
+	switch {
+	case viper.GetBool("log.verbose"):
+		return slog.LevelDebug, nil
+	case viper.GetBool("log.quiet"):
+		return slog.LevelWarn, nil
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(viper.GetString("log.level"))); err != nil {
+		return 0, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", viper.GetString("log.level"))
+	}
+	return level, nil
+}
+
+// initLogging installs default logger configured by log flags
+func initLogging() {
+	level, err := logLevel()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitConfig)
+	}
+	handler, err := newLogHandler(os.Stderr, viper.GetString("log.format"), level)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitConfig)
+	}
+	slog.SetDefault(slog.New(handler))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"nextya-sync/metrics"
+	"nextya-sync/processor"
+
+	"github.com/spf13/viper"
+)
+
+// metricsEnabled reports whether metrics are served or written to textfile
+func metricsEnabled() bool {
+	return viper.GetString("metrics.listen") != "" || viper.GetString("metrics.textfile") != ""
+}
+
+// startMetrics serves /metrics in background if --metrics-listen is set and
+// restores metrics of the previous run from textfile
+func startMetrics() {
+	if textfile := viper.GetString("metrics.textfile"); textfile != "" {
+		if err := metrics.LoadTextfile(textfile); err != nil {
+			slog.Warn("Failed to read previous metrics", "path", textfile, "error", err)
+		}
+	}
+
+	addr := viper.GetString("metrics.listen")
+	if addr == "" {
+		return
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal("Failed to serve metrics", "address", addr, "error", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			slog.Error("Metrics server stopped", "error", err)
+		}
+	}()
+	slog.Info("Serving metrics", "address", listener.Addr().String())
+}
+
+// observeRun records file results and outcome of the run, writing them to textfile if configured
+func observeRun(report *processor.Report, duration time.Duration, err error) {
+	if report == nil {
+		return
+	}
+
+	for _, result := range report.Files {
+		var transferred int64
+		if result.Action == processor.ActionUploaded || result.Action == processor.ActionUpdated {
+			transferred = result.Size
+		}
+		metrics.ObserveFile(string(result.Action), transferred, result.Err)
+	}
+
+	// Errors of failed files are counted with their results
+	runErr := err
+	if errors.Is(err, processor.ErrPartial) {
+		runErr = nil
+	}
+	metrics.ObserveRun(duration, err == nil, runErr)
+
+	if textfile := viper.GetString("metrics.textfile"); textfile != "" {
+		if err := metrics.WriteTextfile(textfile); err != nil {
+			slog.Error("Failed to write metrics", "path", textfile, "error", err)
+		}
+	}
+}
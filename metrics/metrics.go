@@ -0,0 +1,180 @@
+// Package metrics collects Prometheus metrics of synchronization runs and API requests
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"nextya-sync/backend"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Registry registry of all nextya-sync metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	filesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nextya_sync_files_total",
+		Help: "Files processed by synchronization runs by action.",
+	}, []string{"action"})
+	transferredBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "nextya_sync_transferred_bytes_total",
+		Help: "Size of uploaded and updated files.",
+	})
+	errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nextya_sync_errors_total",
+		Help: "Errors of synchronization runs by type.",
+	}, []string{"type"})
+	runsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nextya_sync_runs_total",
+		Help: "Synchronization runs by result.",
+	}, []string{"result"})
+	runDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "nextya_sync_run_duration_seconds",
+		Help: "Duration of the last synchronization run.",
+	})
+	lastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: lastSuccessName,
+		Help: "Time the last successful synchronization run finished.",
+	})
+	apiRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nextya_sync_api_requests_total",
+		Help: "API requests by client, endpoint and response code.",
+	}, []string{"client", "method", "endpoint", "code"})
+	apiDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "nextya_sync_api_request_duration_seconds",
+		Help:    "Latency of API requests until response headers are received.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 8),
+	}, []string{"client", "method", "endpoint"})
+)
+
+// lastSuccessName name of the last success metric, kept across runs in textfile
+const lastSuccessName = "nextya_sync_last_success_timestamp_seconds"
+
+func init() {
+	Registry.MustRegister(filesTotal, transferredBytes, errorsTotal, runsTotal, runDuration, lastSuccess, apiRequests, apiDuration)
+}
+
+// ObserveFile records outcome of a single file, transferred bytes and its error if any
+func ObserveFile(action string, transferred int64, err error) {
+	filesTotal.WithLabelValues(action).Inc()
+	transferredBytes.Add(float64(transferred))
+	if err != nil {
+		errorsTotal.WithLabelValues(ErrorType(err)).Inc()
+	}
+}
+
+// ObserveRun records outcome of the whole run, err is the error the run ended with if any
+func ObserveRun(duration time.Duration, success bool, err error) {
+	if err != nil {
+		errorsTotal.WithLabelValues(ErrorType(err)).Inc()
+	}
+
+	runDuration.Set(duration.Seconds())
+	if !success {
+		runsTotal.WithLabelValues("failure").Inc()
+		return
+	}
+	runsTotal.WithLabelValues("success").Inc()
+	lastSuccess.SetToCurrentTime()
+}
+
+// ErrorType classifies error for the errors metric
+func ErrorType(err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return "unknown"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, backend.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, backend.ErrUnauthorized):
+		return "unauthorized"
+	case errors.Is(err, backend.ErrForbidden):
+		return "permission"
+	case errors.Is(err, backend.ErrQuotaExceeded):
+		return "quota_exceeded"
+	case errors.Is(err, backend.ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, backend.ErrConflict):
+		return "conflict"
+	case errors.Is(err, backend.ErrServer):
+		return "server"
+	case errors.Is(err, backend.ErrNetwork), errors.As(err, &netErr):
+		return "network"
+	default:
+		return "other"
+	}
+}
+
+// Handler serves metrics in Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// WriteTextfile atomically writes metrics to file read by node_exporter textfile collector
+func WriteTextfile(filename string) error {
+	return prometheus.WriteToTextfile(filename, Registry)
+}
+
+// LoadTextfile restores time of the last successful run from textfile written by previous run,
+// so failed runs of cron mode don't reset it. Missing file is not an error.
+func LoadTextfile(filename string) error {
+	file, err := os.Open(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(file)
+	if err != nil {
+		return err
+	}
+	if family, ok := families[lastSuccessName]; ok && len(family.GetMetric()) > 0 {
+		lastSuccess.Set(family.GetMetric()[0].GetGauge().GetValue())
+	}
+	return nil
+}
+
+// Transport records count and latency of requests sent by client, endpoint names request with low cardinality
+func Transport(client string, endpoint func(*http.Request) string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripper(func(req *http.Request) (*http.Response, error) {
+		name := endpoint(req)
+		start := time.Now()
+		resp, err := next.RoundTrip(req)
+		apiDuration.WithLabelValues(client, req.Method, name).Observe(time.Since(start).Seconds())
+
+		code := "error"
+		if err == nil {
+			code = strconv.Itoa(resp.StatusCode)
+		}
+		apiRequests.WithLabelValues(client, req.Method, name, code).Inc()
+		return resp, err
+	})
+}
+
+// roundTripper adapts function to http.RoundTripper
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
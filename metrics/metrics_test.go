@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nextya-sync/backend"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: Transport("test", func(r *http.Request) string { return r.URL.Path }, nil)}
+	for _, p := range []string{"/ok", "/ok", "/missing"} {
+		resp, err := client.Get(server.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	if got := testutil.ToFloat64(apiRequests.WithLabelValues("test", "GET", "/ok", "200")); got != 2 {
+		t.Errorf("requests to /ok = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(apiRequests.WithLabelValues("test", "GET", "/missing", "404")); got != 1 {
+		t.Errorf("requests to /missing = %v, want 1", got)
+	}
+	if got := testutil.CollectAndCount(apiDuration); got == 0 {
+		t.Errorf("no request latencies recorded")
+	}
+}
+
+func TestErrorType(t *testing.T) {
+	for err, want := range map[error]string{
+		fmt.Errorf("get info: %w", backend.ErrNotFound):    "not_found",
+		fmt.Errorf("upload: %w", backend.ErrQuotaExceeded): "quota_exceeded",
+		fmt.Errorf("list: %w", backend.ErrNetwork):         "network",
+		errors.New("unexpected"):                           "other",
+	} {
+		if got := ErrorType(err); got != want {
+			t.Errorf("ErrorType(%v) = %q, want %q", err, got, want)
+		}
+	}
+}
+
+func TestTextfileKeepsLastSuccess(t *testing.T) {
+	textfile := filepath.Join(t.TempDir(), "nextya-sync.prom")
+
+	ObserveRun(time.Second, true, nil)
+	success := testutil.ToFloat64(lastSuccess)
+	if success == 0 {
+		t.Fatal("successful run didn't set last success time")
+	}
+	if err := WriteTextfile(textfile); err != nil {
+		t.Fatal(err)
+	}
+
+	// Next cron run starts from scratch and fails
+	lastSuccess.Set(0)
+	if err := LoadTextfile(textfile); err != nil {
+		t.Fatal(err)
+	}
+	ObserveRun(time.Second, false, errors.New("failed"))
+	if got := testutil.ToFloat64(lastSuccess); got != success {
+		t.Errorf("last success after failed run = %v, want %v", got, success)
+	}
+	if got := testutil.ToFloat64(runsTotal.WithLabelValues("failure")); got != 1 {
+		t.Errorf("failed runs = %v, want 1", got)
+	}
+}
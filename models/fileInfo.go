@@ -4,12 +4,24 @@ import "time"
 
 // FileInfo represents file information
 type FileInfo struct {
-	Name        string    `json:"name"`
-	Path        string    `json:"path"`
-	Size        int64     `json:"size"`
-	IsDir       bool      `json:"is_dir"`
-	ModTime     time.Time `json:"mod_time"`
-	ETag        string    `json:"etag,omitempty"`
-	ContentType string    `json:"content_type,omitempty"`
-	DownloadURL string    `json:"download_url,omitempty"`
+	ID          string            `json:"id,omitempty"`
+	Name        string            `json:"name"`
+	Path        string            `json:"path"`
+	Size        int64             `json:"size"`
+	IsDir       bool              `json:"is_dir"`
+	ModTime     time.Time         `json:"mod_time"`
+	ETag        string            `json:"etag,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	DownloadURL string            `json:"download_url,omitempty"`
+	Properties  map[string]string `json:"properties,omitempty"`
+}
+
+// TrashItem represents resource in trash
+type TrashItem struct {
+	Name       string    `json:"name"`
+	Path       string    `json:"path"`
+	OriginPath string    `json:"origin_path"`
+	Size       int64     `json:"size"`
+	IsDir      bool      `json:"is_dir"`
+	Deleted    time.Time `json:"deleted"`
 }
@@ -3,8 +3,12 @@ package models
 import "time"
 
 type File struct {
-	Path     string
-	Modified time.Time
+	ID          string
+	Path        string
+	Size        int64
+	Modified    time.Time
+	ContentType string
+	Properties  map[string]string
 }
 
 type Folder struct {
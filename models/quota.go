@@ -0,0 +1,14 @@
+package models
+
+// Quota represents storage space information
+type Quota struct {
+	Total       int64 `json:"total"`
+	Used        int64 `json:"used"`
+	Trash       int64 `json:"trash"`
+	MaxFileSize int64 `json:"max_file_size,omitempty"`
+}
+
+// Free returns free space
+func (q Quota) Free() int64 {
+	return q.Total - q.Used
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"nextya-sync/notify"
+	"nextya-sync/processor"
+
+	"github.com/spf13/viper"
+)
+
+// notifyTimeout limit of sending all notifications of a run
+const notifyTimeout = time.Minute
+
+var (
+	// notifier sends results of runs, nil if notifications are disabled
+	notifier *notify.Notifier
+	// notifiedErr error of the run already sent by processor notifier
+	notifiedErr error
+)
+
+// initNotifier creates notifier configured by notify settings
+func initNotifier() {
+	cfg := notify.Config{
+		Targets:  viper.GetStringSlice("notify.targets"),
+		On:       notify.Condition(viper.GetString("notify.on")),
+		Subject:  viper.GetString("notify.subject"),
+		Template: viper.GetString("notify.template"),
+	}
+	if templateFile := viper.GetString("notify.template_file"); templateFile != "" {
+		data, err := os.ReadFile(templateFile)
+		if err != nil {
+			fatalConfig("Failed to read notification template", "path", templateFile, "error", err)
+		}
+		cfg.Template = string(data)
+	}
+
+	var err error
+	if notifier, err = notify.New(cfg); err != nil {
+		fatalConfig("Invalid notification settings", "error", err)
+	}
+}
+
+// syncNotifier notifies about results of processor runs
+type syncNotifier struct{}
+
+// Notify sends summary of the run
+func (syncNotifier) Notify(ctx context.Context, stats processor.SyncStats, err error) {
+	event := newEvent()
+	event.Duration = stats.Duration
+	event.Files = stats.TotalFiles
+	event.Uploaded = stats.UploadedFiles
+	event.Skipped = stats.SkippedFiles
+	event.Errors = stats.ErrorFiles
+	event.Versions = stats.UploadedVersions
+	event.Deleted = stats.DeletedFiles
+	event.Bytes = stats.UploadedBytes
+	if err != nil {
+		event.Status = notify.StatusFailure
+		event.Error = err.Error()
+		notifiedErr = err
+	}
+	sendNotification(ctx, event)
+}
+
+// failureNotifier notifies only about failed processor runs, used for frequent runs of single batches
+type failureNotifier struct{}
+
+// Notify sends summary of the run if it failed
+func (failureNotifier) Notify(ctx context.Context, stats processor.SyncStats, err error) {
+	if err != nil || stats.ErrorFiles > 0 {
+		syncNotifier{}.Notify(ctx, stats, err)
+	}
+}
+
+// processorNotifier returns notifier of processor runs, nil if notifications are disabled
+func processorNotifier() processor.Notifier {
+	if notifier == nil {
+		return nil
+	}
+	return syncNotifier{}
+}
+
+// notifyFatal notifies about fatal error
+func notifyFatal(msg string, args ...any) {
+	if notifier == nil {
+		return
+	}
+
+	event := newEvent()
+	event.Status = notify.StatusFailure
+	event.Error = msg
+	for i := 0; i+1 < len(args); i += 2 {
+		event.Error += fmt.Sprintf(" %v=%v", args[i], args[i+1])
+	}
+	sendNotification(context.Background(), event)
+}
+
+func newEvent() notify.Event {
+	host, _ := os.Hostname()
+	return notify.Event{
+		Status:   notify.StatusSuccess,
+		Host:     host,
+		Finished: time.Now(),
+	}
+}
+
+func sendNotification(ctx context.Context, event notify.Event) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), notifyTimeout)
+	defer cancel()
+	if err := notifier.Notify(ctx, event); err != nil {
+		slog.Error("Failed to send notification", "error", err)
+	}
+}
@@ -0,0 +1,145 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Email sends messages over SMTP, STARTTLS is used if server supports it
+type Email struct {
+	// Addr host:port of SMTP server
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+	// ImplicitTLS connects with TLS from the start (smtps), usually on port 465
+	ImplicitTLS bool
+}
+
+// newEmail creates sender for smtp(s)://user:password@host:port?from=...&to=a,b
+func newEmail(u *url.URL) (*Email, error) {
+	query := u.Query()
+	e := &Email{
+		Addr:        u.Host,
+		From:        query.Get("from"),
+		ImplicitTLS: u.Scheme == "smtps",
+	}
+	for _, to := range strings.Split(query.Get("to"), ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			e.To = append(e.To, to)
+		}
+	}
+	if u.Hostname() == "" || e.From == "" || len(e.To) == 0 {
+		return nil, fmt.Errorf("email target must look like %s://user:password@host:port?from=sender&to=recipient", u.Scheme)
+	}
+	if u.Port() == "" {
+		port := "587"
+		if e.ImplicitTLS {
+			port = "465"
+		}
+		e.Addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	if u.User != nil {
+		e.Username = u.User.Username()
+		e.Password, _ = u.User.Password()
+	}
+	return e, nil
+}
+
+// Send sends message to all recipients
+func (e *Email) Send(ctx context.Context, msg Message) error {
+	if err := e.send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+func (e *Email) send(ctx context.Context, msg Message) error {
+	host, _, err := net.SplitHostPort(e.Addr)
+	if err != nil {
+		return err
+	}
+
+	dialer := &net.Dialer{Timeout: sendTimeout}
+	var conn net.Conn
+	if e.ImplicitTLS {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", e.Addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", e.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(sendTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && !e.ImplicitTLS {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if e.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.Username, e.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(e.From); err != nil {
+		return err
+	}
+	for _, to := range e.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(e.compose(msg, time.Now())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// compose builds plain text email with quoted-printable body
+func (e *Email) compose(msg Message, now time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", e.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(strings.ReplaceAll(msg.Text, "\n", "\r\n")))
+	qp.Close()
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,207 @@
+// Package notify sends messages about results of synchronization runs to webhooks, Telegram bots and email
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Event result of a synchronization run
+type Event struct {
+	Status   string        `json:"status"`
+	Host     string        `json:"host"`
+	Finished time.Time     `json:"finished"`
+	Duration time.Duration `json:"-"`
+	Files    int           `json:"files"`
+	Uploaded int           `json:"uploaded"`
+	Skipped  int           `json:"skipped"`
+	Errors   int           `json:"errors"`
+	Versions int           `json:"versions"`
+	Deleted  int           `json:"deleted"`
+	Bytes    int64         `json:"bytes"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Statuses of events
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+)
+
+// Failed reports whether the run ended with an error or failed files
+func (e Event) Failed() bool {
+	return e.Status == StatusFailure || e.Errors > 0
+}
+
+// Changed reports whether the run stored or removed anything in destination
+func (e Event) Changed() bool {
+	return e.Uploaded > 0 || e.Versions > 0 || e.Deleted > 0
+}
+
+// Condition decides which events are sent
+type Condition string
+
+const (
+	// Always sends every event
+	Always Condition = "always"
+	// OnError sends events of failed runs
+	OnError Condition = "error"
+	// OnChanges sends events of runs which stored files or failed
+	OnChanges Condition = "changes"
+)
+
+// matches reports whether event must be sent
+func (c Condition) matches(e Event) bool {
+	switch c {
+	case Always:
+		return true
+	case OnChanges:
+		return e.Changed() || e.Failed()
+	default:
+		return e.Failed()
+	}
+}
+
+// Message rendered notification
+type Message struct {
+	Subject string
+	Text    string
+	Event   Event
+}
+
+// Sender delivers messages to a single target
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// DefaultSubject default template of message subject
+const DefaultSubject = `nextya-sync {{.Status}} on {{.Host}}`
+
+// DefaultTemplate default template of message text
+const DefaultTemplate = `nextya-sync {{.Status}} on {{.Host}}
+Files: {{.Files}}, uploaded: {{.Uploaded}}, skipped: {{.Skipped}}, errors: {{.Errors}}
+Transferred {{.Bytes}} bytes in {{.Duration}}
+{{- if .Error}}
+Error: {{.Error}}
+{{- end}}
+`
+
+// Config notification settings
+type Config struct {
+	// Targets URLs of targets: http(s) webhooks, telegram://token@chat_id, smtp(s)://user:password@host:port?from=...&to=...
+	Targets []string
+	// On condition of sending, OnError if empty
+	On Condition
+	// Subject and Template text/template of subject and text of messages, defaults if empty
+	Subject  string
+	Template string
+}
+
+// Notifier renders events and sends them to all targets
+type Notifier struct {
+	senders []Sender
+	on      Condition
+	subject *template.Template
+	text    *template.Template
+}
+
+// New creates notifier, nil if no targets are configured
+func New(cfg Config) (*Notifier, error) {
+	if len(cfg.Targets) == 0 {
+		return nil, nil
+	}
+
+	n := &Notifier{on: cfg.On}
+	switch n.on {
+	case "":
+		n.on = OnError
+	case Always, OnError, OnChanges:
+	default:
+		return nil, fmt.Errorf("unknown notification condition %q, expected always, error or changes", cfg.On)
+	}
+
+	var err error
+	if n.subject, err = parseTemplate("subject", cfg.Subject, DefaultSubject); err != nil {
+		return nil, err
+	}
+	if n.text, err = parseTemplate("text", cfg.Template, DefaultTemplate); err != nil {
+		return nil, err
+	}
+
+	for _, target := range cfg.Targets {
+		sender, err := NewSender(target)
+		if err != nil {
+			return nil, err
+		}
+		n.senders = append(n.senders, sender)
+	}
+	return n, nil
+}
+
+func parseTemplate(name, text, fallback string) (*template.Template, error) {
+	if text == "" {
+		text = fallback
+	}
+	t, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification %s template: %w", name, err)
+	}
+	return t, nil
+}
+
+// NewSender creates sender for target URL
+func NewSender(target string) (Sender, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification target: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return &Webhook{URL: target}, nil
+	case "telegram":
+		return newTelegram(u)
+	case "smtp", "smtps":
+		return newEmail(u)
+	default:
+		return nil, fmt.Errorf("unsupported notification target scheme %q", u.Scheme)
+	}
+}
+
+// Notify sends event to all targets if it matches the condition, errors of all targets are joined
+func (n *Notifier) Notify(ctx context.Context, e Event) error {
+	if !n.on.matches(e) {
+		return nil
+	}
+
+	msg := Message{Event: e}
+	var err error
+	if msg.Subject, err = render(n.subject, e); err != nil {
+		return err
+	}
+	if msg.Text, err = render(n.text, e); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, sender := range n.senders {
+		if err := sender.Send(ctx, msg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func render(t *template.Template, e Event) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, e); err != nil {
+		return "", fmt.Errorf("failed to render notification: %w", err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// TelegramAPIURL base URL of Telegram Bot API
+const TelegramAPIURL = "https://api.telegram.org"
+
+// Telegram sends messages with a bot to a chat
+type Telegram struct {
+	// APIURL base URL of Bot API, e.g. of a self-hosted server
+	APIURL string
+	Token  string
+	ChatID string
+}
+
+// newTelegram creates sender for telegram://token@chat_id, optional api query parameter overrides Bot API URL
+func newTelegram(u *url.URL) (*Telegram, error) {
+	if u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return nil, fmt.Errorf("Telegram target must look like telegram://token@chat_id")
+	}
+
+	// Bot tokens look like 123456:ABC, so their second part is parsed as password
+	token := u.User.Username()
+	if secret, ok := u.User.Password(); ok {
+		token += ":" + secret
+	}
+
+	apiURL := u.Query().Get("api")
+	if apiURL == "" {
+		apiURL = TelegramAPIURL
+	}
+	return &Telegram{
+		APIURL: strings.TrimSuffix(apiURL, "/"),
+		Token:  token,
+		ChatID: u.Host,
+	}, nil
+}
+
+// Send sends message text to the chat
+func (t *Telegram) Send(ctx context.Context, msg Message) error {
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	resp, err := resty.New().SetTimeout(sendTimeout).R().
+		SetContext(ctx).
+		SetBody(map[string]string{
+			"chat_id": t.ChatID,
+			"text":    msg.Text,
+		}).
+		SetResult(&result).
+		SetError(&result).
+		Post(t.APIURL + "/bot" + t.Token + "/sendMessage")
+	if err != nil {
+		return fmt.Errorf("failed to send Telegram message: %w", err)
+	}
+	if resp.IsError() || !result.OK {
+		return fmt.Errorf("failed to send Telegram message: status %d: %s", resp.StatusCode(), result.Description)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// sendTimeout limit of a single delivery attempt
+const sendTimeout = 30 * time.Second
+
+// Webhook posts messages as JSON to URL, the text field makes it compatible with Slack and Mattermost
+type Webhook struct {
+	URL string
+}
+
+// webhookPayload JSON body of webhook requests
+type webhookPayload struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	Event
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// Send posts message to webhook
+func (w *Webhook) Send(ctx context.Context, msg Message) error {
+	resp, err := resty.New().SetTimeout(sendTimeout).R().
+		SetContext(ctx).
+		SetBody(webhookPayload{
+			Subject:    msg.Subject,
+			Text:       msg.Text,
+			Event:      msg.Event,
+			DurationMs: msg.Event.Duration.Milliseconds(),
+		}).
+		Post(w.URL)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook: %w", err)
+	}
+	if resp.IsError() {
+		return fmt.Errorf("failed to send webhook: status %d", resp.StatusCode())
+	}
+	return nil
+}
@@ -0,0 +1,495 @@
+package processor
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/models"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// archiveIndexName name of the index file describing archive members of a directory
+	archiveIndexName = ".nextya-archive.json"
+	// archiveName name of the archive holding small files of a directory
+	archiveName = ".nextya-archive.tar"
+	// compressedArchiveName name of the zstd-compressed archive holding small files of a directory
+	compressedArchiveName = ".nextya-archive.tar.zst"
+)
+
+// isArchiveFile reports whether name is one of the files archive mode stores in a directory
+func isArchiveFile(name string) bool {
+	switch name {
+	case archiveIndexName, archiveName, compressedArchiveName:
+		return true
+	}
+	return false
+}
+
+// ArchiveConfig holds configuration of archive mode
+type ArchiveConfig struct {
+	// Paths sync paths stored in archive mode
+	Paths []string
+	// Threshold files smaller than threshold are packed into archives
+	Threshold int64
+	// Compress compresses archives with zstd
+	Compress bool
+}
+
+// enabled reports whether archive mode is enabled for sync path
+func (cfg ArchiveConfig) enabled(syncPath string) bool {
+	for _, p := range cfg.Paths {
+		if p == syncPath {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveIndex describes which files of a directory live in its archive
+type archiveIndex struct {
+	Archive    string          `json:"archive"`
+	Compressed bool            `json:"compressed"`
+	Members    []archiveMember `json:"members"`
+}
+
+// archiveMember single file stored in archive
+type archiveMember struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// splitSmallFiles splits files into ones packed into archive and ones synced individually
+func (cfg ArchiveConfig) splitSmallFiles(files []models.File) (small, large []models.File) {
+	for _, file := range files {
+		if file.Size < cfg.Threshold {
+			small = append(small, file)
+		} else {
+			large = append(large, file)
+		}
+	}
+	return small, large
+}
+
+// newArchiveIndex describes archive of small files of a directory
+func newArchiveIndex(t transfer, small []models.File) archiveIndex {
+	index := archiveIndex{
+		Archive:    archiveName,
+		Compressed: t.archive.Compress,
+	}
+	if index.Compressed {
+		index.Archive = compressedArchiveName
+	}
+	for _, file := range small {
+		index.Members = append(index.Members, archiveMember{
+			Name:     baseName(file.Path, t.srcEscaped),
+			Size:     file.Size,
+			Modified: file.Modified,
+		})
+	}
+	return index
+}
+
+// plannedArchiveBytes estimates size of archive of small files uploaded by syncArchive, 0 if the stored
+// archive is up to date. Size before compression is counted.
+func (p *Processor) plannedArchiveBytes(ctx context.Context, t transfer, small []models.File, dstFiles map[string]models.File) int64 {
+	if len(small) == 0 {
+		return 0
+	}
+
+	index := newArchiveIndex(t, small)
+	if indexFile, exists := dstFiles[archiveIndexName]; exists {
+		stored, err := p.readArchiveIndex(ctx, t.dst, indexFile.Path)
+		if err == nil && index.sameMembers(stored) {
+			return 0
+		}
+	}
+	return index.size()
+}
+
+// size returns size of uncompressed tar archive of members, each with PAX extended header of one block
+func (idx archiveIndex) size() int64 {
+	const block = 512
+	// Two empty blocks end the archive
+	size := int64(2 * block)
+	for _, member := range idx.Members {
+		// PAX extended header, its record block and the member header
+		size += 3*block + (member.Size+block-1)/block*block
+	}
+	return size
+}
+
+// syncArchive packs small files of a directory into archive, rebuilding it only if members changed.
+// Once the archive is stored, copies of its members uploaded one by one and the archive in the
+// other compression form are removed from storedFiles, the destination files by stored name.
+func (p *Processor) syncArchive(ctx context.Context, t transfer, small []models.File, dstFiles, storedFiles map[string]models.File, dstBasePath string, stats *SyncStats) {
+	if len(small) == 0 {
+		return
+	}
+	stats.TotalFiles += len(small)
+	index := newArchiveIndex(t, small)
+
+	// Compare with the index stored at destination
+	uploadBasePath := dstBasePath
+	if _, exists := dstFiles[archiveIndexName]; exists {
+		stored, err := p.readArchiveIndex(ctx, t.dst, joinPath(dstBasePath, archiveIndexName))
+		if err != nil {
+			slog.Warn("Failed to read archive index, will rebuild", "path", dstBasePath, "error", err)
+		} else if index.sameMembers(stored) {
+			slog.Debug("Archive is up to date", "path", dstBasePath, "action", "skip", "files", len(small))
+			stats.SkippedFiles += len(small)
+			recordArchived(stats, small, FileResult{Action: ActionSkipped, Reason: "archive up to date"})
+			p.removeArchivedCopies(ctx, t, index, storedFiles)
+			return
+		}
+
+		uploadBasePath, err = p.prepareArchiveOverwrite(ctx, t, index, dstFiles, dstBasePath)
+		if err != nil {
+			slog.Error("Failed to preserve previous archive", "path", dstBasePath, "error", err)
+			stats.ErrorFiles += len(small)
+			recordArchived(stats, small, FileResult{Action: ActionFailed, Reason: "preserve previous archive", Err: err})
+			return
+		}
+		if uploadBasePath == "" {
+			slog.Info("Archive changed, not overwriting in append-only mode", "path", dstBasePath, "action", "skip")
+			stats.SkippedFiles += len(small)
+			recordArchived(stats, small, FileResult{Action: ActionSkipped, Reason: "append-only mode"})
+			return
+		}
+	}
+
+	var size int64
+	for _, file := range small {
+		size += file.Size
+	}
+	start := time.Now()
+	if err := p.uploadArchive(ctx, t, small, index, uploadBasePath); err != nil {
+		slog.Error("Failed to build archive", "path", dstBasePath, "error", err)
+		stats.ErrorFiles += len(small)
+		recordArchived(stats, small, FileResult{Action: ActionFailed, Reason: "build archive", Err: err})
+		return
+	}
+	recordArchived(stats, small, FileResult{
+		Target: joinPath(uploadBasePath, escapeName(index.Archive, t.dstEscaped)),
+		Action: ActionUploaded,
+		Reason: "archived",
+	})
+	slog.Info("Archive rebuilt", "path", uploadBasePath, "action", "archive", "files", len(small),
+		"bytes", size, "duration", time.Since(start))
+	if uploadBasePath == dstBasePath {
+		p.removeArchivedCopies(ctx, t, index, storedFiles)
+	}
+	stats.UploadedFiles += len(small)
+	stats.UploadedBytes += size
+	if t.changed != nil {
+		for _, file := range small {
+			t.changed[file.Path] = true
+		}
+	}
+}
+
+// recordArchived records the same result for every file packed into archive
+func recordArchived(stats *SyncStats, files []models.File, result FileResult) {
+	for _, file := range files {
+		result.Path, result.Size = file.Path, file.Size
+		stats.record(result)
+	}
+}
+
+// prepareArchiveOverwrite preserves archive and index of directory before they are rebuilt.
+// Returns directory the rebuilt archive must be uploaded to, empty if it must be skipped.
+func (p *Processor) prepareArchiveOverwrite(ctx context.Context, t transfer, index archiveIndex, dstFiles map[string]models.File, dstBasePath string) (string, error) {
+	indexPath := joinPath(dstBasePath, archiveIndexName)
+	newIndexPath, err := p.prepareOverwrite(ctx, t, dstFiles[archiveIndexName].Path, indexPath, func(stored models.FileInfo) (bool, error) {
+		storedIndex, err := p.readArchiveIndex(ctx, t.dst, stored.Path)
+		if err != nil {
+			return false, err
+		}
+		return index.sameMembers(storedIndex), nil
+	})
+	if err != nil || newIndexPath == "" {
+		return "", err
+	}
+
+	if file, ok := dstFiles[index.Archive]; ok && t.backup != nil && !t.backup.appendOnly {
+		if err := p.preserve(ctx, t, file.Path); err != nil {
+			return "", err
+		}
+	}
+
+	return path.Dir(newIndexPath), nil
+}
+
+// removeArchivedCopies removes files made obsolete by stored archive: the archive in the other
+// compression form and copies of members uploaded individually, plain or compressed
+func (p *Processor) removeArchivedCopies(ctx context.Context, t transfer, index archiveIndex, storedFiles map[string]models.File) {
+	other := archiveName
+	if index.Archive == archiveName {
+		other = compressedArchiveName
+	}
+	p.removeStored(ctx, t, storedFiles, other, "archive compression changed")
+
+	for _, member := range index.Members {
+		p.removeStored(ctx, t, storedFiles, member.Name, "archive mode")
+		if file, ok := storedFiles[member.Name+compressedSuffix]; ok && isCompressed(file) {
+			p.removeStored(ctx, t, storedFiles, member.Name+compressedSuffix, "archive mode")
+		}
+	}
+}
+
+// uploadArchive builds archive of files in a temporary file and uploads it together with index
+func (p *Processor) uploadArchive(ctx context.Context, t transfer, files []models.File, index archiveIndex, dstBasePath string) error {
+	tmp, err := os.CreateTemp("", "nextya-archive-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := p.writeArchive(ctx, t, tmp, files, index); err != nil {
+		return err
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := t.dst.UploadFile(ctx, joinPath(dstBasePath, index.Archive), tmp, size); err != nil {
+		return fmt.Errorf("failed to upload archive: %w", err)
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := t.dst.UploadFile(ctx, joinPath(dstBasePath, archiveIndexName), bytes.NewReader(data), int64(len(data))); err != nil {
+		return fmt.Errorf("failed to upload archive index: %w", err)
+	}
+
+	return nil
+}
+
+// writeArchive writes tar archive of files downloaded from source
+func (p *Processor) writeArchive(ctx context.Context, t transfer, w io.Writer, files []models.File, index archiveIndex) error {
+	var encoder *zstd.Encoder
+	if index.Compressed {
+		var err error
+		if encoder, err = zstd.NewWriter(w); err != nil {
+			return err
+		}
+		w = encoder
+	}
+
+	tw := tar.NewWriter(w)
+	for i, file := range files {
+		member := index.Members[i]
+		if err := p.writeArchiveMember(ctx, t, tw, file, member); err != nil {
+			return fmt.Errorf("failed to add %s to archive: %w", member.Name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	if encoder != nil {
+		return encoder.Close()
+	}
+	return nil
+}
+
+// writeArchiveMember downloads single file into archive
+func (p *Processor) writeArchiveMember(ctx context.Context, t transfer, tw *tar.Writer, file models.File, member archiveMember) error {
+	reader, err := t.src.DownloadFile(ctx, file.Path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    member.Name,
+		Size:    member.Size,
+		Mode:    0o644,
+		ModTime: member.Modified,
+		Format:  tar.FormatPAX,
+	}); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, reader)
+	return err
+}
+
+// extractArchive restores members of archive found in source directory.
+// Returns remaining source files which are not part of the archive.
+func (p *Processor) extractArchive(ctx context.Context, t transfer, srcFolder models.Folder, dstFiles map[string]models.File, dstBasePath string, stats *SyncStats) []models.File {
+	var (
+		indexFile models.File
+		found     bool
+		files     []models.File
+	)
+	for _, file := range srcFolder.Files {
+		switch baseName(file.Path, t.srcEscaped) {
+		case archiveIndexName:
+			indexFile, found = file, true
+		case archiveName, compressedArchiveName:
+		default:
+			files = append(files, file)
+		}
+	}
+	if !found {
+		return srcFolder.Files
+	}
+
+	index, err := p.readArchiveIndex(ctx, t.src, indexFile.Path)
+	if err != nil {
+		slog.Error("Failed to read archive index", "path", srcFolder.Path, "error", err)
+		stats.ErrorFiles++
+		stats.record(FileResult{Path: indexFile.Path, Action: ActionFailed, Reason: "read archive index", Err: err})
+		return files
+	}
+	stats.TotalFiles += len(index.Members)
+
+	// Determine members to restore
+	wanted := make(map[string]bool)
+	for _, member := range index.Members {
+		dstFile, exists := dstFiles[member.Name]
+		if exists && !t.isOutdated(models.File{Size: member.Size, Modified: member.Modified}, dstFile) {
+			stats.SkippedFiles++
+			stats.record(FileResult{
+				Path:   joinPath(srcFolder.Path, escapeName(member.Name, t.srcEscaped)),
+				Action: ActionSkipped,
+				Reason: "up to date",
+				Size:   member.Size,
+			})
+			continue
+		}
+		wanted[member.Name] = true
+	}
+	if len(wanted) == 0 {
+		return files
+	}
+
+	slog.Info("Extracting files from archive", "path", srcFolder.Path, "files", len(wanted))
+	archivePath := joinPath(srcFolder.Path, escapeName(index.Archive, t.srcEscaped))
+	err = p.extractMembers(ctx, t, archivePath, index, wanted, dstBasePath, stats)
+	if err != nil {
+		slog.Error("Failed to extract archive", "path", archivePath, "error", err)
+	} else if len(wanted) > 0 {
+		err = fmt.Errorf("missing in archive %s", archivePath)
+	}
+	// Members that were not found in archive are failures
+	stats.ErrorFiles += len(wanted)
+	for _, member := range index.Members {
+		if wanted[member.Name] {
+			stats.record(FileResult{
+				Path:   joinPath(srcFolder.Path, escapeName(member.Name, t.srcEscaped)),
+				Action: ActionFailed,
+				Reason: "extract archive",
+				Size:   member.Size,
+				Err:    err,
+			})
+		}
+	}
+
+	return files
+}
+
+// extractMembers streams archive and uploads wanted members, removing them from wanted once done
+func (p *Processor) extractMembers(ctx context.Context, t transfer, archivePath string, index archiveIndex, wanted map[string]bool, dstBasePath string, stats *SyncStats) error {
+	reader, err := t.src.DownloadFile(ctx, archivePath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	var r io.Reader = reader
+	if index.Compressed {
+		decoder, err := zstd.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer decoder.Close()
+		r = decoder
+	}
+
+	tr := tar.NewReader(r)
+	for len(wanted) > 0 {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !wanted[header.Name] {
+			continue
+		}
+		delete(wanted, header.Name)
+
+		memberPath := joinPath(path.Dir(archivePath), escapeName(header.Name, t.srcEscaped))
+		dstFilePath := joinPath(dstBasePath, escapeName(header.Name, t.dstEscaped))
+		start := time.Now()
+		if err := t.dst.UploadFile(ctx, dstFilePath, tr, header.Size); err != nil {
+			slog.Error("Failed to restore file from archive", "path", dstFilePath, "error", err)
+			stats.ErrorFiles++
+			stats.record(FileResult{Path: memberPath, Target: dstFilePath, Action: ActionFailed,
+				Reason: "extract archive", Size: header.Size, Duration: time.Since(start), Err: err})
+			continue
+		}
+		slog.Info("File restored from archive", "path", dstFilePath, "action", "extract", "bytes", header.Size)
+		stats.record(FileResult{Path: memberPath, Target: dstFilePath, Action: ActionUploaded,
+			Reason: "extracted", Size: header.Size, Duration: time.Since(start)})
+		stats.UploadedFiles++
+		stats.UploadedBytes += header.Size
+	}
+
+	return nil
+}
+
+// readArchiveIndex downloads and parses archive index
+func (p *Processor) readArchiveIndex(ctx context.Context, client backend.Backend, indexPath string) (archiveIndex, error) {
+	reader, err := client.DownloadFile(ctx, indexPath)
+	if err != nil {
+		return archiveIndex{}, err
+	}
+	defer reader.Close()
+
+	var index archiveIndex
+	if err := json.NewDecoder(reader).Decode(&index); err != nil {
+		return archiveIndex{}, fmt.Errorf("failed to parse archive index: %w", err)
+	}
+	return index, nil
+}
+
+// sameMembers reports whether both indexes describe the same archive content
+func (idx archiveIndex) sameMembers(other archiveIndex) bool {
+	if idx.Archive != other.Archive || len(idx.Members) != len(other.Members) {
+		return false
+	}
+
+	stored := make(map[string]archiveMember, len(other.Members))
+	for _, member := range other.Members {
+		stored[member.Name] = member
+	}
+	for _, member := range idx.Members {
+		s, ok := stored[member.Name]
+		if !ok || s.Size != member.Size || !s.Modified.Equal(member.Modified) {
+			return false
+		}
+	}
+	return true
+}
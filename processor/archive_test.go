@@ -0,0 +1,221 @@
+package processor
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/models"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// readArchive returns content of members of archive stored in fake Yandex Disk
+func readArchive(t *testing.T, p *syncEnv, archivePath string, compressed bool) map[string]string {
+	t.Helper()
+	data, err := p.yandex.ReadFile(archivePath)
+	if err != nil {
+		t.Fatalf("archive is missing: %v", err)
+	}
+
+	var r io.Reader = bytes.NewReader(data)
+	if compressed {
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer decoder.Close()
+		r = decoder
+	}
+
+	members := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return members
+		}
+		if err != nil {
+			t.Fatalf("reading archive: %v", err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		members[header.Name] = string(content)
+	}
+}
+
+func TestPipelineArchive(t *testing.T) {
+	p := newPipeline(t)
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	p.write(t, "/Documents/a.txt", "a", modTime)
+	p.write(t, "/Documents/b c.txt", "bc", modTime)
+	p.write(t, "/Documents/large.bin", "larger than threshold", modTime)
+	cfg := Config{Archive: ArchiveConfig{Paths: []string{"/Documents"}, Threshold: 10}}
+
+	p.run(t, cfg)
+	if got := readArchive(t, p, "/backup/.nextya-archive.tar", false); len(got) != 2 || got["a.txt"] != "a" || got["b c.txt"] != "bc" {
+		t.Errorf("archive members = %q", got)
+	}
+	p.requireFile(t, "/backup/large.bin", "larger than threshold")
+	if _, err := p.yandex.ReadFile("/backup/a.txt"); err == nil {
+		t.Error("archived file is uploaded individually")
+	}
+
+	data, err := p.yandex.ReadFile("/backup/.nextya-archive.json")
+	if err != nil {
+		t.Fatalf("archive index is missing: %v", err)
+	}
+	var index archiveIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatalf("parsing archive index: %v", err)
+	}
+	if index.Archive != ".nextya-archive.tar" || index.Compressed || len(index.Members) != 2 ||
+		index.Members[0].Name != "a.txt" || index.Members[0].Size != 1 || !index.Members[0].Modified.Equal(modTime) {
+		t.Errorf("archive index = %+v", index)
+	}
+	uploads := p.yandex.Requests("PUT upload")
+	if uploads != 3 {
+		t.Fatalf("first run uploaded %d files, want archive, index and large file", uploads)
+	}
+
+	// Nothing changed, archive isn't rebuilt
+	p.run(t, cfg)
+	if got := p.yandex.Requests("PUT upload"); got != uploads {
+		t.Errorf("second run uploaded %d files, want none", got-uploads)
+	}
+
+	// Changed member rebuilds archive and index
+	p.write(t, "/Documents/a.txt", "a2", modTime.Add(time.Minute))
+	p.run(t, cfg)
+	if got := p.yandex.Requests("PUT upload"); got != uploads+2 {
+		t.Errorf("third run uploaded %d files, want archive and index", got-uploads)
+	}
+	if got := readArchive(t, p, "/backup/.nextya-archive.tar", false); got["a.txt"] != "a2" || got["b c.txt"] != "bc" {
+		t.Errorf("rebuilt archive members = %q", got)
+	}
+}
+
+func TestPipelineArchiveRestore(t *testing.T) {
+	p := newPipeline(t)
+	past := time.Now().Add(-time.Hour)
+	p.write(t, "/Documents/notes/a.txt", "a", past)
+	p.write(t, "/Documents/notes/b.txt", "b", past)
+	p.write(t, "/Documents/notes/large.bin", "larger than threshold", past)
+	p.run(t, Config{Archive: ArchiveConfig{Paths: []string{"/Documents"}, Threshold: 10, Compress: true}})
+	if got := readArchive(t, p, "/backup/notes/.nextya-archive.tar.zst", true); len(got) != 2 {
+		t.Fatalf("compressed archive members = %q", got)
+	}
+
+	err := p.processor.Restore(context.Background(), Config{TargetPath: "disk:/backup", SyncPaths: []string{"/Restored"}})
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	for filePath, want := range map[string]string{
+		"/Restored/notes/a.txt":     "a",
+		"/Restored/notes/b.txt":     "b",
+		"/Restored/notes/large.bin": "larger than threshold",
+	} {
+		data, err := p.nextcloud.ReadFile(filePath)
+		if err != nil {
+			t.Errorf("%s is missing in Nextcloud: %v", filePath, err)
+		} else if string(data) != want {
+			t.Errorf("%s = %q, want %q", filePath, data, want)
+		}
+	}
+	for _, name := range []string{".nextya-archive.tar.zst", ".nextya-archive.json"} {
+		if _, err := p.nextcloud.ReadFile("/Restored/notes/" + name); err == nil {
+			t.Errorf("%s is restored as a file", name)
+		}
+	}
+}
+
+func TestPipelineArchiveQuotaCheck(t *testing.T) {
+	p := newPipeline(t)
+	past := time.Now().Add(-time.Hour)
+	p.write(t, "/Documents/a.txt", "a", past)
+	p.write(t, "/Documents/b.txt", "b", past)
+	cfg := Config{
+		TargetPath: "disk:/backup",
+		SyncPaths:  []string{"/Documents"},
+		Archive:    ArchiveConfig{Paths: []string{"/Documents"}, Threshold: 10},
+		Quota:      QuotaConfig{Check: true},
+	}
+
+	// Archive of two tiny files doesn't fit into 1 KiB
+	p.yandex.TotalSpace = 1024
+	if err := p.processor.Main(context.Background(), cfg); !errors.Is(err, backend.ErrQuotaExceeded) {
+		t.Fatalf("Main error = %v, want quota exceeded", err)
+	}
+
+	p.yandex.TotalSpace = 1 << 20
+	p.run(t, cfg)
+	archive, err := p.yandex.ReadFile("/backup/.nextya-archive.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := p.yandex.ReadFile("/backup/.nextya-archive.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	members := []models.File{{Path: "/Documents/a.txt", Size: 1}, {Path: "/Documents/b.txt", Size: 1}}
+	if planned := newArchiveIndex(transfer{archive: &cfg.Archive}, members).size(); planned < int64(len(archive)) {
+		t.Errorf("archive estimated at %d bytes, stored archive has %d", planned, len(archive))
+	}
+
+	// Stored archive is up to date, nothing is planned even with less free space than its size
+	p.yandex.TotalSpace = int64(len(archive)+len(index)) + 512
+	if err := p.processor.Main(context.Background(), cfg); err != nil {
+		t.Errorf("Main with up to date archive: %v", err)
+	}
+}
+
+func TestPipelineArchiveRemovesStaleCopies(t *testing.T) {
+	p := newPipeline(t)
+	past := time.Now().Add(-time.Hour)
+	p.write(t, "/Documents/a.txt", "a", past)
+	p.write(t, "/Documents/b.txt", "b", past)
+	p.write(t, "/Documents/large.bin", "larger than threshold", past)
+	missing := func(filePath string) {
+		t.Helper()
+		if _, err := p.yandex.ReadFile(filePath); err == nil {
+			t.Errorf("%s is left in destination", filePath)
+		}
+	}
+
+	// Files uploaded one by one before archive mode was enabled are replaced by the archive
+	p.run(t, Config{})
+	p.requireFile(t, "/backup/a.txt", "a")
+	archive := ArchiveConfig{Paths: []string{"/Documents"}, Threshold: 10}
+	p.run(t, Config{Archive: archive})
+	if got := readArchive(t, p, "/backup/.nextya-archive.tar", false); len(got) != 2 {
+		t.Errorf("archive members = %q", got)
+	}
+	missing("/backup/a.txt")
+	missing("/backup/b.txt")
+	p.requireFile(t, "/backup/large.bin", "larger than threshold")
+
+	// Archive in the other compression form is removed when compression is switched
+	archive.Compress = true
+	p.run(t, Config{Archive: archive})
+	if got := readArchive(t, p, "/backup/.nextya-archive.tar.zst", true); len(got) != 2 {
+		t.Errorf("compressed archive members = %q", got)
+	}
+	missing("/backup/.nextya-archive.tar")
+
+	// With backup directory it is moved there instead
+	archive.Compress = false
+	p.run(t, Config{Archive: archive, Backup: BackupConfig{Dir: "disk:/versions"}})
+	readArchive(t, p, "/backup/.nextya-archive.tar", false)
+	missing("/backup/.nextya-archive.tar.zst")
+	if got := readArchive(t, p, "/versions/.nextya-archive.tar.zst", true); len(got) != 2 {
+		t.Errorf("preserved archive members = %q", got)
+	}
+}
@@ -0,0 +1,244 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/models"
+)
+
+// BackupConfig holds configuration of versioned backups
+type BackupConfig struct {
+	// Dir directory previous versions are moved to, supports {date} and {time} placeholders
+	Dir string
+	// AppendOnly never overwrites files in the target, new versions of changed files go to Dir
+	AppendOnly bool
+}
+
+// Validate checks that append-only mode has a dated backup directory, where versions stored by
+// earlier runs are looked up so a changed file is stored only once
+func (cfg BackupConfig) Validate() error {
+	if !cfg.AppendOnly {
+		return nil
+	}
+	if _, _, err := versionLayout(cfg.Dir); err != nil {
+		return fmt.Errorf("append-only mode requires backup directory: %w", err)
+	}
+	return nil
+}
+
+// backup versioned backup settings of a single run
+type backup struct {
+	// dir backup directory with expanded placeholders
+	dir string
+	// root target root relative paths of backed up files are computed from
+	root       string
+	appendOnly bool
+	// template backup directory with placeholders
+	template string
+
+	// storedDirs dated folders of backup directory newest first, listed once in append-only mode
+	storedDirs []string
+	listStored sync.Once
+	storedErr  error
+}
+
+// ExpandBackupDir replaces {date} and {time} placeholders in backup directory
+func ExpandBackupDir(dir string, now time.Time) string {
+	return strings.NewReplacer(
+		"{date}", now.Format("2006-01-02"),
+		"{time}", now.Format("150405"),
+	).Replace(dir)
+}
+
+// BackupRoot returns static part of backup directory before the first placeholder
+func BackupRoot(dir string) string {
+	if i := strings.Index(dir, "{"); i >= 0 {
+		dir = path.Dir(dir[:i+1])
+	}
+	return strings.TrimSuffix(dir, "/")
+}
+
+// newBackup creates backup settings for the run, nil if backups are disabled
+func (p *Processor) newBackup(cfg Config, now time.Time) (*backup, error) {
+	if err := cfg.Backup.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Backup.Dir == "" {
+		return nil, nil
+	}
+
+	if _, ok := p.destination.(backend.Mover); !ok && !cfg.Backup.AppendOnly {
+		return nil, fmt.Errorf("destination client doesn't support moving files")
+	}
+
+	return &backup{
+		dir:        ExpandBackupDir(cfg.Backup.Dir, now),
+		template:   cfg.Backup.Dir,
+		root:       cfg.TargetPath,
+		appendOnly: cfg.Backup.AppendOnly,
+	}, nil
+}
+
+// pathFor returns path in backup directory for file in target
+func (b *backup) pathFor(filePath string) (string, error) {
+	rel, ok := relativePath(b.root, filePath)
+	if !ok {
+		return "", fmt.Errorf("%s is outside of target %s", filePath, b.root)
+	}
+	return joinPath(b.dir, rel), nil
+}
+
+// prepareOverwrite is called before existing destination file is replaced by a new version.
+// The previous version is moved to the backup directory, in append-only mode the target is left
+// untouched and the new version is redirected to the backup directory instead.
+// Returns path the new version must be uploaded to, empty if upload must be skipped. In append-only mode
+// upload is skipped too when isCurrent reports the newest copy in backup directory is this version already.
+func (p *Processor) prepareOverwrite(ctx context.Context, t transfer, existingPath, newPath string, isCurrent func(stored models.FileInfo) (bool, error)) (string, error) {
+	if t.backup == nil {
+		return newPath, nil
+	}
+
+	if t.backup.appendOnly {
+		backupPath, err := t.backup.pathFor(newPath)
+		if err != nil {
+			return "", err
+		}
+		stored, err := p.storedCopy(ctx, t, newPath)
+		if err != nil {
+			return "", err
+		}
+		if stored != nil {
+			current, err := isCurrent(*stored)
+			if err != nil {
+				return "", err
+			}
+			if current {
+				slog.Debug("Version is already stored in backup directory", "path", newPath, "stored", stored.Path)
+				return "", nil
+			}
+		}
+		if err := p.createFolderChain(ctx, t.dst, path.Dir(backupPath)); err != nil {
+			return "", err
+		}
+		slog.Info("Append-only mode, storing new version in backup directory", "path", newPath, "target", backupPath)
+		return backupPath, nil
+	}
+
+	if err := p.preserve(ctx, t, existingPath); err != nil {
+		return "", err
+	}
+	return newPath, nil
+}
+
+// storedCopy returns the newest copy of target file in dated folders of backup directory, nil if there is none.
+// Compressed copies are found by their original name.
+func (p *Processor) storedCopy(ctx context.Context, t transfer, newPath string) (*models.FileInfo, error) {
+	rel, ok := relativePath(t.backup.root, newPath)
+	if !ok {
+		return nil, fmt.Errorf("%s is outside of target %s", newPath, t.backup.root)
+	}
+
+	t.backup.listStored.Do(func() {
+		root, layout, err := versionLayout(t.backup.template)
+		if err != nil {
+			t.backup.storedErr = err
+			return
+		}
+		versions, err := p.listVersions(ctx, root, layout)
+		if err != nil && !errors.Is(err, backend.ErrNotFound) {
+			t.backup.storedErr = err
+			return
+		}
+		for _, version := range versions {
+			t.backup.storedDirs = append(t.backup.storedDirs, version.Path)
+		}
+	})
+	if t.backup.storedErr != nil {
+		return nil, t.backup.storedErr
+	}
+
+	for _, dir := range append([]string{t.backup.dir}, t.backup.storedDirs...) {
+		for _, name := range []string{rel, rel + compressedSuffix} {
+			info, err := t.dst.GetFileInfo(ctx, joinPath(dir, name))
+			if errors.Is(err, backend.ErrNotFound) {
+				continue
+			}
+			return info, err
+		}
+	}
+	return nil, nil
+}
+
+// preserve moves existing destination file to the backup directory
+func (p *Processor) preserve(ctx context.Context, t transfer, existingPath string) error {
+	if t.backup == nil || t.backup.dir == "" {
+		return nil
+	}
+
+	backupPath, err := t.backup.pathFor(existingPath)
+	if err != nil {
+		return err
+	}
+	if err := p.createFolderChain(ctx, t.dst, path.Dir(backupPath)); err != nil {
+		return fmt.Errorf("failed to create backup folder: %w", err)
+	}
+	if backupPath, err = freeBackupPath(ctx, t.dst, backupPath); err != nil {
+		return err
+	}
+
+	slog.Info("Moving previous version to backup directory", "path", existingPath, "target", backupPath, "action", "preserve")
+	if err := t.dst.(backend.Mover).Move(ctx, existingPath, backupPath); err != nil {
+		return fmt.Errorf("failed to move previous version to backup: %w", err)
+	}
+	return nil
+}
+
+// freeBackupPath returns backupPath if nothing is stored there yet, otherwise the first free path with
+// a counter before the extension, e.g. todo.1.txt. Versions saved earlier the same day are never replaced.
+func freeBackupPath(ctx context.Context, dst backend.Backend, backupPath string) (string, error) {
+	dir, name := path.Split(backupPath)
+	stem, compressed := strings.CutSuffix(name, compressedSuffix)
+	ext := path.Ext(stem)
+	stem = strings.TrimSuffix(stem, ext)
+	if stem == "" {
+		stem, ext = ext, ""
+	}
+	if compressed {
+		ext += compressedSuffix
+	}
+
+	candidate := backupPath
+	for i := 1; ; i++ {
+		_, err := dst.GetFileInfo(ctx, candidate)
+		if errors.Is(err, backend.ErrNotFound) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to check backup path: %w", err)
+		}
+		candidate = dir + stem + "." + strconv.Itoa(i) + ext
+	}
+}
+
+// relativePath returns path relative to root, Yandex-style "disk:" prefixes are ignored
+func relativePath(root, filePath string) (string, bool) {
+	normRoot := "/" + strings.Trim(strings.TrimPrefix(root, "disk:"), "/")
+	normPath := "/" + strings.Trim(strings.TrimPrefix(filePath, "disk:"), "/")
+
+	if normRoot == "/" {
+		return strings.TrimPrefix(normPath, "/"), true
+	}
+	if !strings.HasPrefix(normPath, normRoot+"/") {
+		return "", false
+	}
+	return normPath[len(normRoot)+1:], true
+}
@@ -0,0 +1,194 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/models"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// compressedSuffix suffix appended to names of compressed files
+	compressedSuffix = ".zst"
+
+	// propCompression custom property marking compressed file
+	propCompression = "nextya_compression"
+	// propOriginalSize custom property holding size of file before compression
+	propOriginalSize = "nextya_original_size"
+	// propOriginalModified custom property holding modification time of source file
+	propOriginalModified = "nextya_original_modified"
+)
+
+// CompressionConfig holds configuration of per-file compression
+type CompressionConfig struct {
+	// Patterns file name patterns of files to compress, e.g. "*.log"
+	Patterns []string
+	// MimeTypes content types of files to compress, e.g. "text/*"
+	MimeTypes []string
+}
+
+// enabled reports whether compression is configured
+func (cfg CompressionConfig) enabled() bool {
+	return len(cfg.Patterns) > 0 || len(cfg.MimeTypes) > 0
+}
+
+// matches reports whether file must be compressed
+func (cfg CompressionConfig) matches(name string, file models.File) bool {
+	for _, pattern := range cfg.Patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	contentType, _, _ := strings.Cut(file.ContentType, ";")
+	for _, pattern := range cfg.MimeTypes {
+		if ok, _ := path.Match(pattern, strings.TrimSpace(contentType)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compresses reports whether file is uploaded compressed by this transfer
+func (t transfer) compresses(fileName string, file models.File) bool {
+	return t.compression != nil && t.compression.matches(fileName, file)
+}
+
+// isCompressed reports whether file was stored compressed
+func isCompressed(file models.File) bool {
+	return file.Properties[propCompression] == "zstd"
+}
+
+// uncompressedView returns name and file information as they were before compression.
+// Path is kept so the stored file can still be downloaded.
+func uncompressedView(name string, file models.File) (string, models.File) {
+	if !isCompressed(file) || !strings.HasSuffix(name, compressedSuffix) {
+		return name, file
+	}
+
+	if size, err := strconv.ParseInt(file.Properties[propOriginalSize], 10, 64); err == nil {
+		file.Size = size
+	}
+	if modified, err := time.Parse(time.RFC3339Nano, file.Properties[propOriginalModified]); err == nil {
+		file.Modified = modified
+	}
+	return strings.TrimSuffix(name, compressedSuffix), file
+}
+
+// isCompressedOutdated compares source file with compressed copy using stored original size and time
+func isCompressedOutdated(src, dst models.File) bool {
+	return src.Size != dst.Size || !src.Modified.Equal(dst.Modified)
+}
+
+// compressedProperties returns custom properties describing compressed copy of file
+func compressedProperties(file models.File) map[string]string {
+	return map[string]string{
+		propCompression:      "zstd",
+		propOriginalSize:     strconv.FormatInt(file.Size, 10),
+		propOriginalModified: file.Modified.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// uploadCompressed compresses content while streaming it to destination and marks the result
+func (p *Processor) uploadCompressed(ctx context.Context, t transfer, srcFile models.File, content io.Reader, dstFilePath string) error {
+	setter, ok := t.dst.(backend.PropertySetter)
+	if !ok {
+		return fmt.Errorf("destination doesn't support custom properties")
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		encoder, err := zstd.NewWriter(pw)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(encoder, content); err != nil {
+			encoder.Close()
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(encoder.Close())
+	}()
+	defer pr.Close()
+
+	compressedPath := dstFilePath + compressedSuffix
+	if err := t.dst.UploadFile(ctx, compressedPath, pr, -1); err != nil {
+		return fmt.Errorf("failed to upload compressed file: %w", err)
+	}
+
+	if err := setter.SetProperties(ctx, compressedPath, compressedProperties(srcFile)); err != nil {
+		return fmt.Errorf("failed to mark compressed file: %w", err)
+	}
+
+	return nil
+}
+
+// removeStaleCopy removes copy of file left in the other form after compression rules changed:
+// plain copy of file stored compressed or compressed copy of file stored plain. storedFiles holds
+// destination files by name, the copy is moved to backup directory when backups are enabled.
+func (p *Processor) removeStaleCopy(ctx context.Context, t transfer, storedFiles map[string]models.File, fileName string, compressed bool) {
+	staleName := fileName + compressedSuffix
+	if compressed {
+		staleName = fileName
+	}
+	if stale, ok := storedFiles[staleName]; ok && isCompressed(stale) != compressed {
+		p.removeStored(ctx, t, storedFiles, staleName, "compression rules changed")
+	}
+}
+
+// removeStored removes file stored under name in destination folder because reason made it obsolete.
+// The file is moved to backup directory when backups are enabled and kept in append-only mode.
+func (p *Processor) removeStored(ctx context.Context, t transfer, storedFiles map[string]models.File, name, reason string) {
+	stale, ok := storedFiles[name]
+	if !ok || (t.backup != nil && t.backup.appendOnly) {
+		return
+	}
+
+	var err error
+	if t.backup != nil {
+		err = p.preserve(ctx, t, stale.Path)
+	} else if del, ok := t.dst.(backend.Deleter); ok {
+		slog.Info("Removing copy stored before "+reason, "path", stale.Path, "action", "delete")
+		err = del.Delete(ctx, stale.Path, false)
+	} else {
+		slog.Warn("Destination doesn't support deletion, copy stored before "+reason+" is kept", "path", stale.Path)
+		return
+	}
+	if err != nil {
+		slog.Warn("Failed to remove copy stored before "+reason, "path", stale.Path, "error", err)
+		return
+	}
+	delete(storedFiles, name)
+}
+
+// decompressReader returns reader decompressing content of compressed file
+func decompressReader(content io.ReadCloser) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{decoder, closerFunc(func() error {
+		decoder.Close()
+		return content.Close()
+	})}, nil
+}
+
+// closerFunc adapts function to io.Closer
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
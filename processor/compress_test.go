@@ -0,0 +1,117 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"nextya-sync/models"
+)
+
+func TestCompressionMatches(t *testing.T) {
+	cfg := CompressionConfig{Patterns: []string{"*.log", "dump-*"}, MimeTypes: []string{"text/*", "application/json"}}
+	tests := []struct {
+		name        string
+		contentType string
+		want        bool
+	}{
+		{"app.log", "", true},
+		{"dump-2024.sql", "application/octet-stream", true},
+		{"notes.md", "text/markdown; charset=utf-8", true},
+		{"data", "application/json", true},
+		{"photo.jpg", "image/jpeg", false},
+		{"app.log.gz", "application/gzip", false},
+		{"archive", "", false},
+	}
+	for _, tt := range tests {
+		if got := cfg.matches(tt.name, models.File{ContentType: tt.contentType}); got != tt.want {
+			t.Errorf("matches(%q, %q) = %v, want %v", tt.name, tt.contentType, got, tt.want)
+		}
+	}
+	if (CompressionConfig{}).enabled() {
+		t.Error("empty compression config is enabled")
+	}
+}
+
+func TestUncompressedView(t *testing.T) {
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 6, time.FixedZone("MSK", 3*3600))
+	src := models.File{Path: "/Documents/app.log", Size: 1000, Modified: modified}
+	stored := models.File{
+		Path:       "/backup/app.log.zst",
+		Size:       100,
+		Modified:   time.Now(),
+		Properties: compressedProperties(src),
+	}
+	if stored.Properties[propOriginalSize] != "1000" || stored.Properties[propOriginalModified] != "2024-01-02T00:04:05.000000006Z" {
+		t.Errorf("compressed properties = %v", stored.Properties)
+	}
+
+	name, file := uncompressedView("app.log.zst", stored)
+	if name != "app.log" || file.Size != 1000 || !file.Modified.Equal(modified) || file.Path != stored.Path {
+		t.Errorf("uncompressedView = %q, %+v", name, file)
+	}
+	if (transfer{}).isOutdated(src, file) {
+		t.Error("compressed copy of unchanged file is outdated")
+	}
+	src.Size++
+	if !(transfer{}).isOutdated(src, file) {
+		t.Error("compressed copy of changed file is up to date")
+	}
+
+	// Files without compression mark keep their name, even with the suffix
+	plain := models.File{Path: "/backup/data.zst", Size: 10}
+	if name, file := uncompressedView("data.zst", plain); name != "data.zst" || file.Size != 10 {
+		t.Errorf("uncompressedView of plain file = %q, %+v", name, file)
+	}
+}
+
+func TestCompressionRulesChange(t *testing.T) {
+	e := newSyncEnv(t)
+	e.write(t, "/Documents/app.log", "v1", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	compressed := Config{Compression: CompressionConfig{Patterns: []string{"*.log"}}}
+
+	e.run(t, Config{})
+	e.requireFile(t, "/backup/app.log", "v1")
+
+	// File now stored compressed, plain copy is removed
+	e.write(t, "/Documents/app.log", "v2", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC))
+	e.run(t, compressed)
+	requireOnly(t, e, "/backup/app.log.zst", "/backup/app.log")
+
+	// And the other way round
+	e.write(t, "/Documents/app.log", "v3", time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC))
+	e.run(t, Config{})
+	e.requireFile(t, "/backup/app.log", "v3")
+	requireOnly(t, e, "/backup/app.log", "/backup/app.log.zst")
+
+	// Compressed copy left by earlier runs is removed too
+	e.destination.WriteFile("/backup/app.log.zst", []byte("stale"), time.Now())
+	if err := e.destination.SetProperties(t.Context(), "/backup/app.log.zst", map[string]string{propCompression: "zstd"}); err != nil {
+		t.Fatal(err)
+	}
+	e.run(t, Config{})
+	requireOnly(t, e, "/backup/app.log", "/backup/app.log.zst")
+}
+
+func TestCompressionRulesChangeWithBackup(t *testing.T) {
+	e := newSyncEnv(t)
+	e.write(t, "/Documents/app.log", "v1", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	backup := BackupConfig{Dir: "/versions"}
+
+	e.run(t, Config{Backup: backup})
+	e.write(t, "/Documents/app.log", "v2", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC))
+	e.run(t, Config{Backup: backup, Compression: CompressionConfig{Patterns: []string{"*.log"}}})
+
+	requireOnly(t, e, "/backup/app.log.zst", "/backup/app.log")
+	e.requireFile(t, "/versions/app.log", "v1")
+}
+
+// requireOnly checks that destination has the kept file and not the removed one
+func requireOnly(t *testing.T, e *syncEnv, kept, removed string) {
+	t.Helper()
+	if _, ok := e.destination.ReadFile(kept); !ok {
+		t.Errorf("%s is missing in destination", kept)
+	}
+	if _, ok := e.destination.ReadFile(removed); ok {
+		t.Errorf("%s is left in destination", removed)
+	}
+}
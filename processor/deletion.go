@@ -0,0 +1,187 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/models"
+)
+
+// DeleteFiles removes files and folders deleted in source from destination. Removed files are moved to
+// the backup directory if it's configured, otherwise deleted to trash of destination. Nothing is removed
+// in append-only mode, and files packed into archives are left until the archive is rebuilt.
+func (p *Processor) DeleteFiles(ctx context.Context, cfg Config, files []string) (err error) {
+	start := time.Now()
+	slog.Info("Starting removal of deleted files", "files", len(files))
+
+	syncStats := newSyncStats(cfg.Report, start)
+	defer func() {
+		syncStats.finish()
+		if cfg.Notifier != nil {
+			cfg.Notifier.Notify(ctx, *syncStats, err)
+		}
+	}()
+
+	t, err := p.syncTransfer(cfg)
+	if err != nil {
+		return err
+	}
+	del, canDelete := p.destination.(backend.Deleter)
+	moveToBackup := t.backup != nil && t.backup.dir != "" && !t.backup.appendOnly
+	if !canDelete && !moveToBackup {
+		return fmt.Errorf("destination doesn't support deletion")
+	}
+
+	for _, filePath := range files {
+		filePath = strings.TrimSuffix(filePath, "/")
+		syncPath, targetFolder, ok := cfg.targetFolder(t, path.Dir(filePath))
+		if !ok {
+			slog.Debug("File is outside of sync paths, skipping", "path", filePath)
+			continue
+		}
+		syncStats.syncPath = syncPath
+
+		if t.backup != nil && t.backup.appendOnly {
+			slog.Info("File deleted, not removing in append-only mode", "path", filePath, "action", "skip")
+			syncStats.SkippedFiles++
+			syncStats.record(FileResult{Path: filePath, Action: ActionSkipped, Reason: "append-only mode"})
+			continue
+		}
+
+		target, err := p.findTarget(ctx, t, targetFolder, baseName(filePath, t.srcEscaped))
+		if err != nil {
+			if abortsRun(err) {
+				return err
+			}
+			slog.Error("Failed to find deleted file in destination", "path", filePath, "error", err)
+			syncStats.ErrorFiles++
+			syncStats.record(FileResult{Path: filePath, Action: ActionFailed, Reason: "find in destination", Err: err})
+			continue
+		}
+		if target == nil {
+			slog.Debug("Deleted file is not in destination", "path", filePath)
+			continue
+		}
+
+		if moveToBackup {
+			err = p.preserve(ctx, t, target.Path)
+		} else {
+			slog.Info("Deleting file", "path", target.Path, "action", "delete")
+			err = del.Delete(ctx, target.Path, false)
+		}
+		if err != nil {
+			if abortsRun(err) {
+				return err
+			}
+			slog.Error("Failed to remove deleted file", "path", filePath, "target", target.Path, "error", err)
+			syncStats.ErrorFiles++
+			syncStats.record(FileResult{Path: filePath, Target: target.Path, Action: ActionFailed, Reason: "delete", Err: err})
+			continue
+		}
+		syncStats.DeletedFiles++
+		syncStats.record(FileResult{Path: filePath, Target: target.Path, Action: ActionDeleted, Size: target.Size})
+	}
+
+	slog.Info("Removal of deleted files completed",
+		"deleted", syncStats.DeletedFiles,
+		"errors", syncStats.ErrorFiles,
+		"duration", time.Since(start))
+
+	return syncStats.err()
+}
+
+// MissingFiles returns source paths of destination files and folders whose source is gone, e.g. because
+// deletion happened while nobody was listening. Folders are returned without their content, archives and
+// versions kept by the tool itself are never reported. Nothing is missing in append-only mode.
+func (p *Processor) MissingFiles(ctx context.Context, cfg Config) ([]string, error) {
+	t, err := p.syncTransfer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if t.backup != nil && t.backup.appendOnly {
+		return nil, nil
+	}
+
+	var missing []string
+	for _, syncPath := range cfg.SyncPaths {
+		dstFs, err := p.getFileSystem(ctx, p.destination, cfg.targetPath(syncPath))
+		if errors.Is(err, backend.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read destination of %s: %w", syncPath, err)
+		}
+		dstFs.Folders = withoutFolder(dstFs.Folders, versionsFolderName)
+
+		// Source must be read completely, a failed listing would look like deletion
+		srcFs, err := p.getFileSystem(ctx, p.source, syncPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read source %s: %w", syncPath, err)
+		}
+		missing = append(missing, missingIn(t, srcFs, dstFs)...)
+	}
+	return missing, nil
+}
+
+// missingIn returns source paths of destination entries missing in source folder
+func missingIn(t transfer, srcFolder, dstFolder models.Folder) []string {
+	srcFiles := make(map[string]bool)
+	for _, file := range srcFolder.Files {
+		srcFiles[baseName(file.Path, t.srcEscaped)] = true
+	}
+	srcFolders := make(map[string]models.Folder)
+	for _, folder := range srcFolder.Folders {
+		srcFolders[baseName(folder.Path, t.srcEscaped)] = folder
+	}
+
+	var missing []string
+	for _, file := range dstFolder.Files {
+		name := baseName(file.Path, t.dstEscaped)
+		if isArchiveFile(name) {
+			continue
+		}
+		if name, _ = uncompressedView(name, file); srcFiles[name] {
+			continue
+		}
+		missing = append(missing, joinPath(srcFolder.Path, escapeName(name, t.srcEscaped)))
+	}
+	for _, folder := range dstFolder.Folders {
+		name := baseName(folder.Path, t.dstEscaped)
+		srcSub, ok := srcFolders[name]
+		if !ok {
+			missing = append(missing, joinPath(srcFolder.Path, escapeName(name, t.srcEscaped)))
+			continue
+		}
+		missing = append(missing, missingIn(t, srcSub, folder)...)
+	}
+	return missing
+}
+
+// findTarget returns destination file or folder named name in target folder, compressed files are
+// matched by original name. Returns nil if there is none.
+func (p *Processor) findTarget(ctx context.Context, t transfer, targetFolder, name string) (*models.FileInfo, error) {
+	entries, err := p.destination.ListFiles(ctx, targetFolder)
+	if errors.Is(err, backend.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		entryName := baseName(entry.Path, t.dstEscaped)
+		if !entry.IsDir {
+			entryName, _ = uncompressedView(entryName, fileOf(entry))
+		}
+		if entryName == name {
+			return &entry, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,172 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/clients"
+)
+
+func TestPipelineSync(t *testing.T) {
+	p := newPipeline(t)
+	past := time.Now().Add(-24 * time.Hour)
+	p.write(t, "/Documents/readme.txt", "readme", past)
+	p.write(t, "/Documents/Reports 2024/q1 (draft) #1.txt", "q1", past)
+	p.write(t, "/Documents/Фото/снимок & co.jpg", "photo", past)
+
+	p.run(t, Config{})
+	p.requireFile(t, "/backup/readme.txt", "readme")
+	p.requireFile(t, "/backup/Reports 2024/q1 (draft) #1.txt", "q1")
+	p.requireFile(t, "/backup/Фото/снимок & co.jpg", "photo")
+	if got := p.yandex.Requests("PUT upload"); got != 3 {
+		t.Fatalf("first run uploaded %d files, want 3", got)
+	}
+
+	// Nothing changed, nothing is uploaded
+	p.run(t, Config{})
+	if got := p.yandex.Requests("PUT upload"); got != 3 {
+		t.Errorf("second run uploaded %d files, want none", got-3)
+	}
+
+	// Yandex Disk keeps upload time, so only files changed after it are uploaded again
+	p.write(t, "/Documents/readme.txt", "readme v2", time.Now().Add(time.Hour))
+	p.run(t, Config{})
+	if got := p.yandex.Requests("PUT upload"); got != 4 {
+		t.Errorf("third run uploaded %d files, want 1", got-3)
+	}
+	p.requireFile(t, "/backup/readme.txt", "readme v2")
+}
+
+func TestPipelineBackup(t *testing.T) {
+	p := newPipeline(t)
+	p.write(t, "/Documents/notes/todo.txt", "v1", time.Now().Add(-time.Hour))
+	cfg := Config{Backup: BackupConfig{Dir: "disk:/versions"}}
+	p.run(t, cfg)
+
+	p.write(t, "/Documents/notes/todo.txt", "v2", time.Now().Add(time.Hour))
+	p.run(t, cfg)
+
+	p.requireFile(t, "/backup/notes/todo.txt", "v2")
+	p.requireFile(t, "/versions/notes/todo.txt", "v1")
+}
+
+func TestPipelineBackupSameDay(t *testing.T) {
+	p := newPipeline(t)
+	cfg := Config{Backup: BackupConfig{Dir: "disk:/versions/{date}"}}
+	day := "/versions/" + time.Now().Format("2006-01-02")
+
+	// A good copy saved in the morning is not replaced by an encrypted one saved later the same day
+	for i, content := range []string{"good", "encrypted", "encrypted again"} {
+		p.write(t, "/Documents/todo.txt", content, time.Now().Add(time.Duration(i)*time.Hour))
+		p.run(t, cfg)
+	}
+	if day != "/versions/"+time.Now().Format("2006-01-02") {
+		t.Skip("run crossed midnight")
+	}
+	p.requireFile(t, "/backup/todo.txt", "encrypted again")
+	p.requireFile(t, day+"/todo.txt", "good")
+	p.requireFile(t, day+"/todo.1.txt", "encrypted")
+}
+
+func TestPipelineAppendOnly(t *testing.T) {
+	p := newPipeline(t)
+	p.processor.destination.(*clients.YandexDiskClient).SetOverwrite(false)
+	p.write(t, "/Documents/todo.txt", "v1", time.Now().Add(-2*time.Hour))
+	cfg := Config{Backup: BackupConfig{Dir: "disk:/versions/{date}", AppendOnly: true}}
+	p.run(t, cfg)
+
+	// Yandex reports upload time as modification time, pretend v1 was uploaded before v2 was written
+	if err := p.yandex.WriteFile("/backup/todo.txt", []byte("v1"), time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The changed version is stored in backup directory once, later runs find it there
+	p.write(t, "/Documents/todo.txt", "v2", time.Now().Add(-time.Minute))
+	for range 3 {
+		p.run(t, cfg)
+	}
+	if got := p.yandex.Requests("PUT upload"); got != 2 {
+		t.Errorf("uploaded %d times, want 2", got)
+	}
+	p.requireFile(t, "/backup/todo.txt", "v1")
+	p.requireFile(t, "/versions/"+time.Now().Format("2006-01-02")+"/todo.txt", "v2")
+
+	// Without dated backup directory changed files would be lost
+	err := p.processor.Main(context.Background(), Config{TargetPath: "disk:/backup", SyncPaths: []string{"/Documents"},
+		Backup: BackupConfig{AppendOnly: true}})
+	if err == nil {
+		t.Error("append-only mode without backup directory was accepted")
+	}
+}
+
+func TestPipelineQuotaCheck(t *testing.T) {
+	p := newPipeline(t)
+	past := time.Now().Add(-time.Hour)
+	p.write(t, "/Documents/big.bin", "fifteen bytes!!", past)
+	p.write(t, "/Documents/small.txt", "ten bytes!", past)
+	cfg := Config{TargetPath: "disk:/backup", SyncPaths: []string{"/Documents"}, Quota: QuotaConfig{Check: true}}
+
+	// Run which doesn't fit is not started
+	p.yandex.TotalSpace = 20
+	if err := p.processor.Main(context.Background(), cfg); !errors.Is(err, backend.ErrQuotaExceeded) {
+		t.Fatalf("Main error = %v, want quota exceeded", err)
+	}
+	if got := p.yandex.Requests("PUT upload"); got != 0 {
+		t.Fatalf("uploaded %d files without free space", got)
+	}
+
+	// Files over upload limit are skipped
+	p.yandex.TotalSpace = 1 << 20
+	p.yandex.MaxFileUploadSize = 12
+	if err := p.processor.Main(context.Background(), cfg); !errors.Is(err, ErrPartial) {
+		t.Fatalf("Main error = %v, want partial failure", err)
+	}
+	p.requireFile(t, "/backup/small.txt", "ten bytes!")
+	if got := p.yandex.Requests("PUT upload"); got != 1 {
+		t.Errorf("uploaded %d files, want 1", got)
+	}
+}
+
+func TestPipelineCompressionRestore(t *testing.T) {
+	p := newPipeline(t)
+	past := time.Now().Add(-time.Hour)
+	p.write(t, "/Documents/logs/app.log", "line 1\nline 2\n", past)
+	p.write(t, "/Documents/image.png", "png", past)
+	cfg := Config{Compression: CompressionConfig{Patterns: []string{"*.log"}}}
+
+	p.run(t, cfg)
+	if _, err := p.yandex.ReadFile("/backup/logs/app.log.zst"); err != nil {
+		t.Fatalf("compressed file is missing: %v", err)
+	}
+	if len(p.yandex.Properties("/backup/logs/app.log.zst")) == 0 {
+		t.Errorf("compressed file has no custom properties")
+	}
+	p.requireFile(t, "/backup/image.png", "png")
+
+	p.run(t, cfg)
+	if got := p.yandex.Requests("PUT upload"); got != 2 {
+		t.Errorf("uploaded %d files in total, want 2", got)
+	}
+
+	err := p.processor.Restore(context.Background(), Config{
+		TargetPath: "disk:/backup",
+		SyncPaths:  []string{"/Restored"},
+	})
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	for filePath, want := range map[string]string{
+		"/Restored/logs/app.log": "line 1\nline 2\n",
+		"/Restored/image.png":    "png",
+	} {
+		data, err := p.nextcloud.ReadFile(filePath)
+		if err != nil {
+			t.Errorf("%s is missing in Nextcloud: %v", filePath, err)
+		} else if string(data) != want {
+			t.Errorf("%s = %q, want %q", filePath, data, want)
+		}
+	}
+}
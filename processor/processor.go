@@ -2,143 +2,482 @@ package processor
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"net/url"
 	"path"
 	"strings"
+	"time"
 
+	"nextya-sync/backend"
 	"nextya-sync/models"
 )
 
-type cloudClient interface {
-	ListFiles(ctx context.Context, folderPath string) ([]models.FileInfo, error)
-	DownloadFile(ctx context.Context, path string) (io.ReadCloser, error)
-	UploadFile(ctx context.Context, path string, content io.Reader, size int64) error
-	CreateFolder(ctx context.Context, path string) error
-	GetFileInfo(ctx context.Context, path string) (*models.FileInfo, error)
-}
-
-// Processor handles synchronization between cloud storage services
+// Processor handles synchronization between storage backends
 type Processor struct {
-	yandexClient    cloudClient
-	nextcloudClient cloudClient
+	source      backend.Backend
+	destination backend.Backend
 }
 
 // Dependencies configuration for creating a processor
 type Dependencies struct {
-	YandexClient    cloudClient
-	NextcloudClient cloudClient
+	Source      backend.Backend
+	Destination backend.Backend
 }
 
 // Config holds configuration for the synchronization processor
 type Config struct {
-	YandexTargetPath   string
-	NextcloudSyncPaths []string
+	// TargetPath destination folder files are synchronized to
+	TargetPath string
+	// SyncPaths source folders to synchronize
+	SyncPaths   []string
+	Archive     ArchiveConfig
+	Compression CompressionConfig
+	Backup      BackupConfig
+	// Versions backs up previous versions of files kept by source
+	Versions bool
+	// Quota checks free space of destination before transferring files
+	Quota QuotaConfig
+	// TrashbinTargetPath destination folder source trashbin is backed up to, disabled if empty
+	TrashbinTargetPath string
+	// Report collects per-file outcomes of the run if set
+	Report *Report
+	// Notifier is told about the result of the run if set
+	Notifier Notifier
+}
+
+// ErrPartial run completed, but some files or sync paths failed
+var ErrPartial = errors.New("partial failure")
+
+// Notifier receives statistics of a finished run and the error it failed with, if any
+type Notifier interface {
+	Notify(ctx context.Context, stats SyncStats, err error)
 }
 
 // NewProcessor creates a new instance of synchronization processor
 func NewProcessor(d *Dependencies) *Processor {
 	return &Processor{
-		yandexClient:    d.YandexClient,
-		nextcloudClient: d.NextcloudClient,
+		source:      d.Source,
+		destination: d.Destination,
 	}
 }
 
-// Main main function for synchronizing files from Nextcloud to Yandex Disk
-func (p *Processor) Main(ctx context.Context, cfg Config) error {
-	log.Println("Starting synchronization from Nextcloud to Yandex Disk...")
+// Main main function for synchronizing files from source to destination
+func (p *Processor) Main(ctx context.Context, cfg Config) (err error) {
+	start := time.Now()
+	slog.Info("Starting synchronization from source to destination")
+
+	syncStats := newSyncStats(cfg.Report, start)
+	defer func() {
+		syncStats.finish()
+		if cfg.Notifier != nil {
+			cfg.Notifier.Notify(ctx, *syncStats, err)
+		}
+	}()
 
 	// Validate configuration
-	if len(cfg.NextcloudSyncPaths) == 0 {
-		return fmt.Errorf("no Nextcloud sync paths specified")
+	if len(cfg.SyncPaths) == 0 {
+		return fmt.Errorf("no source sync paths specified")
 	}
 
-	// Get file structure from Yandex Disk
-	log.Println("Reading Yandex Disk file structure...")
-	yandexTargetPath := cfg.YandexTargetPath
-	yndxFs, err := p.getYandexFileSystem(ctx, yandexTargetPath)
+	// Get file structure from destination
+	slog.Info("Reading destination file structure", "path", cfg.TargetPath)
+	rootTargetPath := cfg.TargetPath
+	rootDstFs, err := p.getFileSystem(ctx, p.destination, rootTargetPath)
+	if err != nil && !errors.Is(err, backend.ErrNotFound) {
+		return fmt.Errorf("failed to read destination file structure: %w", err)
+	}
 	if err != nil {
-		log.Printf("Yandex Disk target folder doesn't exist, will create it")
+		slog.Info("Destination target folder doesn't exist, will create it", "path", rootTargetPath)
 		// Create target folder chain if it doesn't exist
-		if createErr := p.createFolderChain(ctx, yandexTargetPath); createErr != nil {
-			return fmt.Errorf("failed to create target folder chain in Yandex Disk: %w", createErr)
+		if createErr := p.createFolderChain(ctx, p.destination, rootTargetPath); createErr != nil {
+			return fmt.Errorf("failed to create target folder chain in destination: %w", createErr)
 		}
 		// Create empty structure
-		yndxFs = models.Folder{Path: yandexTargetPath}
+		rootDstFs = models.Folder{Path: rootTargetPath}
 	}
 
 	// Synchronize each specified path
-	syncStats := &SyncStats{}
-	for _, syncPath := range cfg.NextcloudSyncPaths {
-		log.Printf("Processing sync path: %s", syncPath)
-
-		// Get file structure from Nextcloud for this specific path
-		log.Printf("Reading Nextcloud file structure for path: %s", syncPath)
-		ncFs, err := p.getNextcloudFileSystem(ctx, syncPath)
+	t, err := p.syncTransfer(cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.Versions {
+		t.changed = make(map[string]bool)
+	}
+	// Read source and destination folders of all paths first, so the plan can be checked against quota
+	var targets []syncTarget
+	for _, syncPath := range cfg.SyncPaths {
+		syncStats.syncPath = syncPath
+
+		// Get file structure from source for this specific path
+		slog.Info("Reading source file structure", "path", syncPath)
+		srcFs, err := p.getFileSystem(ctx, p.source, syncPath)
 		if err != nil {
-			log.Printf("Warning: failed to get Nextcloud file system for path %s: %v", syncPath, err)
+			if abortsRun(err) {
+				return fmt.Errorf("failed to read source %s: %w", syncPath, err)
+			}
+			slog.Warn("Failed to get source file system", "path", syncPath, "error", err)
+			syncStats.FailedPaths++
 			continue
 		}
 
-		// Determine target path in Yandex Disk
-		// If syncing multiple paths, create subfolder for each path to avoid conflicts
-		var targetPath string
-		if len(cfg.NextcloudSyncPaths) == 1 {
-			// If only one path, sync directly to target folder
-			targetPath = yandexTargetPath
-		} else {
-			// If multiple paths, create subfolder based on the sync path name
-			pathName := path.Base(syncPath)
-			if pathName == "/" || pathName == "." {
-				pathName = "root"
-			}
-			targetPath = yandexTargetPath + "/" + pathName
+		// Determine target path in destination
+		target := syncTarget{syncPath: syncPath, targetPath: cfg.targetPath(syncPath), src: srcFs, transfer: t}
+		if cfg.Archive.enabled(syncPath) {
+			target.transfer.archive = &cfg.Archive
 		}
 
-		// Get or create corresponding Yandex folder structure
-		var targetYandexFs models.Folder
-		if targetPath == yandexTargetPath {
-			targetYandexFs = yndxFs
+		// Get corresponding destination folder structure, missing subfolder is created before synchronization
+		if target.targetPath == rootTargetPath {
+			target.dst = rootDstFs
 		} else {
-			// Try to get existing folder or create new one
-			existingFs, err := p.getYandexFileSystem(ctx, targetPath)
-			if err != nil {
-				log.Printf("Target subfolder %s doesn't exist, will create it", targetPath)
-				if createErr := p.yandexClient.CreateFolder(ctx, targetPath); createErr != nil {
-					log.Printf("Warning: failed to create target subfolder %s: %v", targetPath, createErr)
-					continue
+			existingFs, err := p.getFileSystem(ctx, p.destination, target.targetPath)
+			switch {
+			case errors.Is(err, backend.ErrNotFound):
+				target.create = true
+				target.dst = models.Folder{Path: target.targetPath}
+			case err != nil:
+				if abortsRun(err) {
+					return fmt.Errorf("failed to read target subfolder %s: %w", target.targetPath, err)
 				}
-				targetYandexFs = models.Folder{Path: targetPath}
-			} else {
-				targetYandexFs = existingFs
+				slog.Warn("Failed to get target subfolder", "path", target.targetPath, "error", err)
+				syncStats.FailedPaths++
+				continue
+			default:
+				target.dst = existingFs
+			}
+		}
+		targets = append(targets, target)
+	}
+
+	var maxFileSize int64
+	if cfg.Quota.Check {
+		if maxFileSize, err = p.checkQuota(ctx, cfg.Quota, targets); err != nil {
+			return err
+		}
+	}
+
+	for _, target := range targets {
+		syncPath, targetPath := target.syncPath, target.targetPath
+		syncStats.syncPath = syncPath
+
+		if target.create {
+			slog.Info("Target subfolder doesn't exist, will create it", "path", targetPath)
+			if err := p.destination.CreateFolder(ctx, targetPath); err != nil {
+				slog.Warn("Failed to create target subfolder", "path", targetPath, "error", err)
+				syncStats.FailedPaths++
+				continue
 			}
 		}
 
 		// Synchronize this specific path
-		if err := p.syncFolders(ctx, ncFs, targetYandexFs, targetPath, syncStats); err != nil {
-			log.Printf("Warning: synchronization failed for path %s: %v", syncPath, err)
+		pathTransfer := target.transfer
+		pathTransfer.maxFileSize = maxFileSize
+		pathTransfer.checkSpace = cfg.Quota.Check
+		if err := p.syncFolders(ctx, pathTransfer, target.src, target.dst, targetPath, syncStats); err != nil {
+			if abortsRun(err) {
+				return fmt.Errorf("synchronization of %s failed: %w", syncPath, err)
+			}
+			slog.Warn("Synchronization failed", "path", syncPath, "error", err)
+			syncStats.FailedPaths++
 			continue
 		}
+
+		// Back up previous versions of files
+		if cfg.Versions {
+			slog.Info("Synchronizing versions", "path", syncPath)
+			if err := p.syncVersionsTree(ctx, pathTransfer, target.src, targetPath, syncStats); err != nil {
+				return fmt.Errorf("synchronization of %s failed: %w", syncPath, err)
+			}
+		}
 	}
 
-	log.Printf("Synchronization completed! Files processed: %d, uploaded: %d, skipped: %d, errors: %d",
-		syncStats.TotalFiles, syncStats.UploadedFiles, syncStats.SkippedFiles, syncStats.ErrorFiles)
+	// Back up source trashbin
+	if cfg.TrashbinTargetPath != "" {
+		if err := p.SyncTrashbin(ctx, cfg, syncStats); err != nil {
+			if errors.Is(err, backend.ErrQuotaExceeded) {
+				return fmt.Errorf("trashbin synchronization failed: %w", err)
+			}
+			slog.Warn("Trashbin synchronization failed", "error", err)
+			syncStats.FailedPaths++
+		}
+	}
 
-	return nil
+	attrs := []any{
+		"files", syncStats.TotalFiles,
+		"uploaded", syncStats.UploadedFiles,
+		"skipped", syncStats.SkippedFiles,
+		"errors", syncStats.ErrorFiles,
+		"bytes", syncStats.UploadedBytes,
+		"duration", time.Since(start),
+	}
+	if cfg.Versions {
+		attrs = append(attrs, "versions", syncStats.UploadedVersions)
+	}
+	slog.Info("Synchronization completed", attrs...)
+
+	return syncStats.err()
+}
+
+// Restore restores files from destination back to source.
+// Files missing in source or differing in size are uploaded, others are left untouched.
+func (p *Processor) Restore(ctx context.Context, cfg Config) error {
+	start := time.Now()
+	slog.Info("Starting restore from destination to source")
+
+	if len(cfg.SyncPaths) == 0 {
+		return fmt.Errorf("no source sync paths specified")
+	}
+
+	syncStats := newSyncStats(cfg.Report, start)
+	defer syncStats.finish()
+	t := transfer{
+		src:        p.destination,
+		dst:        p.source,
+		srcEscaped: backend.IsEscaped(p.destination),
+		dstEscaped: backend.IsEscaped(p.source),
+		outdated:   isDifferentSize,
+		extract:    true,
+	}
+	for _, syncPath := range cfg.SyncPaths {
+		backupPath := cfg.targetPath(syncPath)
+		syncStats.syncPath = syncPath
+		slog.Info("Restoring to source", "path", backupPath, "target", syncPath)
+
+		backupFs, err := p.getFileSystem(ctx, p.destination, backupPath)
+		if err != nil {
+			if abortsRun(err) {
+				return fmt.Errorf("failed to read destination %s: %w", backupPath, err)
+			}
+			slog.Warn("Failed to get destination file system", "path", backupPath, "error", err)
+			syncStats.FailedPaths++
+			continue
+		}
+		backupFs.Folders = withoutFolder(backupFs.Folders, versionsFolderName)
+
+		srcFs, err := p.getFileSystem(ctx, p.source, syncPath)
+		if err != nil && !errors.Is(err, backend.ErrNotFound) {
+			if abortsRun(err) {
+				return fmt.Errorf("failed to read source %s: %w", syncPath, err)
+			}
+			slog.Warn("Failed to get source file system", "path", syncPath, "error", err)
+			syncStats.FailedPaths++
+			continue
+		}
+		if err != nil {
+			slog.Info("Source folder doesn't exist, will create it", "path", syncPath)
+			if createErr := p.createFolderChain(ctx, p.source, syncPath); createErr != nil {
+				slog.Warn("Failed to create source folder", "path", syncPath, "error", createErr)
+				syncStats.FailedPaths++
+				continue
+			}
+			srcFs = models.Folder{Path: syncPath}
+		}
+
+		if err := p.syncFolders(ctx, t, backupFs, srcFs, syncPath, syncStats); err != nil {
+			slog.Warn("Restore failed", "path", syncPath, "error", err)
+			syncStats.FailedPaths++
+			continue
+		}
+	}
+
+	slog.Info("Restore completed",
+		"files", syncStats.TotalFiles,
+		"uploaded", syncStats.UploadedFiles,
+		"skipped", syncStats.SkippedFiles,
+		"errors", syncStats.ErrorFiles,
+		"bytes", syncStats.UploadedBytes,
+		"duration", time.Since(start))
+
+	return syncStats.err()
+}
+
+// syncTransfer returns transfer from source to destination configured for synchronization
+func (p *Processor) syncTransfer(cfg Config) (transfer, error) {
+	t := transfer{
+		src:        p.source,
+		dst:        p.destination,
+		srcEscaped: backend.IsEscaped(p.source),
+		dstEscaped: backend.IsEscaped(p.destination),
+		outdated:   isNewer,
+	}
+	var err error
+	if t.backup, err = p.newBackup(cfg, time.Now()); err != nil {
+		return transfer{}, fmt.Errorf("failed to configure backups: %w", err)
+	}
+	if cfg.Compression.enabled() {
+		if _, ok := p.destination.(backend.PropertySetter); ok {
+			t.compression = &cfg.Compression
+		} else {
+			slog.Warn("Destination doesn't support custom properties, compression is disabled")
+		}
+	}
+	return t, nil
+}
+
+// abortsRun reports whether error makes processing of remaining paths pointless
+func abortsRun(err error) bool {
+	return errors.Is(err, backend.ErrUnauthorized)
+}
+
+// targetPath determines path in destination for the given sync path
+func (cfg Config) targetPath(syncPath string) string {
+	// If only one path, sync directly to target folder
+	if len(cfg.SyncPaths) == 1 {
+		return cfg.TargetPath
+	}
+
+	// If multiple paths, create subfolder based on the sync path name to avoid conflicts
+	pathName := path.Base(syncPath)
+	if pathName == "/" || pathName == "." {
+		pathName = "root"
+	}
+	return cfg.TargetPath + "/" + pathName
 }
 
 // SyncStats synchronization statistics
 type SyncStats struct {
-	TotalFiles    int
-	UploadedFiles int
-	SkippedFiles  int
-	ErrorFiles    int
+	TotalFiles       int
+	UploadedFiles    int
+	SkippedFiles     int
+	ErrorFiles       int
+	UploadedVersions int
+	// UploadedBytes size of uploaded files before compression
+	UploadedBytes int64
+
+	UploadedTrashbinFiles int
+	// DeletedFiles files and folders removed from destination after deletion in source
+	DeletedFiles int
+	// FailedPaths sync paths and trashbin left unprocessed because of errors
+	FailedPaths int
+	// Duration time the run took
+	Duration time.Duration
+
+	// report receives file results, nil if disabled
+	report *Report
+	// syncPath sync path currently processed
+	syncPath string
+	started  time.Time
+}
+
+// newSyncStats creates statistics of a run started at start, recording file results into report if set
+func newSyncStats(report *Report, start time.Time) *SyncStats {
+	if report != nil {
+		report.Started = start
+	}
+	return &SyncStats{report: report, started: start}
+}
+
+// record adds file result of the current sync path to the report
+func (s *SyncStats) record(result FileResult) {
+	if s.report == nil {
+		return
+	}
+	result.SyncPath = s.syncPath
+	s.report.Files = append(s.report.Files, result)
+}
+
+// err returns ErrPartial if some files or paths failed
+func (s *SyncStats) err() error {
+	if s.ErrorFiles == 0 && s.FailedPaths == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %d files and %d paths failed", ErrPartial, s.ErrorFiles, s.FailedPaths)
+}
+
+// finish records end of the run
+func (s *SyncStats) finish() {
+	now := time.Now()
+	if s.report != nil {
+		s.report.Finished = now
+	}
+	s.Duration = now.Sub(s.started)
+}
+
+// syncTarget source folder and its destination folder read before synchronization
+type syncTarget struct {
+	syncPath   string
+	targetPath string
+	src        models.Folder
+	dst        models.Folder
+	transfer   transfer
+	// create reports that destination folder doesn't exist yet
+	create bool
+}
+
+// transfer describes direction of synchronization
+type transfer struct {
+	src backend.Backend
+	dst backend.Backend
+	// srcEscaped and dstEscaped report whether names in paths are URL-escaped (WebDAV hrefs)
+	srcEscaped bool
+	dstEscaped bool
+	// outdated reports whether existing destination file must be replaced by source file
+	outdated func(src, dst models.File) bool
+	// archive packs small files into per-directory archives when set
+	archive *ArchiveConfig
+	// extract unpacks per-directory archives found in source
+	extract bool
+	// compression compresses matching files on upload when set
+	compression *CompressionConfig
+	// backup keeps previous versions of overwritten files when set
+	backup *backup
+	// changed collects source paths of files uploaded in this run when set
+	changed map[string]bool
+	// maxFileSize files bigger than this are not uploaded, unlimited if 0
+	maxFileSize int64
+	// checkSpace checks free space of destination before versions are uploaded
+	checkSpace bool
+}
+
+// isOutdated reports whether existing destination file must be replaced by source file
+func (t transfer) isOutdated(src, dst models.File) bool {
+	if isCompressed(dst) {
+		return isCompressedOutdated(src, dst)
+	}
+	return t.outdated(src, dst)
+}
+
+// isNewer reports whether source file was modified after destination file
+func isNewer(src, dst models.File) bool {
+	return src.Modified.After(dst.Modified)
+}
+
+// isDifferentSize reports whether source and destination file sizes differ
+func isDifferentSize(src, dst models.File) bool {
+	return src.Size != dst.Size
+}
+
+// sameContent reports whether both backends report equal content hashes of files, false if unknown
+func (p *Processor) sameContent(ctx context.Context, t transfer, src, dst models.File) bool {
+	if src.Size != dst.Size || isCompressed(src) || isCompressed(dst) {
+		return false
+	}
+
+	dstHasher, ok := t.dst.(backend.Hasher)
+	if !ok {
+		return false
+	}
+	srcHasher, ok := t.src.(backend.Hasher)
+	if !ok {
+		return false
+	}
+
+	dstSum, dstAlgo, err := dstHasher.Hash(ctx, dst.Path)
+	if err != nil {
+		return false
+	}
+	srcSum, srcAlgo, err := srcHasher.Hash(ctx, src.Path)
+	if err != nil {
+		return false
+	}
+	return srcAlgo == dstAlgo && strings.EqualFold(srcSum, dstSum)
 }
 
 // createFolderChain creates a chain of folders recursively
-func (p *Processor) createFolderChain(ctx context.Context, folderPath string) error {
+func (p *Processor) createFolderChain(ctx context.Context, client backend.Backend, folderPath string) error {
 	// Normalize path separators and remove leading/trailing slashes
 	folderPath = strings.Trim(strings.ReplaceAll(folderPath, "\\", "/"), "/")
 
@@ -147,24 +486,27 @@ func (p *Processor) createFolderChain(ctx context.Context, folderPath string) er
 	}
 
 	// Check if folder already exists
-	_, err := p.yandexClient.GetFileInfo(ctx, folderPath)
+	_, err := client.GetFileInfo(ctx, folderPath)
 	if err == nil {
 		// Folder exists, nothing to do
 		return nil
 	}
+	if !errors.Is(err, backend.ErrNotFound) {
+		return fmt.Errorf("failed to check folder %s: %w", folderPath, err)
+	}
 
 	// Get parent folder path
 	parentPath := path.Dir(folderPath)
 	if parentPath != "." && parentPath != "/" && parentPath != folderPath {
 		// Recursively create parent folder chain
-		if createErr := p.createFolderChain(ctx, parentPath); createErr != nil {
+		if createErr := p.createFolderChain(ctx, client, parentPath); createErr != nil {
 			return fmt.Errorf("failed to create parent folder %s: %w", parentPath, createErr)
 		}
 	}
 
 	// Create current folder
-	log.Printf("Creating folder: %s", folderPath)
-	if err := p.yandexClient.CreateFolder(ctx, folderPath); err != nil {
+	slog.Info("Creating folder", "path", folderPath, "action", "create_folder")
+	if err := client.CreateFolder(ctx, folderPath); err != nil {
 		return fmt.Errorf("failed to create folder %s: %w", folderPath, err)
 	}
 
@@ -172,91 +514,147 @@ func (p *Processor) createFolderChain(ctx context.Context, folderPath string) er
 }
 
 // syncFolders synchronizes folders recursively
-func (p *Processor) syncFolders(ctx context.Context, ncFolder, yandexFolder models.Folder, yandexBasePath string, stats *SyncStats) error {
-	// Create Yandex Disk files map for quick lookup
-	yandexFiles := make(map[string]models.File)
-	for _, file := range yandexFolder.Files {
-		fileName := path.Base(file.Path)
-		yandexFiles[fileName] = file
+func (p *Processor) syncFolders(ctx context.Context, t transfer, srcFolder, dstFolder models.Folder, dstBasePath string, stats *SyncStats) error {
+	// Create destination files map for quick lookup
+	dstFiles := make(map[string]models.File)
+	// storedFiles holds destination files by stored name, compressed copies keep their suffix
+	storedFiles := make(map[string]models.File)
+	for _, file := range dstFolder.Files {
+		storedFiles[baseName(file.Path, t.dstEscaped)] = file
+		name, file := uncompressedView(baseName(file.Path, t.dstEscaped), file)
+		dstFiles[name] = file
 	}
 
-	// Create Yandex Disk folders map
-	yandexFolders := make(map[string]models.Folder)
-	for _, folder := range yandexFolder.Folders {
-		folderName := path.Base(folder.Path)
-		yandexFolders[folderName] = folder
+	// Create destination folders map
+	dstFolders := make(map[string]models.Folder)
+	for _, folder := range dstFolder.Folders {
+		dstFolders[baseName(folder.Path, t.dstEscaped)] = folder
+	}
+
+	// Pack small files into archive or unpack archive, the rest is synced file by file
+	srcFiles := srcFolder.Files
+	if t.archive != nil {
+		var small []models.File
+		small, srcFiles = t.archive.splitSmallFiles(srcFiles)
+		p.syncArchive(ctx, t, small, dstFiles, storedFiles, dstBasePath, stats)
+	}
+	if t.extract {
+		srcFiles = p.extractArchive(ctx, t, srcFolder, dstFiles, dstBasePath, stats)
 	}
 
 	// Synchronize files
-	for _, ncFile := range ncFolder.Files {
+	for _, srcFile := range srcFiles {
 		stats.TotalFiles++
-		fileName := path.Base(ncFile.Path)
 
 		// Decode filename for comparison and logging
-		decodedFileName, err := url.QueryUnescape(fileName)
-		if err != nil {
-			log.Printf("Warning: failed to decode filename %s, using original: %v", fileName, err)
-			decodedFileName = fileName
-		}
+		fileName, srcFile := uncompressedView(baseName(srcFile.Path, t.srcEscaped), srcFile)
 
 		// Check if file needs synchronization (compare with decoded name)
-		yandexFile, exists := yandexFiles[decodedFileName]
+		dstFile, exists := dstFiles[fileName]
 		needsSync := false
 
+		action, reportAction := "upload", ActionUploaded
 		if !exists {
-			log.Printf("File %s doesn't exist in Yandex Disk, will upload", decodedFileName)
+			slog.Debug("File doesn't exist in destination, will upload", "path", srcFile.Path)
 			needsSync = true
 		} else {
-			// Compare modification dates
-			if ncFile.Modified.After(yandexFile.Modified) {
-				log.Printf("File %s is newer in Nextcloud (%v vs %v), will update",
-					decodedFileName, ncFile.Modified, yandexFile.Modified)
-				needsSync = true
-			} else {
-				log.Printf("File %s is up to date, skipping", decodedFileName)
+			switch {
+			case !t.isOutdated(srcFile, dstFile):
+				slog.Debug("File is up to date", "path", srcFile.Path, "action", "skip")
+				stats.SkippedFiles++
+				stats.record(FileResult{Path: srcFile.Path, Action: ActionSkipped, Reason: "up to date", Size: srcFile.Size})
+			case p.sameContent(ctx, t, srcFile, dstFile):
+				slog.Debug("File has the same content as destination", "path", srcFile.Path, "action", "skip")
 				stats.SkippedFiles++
+				stats.record(FileResult{Path: srcFile.Path, Action: ActionSkipped, Reason: "same content", Size: srcFile.Size})
+			default:
+				slog.Debug("File differs from destination, will update", "path", srcFile.Path,
+					"modified", srcFile.Modified, "destination_modified", dstFile.Modified)
+				action, reportAction = "update", ActionUpdated
+				needsSync = true
+			}
+			if !needsSync {
+				p.removeStaleCopy(ctx, t, storedFiles, fileName, isCompressed(dstFile))
 			}
 		}
 
+		if needsSync && exceedsMaxSize(srcFile, t.maxFileSize) {
+			slog.Warn("File exceeds maximum upload size of destination, skipping", "path", srcFile.Path,
+				"bytes", srcFile.Size, "max_bytes", t.maxFileSize)
+			stats.ErrorFiles++
+			stats.record(FileResult{Path: srcFile.Path, Action: ActionFailed, Reason: "exceeds max upload size",
+				Size: srcFile.Size, Err: backend.ErrQuotaExceeded})
+			continue
+		}
+
 		if needsSync {
-			if err := p.syncFile(ctx, ncFile.Path, yandexBasePath, fileName); err != nil {
-				log.Printf("Error syncing file %s: %v", decodedFileName, err)
+			dstFilePath := joinPath(dstBasePath, escapeName(fileName, t.dstEscaped))
+			if exists {
+				var err error
+				dstFilePath, err = p.prepareOverwrite(ctx, t, dstFile.Path, dstFilePath, func(stored models.FileInfo) (bool, error) {
+					_, storedFile := uncompressedView(baseName(stored.Path, t.dstEscaped), fileOf(stored))
+					return !t.isOutdated(srcFile, storedFile) || p.sameContent(ctx, t, srcFile, storedFile), nil
+				})
+				if err != nil {
+					slog.Error("Failed to preserve previous version", "path", srcFile.Path, "error", err)
+					stats.ErrorFiles++
+					stats.record(FileResult{Path: srcFile.Path, Action: ActionFailed, Reason: "preserve previous version", Size: srcFile.Size, Err: err})
+					continue
+				}
+				if dstFilePath == "" {
+					slog.Info("File changed, not overwriting in append-only mode", "path", srcFile.Path, "action", "skip")
+					stats.SkippedFiles++
+					stats.record(FileResult{Path: srcFile.Path, Action: ActionSkipped, Reason: "append-only mode", Size: srcFile.Size})
+					continue
+				}
+				if t.backup != nil && !t.backup.appendOnly {
+					// Previous version was moved to backup directory
+					delete(storedFiles, baseName(dstFile.Path, t.dstEscaped))
+				}
+			}
+			fileStart := time.Now()
+			if err := p.syncFile(ctx, t, srcFile, fileName, dstFilePath); err != nil {
+				slog.Error("Failed to sync file", "path", srcFile.Path, "action", action, "error", err)
 				stats.ErrorFiles++
+				stats.record(FileResult{Path: srcFile.Path, Target: dstFilePath, Action: ActionFailed,
+					Size: srcFile.Size, Duration: time.Since(fileStart), Err: err})
 			} else {
-				log.Printf("Successfully synced file %s", decodedFileName)
+				duration := time.Since(fileStart)
+				slog.Info("File synced", "path", srcFile.Path, "target", dstFilePath, "action", action,
+					"bytes", srcFile.Size, "duration", duration)
 				stats.UploadedFiles++
+				stats.record(FileResult{Path: srcFile.Path, Target: dstFilePath, Action: reportAction,
+					Size: srcFile.Size, Duration: duration})
+				stats.UploadedBytes += srcFile.Size
+				if t.changed != nil {
+					t.changed[srcFile.Path] = true
+				}
+				p.removeStaleCopy(ctx, t, storedFiles, fileName, t.compresses(fileName, srcFile))
 			}
 		}
 	}
 
 	// Recursively synchronize subfolders
-	for _, ncSubFolder := range ncFolder.Folders {
-		subFolderName := path.Base(ncSubFolder.Path)
-
+	for _, srcSubFolder := range srcFolder.Folders {
 		// Decode folder name
-		decodedFolderName, err := url.QueryUnescape(subFolderName)
-		if err != nil {
-			log.Printf("Warning: failed to decode folder name %s, using original: %v", subFolderName, err)
-			decodedFolderName = subFolderName
-		}
+		folderName := baseName(srcSubFolder.Path, t.srcEscaped)
 
-		yandexSubFolderPath := yandexBasePath + "/" + decodedFolderName
+		dstSubFolderPath := joinPath(dstBasePath, escapeName(folderName, t.dstEscaped))
 
-		// Check if folder exists in Yandex Disk (compare with decoded name)
-		yandexSubFolder, exists := yandexFolders[decodedFolderName]
+		// Check if folder exists in destination (compare with decoded name)
+		dstSubFolder, exists := dstFolders[folderName]
 		if !exists {
-			log.Printf("Creating folder %s in Yandex Disk", yandexSubFolderPath)
-			if err := p.createFolderChain(ctx, yandexSubFolderPath); err != nil {
-				log.Printf("Error creating folder chain %s: %v", yandexSubFolderPath, err)
+			if err := p.createFolderChain(ctx, t.dst, dstSubFolderPath); err != nil {
+				slog.Error("Failed to create folder", "path", dstSubFolderPath, "error", err)
 				continue
 			}
 			// Create empty structure for new folder
-			yandexSubFolder = models.Folder{Path: yandexSubFolderPath}
+			dstSubFolder = models.Folder{Path: dstSubFolderPath}
 		}
 
 		// Recursively synchronize subfolder
-		if err := p.syncFolders(ctx, ncSubFolder, yandexSubFolder, yandexSubFolderPath, stats); err != nil {
-			log.Printf("Error syncing subfolder %s: %v", decodedFolderName, err)
+		if err := p.syncFolders(ctx, t, srcSubFolder, dstSubFolder, dstSubFolderPath, stats); err != nil {
+			slog.Error("Failed to sync subfolder", "path", srcSubFolder.Path, "error", err)
 		}
 	}
 
@@ -264,38 +662,45 @@ func (p *Processor) syncFolders(ctx context.Context, ncFolder, yandexFolder mode
 }
 
 // syncFile synchronizes individual file
-func (p *Processor) syncFile(ctx context.Context, ncFilePath, yandexBasePath, fileName string) error {
-	// Decode filename from URL encoding
-	decodedFileName, err := url.QueryUnescape(fileName)
+func (p *Processor) syncFile(ctx context.Context, t transfer, srcFile models.File, fileName, dstFilePath string) error {
+	// Download file from source
+	reader, err := t.src.DownloadFile(ctx, srcFile.Path)
 	if err != nil {
-		log.Printf("Warning: failed to decode filename %s, using original: %v", fileName, err)
-		decodedFileName = fileName
+		return fmt.Errorf("failed to download file: %w", err)
 	}
-
-	// Download file from Nextcloud
-	reader, err := p.nextcloudClient.DownloadFile(ctx, ncFilePath)
-	if err != nil {
-		return fmt.Errorf("failed to download file from Nextcloud: %w", err)
+	if isCompressed(srcFile) {
+		decompressed, err := decompressReader(reader)
+		if err != nil {
+			reader.Close()
+			return fmt.Errorf("failed to decompress file: %w", err)
+		}
+		reader = decompressed
 	}
 	defer reader.Close()
 
-	// Get file info for size
-	ncFileInfo, err := p.nextcloudClient.GetFileInfo(ctx, ncFilePath)
-	if err != nil {
-		return fmt.Errorf("failed to get file info from Nextcloud: %w", err)
+	// Compress file on the fly if it matches compression rules
+	if t.compresses(fileName, srcFile) {
+		return p.uploadCompressed(ctx, t, srcFile, reader, dstFilePath)
+	}
+
+	// Upload file to destination
+	if err := t.dst.UploadFile(ctx, dstFilePath, reader, srcFile.Size); err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
 	}
 
-	// Upload file to Yandex Disk with decoded name
-	yandexFilePath := yandexBasePath + "/" + decodedFileName
-	if err := p.yandexClient.UploadFile(ctx, yandexFilePath, reader, ncFileInfo.Size); err != nil {
-		return fmt.Errorf("failed to upload file to Yandex Disk: %w", err)
+	// Keep modification time of source file where destination allows it
+	if setter, ok := t.dst.(backend.ModTimeSetter); ok && !srcFile.Modified.IsZero() {
+		if err := setter.SetModTime(ctx, dstFilePath, srcFile.Modified); err != nil {
+			return fmt.Errorf("failed to set modification time: %w", err)
+		}
 	}
 
 	return nil
 }
 
-func (p *Processor) getYandexFileSystem(ctx context.Context, rootPath string) (models.Folder, error) {
-	files, err := p.yandexClient.ListFiles(ctx, rootPath)
+// getFileSystem reads folder structure recursively
+func (p *Processor) getFileSystem(ctx context.Context, client backend.Backend, rootPath string) (models.Folder, error) {
+	files, err := client.ListFiles(ctx, rootPath)
 	if err != nil {
 		return models.Folder{}, err
 	}
@@ -306,44 +711,65 @@ func (p *Processor) getYandexFileSystem(ctx context.Context, rootPath string) (m
 
 	for _, file := range files {
 		if file.IsDir {
-			subFolder, err := p.getYandexFileSystem(ctx, file.Path)
+			subFolder, err := p.getFileSystem(ctx, client, file.Path)
 			if err != nil {
 				return models.Folder{}, err
 			}
 			folder.Folders = append(folder.Folders, subFolder)
 		} else {
-			folder.Files = append(folder.Files, models.File{
-				Path:     file.Path,
-				Modified: file.ModTime,
-			})
+			folder.Files = append(folder.Files, fileOf(file))
 		}
 	}
 	return folder, nil
 }
 
-func (p *Processor) getNextcloudFileSystem(ctx context.Context, rootPath string) (models.Folder, error) {
-	files, err := p.nextcloudClient.ListFiles(ctx, rootPath)
-	if err != nil {
-		return models.Folder{}, err
+// fileOf converts listed file to file of folder structure
+func fileOf(file models.FileInfo) models.File {
+	return models.File{
+		ID:          file.ID,
+		Path:        file.Path,
+		Size:        file.Size,
+		Modified:    file.ModTime,
+		ContentType: file.ContentType,
+		Properties:  file.Properties,
+	}
+}
+
+// withoutFolder returns folders except the one with given name
+func withoutFolder(folders []models.Folder, name string) []models.Folder {
+	var result []models.Folder
+	for _, folder := range folders {
+		if path.Base(folder.Path) != name {
+			result = append(result, folder)
+		}
 	}
+	return result
+}
 
-	folder := models.Folder{
-		Path: rootPath,
+// baseName returns last path element, decoding it if it is URL-escaped
+func baseName(filePath string, escaped bool) string {
+	name := path.Base(filePath)
+	if !escaped {
+		return name
 	}
 
-	for _, file := range files {
-		if file.IsDir {
-			subFolder, err := p.getNextcloudFileSystem(ctx, file.Path)
-			if err != nil {
-				return models.Folder{}, err
-			}
-			folder.Folders = append(folder.Folders, subFolder)
-		} else {
-			folder.Files = append(folder.Files, models.File{
-				Path:     file.Path,
-				Modified: file.ModTime,
-			})
-		}
+	decoded, err := url.PathUnescape(name)
+	if err != nil {
+		slog.Warn("Failed to decode name, using original", "name", name, "error", err)
+		return name
 	}
-	return folder, nil
+	return decoded
+}
+
+// escapeName escapes name for use in URL-escaped paths
+func escapeName(name string, escaped bool) string {
+	if !escaped {
+		return name
+	}
+	return url.PathEscape(name)
+}
+
+// joinPath joins base path and name
+func joinPath(basePath, name string) string {
+	return strings.TrimSuffix(basePath, "/") + "/" + name
 }
@@ -0,0 +1,361 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/backend/memory"
+	"nextya-sync/clients"
+	"nextya-sync/crypt"
+	"nextya-sync/internal/fakenextcloud"
+	"nextya-sync/internal/fakeyandex"
+)
+
+// syncEnv fake Nextcloud source synchronized to a destination
+type syncEnv struct {
+	nextcloud *fakenextcloud.Server
+	processor *Processor
+	// target target path of runs
+	target string
+	// readFile reads file from destination
+	readFile func(filePath string) ([]byte, error)
+
+	// destination in-memory destination, nil if another one is used
+	destination *memory.Backend
+	// yandex fake Yandex Disk destination, nil if another one is used
+	yandex *fakeyandex.Server
+}
+
+// newEnv creates fake Nextcloud source synchronized to destination
+func newEnv(t *testing.T, destination backend.Backend, target string, readFile func(string) ([]byte, error)) *syncEnv {
+	server := fakenextcloud.New("admin", "secret", t.TempDir())
+	t.Cleanup(server.Close)
+
+	return &syncEnv{
+		nextcloud: server,
+		processor: NewProcessor(&Dependencies{
+			Source:      clients.NewNextcloudClient(server.URL, "admin", "secret"),
+			Destination: destination,
+		}),
+		target:   target,
+		readFile: readFile,
+	}
+}
+
+// newSyncEnv synchronizes to in-memory destination folder /backup
+func newSyncEnv(t *testing.T) *syncEnv {
+	destination := memory.New()
+	e := newEnv(t, destination, "/backup", func(filePath string) ([]byte, error) {
+		data, ok := destination.ReadFile(filePath)
+		if !ok {
+			return nil, backend.ErrNotFound
+		}
+		return data, nil
+	})
+	e.destination = destination
+	return e
+}
+
+// newPipeline synchronizes over HTTP to fake Yandex Disk folder /backup
+func newPipeline(t *testing.T) *syncEnv {
+	yandex := fakeyandex.New("token", t.TempDir())
+	t.Cleanup(yandex.Close)
+
+	yandexClient := clients.NewYandexDiskClient("token")
+	yandexClient.SetAPIURL(yandex.APIURL())
+
+	e := newEnv(t, yandexClient, "disk:/backup", yandex.ReadFile)
+	e.yandex = yandex
+	return e
+}
+
+// write stores file in Nextcloud
+func (e *syncEnv) write(t *testing.T, filePath, content string, modTime time.Time) {
+	t.Helper()
+	if err := e.nextcloud.WriteFile(filePath, []byte(content), modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// run synchronizes to target path, sync paths default to /Documents
+func (e *syncEnv) run(t *testing.T, cfg Config) {
+	t.Helper()
+	if cfg.TargetPath == "" {
+		cfg.TargetPath = e.target
+	}
+	if cfg.SyncPaths == nil {
+		cfg.SyncPaths = []string{"/Documents"}
+	}
+	if err := e.processor.Main(context.Background(), cfg); err != nil {
+		t.Fatalf("Main: %v", err)
+	}
+}
+
+// sync synchronizes sync paths to target path
+func (e *syncEnv) sync(t *testing.T, syncPaths ...string) {
+	t.Helper()
+	e.run(t, Config{SyncPaths: syncPaths})
+}
+
+// requireFile checks content of destination file
+func (e *syncEnv) requireFile(t *testing.T, filePath, content string) {
+	t.Helper()
+	data, err := e.readFile(filePath)
+	if err != nil {
+		t.Errorf("%s is missing in destination: %v", filePath, err)
+	} else if string(data) != content {
+		t.Errorf("%s = %q, want %q", filePath, data, content)
+	}
+}
+
+func TestMainSyncsTree(t *testing.T) {
+	e := newSyncEnv(t)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e.write(t, "/Documents/readme.txt", "readme", modTime)
+	e.write(t, "/Documents/Reports 2024/q1 (draft) #1.txt", "q1", modTime)
+	e.write(t, "/Documents/Reports 2024/100% done+more.txt", "done", modTime)
+	e.write(t, "/Documents/Фото/снимок.jpg", "photo", modTime)
+
+	e.sync(t, "/Documents")
+
+	e.requireFile(t, "/backup/readme.txt", "readme")
+	e.requireFile(t, "/backup/Reports 2024/q1 (draft) #1.txt", "q1")
+	e.requireFile(t, "/backup/Reports 2024/100% done+more.txt", "done")
+	e.requireFile(t, "/backup/Фото/снимок.jpg", "photo")
+
+	info, err := e.destination.GetFileInfo(context.Background(), "/backup/readme.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime.Equal(modTime) {
+		t.Errorf("destination ModTime = %v, want source time %v", info.ModTime, modTime)
+	}
+}
+
+func TestMainSkipsUnchangedFiles(t *testing.T) {
+	e := newSyncEnv(t)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e.write(t, "/Documents/a.txt", "a", modTime)
+	e.write(t, "/Documents/with space/b.txt", "b", modTime)
+
+	e.sync(t, "/Documents")
+	if got := e.destination.Calls(memory.OpUpload); got != 2 {
+		t.Fatalf("first run uploaded %d files, want 2", got)
+	}
+	gets := e.nextcloud.Requests("GET")
+
+	e.sync(t, "/Documents")
+	if got := e.destination.Calls(memory.OpUpload); got != 2 {
+		t.Errorf("second run uploaded %d files, want none", got-2)
+	}
+	if got := e.nextcloud.Requests("GET"); got != gets {
+		t.Errorf("second run downloaded %d files, want none", got-gets)
+	}
+}
+
+func TestMainUpdatesModifiedFiles(t *testing.T) {
+	e := newSyncEnv(t)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e.write(t, "/Documents/a.txt", "a", modTime)
+	e.write(t, "/Documents/b.txt", "b", modTime)
+	e.sync(t, "/Documents")
+
+	e.write(t, "/Documents/b.txt", "b changed", modTime.Add(time.Hour))
+	e.write(t, "/Documents/c.txt", "c", modTime)
+	e.sync(t, "/Documents")
+
+	if got := e.destination.Calls(memory.OpUpload); got != 4 {
+		t.Errorf("uploaded %d files in total, want 4", got)
+	}
+	e.requireFile(t, "/backup/a.txt", "a")
+	e.requireFile(t, "/backup/b.txt", "b changed")
+	e.requireFile(t, "/backup/c.txt", "c")
+}
+
+func TestMainMultipleSyncPaths(t *testing.T) {
+	e := newSyncEnv(t)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e.write(t, "/Documents/a.txt", "a", modTime)
+	e.write(t, "/Photos/p.jpg", "p", modTime)
+
+	cfg := Config{TargetPath: "/backup", SyncPaths: []string{"/Documents", "/Photos", "/Missing"}}
+	if err := e.processor.Main(context.Background(), cfg); !errors.Is(err, ErrPartial) {
+		t.Fatalf("Main error = %v, want partial failure", err)
+	}
+
+	e.requireFile(t, "/backup/Documents/a.txt", "a")
+	e.requireFile(t, "/backup/Photos/p.jpg", "p")
+}
+
+func TestMainRetriesFailedUploads(t *testing.T) {
+	e := newSyncEnv(t)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e.write(t, "/Documents/a.txt", "a", modTime)
+	e.write(t, "/Documents/b.txt", "b", modTime)
+
+	e.destination.FailTimes(memory.OpUpload, "/backup/b.txt", errors.New("disk full"), 1)
+	cfg := Config{TargetPath: "/backup", SyncPaths: []string{"/Documents"}}
+	if err := e.processor.Main(context.Background(), cfg); !errors.Is(err, ErrPartial) {
+		t.Fatalf("Main error = %v, want partial failure", err)
+	}
+	e.requireFile(t, "/backup/a.txt", "a")
+	if _, ok := e.destination.ReadFile("/backup/b.txt"); ok {
+		t.Fatalf("failed upload left file in destination")
+	}
+
+	e.sync(t, "/Documents")
+	e.requireFile(t, "/backup/b.txt", "b")
+}
+
+func TestMainBranchesOnErrorKinds(t *testing.T) {
+	e := newSyncEnv(t)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e.write(t, "/Documents/a.txt", "a", modTime)
+	e.write(t, "/Photos/p.jpg", "p", modTime)
+	cfg := Config{TargetPath: "/backup", SyncPaths: []string{"/Documents", "/Photos"}}
+
+	// Unreadable target is not mistaken for missing one
+	e.destination.FailTimes(memory.OpList, "/backup", fmt.Errorf("status 503: %w", backend.ErrServer), 1)
+	if err := e.processor.Main(context.Background(), cfg); !errors.Is(err, backend.ErrServer) {
+		t.Fatalf("Main error = %v, want server error", err)
+	}
+	if len(e.destination.Paths()) != 0 {
+		t.Fatalf("destination changed after failed read: %q", e.destination.Paths())
+	}
+
+	// Rejected credentials abort the run instead of failing every path
+	e.destination.FailTimes(memory.OpList, "/backup/Documents", fmt.Errorf("status 401: %w", backend.ErrUnauthorized), 1)
+	if err := e.processor.Main(context.Background(), cfg); !errors.Is(err, backend.ErrUnauthorized) {
+		t.Fatalf("Main error = %v, want unauthorized", err)
+	}
+	if _, ok := e.destination.ReadFile("/backup/Photos/p.jpg"); ok {
+		t.Errorf("run continued after authentication failure")
+	}
+
+	e.sync(t, "/Documents", "/Photos")
+	e.requireFile(t, "/backup/Photos/p.jpg", "p")
+}
+
+func TestRestoreToNextcloud(t *testing.T) {
+	e := newSyncEnv(t)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e.destination.WriteFile("/backup/notes & ideas.txt", []byte("notes"), modTime)
+	e.destination.WriteFile("/backup/Sub folder/100% #1.txt", []byte("one"), modTime)
+
+	err := e.processor.Restore(context.Background(), Config{TargetPath: "/backup", SyncPaths: []string{"/Restored"}})
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	for filePath, want := range map[string]string{
+		"/Restored/notes & ideas.txt":      "notes",
+		"/Restored/Sub folder/100% #1.txt": "one",
+	} {
+		data, err := e.nextcloud.ReadFile(filePath)
+		if err != nil {
+			t.Errorf("%s is missing in Nextcloud: %v", filePath, err)
+		} else if string(data) != want {
+			t.Errorf("%s = %q, want %q", filePath, data, want)
+		}
+	}
+}
+
+// recordingNotifier remembers results of runs
+type recordingNotifier struct {
+	stats []SyncStats
+	errs  []error
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, stats SyncStats, err error) {
+	n.stats = append(n.stats, stats)
+	n.errs = append(n.errs, err)
+}
+
+func TestMainNotifies(t *testing.T) {
+	e := newSyncEnv(t)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e.write(t, "/Documents/a.txt", "a", modTime)
+	e.write(t, "/Documents/b.txt", "b", modTime)
+	e.destination.FailTimes(memory.OpUpload, "/backup/b.txt", errors.New("disk full"), 1)
+
+	notifier := &recordingNotifier{}
+	cfg := Config{TargetPath: "/backup", SyncPaths: []string{"/Documents"}, Notifier: notifier}
+	if err := e.processor.Main(context.Background(), cfg); !errors.Is(err, ErrPartial) {
+		t.Fatalf("Main error = %v, want partial failure", err)
+	}
+	if len(notifier.stats) != 1 {
+		t.Fatalf("notified %d times, want once", len(notifier.stats))
+	}
+	stats := notifier.stats[0]
+	if stats.UploadedFiles != 1 || stats.ErrorFiles != 1 || stats.Duration <= 0 || !errors.Is(notifier.errs[0], ErrPartial) {
+		t.Errorf("notified stats = %+v, error %v", stats, notifier.errs[0])
+	}
+
+	// Runs failing before synchronization are reported too
+	cfg.SyncPaths = nil
+	if err := e.processor.Main(context.Background(), cfg); err == nil {
+		t.Fatal("Main without sync paths succeeded")
+	}
+	if len(notifier.errs) != 2 || notifier.errs[1] == nil {
+		t.Errorf("failed run is not notified: %v", notifier.errs)
+	}
+}
+
+func TestMainEncrypted(t *testing.T) {
+	destination := memory.New()
+	cipher, err := crypt.NewCipher(bytes.Repeat([]byte{7}, 32), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newEnv(t, crypt.NewClient(destination, cipher, "/backup"), "/backup", nil)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e.write(t, "/Documents/secret.txt", "top secret", modTime)
+	e.write(t, "/Documents/Payroll/salaries 2024.csv", "alice,100", modTime)
+
+	e.sync(t, "/Documents")
+	if got := destination.Calls(memory.OpUpload); got != 2 {
+		t.Fatalf("first run uploaded %d files, want 2", got)
+	}
+
+	// Stored names and content are opaque
+	paths := destination.Paths()
+	if len(paths) != 4 {
+		t.Errorf("destination paths = %q, want target, two files and a folder", paths)
+	}
+	for _, filePath := range paths {
+		if strings.Contains(filePath, "secret") || strings.Contains(filePath, "Payroll") || strings.Contains(filePath, "salaries") {
+			t.Errorf("plaintext name is stored: %s", filePath)
+		}
+		if data, ok := destination.ReadFile(filePath); ok && (strings.Contains(string(data), "top secret") || strings.Contains(string(data), "alice")) {
+			t.Errorf("plaintext content is stored in %s", filePath)
+		}
+	}
+
+	// Decrypted names and sizes match the source, nothing is uploaded again
+	e.sync(t, "/Documents")
+	if got := destination.Calls(memory.OpUpload); got != 2 {
+		t.Errorf("second run uploaded %d files, want none", got-2)
+	}
+
+	err = e.processor.Restore(context.Background(), Config{TargetPath: "/backup", SyncPaths: []string{"/Restored"}})
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	for filePath, want := range map[string]string{
+		"/Restored/secret.txt":                "top secret",
+		"/Restored/Payroll/salaries 2024.csv": "alice,100",
+	} {
+		data, err := e.nextcloud.ReadFile(filePath)
+		if err != nil {
+			t.Errorf("%s is missing in Nextcloud: %v", filePath, err)
+		} else if string(data) != want {
+			t.Errorf("%s = %q, want %q", filePath, data, want)
+		}
+	}
+}
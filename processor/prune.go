@@ -0,0 +1,253 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/models"
+)
+
+// RetentionPolicy describes which backup versions are kept, zero disables a rule
+type RetentionPolicy struct {
+	// KeepLast keeps the newest versions
+	KeepLast int
+	// KeepDaily keeps the newest version of each day for the given number of days
+	KeepDaily int
+	// KeepWeekly keeps the newest version of each week for the given number of weeks
+	KeepWeekly int
+	// KeepMonthly keeps the newest version of each month for the given number of months
+	KeepMonthly int
+}
+
+// empty reports whether no rule is set
+func (policy RetentionPolicy) empty() bool {
+	return policy.KeepLast == 0 && policy.KeepDaily == 0 && policy.KeepWeekly == 0 && policy.KeepMonthly == 0
+}
+
+// PruneConfig holds configuration of backup versions pruning
+type PruneConfig struct {
+	// BackupDir backup directory template, versions are its dated folders
+	BackupDir string
+	Policy    RetentionPolicy
+	// MinFreeSpace prunes oldest versions until free space is at least that many bytes
+	MinFreeSpace int64
+	// Permanently deletes versions bypassing trash, always done in quota-aware mode
+	Permanently bool
+	// DryRun only reports what would be deleted
+	DryRun bool
+}
+
+// backupVersion single dated folder of backup directory
+type backupVersion struct {
+	Path string
+	Time time.Time
+	Size int64
+}
+
+// Prune applies retention policy to dated version folders of backup directory
+func (p *Processor) Prune(ctx context.Context, cfg PruneConfig) error {
+	del, ok := p.destination.(backend.Deleter)
+	if !ok {
+		return fmt.Errorf("destination client doesn't support deleting")
+	}
+
+	root, layout, err := versionLayout(cfg.BackupDir)
+	if err != nil {
+		return err
+	}
+
+	versions, err := p.listVersions(ctx, root, layout)
+	if err != nil {
+		return err
+	}
+	slog.Info("Found backup versions", "path", root, "versions", len(versions))
+
+	now := time.Now()
+	var kept []backupVersion
+	if cfg.Policy.empty() {
+		kept = versions
+	} else {
+		keep := cfg.Policy.keep(versions, now)
+		for _, version := range versions {
+			if keep[version.Path] {
+				kept = append(kept, version)
+				continue
+			}
+			if err := p.deleteVersion(ctx, del, version, cfg.Permanently, cfg.DryRun, "retention policy"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if cfg.MinFreeSpace > 0 {
+		return p.pruneForQuota(ctx, del, kept, cfg)
+	}
+	return nil
+}
+
+// pruneForQuota deletes oldest versions until free space reaches the threshold, the newest version is never deleted
+func (p *Processor) pruneForQuota(ctx context.Context, del backend.Deleter, versions []backupVersion, cfg PruneConfig) error {
+	quotaClient, ok := p.destination.(backend.Quota)
+	if !ok {
+		return fmt.Errorf("destination client doesn't report quota")
+	}
+
+	quota, err := quotaClient.GetQuota(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get quota: %w", err)
+	}
+
+	free := quota.Free()
+	slog.Info("Checking free space", "free", free, "required", cfg.MinFreeSpace)
+
+	// Versions are sorted newest first
+	for i := len(versions) - 1; i > 0 && free < cfg.MinFreeSpace; i-- {
+		version := versions[i]
+		if err := p.fillVersionSize(ctx, &version); err != nil {
+			return err
+		}
+		if err := p.deleteVersion(ctx, del, version, true, cfg.DryRun, "low free space"); err != nil {
+			return err
+		}
+		free += version.Size
+	}
+
+	if free < cfg.MinFreeSpace {
+		slog.Warn("Free space is still below required after pruning", "free", free, "required", cfg.MinFreeSpace)
+	}
+	return nil
+}
+
+// deleteVersion deletes version folder or reports it in dry-run mode
+func (p *Processor) deleteVersion(ctx context.Context, del backend.Deleter, version backupVersion, permanently, dryRun bool, reason string) error {
+	if dryRun {
+		slog.Info("Would delete backup version", "path", version.Path, "reason", reason, "action", "delete", "dry_run", true)
+		return nil
+	}
+
+	slog.Info("Deleting backup version", "path", version.Path, "reason", reason, "action", "delete")
+	if err := del.Delete(ctx, version.Path, permanently); err != nil {
+		return fmt.Errorf("failed to delete backup version %s: %w", version.Path, err)
+	}
+	return nil
+}
+
+// listVersions lists dated version folders sorted newest first
+func (p *Processor) listVersions(ctx context.Context, root, layout string) ([]backupVersion, error) {
+	files, err := p.destination.ListFiles(ctx, root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backup directory %s: %w", root, err)
+	}
+
+	var versions []backupVersion
+	for _, file := range files {
+		if !file.IsDir {
+			continue
+		}
+		t, err := time.ParseInLocation(layout, file.Name, time.Local)
+		if err != nil {
+			slog.Warn("Skipping folder which doesn't match backup directory layout", "path", file.Path)
+			continue
+		}
+		versions = append(versions, backupVersion{Path: file.Path, Time: t})
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Time.After(versions[j].Time)
+	})
+	return versions, nil
+}
+
+// fillVersionSize calculates total size of files in version folder
+func (p *Processor) fillVersionSize(ctx context.Context, version *backupVersion) error {
+	folder, err := p.getFileSystem(ctx, p.destination, version.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read backup version %s: %w", version.Path, err)
+	}
+	version.Size = folderSize(folder)
+	return nil
+}
+
+// keep returns paths of versions kept by policy, versions must be sorted newest first.
+// Periodic rules count calendar days, ISO weeks and months including the current one.
+// The newest version is always kept.
+func (policy RetentionPolicy) keep(versions []backupVersion, now time.Time) map[string]bool {
+	keep := make(map[string]bool)
+	if len(versions) > 0 {
+		keep[versions[0].Path] = true
+	}
+	for i := 0; i < policy.KeepLast && i < len(versions); i++ {
+		keep[versions[i].Path] = true
+	}
+
+	keepPeriodic := func(since time.Time, bucket func(time.Time) string) {
+		seen := make(map[string]bool)
+		for _, version := range versions {
+			if version.Time.Before(since) {
+				break
+			}
+			key := bucket(version.Time)
+			if !seen[key] {
+				seen[key] = true
+				keep[version.Path] = true
+			}
+		}
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if policy.KeepDaily > 0 {
+		keepPeriodic(today.AddDate(0, 0, 1-policy.KeepDaily), func(t time.Time) string {
+			return t.Format("2006-01-02")
+		})
+	}
+	if policy.KeepWeekly > 0 {
+		monday := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+		keepPeriodic(monday.AddDate(0, 0, 7*(1-policy.KeepWeekly)), func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%02d", year, week)
+		})
+	}
+	if policy.KeepMonthly > 0 {
+		keepPeriodic(today.AddDate(0, 1-policy.KeepMonthly, 1-today.Day()), func(t time.Time) string {
+			return t.Format("2006-01")
+		})
+	}
+
+	return keep
+}
+
+// versionLayout returns folder holding versions and time layout of version folder names
+func versionLayout(backupDir string) (string, string, error) {
+	if backupDir == "" {
+		return "", "", fmt.Errorf("backup directory is not set")
+	}
+
+	root := BackupRoot(backupDir)
+	name := strings.TrimPrefix(strings.TrimPrefix(backupDir, root), "/")
+	if name == "" || strings.Contains(name, "/") || !strings.Contains(name, "{") {
+		return "", "", fmt.Errorf("backup directory %s must end with a dated folder like {date}", backupDir)
+	}
+
+	layout := strings.NewReplacer(
+		"{date}", "2006-01-02",
+		"{time}", "150405",
+	).Replace(name)
+	return root, layout, nil
+}
+
+// folderSize returns total size of files in folder recursively
+func folderSize(folder models.Folder) int64 {
+	var size int64
+	for _, file := range folder.Files {
+		size += file.Size
+	}
+	for _, sub := range folder.Folders {
+		size += folderSize(sub)
+	}
+	return size
+}
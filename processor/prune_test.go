@@ -0,0 +1,212 @@
+package processor
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"nextya-sync/backend/memory"
+	"nextya-sync/models"
+)
+
+// versionsAt returns versions named by their times, times must be sorted newest first
+func versionsAt(times ...string) []backupVersion {
+	var versions []backupVersion
+	for _, value := range times {
+		t, err := time.Parse("2006-01-02 15:04", value)
+		if err != nil {
+			panic(err)
+		}
+		versions = append(versions, backupVersion{Path: value, Time: t})
+	}
+	return versions
+}
+
+func TestRetentionPolicyKeep(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   RetentionPolicy
+		now      string
+		versions []backupVersion
+		want     []string
+	}{
+		{
+			name:     "keep last",
+			policy:   RetentionPolicy{KeepLast: 2},
+			now:      "2024-03-10 12:00",
+			versions: versionsAt("2024-03-10 09:00", "2024-03-09 09:00", "2024-03-08 09:00", "2024-03-07 09:00"),
+			want:     []string{"2024-03-10 09:00", "2024-03-09 09:00"},
+		},
+		{
+			name:     "keep last more than stored",
+			policy:   RetentionPolicy{KeepLast: 5},
+			now:      "2024-03-10 12:00",
+			versions: versionsAt("2024-03-10 09:00", "2024-03-09 09:00"),
+			want:     []string{"2024-03-10 09:00", "2024-03-09 09:00"},
+		},
+		{
+			name:   "daily counts calendar days including today",
+			policy: RetentionPolicy{KeepDaily: 3},
+			now:    "2024-03-10 12:00",
+			versions: versionsAt("2024-03-10 09:00", "2024-03-10 08:00", "2024-03-09 23:59",
+				"2024-03-08 00:00", "2024-03-07 23:59"),
+			want: []string{"2024-03-10 09:00", "2024-03-09 23:59", "2024-03-08 00:00"},
+		},
+		{
+			name:   "weekly across year boundary",
+			policy: RetentionPolicy{KeepWeekly: 2},
+			// Wednesday of 2021-W01, Sunday 2021-01-03 belongs to 2020-W53
+			now: "2021-01-06 12:00",
+			versions: versionsAt("2021-01-05 10:00", "2021-01-04 00:00", "2021-01-03 23:00",
+				"2020-12-28 00:00", "2020-12-27 23:00"),
+			want: []string{"2021-01-05 10:00", "2021-01-03 23:00"},
+		},
+		{
+			name:   "ISO week starting in previous year",
+			policy: RetentionPolicy{KeepWeekly: 1},
+			// Monday 2024-12-30 starts 2025-W01
+			now:      "2025-01-02 12:00",
+			versions: versionsAt("2025-01-01 10:00", "2024-12-30 00:00", "2024-12-29 23:00"),
+			want:     []string{"2025-01-01 10:00"},
+		},
+		{
+			name:     "monthly",
+			policy:   RetentionPolicy{KeepMonthly: 2},
+			now:      "2024-03-31 12:00",
+			versions: versionsAt("2024-03-01 00:00", "2024-02-29 23:00", "2024-02-01 00:00", "2024-01-31 23:00"),
+			want:     []string{"2024-03-01 00:00", "2024-02-29 23:00"},
+		},
+		{
+			name:     "combined rules",
+			policy:   RetentionPolicy{KeepLast: 1, KeepDaily: 2, KeepMonthly: 3},
+			now:      "2024-03-10 12:00",
+			versions: versionsAt("2024-03-10 09:00", "2024-03-09 09:00", "2024-03-05 09:00", "2024-02-15 09:00", "2024-02-01 09:00", "2023-12-31 09:00"),
+			want:     []string{"2024-03-10 09:00", "2024-03-09 09:00", "2024-02-15 09:00"},
+		},
+		{
+			name:     "newest version is kept when all are out of range",
+			policy:   RetentionPolicy{KeepDaily: 1, KeepWeekly: 1},
+			now:      "2024-03-10 12:00",
+			versions: versionsAt("2024-01-01 09:00", "2023-12-01 09:00"),
+			want:     []string{"2024-01-01 09:00"},
+		},
+		{
+			name:   "no versions",
+			policy: RetentionPolicy{KeepLast: 1},
+			now:    "2024-03-10 12:00",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now, err := time.Parse("2006-01-02 15:04", tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keep := tt.policy.keep(tt.versions, now)
+			var got []string
+			for _, version := range tt.versions {
+				if keep[version.Path] {
+					got = append(got, version.Path)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("kept %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// quotaBackend in-memory destination reporting quota of fixed total size
+type quotaBackend struct {
+	*memory.Backend
+	total int64
+}
+
+func (b quotaBackend) GetQuota(ctx context.Context) (*models.Quota, error) {
+	var used int64
+	for _, filePath := range b.Paths() {
+		data, _ := b.ReadFile(filePath)
+		used += int64(len(data))
+	}
+	return &models.Quota{Total: b.total, Used: used}, nil
+}
+
+// newPruneEnv stores backup versions of 10 bytes each in /versions of destination with 100 bytes of space,
+// one per given number of days ago. Returns path of file in version of given day.
+func newPruneEnv(days ...int) (*memory.Backend, *Processor, func(day int) string) {
+	destination := memory.New()
+	now := time.Now()
+	version := func(day int) string {
+		return "/versions/" + now.AddDate(0, 0, -day).Format("2006-01-02") + "/file.txt"
+	}
+	for _, day := range days {
+		destination.WriteFile(version(day), []byte("0123456789"), now)
+	}
+	return destination, NewProcessor(&Dependencies{Destination: quotaBackend{Backend: destination, total: 100}}), version
+}
+
+func TestPruneDryRun(t *testing.T) {
+	destination, p, _ := newPruneEnv(0, 1, 2, 40, 400)
+	before := destination.Paths()
+
+	err := p.Prune(context.Background(), PruneConfig{
+		BackupDir:    "/versions/{date}",
+		Policy:       RetentionPolicy{KeepLast: 1},
+		MinFreeSpace: 1000,
+		DryRun:       true,
+	})
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if calls := destination.Calls(memory.OpDelete); calls != 0 {
+		t.Errorf("dry run made %d deletions", calls)
+	}
+	if after := destination.Paths(); !slices.Equal(after, before) {
+		t.Errorf("dry run changed destination: %q, was %q", after, before)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	destination, p, version := newPruneEnv(0, 1, 2, 40, 400)
+
+	err := p.Prune(context.Background(), PruneConfig{
+		BackupDir: "/versions/{date}",
+		Policy:    RetentionPolicy{KeepDaily: 7},
+	})
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	for day, want := range map[int]bool{0: true, 1: true, 2: true, 40: false, 400: false} {
+		if _, ok := destination.ReadFile(version(day)); ok != want {
+			t.Errorf("version of %d days ago kept = %v, want %v", day, ok, want)
+		}
+	}
+}
+
+func TestPruneForQuota(t *testing.T) {
+	destination, p, version := newPruneEnv(0, 1, 2)
+
+	// 30 of 100 bytes are used, deleting the oldest version leaves 80 bytes free
+	cfg := PruneConfig{BackupDir: "/versions/{date}", MinFreeSpace: 80}
+	if err := p.Prune(context.Background(), cfg); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	for day, want := range map[int]bool{0: true, 1: true, 2: false} {
+		if _, ok := destination.ReadFile(version(day)); ok != want {
+			t.Errorf("version of %d days ago kept = %v, want %v", day, ok, want)
+		}
+	}
+
+	// Newest version is kept even if free space stays below required
+	cfg.MinFreeSpace = 1000
+	if err := p.Prune(context.Background(), cfg); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if _, ok := destination.ReadFile(version(0)); !ok {
+		t.Error("newest version was deleted")
+	}
+	if _, ok := destination.ReadFile(version(1)); ok {
+		t.Error("older version was kept while free space is low")
+	}
+}
@@ -0,0 +1,131 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"nextya-sync/backend"
+	"nextya-sync/models"
+)
+
+// QuotaConfig configuration of the check of destination space before transferring files
+type QuotaConfig struct {
+	// Check compares size of planned uploads with free space of destination and limits uploaded file size
+	Check bool
+	// WarnPercent warns when free space left after the run drops below this percent of total space, 0 disables it
+	WarnPercent float64
+}
+
+// getQuota returns quota of destination, nil if destination doesn't report it
+func (p *Processor) getQuota(ctx context.Context) (*models.Quota, error) {
+	quotaClient, ok := p.destination.(backend.Quota)
+	if !ok {
+		slog.Debug("Destination doesn't report quota, skipping free space check")
+		return nil, nil
+	}
+
+	quota, err := quotaClient.GetQuota(ctx)
+	if err != nil {
+		if abortsRun(err) {
+			return nil, fmt.Errorf("failed to get quota: %w", err)
+		}
+		slog.Warn("Failed to get quota, skipping free space check", "error", err)
+		return nil, nil
+	}
+	return quota, nil
+}
+
+// checkQuota fails with backend.ErrQuotaExceeded if planned uploads of files and archives don't fit into
+// free space of destination, returns maximum size of uploaded file, 0 if unlimited
+func (p *Processor) checkQuota(ctx context.Context, cfg QuotaConfig, targets []syncTarget) (int64, error) {
+	quota, err := p.getQuota(ctx)
+	if err != nil || quota == nil {
+		return 0, err
+	}
+
+	var required int64
+	for _, target := range targets {
+		required += p.plannedBytes(ctx, target.transfer, target.src, target.dst, quota.MaxFileSize)
+	}
+	free := quota.Free()
+	slog.Info("Checking free space", "free", free, "required", required)
+
+	if required > free {
+		return 0, fmt.Errorf("not enough free space in destination: %d bytes required, %d free: %w",
+			required, free, backend.ErrQuotaExceeded)
+	}
+	if cfg.WarnPercent > 0 && quota.Total > 0 {
+		left := float64(free-required) / float64(quota.Total) * 100
+		if left < cfg.WarnPercent {
+			slog.Warn("Free space in destination is running low", "free", free-required, "total", quota.Total,
+				"percent", fmt.Sprintf("%.1f", left))
+		}
+	}
+	return quota.MaxFileSize, nil
+}
+
+// checkSpace fails with backend.ErrQuotaExceeded if required bytes don't fit into free space of destination.
+// Versions and trashbin copies are checked this way right before they are uploaded, once their listings are read.
+func (p *Processor) checkSpace(ctx context.Context, what string, required int64) error {
+	if required == 0 {
+		return nil
+	}
+	quota, err := p.getQuota(ctx)
+	if err != nil || quota == nil {
+		return err
+	}
+
+	free := quota.Free()
+	slog.Info("Checking free space", "for", what, "free", free, "required", required)
+	if required > free {
+		return fmt.Errorf("not enough free space in destination for %s: %d bytes required, %d free: %w",
+			what, required, free, backend.ErrQuotaExceeded)
+	}
+	return nil
+}
+
+// plannedBytes estimates size of files uploaded by synchronization of source folder to destination folder.
+// Archives to rebuild count with all their members, files over maxFileSize are not counted. Changed files
+// count in full since their previous versions may be kept. Nextcloud versions and trashbin copies are
+// checked separately with checkSpace, they are known only once files are synced.
+func (p *Processor) plannedBytes(ctx context.Context, t transfer, srcFolder, dstFolder models.Folder, maxFileSize int64) int64 {
+	dstFiles := make(map[string]models.File)
+	for _, file := range dstFolder.Files {
+		name, file := uncompressedView(baseName(file.Path, t.dstEscaped), file)
+		dstFiles[name] = file
+	}
+	dstFolders := make(map[string]models.Folder)
+	for _, folder := range dstFolder.Folders {
+		dstFolders[baseName(folder.Path, t.dstEscaped)] = folder
+	}
+
+	var planned int64
+	srcFiles := srcFolder.Files
+	if t.archive != nil {
+		var small []models.File
+		small, srcFiles = t.archive.splitSmallFiles(srcFiles)
+		planned += p.plannedArchiveBytes(ctx, t, small, dstFiles)
+	}
+
+	for _, srcFile := range srcFiles {
+		fileName, srcFile := uncompressedView(baseName(srcFile.Path, t.srcEscaped), srcFile)
+		if exceedsMaxSize(srcFile, maxFileSize) {
+			continue
+		}
+		if dstFile, exists := dstFiles[fileName]; !exists || t.isOutdated(srcFile, dstFile) {
+			planned += srcFile.Size
+		}
+	}
+
+	for _, srcSubFolder := range srcFolder.Folders {
+		dstSubFolder := dstFolders[baseName(srcSubFolder.Path, t.srcEscaped)]
+		planned += p.plannedBytes(ctx, t, srcSubFolder, dstSubFolder, maxFileSize)
+	}
+	return planned
+}
+
+// exceedsMaxSize reports whether file is too big to upload, maxFileSize 0 means unlimited
+func exceedsMaxSize(file models.File, maxFileSize int64) bool {
+	return maxFileSize > 0 && file.Size > maxFileSize
+}
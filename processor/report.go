@@ -0,0 +1,177 @@
+package processor
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Action outcome of a single file in a run
+type Action string
+
+const (
+	ActionUploaded Action = "uploaded"
+	ActionUpdated  Action = "updated"
+	ActionSkipped  Action = "skipped"
+	ActionFiltered Action = "filtered"
+	ActionFailed   Action = "failed"
+	ActionDeleted  Action = "deleted"
+)
+
+// FileResult outcome of a single file
+type FileResult struct {
+	// SyncPath source sync path the file belongs to
+	SyncPath string
+	Path     string
+	// Target destination path, empty if nothing was written
+	Target   string
+	Action   Action
+	Reason   string
+	Size     int64
+	Duration time.Duration
+	Err      error
+}
+
+// PathTotals totals of a single sync path
+type PathTotals struct {
+	SyncPath string `json:"sync_path"`
+	Files    int    `json:"files"`
+	Uploaded int    `json:"uploaded"`
+	Updated  int    `json:"updated"`
+	Skipped  int    `json:"skipped"`
+	Filtered int    `json:"filtered"`
+	Failed   int    `json:"failed"`
+	Deleted  int    `json:"deleted"`
+	// Bytes size of uploaded and updated files
+	Bytes int64 `json:"bytes"`
+}
+
+// add counts file result
+func (t *PathTotals) add(result FileResult) {
+	t.Files++
+	switch result.Action {
+	case ActionUploaded:
+		t.Uploaded++
+		t.Bytes += result.Size
+	case ActionUpdated:
+		t.Updated++
+		t.Bytes += result.Size
+	case ActionSkipped:
+		t.Skipped++
+	case ActionFiltered:
+		t.Filtered++
+	case ActionFailed:
+		t.Failed++
+	case ActionDeleted:
+		t.Deleted++
+	}
+}
+
+// Report per-file outcomes of a run, collected when set in Config
+type Report struct {
+	Started  time.Time
+	Finished time.Time
+	Files    []FileResult
+}
+
+// Totals returns totals per sync path in order of first appearance
+func (r *Report) Totals() []PathTotals {
+	var totals []PathTotals
+	index := make(map[string]int)
+	for _, result := range r.Files {
+		i, ok := index[result.SyncPath]
+		if !ok {
+			i = len(totals)
+			index[result.SyncPath] = i
+			totals = append(totals, PathTotals{SyncPath: result.SyncPath})
+		}
+		totals[i].add(result)
+	}
+	return totals
+}
+
+// Total returns totals of the whole run
+func (r *Report) Total() PathTotals {
+	var total PathTotals
+	for _, result := range r.Files {
+		total.add(result)
+	}
+	return total
+}
+
+// reportFile file result as written to JSON report
+type reportFile struct {
+	SyncPath   string `json:"sync_path"`
+	Path       string `json:"path"`
+	Target     string `json:"target,omitempty"`
+	Action     Action `json:"action"`
+	Reason     string `json:"reason,omitempty"`
+	Size       int64  `json:"size"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// WriteJSON writes report with totals and file results as JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	total := r.Total()
+	out := struct {
+		Started    time.Time    `json:"started"`
+		Finished   time.Time    `json:"finished"`
+		DurationMs int64        `json:"duration_ms"`
+		Total      PathTotals   `json:"total"`
+		SyncPaths  []PathTotals `json:"sync_paths"`
+		Files      []reportFile `json:"files"`
+	}{
+		Started:    r.Started,
+		Finished:   r.Finished,
+		DurationMs: r.Finished.Sub(r.Started).Milliseconds(),
+		Total:      total,
+		SyncPaths:  r.Totals(),
+		Files:      make([]reportFile, 0, len(r.Files)),
+	}
+	for _, result := range r.Files {
+		out.Files = append(out.Files, reportFile{
+			SyncPath:   result.SyncPath,
+			Path:       result.Path,
+			Target:     result.Target,
+			Action:     result.Action,
+			Reason:     result.Reason,
+			Size:       result.Size,
+			DurationMs: result.Duration.Milliseconds(),
+			Error:      errorText(result.Err),
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// WriteCSV writes file results as CSV, one row per file
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"sync_path", "path", "target", "action", "reason", "size", "duration_ms", "error"})
+	for _, result := range r.Files {
+		cw.Write([]string{
+			result.SyncPath,
+			result.Path,
+			result.Target,
+			string(result.Action),
+			result.Reason,
+			strconv.FormatInt(result.Size, 10),
+			strconv.FormatInt(result.Duration.Milliseconds(), 10),
+			errorText(result.Err),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
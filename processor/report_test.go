@@ -0,0 +1,118 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"nextya-sync/backend/memory"
+)
+
+// results maps file paths of report to their actions
+func results(report *Report) map[string]Action {
+	actions := make(map[string]Action)
+	for _, result := range report.Files {
+		actions[result.Path] = result.Action
+	}
+	return actions
+}
+
+func TestReportFileResults(t *testing.T) {
+	e := newSyncEnv(t)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e.write(t, "/Documents/a.txt", "a", modTime)
+	e.write(t, "/Documents/b.txt", "b", modTime)
+	e.write(t, "/Photos/p.jpg", "photo", modTime)
+	e.sync(t, "/Documents", "/Photos")
+
+	e.write(t, "/Documents/b.txt", "b changed", modTime.Add(time.Hour))
+	e.write(t, "/Documents/c.txt", "c", modTime)
+	e.destination.FailTimes(memory.OpUpload, "/backup/Photos/p.jpg", errors.New("disk full"), 1)
+	e.write(t, "/Photos/p.jpg", "photo v2", modTime.Add(time.Hour))
+
+	report := &Report{}
+	cfg := Config{TargetPath: "/backup", SyncPaths: []string{"/Documents", "/Photos"}, Report: report}
+	if err := e.processor.Main(context.Background(), cfg); !errors.Is(err, ErrPartial) {
+		t.Fatalf("Main error = %v, want partial failure", err)
+	}
+
+	want := map[string]Action{
+		"/Documents/a.txt": ActionSkipped,
+		"/Documents/b.txt": ActionUpdated,
+		"/Documents/c.txt": ActionUploaded,
+		"/Photos/p.jpg":    ActionFailed,
+	}
+	got := results(report)
+	for filePath, action := range want {
+		if got[filePath] != action {
+			t.Errorf("%s action = %q, want %q", filePath, got[filePath], action)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("report has %d files, want %d: %v", len(got), len(want), got)
+	}
+
+	totals := report.Totals()
+	if len(totals) != 2 {
+		t.Fatalf("got totals of %d sync paths, want 2", len(totals))
+	}
+	documents := PathTotals{SyncPath: "/Documents", Files: 3, Uploaded: 1, Updated: 1, Skipped: 1, Bytes: int64(len("b changed") + len("c"))}
+	if totals[0] != documents {
+		t.Errorf("totals of /Documents = %+v, want %+v", totals[0], documents)
+	}
+	if totals[1].SyncPath != "/Photos" || totals[1].Failed != 1 {
+		t.Errorf("totals of /Photos = %+v, want one failure", totals[1])
+	}
+	if report.Started.IsZero() || report.Finished.Before(report.Started) {
+		t.Errorf("report times are not set: %v - %v", report.Started, report.Finished)
+	}
+}
+
+func TestReportWrite(t *testing.T) {
+	report := &Report{
+		Started:  time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Finished: time.Date(2024, 1, 2, 3, 4, 7, 0, time.UTC),
+		Files: []FileResult{
+			{SyncPath: "/docs", Path: "/docs/a.txt", Target: "/backup/a.txt", Action: ActionUploaded, Size: 10, Duration: 1500 * time.Millisecond},
+			{SyncPath: "/docs", Path: "/docs/b, c.txt", Action: ActionFailed, Size: 5, Err: errors.New("status 507")},
+		},
+	}
+
+	var jsonOut bytes.Buffer
+	if err := report.WriteJSON(&jsonOut); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		DurationMs int64 `json:"duration_ms"`
+		Total      PathTotals
+		Files      []reportFile
+	}
+	if err := json.Unmarshal(jsonOut.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON report: %v\n%s", err, jsonOut.String())
+	}
+	if decoded.DurationMs != 2000 || decoded.Total.Files != 2 || decoded.Total.Bytes != 10 {
+		t.Errorf("JSON report summary = %+v", decoded)
+	}
+	if len(decoded.Files) != 2 || decoded.Files[0].DurationMs != 1500 || decoded.Files[1].Error != "status 507" {
+		t.Errorf("JSON report files = %+v", decoded.Files)
+	}
+
+	var csvOut bytes.Buffer
+	if err := report.WriteCSV(&csvOut); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&csvOut).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV report: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("CSV report has %d rows, want header and 2 files", len(rows))
+	}
+	if rows[2][1] != "/docs/b, c.txt" || rows[2][3] != "failed" || rows[2][7] != "status 507" {
+		t.Errorf("CSV row = %q", rows[2])
+	}
+}
@@ -0,0 +1,253 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/models"
+)
+
+// SyncFolders synchronizes only the given source folders, e.g. ones a watcher found changed.
+// Files directly in the folders are compared, subfolders missing in destination are synchronized
+// with all their content and other subfolders are left alone. Versions and trashbin aren't backed up.
+func (p *Processor) SyncFolders(ctx context.Context, cfg Config, folders []string) error {
+	slog.Info("Starting synchronization of changed folders", "folders", len(folders))
+	return p.syncChanged(ctx, cfg, folders, p.readChangedFolder)
+}
+
+// SyncFiles synchronizes only the given source files and folders, e.g. ones reported by webhooks.
+// Folders are synchronized with all their content if they are missing in destination. Files of
+// folders packed into archives are synchronized with the whole folder, since archive is rebuilt from it.
+func (p *Processor) SyncFiles(ctx context.Context, cfg Config, files []string) error {
+	slog.Info("Starting synchronization of changed files", "files", len(files))
+
+	byFolder := make(map[string][]string)
+	var folders []string
+	for _, filePath := range files {
+		folder := path.Dir(strings.TrimSuffix(filePath, "/"))
+		if _, ok := byFolder[folder]; !ok {
+			folders = append(folders, folder)
+		}
+		byFolder[folder] = append(byFolder[folder], filePath)
+	}
+
+	return p.syncChanged(ctx, cfg, folders, func(ctx context.Context, folder, targetPath string, t transfer) (syncTarget, error) {
+		if t.archive != nil {
+			return p.readChangedFolder(ctx, folder, targetPath, t)
+		}
+		return p.readChangedFiles(ctx, folder, targetPath, t, byFolder[folder])
+	})
+}
+
+// readChanged reads source folder and its destination folder limited to changed content
+type readChanged func(ctx context.Context, folder, targetPath string, t transfer) (syncTarget, error)
+
+// syncChanged synchronizes source folders read by read function
+func (p *Processor) syncChanged(ctx context.Context, cfg Config, folders []string, read readChanged) (err error) {
+	start := time.Now()
+	syncStats := newSyncStats(cfg.Report, start)
+	defer func() {
+		syncStats.finish()
+		if cfg.Notifier != nil {
+			cfg.Notifier.Notify(ctx, *syncStats, err)
+		}
+	}()
+
+	t, err := p.syncTransfer(cfg)
+	if err != nil {
+		return err
+	}
+
+	var targets []syncTarget
+	for _, folder := range folders {
+		syncPath, targetPath, ok := cfg.targetFolder(t, folder)
+		if !ok {
+			slog.Debug("Folder is outside of sync paths, skipping", "path", folder)
+			continue
+		}
+		syncStats.syncPath = syncPath
+
+		pathTransfer := t
+		if cfg.Archive.enabled(syncPath) {
+			pathTransfer.archive = &cfg.Archive
+		}
+		target, err := read(ctx, folder, targetPath, pathTransfer)
+		if err != nil {
+			if abortsRun(err) {
+				return err
+			}
+			slog.Warn("Failed to read changed folder", "path", folder, "error", err)
+			syncStats.FailedPaths++
+			continue
+		}
+		target.syncPath = syncPath
+		targets = append(targets, target)
+	}
+
+	var maxFileSize int64
+	if cfg.Quota.Check {
+		if maxFileSize, err = p.checkQuota(ctx, cfg.Quota, targets); err != nil {
+			return err
+		}
+	}
+
+	for _, target := range targets {
+		syncStats.syncPath = target.syncPath
+		if target.create {
+			if err := p.createFolderChain(ctx, p.destination, target.targetPath); err != nil {
+				slog.Warn("Failed to create target folder", "path", target.targetPath, "error", err)
+				syncStats.FailedPaths++
+				continue
+			}
+		}
+
+		pathTransfer := target.transfer
+		pathTransfer.maxFileSize = maxFileSize
+		if err := p.syncFolders(ctx, pathTransfer, target.src, target.dst, target.targetPath, syncStats); err != nil {
+			if abortsRun(err) {
+				return fmt.Errorf("synchronization of %s failed: %w", target.src.Path, err)
+			}
+			slog.Warn("Synchronization failed", "path", target.src.Path, "error", err)
+			syncStats.FailedPaths++
+		}
+	}
+
+	slog.Info("Synchronization of changes completed",
+		"files", syncStats.TotalFiles,
+		"uploaded", syncStats.UploadedFiles,
+		"skipped", syncStats.SkippedFiles,
+		"errors", syncStats.ErrorFiles,
+		"bytes", syncStats.UploadedBytes,
+		"duration", time.Since(start))
+
+	return syncStats.err()
+}
+
+// readTargetFolder reads files directly in destination folder, returns names of its subfolders
+func (p *Processor) readTargetFolder(ctx context.Context, folder, targetPath string, t transfer) (syncTarget, map[string]bool, error) {
+	target := syncTarget{
+		targetPath: targetPath,
+		src:        models.Folder{Path: folder},
+		dst:        models.Folder{Path: targetPath},
+		transfer:   t,
+	}
+
+	dstEntries, err := p.destination.ListFiles(ctx, targetPath)
+	if errors.Is(err, backend.ErrNotFound) {
+		target.create = true
+	} else if err != nil {
+		return syncTarget{}, nil, err
+	}
+	existing := make(map[string]bool)
+	for _, entry := range dstEntries {
+		if entry.IsDir {
+			existing[baseName(entry.Path, t.dstEscaped)] = true
+			target.dst.Folders = append(target.dst.Folders, models.Folder{Path: entry.Path})
+		} else {
+			target.dst.Files = append(target.dst.Files, fileOf(entry))
+		}
+	}
+	return target, existing, nil
+}
+
+// readChangedFolder reads files directly in source folder and its destination folder,
+// subfolders missing in destination are read with all their content
+func (p *Processor) readChangedFolder(ctx context.Context, folder, targetPath string, t transfer) (syncTarget, error) {
+	target, existing, err := p.readTargetFolder(ctx, folder, targetPath, t)
+	if err != nil {
+		return syncTarget{}, err
+	}
+
+	srcEntries, err := p.source.ListFiles(ctx, folder)
+	if err != nil {
+		return syncTarget{}, err
+	}
+	for _, entry := range srcEntries {
+		switch {
+		case !entry.IsDir:
+			target.src.Files = append(target.src.Files, fileOf(entry))
+		case !existing[baseName(entry.Path, t.srcEscaped)]:
+			subFolder, err := p.getFileSystem(ctx, p.source, entry.Path)
+			if err != nil {
+				return syncTarget{}, err
+			}
+			target.src.Folders = append(target.src.Folders, subFolder)
+		}
+	}
+	return target, nil
+}
+
+// readChangedFiles reads given files of source folder and its destination folder, files already
+// gone from source are left out and folders are read with all their content unless they exist in destination
+func (p *Processor) readChangedFiles(ctx context.Context, folder, targetPath string, t transfer, files []string) (syncTarget, error) {
+	target, existing, err := p.readTargetFolder(ctx, folder, targetPath, t)
+	if err != nil {
+		return syncTarget{}, err
+	}
+
+	for _, filePath := range files {
+		info, err := p.source.GetFileInfo(ctx, filePath)
+		if errors.Is(err, backend.ErrNotFound) {
+			slog.Debug("File is gone from source, skipping", "path", filePath)
+			continue
+		}
+		if err != nil {
+			return syncTarget{}, err
+		}
+
+		switch {
+		case !info.IsDir:
+			target.src.Files = append(target.src.Files, fileOf(*info))
+		case !existing[baseName(info.Path, t.srcEscaped)]:
+			subFolder, err := p.getFileSystem(ctx, p.source, info.Path)
+			if err != nil {
+				return syncTarget{}, err
+			}
+			target.src.Folders = append(target.src.Folders, subFolder)
+		}
+	}
+	return target, nil
+}
+
+// targetFolder returns sync path containing source folder and path of the folder in destination
+func (cfg Config) targetFolder(t transfer, folder string) (syncPath, targetPath string, ok bool) {
+	segments := pathSegments(folder)
+	for _, syncPath := range cfg.SyncPaths {
+		root := pathSegments(syncPath)
+		if len(segments) < len(root) {
+			continue
+		}
+		matches := true
+		for i, name := range root {
+			if baseName(segments[i], t.srcEscaped) != name {
+				matches = false
+				break
+			}
+		}
+		if !matches {
+			continue
+		}
+
+		targetPath = cfg.targetPath(syncPath)
+		for _, segment := range segments[len(root):] {
+			targetPath = joinPath(targetPath, escapeName(baseName(segment, t.srcEscaped), t.dstEscaped))
+		}
+		return syncPath, targetPath, true
+	}
+	return "", "", false
+}
+
+// pathSegments returns names of path elements
+func pathSegments(filePath string) []string {
+	filePath = strings.Trim(filePath, "/")
+	if filePath == "" {
+		return nil
+	}
+	return strings.Split(filePath, "/")
+}
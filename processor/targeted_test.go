@@ -0,0 +1,229 @@
+package processor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"nextya-sync/webhook"
+)
+
+func TestSyncFolders(t *testing.T) {
+	e := newSyncEnv(t)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e.write(t, "/Documents/a.txt", "a", modTime)
+	e.write(t, "/Documents/sub dir/b.txt", "b", modTime)
+	e.write(t, "/Documents/other/c.txt", "c", modTime)
+	e.sync(t, "/Documents")
+
+	changed := modTime.Add(time.Hour)
+	e.write(t, "/Documents/sub dir/b.txt", "b v2", changed)
+	e.write(t, "/Documents/sub dir/new/d.txt", "d", changed)
+	e.write(t, "/Documents/other/c.txt", "c v2", changed)
+
+	cfg := Config{TargetPath: "/backup", SyncPaths: []string{"/Documents"}}
+	if err := e.processor.SyncFolders(context.Background(), cfg, []string{"/Documents/sub%20dir/", "/Photos/"}); err != nil {
+		t.Fatalf("SyncFolders: %v", err)
+	}
+
+	e.requireFile(t, "/backup/sub dir/b.txt", "b v2")
+	e.requireFile(t, "/backup/sub dir/new/d.txt", "d")
+	// Folders not reported changed are left alone
+	e.requireFile(t, "/backup/other/c.txt", "c")
+}
+
+func TestTargetFolder(t *testing.T) {
+	cfg := Config{TargetPath: "disk:/backup", SyncPaths: []string{"/Documents", "/My Photos"}}
+	tr := transfer{srcEscaped: true}
+	for folder, want := range map[string]string{
+		"/Documents/":               "disk:/backup/Documents",
+		"/Documents/a%20b/c/":       "disk:/backup/Documents/a b/c",
+		"/My%20Photos/2024":         "disk:/backup/My Photos/2024",
+		"/Documents-old/readme.txt": "",
+	} {
+		_, got, ok := cfg.targetFolder(tr, folder)
+		if !ok {
+			got = ""
+		}
+		if got != want {
+			t.Errorf("targetFolder(%q) = %q, want %q", folder, got, want)
+		}
+	}
+}
+
+func TestSyncFiles(t *testing.T) {
+	e := newSyncEnv(t)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e.write(t, "/Documents/a.txt", "a", modTime)
+	e.write(t, "/Documents/b.txt", "b", modTime)
+	e.sync(t, "/Documents")
+
+	changed := modTime.Add(time.Hour)
+	e.write(t, "/Documents/a.txt", "a v2", changed)
+	e.write(t, "/Documents/b.txt", "b v2", changed)
+	e.write(t, "/Documents/new dir/c.txt", "c", changed)
+
+	cfg := Config{TargetPath: "/backup", SyncPaths: []string{"/Documents"}}
+	files := []string{"/Documents/a.txt", "/Documents/new%20dir", "/Documents/gone.txt"}
+	if err := e.processor.SyncFiles(context.Background(), cfg, files); err != nil {
+		t.Fatalf("SyncFiles: %v", err)
+	}
+
+	e.requireFile(t, "/backup/a.txt", "a v2")
+	e.requireFile(t, "/backup/new dir/c.txt", "c")
+	// Files not reported changed are left alone
+	e.requireFile(t, "/backup/b.txt", "b")
+}
+
+func TestDeleteFiles(t *testing.T) {
+	e := newSyncEnv(t)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e.write(t, "/Documents/a.txt", "a", modTime)
+	e.write(t, "/Documents/b.txt", "b", modTime)
+	e.write(t, "/Documents/sub dir/c.txt", "c", modTime)
+	e.sync(t, "/Documents")
+
+	ctx := context.Background()
+	report := &Report{}
+	cfg := Config{TargetPath: "/backup", SyncPaths: []string{"/Documents"}, Report: report}
+	if err := e.processor.DeleteFiles(ctx, cfg, []string{"/Documents/a.txt", "/Documents/sub%20dir/", "/Documents/missing.txt", "/Documents"}); err != nil {
+		t.Fatalf("DeleteFiles: %v", err)
+	}
+	for _, deleted := range []string{"/backup/a.txt", "/backup/sub dir/c.txt"} {
+		if _, ok := e.destination.ReadFile(deleted); ok {
+			t.Errorf("%s is still in destination", deleted)
+		}
+	}
+	e.requireFile(t, "/backup/b.txt", "b")
+	if total := report.Total(); total.Deleted != 2 || total.Failed != 0 {
+		t.Errorf("report totals = %+v, want 2 deleted", total)
+	}
+
+	// With backup directory deleted files are moved there
+	cfg = Config{TargetPath: "/backup", SyncPaths: []string{"/Documents"}, Backup: BackupConfig{Dir: "/versions"}}
+	if err := e.processor.DeleteFiles(ctx, cfg, []string{"/Documents/b.txt"}); err != nil {
+		t.Fatalf("DeleteFiles with backup: %v", err)
+	}
+	if _, ok := e.destination.ReadFile("/backup/b.txt"); ok {
+		t.Errorf("/backup/b.txt is still in destination")
+	}
+	e.requireFile(t, "/versions/b.txt", "b")
+}
+
+func TestMissingFiles(t *testing.T) {
+	e := newSyncEnv(t)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e.write(t, "/Documents/a.txt", "a", modTime)
+	e.write(t, "/Documents/keep/b.txt", "b", modTime)
+	e.sync(t, "/Documents")
+
+	// Left behind by deletions nobody was notified about
+	e.destination.WriteFile("/backup/gone.txt", []byte("gone"), modTime)
+	e.destination.WriteFile("/backup/keep/gone too.txt", []byte("gone"), modTime)
+	e.destination.WriteFile("/backup/old dir/c.txt", []byte("c"), modTime)
+	// Kept by the tool itself
+	e.destination.WriteFile("/backup/.versions/a.txt/2024-01-01T00-00-00Z", []byte("a v0"), modTime)
+	e.destination.WriteFile("/backup/keep/"+archiveIndexName, []byte("{}"), modTime)
+
+	ctx := context.Background()
+	cfg := Config{TargetPath: "/backup", SyncPaths: []string{"/Documents"}}
+	missing, err := e.processor.MissingFiles(ctx, cfg)
+	if err != nil {
+		t.Fatalf("MissingFiles: %v", err)
+	}
+	slices.Sort(missing)
+	want := []string{"/Documents/gone.txt", "/Documents/keep/gone%20too.txt", "/Documents/old%20dir"}
+	if !slices.Equal(missing, want) {
+		t.Fatalf("MissingFiles = %q, want %q", missing, want)
+	}
+
+	if err := e.processor.DeleteFiles(ctx, cfg, missing); err != nil {
+		t.Fatalf("DeleteFiles: %v", err)
+	}
+	if missing, err := e.processor.MissingFiles(ctx, cfg); err != nil || len(missing) != 0 {
+		t.Errorf("MissingFiles after deletion = %q, %v", missing, err)
+	}
+	e.requireFile(t, "/backup/a.txt", "a")
+	e.requireFile(t, "/backup/keep/b.txt", "b")
+
+	// Nothing is removed in append-only mode
+	e.destination.WriteFile("/backup/gone.txt", []byte("gone"), modTime)
+	cfg.Backup = BackupConfig{Dir: "/versions/{date}", AppendOnly: true}
+	if missing, err := e.processor.MissingFiles(ctx, cfg); err != nil || len(missing) != 0 {
+		t.Errorf("MissingFiles in append-only mode = %q, %v", missing, err)
+	}
+}
+
+func TestWebhookDeletesPlusNames(t *testing.T) {
+	e := newSyncEnv(t)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e.write(t, "/Documents/a+b.txt", "ab", modTime)
+	e.write(t, "/Documents/c+d/e.txt", "e", modTime)
+	e.write(t, "/Documents/f+g.txt", "fg", modTime)
+	e.sync(t, "/Documents")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := Config{TargetPath: "/backup", SyncPaths: []string{"/Documents"}}
+	deleted := make(chan []string, 1)
+	l := &webhook.Listener{
+		Secret:            "secret",
+		Paths:             cfg.SyncPaths,
+		Escaped:           true,
+		Delay:             10 * time.Millisecond,
+		ReconcileInterval: time.Hour,
+		Sync:              func(ctx context.Context, files []string) error { return nil },
+		Delete: func(ctx context.Context, files []string) error {
+			err := e.processor.DeleteFiles(ctx, cfg, files)
+			deleted <- files
+			return err
+		},
+		Reconcile: func(ctx context.Context) error { return nil },
+		Missing:   func(ctx context.Context) ([]string, error) { return nil, nil },
+	}
+	go l.Run(ctx)
+
+	for _, nodePath := range []string{"/admin/files/Documents/a+b.txt", "/admin/files/Documents/c+d"} {
+		body := `{"user":{"uid":"admin"},"time":1726000000,` +
+			`"event":{"class":"OCP\\Files\\Events\\Node\\NodeDeletedEvent","node":{"id":42,"path":"` + nodePath + `"}}}`
+		r := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, r)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("webhook status = %d", w.Code)
+		}
+	}
+	for passed := 0; passed < 2; {
+		select {
+		case files := <-deleted:
+			passed += len(files)
+		case <-time.After(5 * time.Second):
+			t.Fatal("deletions weren't passed to DeleteFiles")
+		}
+	}
+	for _, filePath := range []string{"/backup/a+b.txt", "/backup/c+d/e.txt"} {
+		if _, ok := e.destination.ReadFile(filePath); ok {
+			t.Errorf("%s is still in destination", filePath)
+		}
+	}
+
+	// Reconciliation finds deletions nobody was notified about
+	if err := e.nextcloud.Trash("/Documents/f+g.txt", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	missing, err := e.processor.MissingFiles(ctx, cfg)
+	if err != nil {
+		t.Fatalf("MissingFiles: %v", err)
+	}
+	if err := e.processor.DeleteFiles(ctx, cfg, missing); err != nil {
+		t.Fatalf("DeleteFiles: %v", err)
+	}
+	if _, ok := e.destination.ReadFile("/backup/f+g.txt"); ok {
+		t.Errorf("/backup/f+g.txt is still in destination, missing = %q", missing)
+	}
+}
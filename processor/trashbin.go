@@ -0,0 +1,226 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/models"
+)
+
+// trashbinLister is implemented by clients exposing their trashbin
+type trashbinLister interface {
+	ListTrash(ctx context.Context) ([]models.TrashItem, error)
+	ListTrashFolder(ctx context.Context, folderPath string) ([]models.FileInfo, error)
+	DownloadTrashFile(ctx context.Context, filePath string) (io.ReadCloser, error)
+}
+
+// trashbinFolderName returns name of the backup folder for items deleted at the same time
+func trashbinFolderName(item models.TrashItem) string {
+	return item.Deleted.UTC().Format("2006-01-02T15-04-05Z")
+}
+
+// trashbinBackup state of a single trashbin backup run
+type trashbinBackup struct {
+	lister     trashbinLister
+	targetPath string
+	// stored paths relative to target of files already stored in destination
+	stored map[string]bool
+	// folders paths relative to target of folders existing in destination
+	folders map[string]bool
+	// files deleted files found in trashbin, listed before anything is uploaded
+	files []trashbinFile
+}
+
+// trashbinFile deleted file to back up
+type trashbinFile struct {
+	syncPath  string
+	trashPath string
+	size      int64
+	dstPath   string
+}
+
+// SyncTrashbin backs up source trashbin into separate destination folder.
+// Items are stored as <target>/<deletion time>/<original location>, already stored files are skipped.
+func (p *Processor) SyncTrashbin(ctx context.Context, cfg Config, stats *SyncStats) error {
+	lister, ok := p.source.(trashbinLister)
+	if !ok {
+		return fmt.Errorf("source client doesn't support trashbin")
+	}
+
+	slog.Info("Reading source trashbin")
+	items, err := lister.ListTrash(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list source trashbin: %w", err)
+	}
+
+	targetPath := cfg.TrashbinTargetPath
+	b := &trashbinBackup{lister: lister, targetPath: targetPath, stored: make(map[string]bool), folders: make(map[string]bool)}
+	trashFs, err := p.getFileSystem(ctx, p.destination, targetPath)
+	switch {
+	case errors.Is(err, backend.ErrNotFound):
+		slog.Info("Destination trashbin folder doesn't exist, will create it", "path", targetPath)
+		if createErr := p.createFolderChain(ctx, p.destination, targetPath); createErr != nil {
+			return fmt.Errorf("failed to create trashbin folder in destination: %w", createErr)
+		}
+	case err != nil:
+		return fmt.Errorf("failed to read trashbin folder in destination: %w", err)
+	default:
+		collectFiles(trashFs, targetPath, b.stored, b.folders)
+	}
+
+	for _, item := range items {
+		syncPath, ok := matchSyncPath(item.OriginPath, cfg.SyncPaths)
+		if !ok {
+			stats.syncPath = ""
+			stats.record(FileResult{Path: item.Path, Action: ActionFiltered, Reason: "outside sync paths", Size: item.Size})
+			continue
+		}
+		stats.syncPath = syncPath
+
+		basePath := joinPath(joinPath(targetPath, trashbinFolderName(item)), strings.TrimPrefix(item.OriginPath, "/"))
+		if item.IsDir {
+			b.listTrashbinFolder(ctx, syncPath, item.Path, basePath, stats)
+			continue
+		}
+		b.files = append(b.files, trashbinFile{syncPath: syncPath, trashPath: item.Path, size: item.Size, dstPath: basePath})
+	}
+
+	if cfg.Quota.Check {
+		var required int64
+		for _, file := range b.files {
+			if rel, _ := relativePath(targetPath, file.dstPath); !b.stored[rel] {
+				required += file.size
+			}
+		}
+		if err := p.checkSpace(ctx, "trashbin", required); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range b.files {
+		stats.syncPath = file.syncPath
+		p.syncTrashbinFile(ctx, b, file, stats)
+	}
+
+	slog.Info("Trashbin synchronization completed", "uploaded", stats.UploadedTrashbinFiles)
+	return nil
+}
+
+// listTrashbinFolder collects files of deleted folder recursively
+func (b *trashbinBackup) listTrashbinFolder(ctx context.Context, syncPath, trashPath, dstPath string, stats *SyncStats) {
+	files, err := b.lister.ListTrashFolder(ctx, trashPath)
+	if err != nil {
+		slog.Error("Failed to list deleted folder", "path", trashPath, "error", err)
+		stats.ErrorFiles++
+		stats.record(FileResult{Path: trashPath, Action: ActionFailed, Reason: "trashbin", Err: err})
+		return
+	}
+
+	for _, file := range files {
+		name := baseName(file.Path, true)
+		if file.IsDir {
+			b.listTrashbinFolder(ctx, syncPath, file.Path, joinPath(dstPath, name), stats)
+			continue
+		}
+		b.files = append(b.files, trashbinFile{syncPath: syncPath, trashPath: file.Path, size: file.Size, dstPath: joinPath(dstPath, name)})
+	}
+}
+
+// syncTrashbinFile backs up deleted file unless it is already stored
+func (p *Processor) syncTrashbinFile(ctx context.Context, b *trashbinBackup, file trashbinFile, stats *SyncStats) {
+	trashPath, size, dstPath := file.trashPath, file.size, file.dstPath
+	stats.TotalFiles++
+
+	rel, _ := relativePath(b.targetPath, dstPath)
+	if b.stored[rel] {
+		stats.SkippedFiles++
+		stats.record(FileResult{Path: trashPath, Target: dstPath, Action: ActionSkipped, Reason: "trashbin", Size: size})
+		return
+	}
+
+	start := time.Now()
+	failed := func(err error) {
+		stats.ErrorFiles++
+		stats.record(FileResult{Path: trashPath, Target: dstPath, Action: ActionFailed, Reason: "trashbin",
+			Size: size, Duration: time.Since(start), Err: err})
+	}
+
+	if err := p.createTrashbinFolder(ctx, b, path.Dir(rel)); err != nil {
+		slog.Error("Failed to create trashbin folder", "path", dstPath, "error", err)
+		failed(err)
+		return
+	}
+
+	reader, err := b.lister.DownloadTrashFile(ctx, trashPath)
+	if err != nil {
+		slog.Error("Failed to download deleted file", "path", trashPath, "error", err)
+		failed(err)
+		return
+	}
+	defer reader.Close()
+
+	if err := p.destination.UploadFile(ctx, dstPath, reader, size); err != nil {
+		slog.Error("Failed to upload deleted file", "path", dstPath, "error", err)
+		failed(err)
+		return
+	}
+
+	slog.Info("Deleted file synced", "path", dstPath, "action", "upload", "bytes", size)
+	stats.record(FileResult{Path: trashPath, Target: dstPath, Action: ActionUploaded, Reason: "trashbin",
+		Size: size, Duration: time.Since(start)})
+	b.stored[rel] = true
+	stats.UploadedTrashbinFiles++
+}
+
+// createTrashbinFolder creates folder given relative to target with its missing parents. Existing folders
+// are known from the listing of target, so every folder is checked and created at most once per run.
+func (p *Processor) createTrashbinFolder(ctx context.Context, b *trashbinBackup, rel string) error {
+	if rel == "." || rel == "" || b.folders[rel] {
+		return nil
+	}
+	if err := p.createTrashbinFolder(ctx, b, path.Dir(rel)); err != nil {
+		return err
+	}
+
+	folderPath := joinPath(b.targetPath, rel)
+	slog.Info("Creating folder", "path", folderPath, "action", "create_folder")
+	if err := p.destination.CreateFolder(ctx, folderPath); err != nil {
+		return fmt.Errorf("failed to create folder %s: %w", folderPath, err)
+	}
+	b.folders[rel] = true
+	return nil
+}
+
+// collectFiles collects paths of all files and folders in folder relative to root
+func collectFiles(folder models.Folder, root string, files, folders map[string]bool) {
+	for _, file := range folder.Files {
+		if rel, ok := relativePath(root, file.Path); ok {
+			files[rel] = true
+		}
+	}
+	for _, sub := range folder.Folders {
+		if rel, ok := relativePath(root, sub.Path); ok {
+			folders[rel] = true
+		}
+		collectFiles(sub, root, files, folders)
+	}
+}
+
+// matchSyncPath returns sync path containing source path, false if it is outside of all sync paths
+func matchSyncPath(filePath string, syncPaths []string) (string, bool) {
+	filePath = "/" + strings.Trim(filePath, "/")
+	for _, syncPath := range syncPaths {
+		root := strings.TrimSuffix("/"+strings.Trim(syncPath, "/"), "/")
+		if root == "" || filePath == root || strings.HasPrefix(filePath, root+"/") {
+			return syncPath, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,109 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/backend/memory"
+)
+
+func TestSyncTrashbin(t *testing.T) {
+	e := newSyncEnv(t)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	first := time.Date(2024, 5, 1, 10, 20, 30, 0, time.UTC)
+	second := first.Add(time.Hour)
+	e.write(t, "/Documents/a.txt", "a", modTime)
+	e.write(t, "/Documents/d.txt", "d", modTime)
+	e.write(t, "/Documents/dir/b.txt", "b", modTime)
+	e.write(t, "/Documents/dir/sub/c.txt", "c", modTime)
+	e.write(t, "/Other/x.txt", "x", modTime)
+	for filePath, deleted := range map[string]time.Time{"/Documents/a.txt": first, "/Documents/dir": second, "/Other/x.txt": first} {
+		if err := e.nextcloud.Trash(filePath, deleted); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := Config{TrashbinTargetPath: "/trash", SyncPaths: []string{"/Documents"}}
+	sync := func() *SyncStats {
+		t.Helper()
+		stats := newSyncStats(nil, time.Now())
+		if err := e.processor.SyncTrashbin(context.Background(), cfg, stats); err != nil {
+			t.Fatalf("SyncTrashbin: %v", err)
+		}
+		return stats
+	}
+
+	stats := sync()
+	e.requireFile(t, "/trash/2024-05-01T10-20-30Z/Documents/a.txt", "a")
+	e.requireFile(t, "/trash/2024-05-01T11-20-30Z/Documents/dir/b.txt", "b")
+	e.requireFile(t, "/trash/2024-05-01T11-20-30Z/Documents/dir/sub/c.txt", "c")
+	if _, ok := e.destination.ReadFile("/trash/2024-05-01T10-20-30Z/Other/x.txt"); ok {
+		t.Error("file deleted outside sync paths is backed up")
+	}
+	if stats.UploadedTrashbinFiles != 3 {
+		t.Errorf("uploaded %d deleted files, want 3", stats.UploadedTrashbinFiles)
+	}
+	// Target, two deletion time folders, Documents in both, dir and sub, each once
+	if got := e.destination.Calls(memory.OpCreateFolder); got != 7 {
+		t.Errorf("created folders %d times, want 7", got)
+	}
+	// Only the missing target is looked up, the rest is known from its listing
+	if got := e.destination.Calls(memory.OpGetFileInfo); got != 1 {
+		t.Errorf("looked up %d paths, want 1", got)
+	}
+
+	// Stored files are skipped without touching destination
+	uploads, folders := e.destination.Calls(memory.OpUpload), e.destination.Calls(memory.OpCreateFolder)
+	stats = sync()
+	if stats.UploadedTrashbinFiles != 0 || stats.SkippedFiles != 3 {
+		t.Errorf("second run uploaded %d and skipped %d files, want 0 and 3", stats.UploadedTrashbinFiles, stats.SkippedFiles)
+	}
+	if e.destination.Calls(memory.OpUpload) != uploads || e.destination.Calls(memory.OpCreateFolder) != folders {
+		t.Error("second run changed destination")
+	}
+
+	// File deleted at the same time goes into existing folder
+	if err := e.nextcloud.Trash("/Documents/d.txt", first); err != nil {
+		t.Fatal(err)
+	}
+	stats = sync()
+	e.requireFile(t, "/trash/2024-05-01T10-20-30Z/Documents/d.txt", "d")
+	if stats.UploadedTrashbinFiles != 1 || e.destination.Calls(memory.OpCreateFolder) != folders {
+		t.Errorf("third run uploaded %d files and created %d folders, want 1 and none",
+			stats.UploadedTrashbinFiles, e.destination.Calls(memory.OpCreateFolder)-folders)
+	}
+}
+
+func TestSyncTrashbinQuotaCheck(t *testing.T) {
+	destination := memory.New()
+	dst := &quotaBackend{Backend: destination, total: 10}
+	e := newEnv(t, dst, "/backup", nil)
+	e.write(t, "/Documents/a.txt", "a", time.Now())
+	e.write(t, "/Documents/dir/b.txt", "deleted folder content", time.Now())
+	for _, filePath := range []string{"/Documents/a.txt", "/Documents/dir"} {
+		if err := e.nextcloud.Trash(filePath, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := Config{TrashbinTargetPath: "/trash", SyncPaths: []string{"/Documents"}, Quota: QuotaConfig{Check: true}}
+
+	// Files of deleted folders are counted too, nothing is uploaded if they don't fit
+	err := e.processor.SyncTrashbin(context.Background(), cfg, newSyncStats(nil, time.Now()))
+	if !errors.Is(err, backend.ErrQuotaExceeded) {
+		t.Fatalf("SyncTrashbin error = %v, want quota exceeded", err)
+	}
+	if got := destination.Calls(memory.OpUpload); got != 0 {
+		t.Errorf("uploaded %d files without free space", got)
+	}
+
+	dst.total = 1000
+	stats := newSyncStats(nil, time.Now())
+	if err := e.processor.SyncTrashbin(context.Background(), cfg, stats); err != nil {
+		t.Fatalf("SyncTrashbin: %v", err)
+	}
+	if stats.UploadedTrashbinFiles != 2 {
+		t.Errorf("uploaded %d deleted files, want 2", stats.UploadedTrashbinFiles)
+	}
+}
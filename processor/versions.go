@@ -0,0 +1,274 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/models"
+)
+
+// versionsFolderName name of the folder holding previous versions of files in the target
+const versionsFolderName = ".versions"
+
+// retryPrefix prefix of files in versions folder listing source files whose versions failed to sync.
+// Every change of the list is stored in a new file named by time, so append-only targets can keep it too.
+const retryPrefix = ".retry-"
+
+// versionLister is implemented by clients keeping previous versions of files
+type versionLister interface {
+	ListVersions(ctx context.Context, fileID string) ([]models.FileInfo, error)
+	DownloadVersion(ctx context.Context, fileID, version string) (io.ReadCloser, error)
+}
+
+// versionName returns name of stored version keyed by its timestamp, extension is kept
+func versionName(fileName string, version models.FileInfo) string {
+	return version.ModTime.UTC().Format("2006-01-02T15-04-05Z") + path.Ext(fileName)
+}
+
+// versionRetries source files whose versions must be checked again in the next run
+type versionRetries struct {
+	// pending files failed in previous runs
+	pending map[string]bool
+	// failed files failed in this run
+	failed map[string]bool
+	// stored retry files of versions folder
+	stored []models.File
+}
+
+// versionUploads versions of single source file missing in its versions folder
+type versionUploads struct {
+	srcFile models.File
+	// folderPath versions folder of the file, folderKnown reports that it exists
+	folderPath  string
+	folderKnown bool
+	versions    []models.FileInfo
+	names       []string
+}
+
+// syncVersionsTree backs up previous versions of files into parallel versions tree of target path.
+// Versions are checked for files changed in this run, files whose versions failed to sync before
+// and for all files in folders seen for the first time. With quota check missing versions are listed
+// first and fail with backend.ErrQuotaExceeded if they don't fit, the files are retried in the next run.
+func (p *Processor) syncVersionsTree(ctx context.Context, t transfer, srcFolder models.Folder, targetPath string, stats *SyncStats) error {
+	lister, ok := t.src.(versionLister)
+	if !ok {
+		slog.Warn("Source client doesn't support versions, skipping version backup")
+		return nil
+	}
+
+	versionsPath := joinPath(targetPath, versionsFolderName)
+	versionsFs, err := p.getFileSystem(ctx, t.dst, versionsPath)
+	known := err == nil
+	if errors.Is(err, backend.ErrNotFound) {
+		versionsFs = models.Folder{Path: versionsPath}
+	} else if err != nil {
+		slog.Error("Failed to read versions folder", "path", versionsPath, "error", err)
+		return nil
+	}
+
+	retries := p.readVersionRetries(ctx, t, versionsFs)
+	defer p.writeVersionRetries(ctx, t, versionsPath, retries)
+
+	var plan []versionUploads
+	p.planVersions(ctx, t, lister, srcFolder, versionsFs, known, versionsPath, retries, &plan, stats)
+	if t.checkSpace {
+		var required int64
+		for _, uploads := range plan {
+			for _, version := range uploads.versions {
+				required += version.Size
+			}
+		}
+		if err := p.checkSpace(ctx, "versions", required); err != nil {
+			for _, uploads := range plan {
+				retries.failed[uploads.srcFile.Path] = true
+			}
+			return err
+		}
+	}
+
+	for _, uploads := range plan {
+		if !p.uploadFileVersions(ctx, t, lister, uploads, stats) {
+			retries.failed[uploads.srcFile.Path] = true
+		}
+	}
+	return nil
+}
+
+// readVersionRetries reads the newest retry file of versions folder
+func (p *Processor) readVersionRetries(ctx context.Context, t transfer, versionsFs models.Folder) *versionRetries {
+	retries := &versionRetries{pending: make(map[string]bool), failed: make(map[string]bool)}
+	for _, file := range versionsFs.Files {
+		if strings.HasPrefix(baseName(file.Path, t.dstEscaped), retryPrefix) {
+			retries.stored = append(retries.stored, file)
+		}
+	}
+	if len(retries.stored) == 0 {
+		return retries
+	}
+	// Names are ordered by time
+	slices.SortFunc(retries.stored, func(a, b models.File) int { return strings.Compare(a.Path, b.Path) })
+	newest := retries.stored[len(retries.stored)-1]
+
+	var files []string
+	reader, err := t.dst.DownloadFile(ctx, newest.Path)
+	if err == nil {
+		err = json.NewDecoder(reader).Decode(&files)
+		reader.Close()
+	}
+	if err != nil {
+		slog.Warn("Failed to read files whose versions must be synced again", "path", newest.Path, "error", err)
+	}
+	for _, file := range files {
+		retries.pending[file] = true
+	}
+	return retries
+}
+
+// writeVersionRetries stores files whose versions failed in this run if they differ from the stored ones.
+// Older retry files are removed unless the target is append-only.
+func (p *Processor) writeVersionRetries(ctx context.Context, t transfer, versionsPath string, retries *versionRetries) {
+	if maps.Equal(retries.pending, retries.failed) {
+		return
+	}
+	del, canDelete := t.dst.(backend.Deleter)
+	canDelete = canDelete && (t.backup == nil || !t.backup.appendOnly)
+
+	if len(retries.failed) > 0 || !canDelete {
+		files := slices.Sorted(maps.Keys(retries.failed))
+		data, err := json.Marshal(files)
+		if err != nil {
+			slog.Error("Failed to encode files whose versions must be synced again", "error", err)
+			return
+		}
+		retryPath := joinPath(versionsPath, retryPrefix+time.Now().UTC().Format("2006-01-02T15-04-05.000000000Z")+".json")
+		if err := p.createFolderChain(ctx, t.dst, versionsPath); err != nil {
+			slog.Error("Failed to create versions folder", "path", versionsPath, "error", err)
+			return
+		}
+		if err := t.dst.UploadFile(ctx, retryPath, bytes.NewReader(data), int64(len(data))); err != nil {
+			slog.Error("Failed to store files whose versions must be synced again", "path", retryPath, "error", err)
+			return
+		}
+	}
+
+	if canDelete {
+		for _, file := range retries.stored {
+			if err := del.Delete(ctx, file.Path, true); err != nil {
+				slog.Warn("Failed to remove old retry file", "path", file.Path, "error", err)
+			}
+		}
+	}
+}
+
+// planVersions collects versions of files in folder missing in destination recursively
+func (p *Processor) planVersions(ctx context.Context, t transfer, lister versionLister, srcFolder, versionsFolder models.Folder, known bool, versionsBasePath string, retries *versionRetries, plan *[]versionUploads, stats *SyncStats) {
+	// Stored versions of each file are kept in a folder named after the file
+	storedFolders := make(map[string]models.Folder)
+	for _, folder := range versionsFolder.Folders {
+		storedFolders[baseName(folder.Path, t.dstEscaped)] = folder
+	}
+
+	for _, srcFile := range srcFolder.Files {
+		if srcFile.ID == "" || (known && !t.changed[srcFile.Path] && !retries.pending[srcFile.Path]) {
+			continue
+		}
+
+		fileName := baseName(srcFile.Path, t.srcEscaped)
+		stored, fileKnown := storedFolders[fileName]
+		uploads, ok := p.planFileVersions(ctx, t, lister, srcFile, fileName, stored, stats)
+		if !ok {
+			retries.failed[srcFile.Path] = true
+			continue
+		}
+		if len(uploads.versions) > 0 {
+			uploads.folderPath = joinPath(versionsBasePath, escapeName(fileName, t.dstEscaped))
+			uploads.folderKnown = fileKnown
+			*plan = append(*plan, uploads)
+		}
+	}
+
+	for _, srcSubFolder := range srcFolder.Folders {
+		folderName := baseName(srcSubFolder.Path, t.srcEscaped)
+		stored, folderKnown := storedFolders[folderName]
+		p.planVersions(ctx, t, lister, srcSubFolder, stored, known && folderKnown, joinPath(versionsBasePath, escapeName(folderName, t.dstEscaped)), retries, plan, stats)
+	}
+}
+
+// planFileVersions returns versions of single file missing in its stored versions folder,
+// false if they couldn't be listed
+func (p *Processor) planFileVersions(ctx context.Context, t transfer, lister versionLister, srcFile models.File, fileName string, stored models.Folder, stats *SyncStats) (versionUploads, bool) {
+	uploads := versionUploads{srcFile: srcFile}
+	versions, err := lister.ListVersions(ctx, srcFile.ID)
+	if err != nil {
+		slog.Error("Failed to list versions", "path", srcFile.Path, "error", err)
+		stats.ErrorFiles++
+		stats.record(FileResult{Path: srcFile.Path, Action: ActionFailed, Reason: "list versions", Err: err})
+		return uploads, false
+	}
+
+	storedVersions := make(map[string]bool)
+	for _, file := range stored.Files {
+		storedVersions[baseName(file.Path, t.dstEscaped)] = true
+	}
+	for _, version := range versions {
+		if name := versionName(fileName, version); !storedVersions[name] {
+			uploads.versions = append(uploads.versions, version)
+			uploads.names = append(uploads.names, name)
+		}
+	}
+	return uploads, true
+}
+
+// uploadFileVersions uploads missing versions of single file, returns false if any of them failed
+func (p *Processor) uploadFileVersions(ctx context.Context, t transfer, lister versionLister, uploads versionUploads, stats *SyncStats) bool {
+	srcFile := uploads.srcFile
+	if !uploads.folderKnown {
+		if err := p.createFolderChain(ctx, t.dst, uploads.folderPath); err != nil {
+			slog.Error("Failed to create versions folder", "path", uploads.folderPath, "error", err)
+			stats.ErrorFiles++
+			stats.record(FileResult{Path: srcFile.Path, Target: uploads.folderPath, Action: ActionFailed, Reason: "create versions folder", Err: err})
+			return false
+		}
+	}
+
+	ok := true
+	for i, version := range uploads.versions {
+		name := uploads.names[i]
+		versionPath := joinPath(uploads.folderPath, escapeName(name, t.dstEscaped))
+		start := time.Now()
+		if err := p.uploadVersion(ctx, t, lister, version, versionPath); err != nil {
+			slog.Error("Failed to sync version", "path", srcFile.Path, "version", name, "error", err)
+			stats.ErrorFiles++
+			stats.record(FileResult{Path: srcFile.Path, Target: versionPath, Action: ActionFailed,
+				Reason: "version", Size: version.Size, Duration: time.Since(start), Err: err})
+			ok = false
+			continue
+		}
+		slog.Info("Version synced", "path", srcFile.Path, "version", name, "action", "upload")
+		stats.record(FileResult{Path: srcFile.Path, Target: versionPath, Action: ActionUploaded,
+			Reason: "version", Size: version.Size, Duration: time.Since(start)})
+		stats.UploadedVersions++
+	}
+	return ok
+}
+
+// uploadVersion copies single version to destination
+func (p *Processor) uploadVersion(ctx context.Context, t transfer, lister versionLister, version models.FileInfo, dstPath string) error {
+	reader, err := lister.DownloadVersion(ctx, version.ID, version.Name)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return t.dst.UploadFile(ctx, dstPath, reader, version.Size)
+}
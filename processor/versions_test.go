@@ -0,0 +1,141 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/backend/memory"
+	"nextya-sync/clients"
+	"nextya-sync/internal/fakenextcloud"
+)
+
+func TestPipelineVersions(t *testing.T) {
+	p := newPipeline(t)
+	first := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	second := first.Add(time.Hour)
+	p.write(t, "/Documents/notes.txt", "v3", time.Now().Add(-time.Hour))
+	for version, modTime := range map[string]time.Time{"v1": first, "v2": second} {
+		if err := p.nextcloud.AddVersion("/Documents/notes.txt", []byte(version), modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := Config{Versions: true}
+	p.run(t, cfg)
+	p.requireFile(t, "/backup/notes.txt", "v3")
+	p.requireFile(t, "/backup/.versions/notes.txt/2024-01-02T03-04-05Z.txt", "v1")
+	p.requireFile(t, "/backup/.versions/notes.txt/2024-01-02T04-04-05Z.txt", "v2")
+	if got := p.yandex.Requests("PUT upload"); got != 3 {
+		t.Fatalf("first run uploaded %d files, want file and 2 versions", got)
+	}
+
+	// Versions of unchanged files aren't checked again
+	p.run(t, cfg)
+	if got := p.yandex.Requests("PUT upload"); got != 3 {
+		t.Errorf("second run uploaded %d files, want none", got-3)
+	}
+}
+
+func TestVersionsRetried(t *testing.T) {
+	e := newSyncEnv(t)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e.write(t, "/Documents/todo.txt", "v2", modTime)
+	if err := e.nextcloud.AddVersion("/Documents/todo.txt", []byte("v1"), modTime.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	versionPath := "/backup/.versions/todo.txt/2024-01-02T02-04-05Z.txt"
+
+	e.destination.FailTimes(memory.OpUpload, versionPath, errors.New("disk full"), 1)
+	cfg := Config{TargetPath: "/backup", SyncPaths: []string{"/Documents"}, Versions: true}
+	if err := e.processor.Main(context.Background(), cfg); !errors.Is(err, ErrPartial) {
+		t.Fatalf("Main error = %v, want partial failure", err)
+	}
+	if retryFiles(e) != 1 {
+		t.Fatalf("failed version isn't recorded for retry: %q", e.destination.Paths())
+	}
+
+	// Source file is unchanged, its versions are checked because of the failure
+	e.run(t, cfg)
+	e.requireFile(t, versionPath, "v1")
+	if retryFiles(e) != 0 {
+		t.Errorf("retry list is left after versions synced: %q", e.destination.Paths())
+	}
+
+	uploads := e.destination.Calls(memory.OpUpload)
+	e.run(t, cfg)
+	if got := e.destination.Calls(memory.OpUpload); got != uploads {
+		t.Errorf("third run uploaded %d files, want none", got-uploads)
+	}
+}
+
+// retryFiles counts retry lists stored in versions folder
+func retryFiles(e *syncEnv) int {
+	count := 0
+	for _, filePath := range e.destination.Paths() {
+		if strings.HasPrefix(filePath, "/backup/.versions/"+retryPrefix) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestVersionsEscapedDestination(t *testing.T) {
+	dst := fakenextcloud.New("backup", "secret", t.TempDir())
+	t.Cleanup(dst.Close)
+	e := newEnv(t, clients.NewNextcloudClient(dst.URL, "backup", "secret"), "/backup", dst.ReadFile)
+	e.write(t, "/Documents/My Notes/Q1 report+draft.txt", "v2", time.Now().Add(-time.Hour))
+	if err := e.nextcloud.AddVersion("/Documents/My Notes/Q1 report+draft.txt", []byte("v1"), time.Date(2024, 1, 2, 2, 4, 5, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{Versions: true}
+	e.run(t, cfg)
+	e.requireFile(t, "/backup/.versions/My Notes/Q1 report+draft.txt/2024-01-02T02-04-05Z.txt", "v1")
+	uploads := dst.Requests("PUT")
+	if uploads != 2 {
+		t.Fatalf("first run uploaded %d files, want file and version", uploads)
+	}
+
+	// Stored versions are found by their decoded names, only the changed file is uploaded
+	e.write(t, "/Documents/My Notes/Q1 report+draft.txt", "v3", time.Now().Add(time.Hour))
+	e.run(t, cfg)
+	if got := dst.Requests("PUT"); got != uploads+1 {
+		t.Errorf("second run uploaded %d files, want only the changed file", got-uploads)
+	}
+}
+
+func TestVersionsQuotaCheck(t *testing.T) {
+	destination := memory.New()
+	dst := &quotaBackend{Backend: destination, total: 60}
+	e := newEnv(t, dst, "/backup", nil)
+	e.destination = destination
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e.write(t, "/Documents/todo.txt", "v2", modTime)
+	if err := e.nextcloud.AddVersion("/Documents/todo.txt", []byte(strings.Repeat("v1", 30)), modTime.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	versionPath := "/backup/.versions/todo.txt/2024-01-02T02-04-05Z.txt"
+
+	// The file fits, its version doesn't and isn't started
+	cfg := Config{TargetPath: "/backup", SyncPaths: []string{"/Documents"}, Versions: true, Quota: QuotaConfig{Check: true}}
+	if err := e.processor.Main(context.Background(), cfg); !errors.Is(err, backend.ErrQuotaExceeded) {
+		t.Fatalf("Main error = %v, want quota exceeded", err)
+	}
+	if data, _ := destination.ReadFile("/backup/todo.txt"); string(data) != "v2" {
+		t.Errorf("file = %q, want v2", data)
+	}
+	if _, ok := destination.ReadFile(versionPath); ok {
+		t.Error("version is uploaded without free space")
+	}
+
+	// Versions of the unchanged file are retried once there is space
+	dst.total = 1000
+	e.run(t, cfg)
+	if _, ok := destination.ReadFile(versionPath); !ok {
+		t.Errorf("version isn't retried: %q", destination.Paths())
+	}
+}
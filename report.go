@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"nextya-sync/processor"
+
+	"github.com/spf13/viper"
+)
+
+// newReport creates report collecting file results, nil if neither report nor metrics are enabled
+func newReport() *processor.Report {
+	if viper.GetString("report.path") == "" && !metricsEnabled() {
+		return nil
+	}
+	if _, err := reportFormat(); err != nil {
+		fatalConfig("Invalid report format", "error", err)
+	}
+	return &processor.Report{}
+}
+
+// reportFormat returns configured report format, detected from file extension by default
+func reportFormat() (string, error) {
+	format := strings.ToLower(viper.GetString("report.format"))
+	if format == "" {
+		if strings.EqualFold(filepath.Ext(viper.GetString("report.path")), ".csv") {
+			return "csv", nil
+		}
+		return "json", nil
+	}
+	if format != "json" && format != "csv" {
+		return "", fmt.Errorf("unknown report format %q, expected json or csv", format)
+	}
+	return format, nil
+}
+
+// writeReport writes report to the configured file
+func writeReport(report *processor.Report) {
+	reportPath := viper.GetString("report.path")
+	if report == nil || reportPath == "" {
+		return
+	}
+
+	file, err := os.Create(reportPath)
+	if err != nil {
+		fatal("Failed to create report", "path", reportPath, "error", err)
+	}
+	defer file.Close()
+
+	format, _ := reportFormat()
+	if format == "csv" {
+		err = report.WriteCSV(file)
+	} else {
+		err = report.WriteJSON(file)
+	}
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		fatal("Failed to write report", "path", reportPath, "error", err)
+	}
+	slog.Info("Report written", "path", reportPath, "format", format, "files", len(report.Files))
+}
@@ -0,0 +1,123 @@
+// Package schedule runs daemon jobs on cron schedules, one at a time
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"nextya-sync/processor"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Commands run by jobs
+const (
+	CommandSync  = "sync"
+	CommandPrune = "prune"
+)
+
+// Job command run on schedule
+type Job struct {
+	Name string `mapstructure:"name"`
+	// Schedule cron expression or interval
+	Schedule string `mapstructure:"schedule"`
+	// Command sync or prune, sync by default
+	Command string `mapstructure:"command"`
+
+	schedule cron.Schedule
+}
+
+// Next returns time of the next run of job after t
+func (job Job) Next(t time.Time) time.Time {
+	return job.schedule.Next(t)
+}
+
+// ParseJobs fills default command and names of jobs and parses their schedules.
+// Without jobs synchronization on defaultSchedule is the only job.
+func ParseJobs(jobs []Job, defaultSchedule string) ([]Job, error) {
+	if len(jobs) == 0 {
+		if defaultSchedule == "" {
+			return nil, errors.New("no jobs and no schedule are configured")
+		}
+		jobs = []Job{{Schedule: defaultSchedule}}
+	}
+
+	parsed := make([]Job, len(jobs))
+	for i, job := range jobs {
+		if job.Command == "" {
+			job.Command = CommandSync
+		}
+		if job.Name == "" {
+			job.Name = fmt.Sprintf("%s-%d", job.Command, i+1)
+		}
+		if job.Command != CommandSync && job.Command != CommandPrune {
+			return nil, fmt.Errorf("job %s: unknown command %q, expected sync or prune", job.Name, job.Command)
+		}
+
+		schedule, err := cron.ParseStandard(job.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: invalid schedule %q: %w", job.Name, job.Schedule, err)
+		}
+		job.schedule = schedule
+		parsed[i] = job
+	}
+	return parsed, nil
+}
+
+// Runner runs command of job
+type Runner func(ctx context.Context, job Job) error
+
+// Scheduler runs jobs one at a time
+type Scheduler struct {
+	run Runner
+	// active is held while a job runs
+	active sync.Mutex
+}
+
+// New creates scheduler running jobs with run
+func New(run Runner) *Scheduler {
+	return &Scheduler{run: run}
+}
+
+// Run runs job unless another one is still active, returns false if the run was skipped
+func (s *Scheduler) Run(ctx context.Context, job Job) bool {
+	if !s.active.TryLock() {
+		slog.Warn("Previous run is still active, skipping", "job", job.Name, "next", job.Next(time.Now()))
+		return false
+	}
+	defer s.active.Unlock()
+
+	slog.Info("Job started", "job", job.Name, "command", job.Command)
+	start := time.Now()
+	err := s.run(ctx, job)
+
+	attrs := []any{"job", job.Name, "duration", time.Since(start), "next", job.Next(time.Now())}
+	switch {
+	case err == nil:
+		slog.Info("Job finished", attrs...)
+	case errors.Is(err, processor.ErrPartial):
+		slog.Warn("Job finished with errors", append(attrs, "error", err)...)
+	default:
+		slog.Error("Job failed", append(attrs, "error", err)...)
+	}
+	return true
+}
+
+// Serve runs jobs on their schedules until ctx is done, then waits for the active run to finish
+func (s *Scheduler) Serve(ctx context.Context, jobs []Job) {
+	c := cron.New()
+	for _, job := range jobs {
+		c.Schedule(job.schedule, cron.FuncJob(func() { s.Run(ctx, job) }))
+		slog.Info("Job scheduled", "job", job.Name, "command", job.Command, "schedule", job.Schedule,
+			"next", job.Next(time.Now()))
+	}
+	c.Start()
+
+	<-ctx.Done()
+	slog.Info("Stopping daemon, waiting for active run to finish")
+	<-c.Stop().Done()
+}
@@ -0,0 +1,113 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseJobs(t *testing.T) {
+	jobs, err := ParseJobs([]Job{
+		{Schedule: "0 3 * * *"},
+		{Name: "weekly-prune", Schedule: "@weekly", Command: CommandPrune},
+		{Schedule: "@every 6h", Command: CommandPrune},
+	}, "")
+	if err != nil {
+		t.Fatalf("ParseJobs: %v", err)
+	}
+	want := []Job{
+		{Name: "sync-1", Command: CommandSync},
+		{Name: "weekly-prune", Command: CommandPrune},
+		{Name: "prune-3", Command: CommandPrune},
+	}
+	for i, job := range jobs {
+		if job.Name != want[i].Name || job.Command != want[i].Command {
+			t.Errorf("job %d = %s %s, want %s %s", i, job.Name, job.Command, want[i].Name, want[i].Command)
+		}
+	}
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	if next := jobs[0].Next(now); !next.Equal(time.Date(2024, 1, 2, 3, 0, 0, 0, time.Local)) {
+		t.Errorf("next run of daily job = %v", next)
+	}
+	if next := jobs[2].Next(now); next.Sub(now) != 6*time.Hour {
+		t.Errorf("next run of interval job = %v", next)
+	}
+}
+
+func TestParseJobsDefault(t *testing.T) {
+	jobs, err := ParseJobs(nil, "@every 1h")
+	if err != nil {
+		t.Fatalf("ParseJobs: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].Name != "sync-1" || jobs[0].Command != CommandSync || jobs[0].Schedule != "@every 1h" {
+		t.Errorf("default jobs = %+v, want single sync job", jobs)
+	}
+
+	// Configured jobs take precedence over default schedule
+	jobs, err = ParseJobs([]Job{{Schedule: "@daily", Command: CommandPrune}}, "@every 1h")
+	if err != nil || len(jobs) != 1 || jobs[0].Command != CommandPrune {
+		t.Errorf("ParseJobs with jobs and default schedule = %+v, %v", jobs, err)
+	}
+}
+
+func TestParseJobsErrors(t *testing.T) {
+	tests := map[string][]Job{
+		"unknown command":  {{Schedule: "@daily", Command: "backup"}},
+		"invalid schedule": {{Schedule: "every day"}},
+		"too many fields":  {{Schedule: "0 0 3 * * *"}},
+		"empty schedule":   {{Command: CommandPrune}},
+	}
+	for name, jobs := range tests {
+		if _, err := ParseJobs(jobs, "@every 1h"); err == nil {
+			t.Errorf("%s: ParseJobs succeeded", name)
+		}
+	}
+	if _, err := ParseJobs(nil, ""); err == nil {
+		t.Error("ParseJobs without jobs and schedule succeeded")
+	}
+}
+
+func TestSchedulerSkipsWhileActive(t *testing.T) {
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	s := New(func(ctx context.Context, job Job) error {
+		started <- job.Name
+		if job.Command == CommandSync {
+			<-release
+		}
+		return errors.New("failed")
+	})
+	jobs, err := ParseJobs([]Job{{Name: "sync", Schedule: "@every 1m"}, {Name: "prune", Schedule: "@every 1m", Command: CommandPrune}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan bool)
+	go func() { done <- s.Run(context.Background(), jobs[0]) }()
+	if name := <-started; name != "sync" {
+		t.Fatalf("started %s, want sync", name)
+	}
+
+	// Any job is skipped while another one runs
+	for _, job := range jobs {
+		if s.Run(context.Background(), job) {
+			t.Errorf("%s ran while sync was active", job.Name)
+		}
+	}
+	close(release)
+	if !<-done {
+		t.Error("active run is reported as skipped")
+	}
+	select {
+	case name := <-started:
+		t.Fatalf("skipped job %s was started", name)
+	default:
+	}
+
+	// Failed run doesn't block the next one
+	if !s.Run(context.Background(), jobs[1]) || <-started != "prune" {
+		t.Error("job didn't run after the active one finished")
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"nextya-sync/clients"
+	"nextya-sync/processor"
+	"nextya-sync/trash"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	trashCmd = &cobra.Command{
+		Use:   "trash",
+		Short: "Manage Yandex Disk trash",
+		Long: `Manage items in the Yandex Disk trash which were deleted from the
+target path or the backup directory. Other items in the trash are never touched.`,
+	}
+	trashLsCmd = &cobra.Command{
+		Use:   "ls",
+		Short: "List trash items deleted from the target path",
+		Args:  cobra.NoArgs,
+		Run:   trashLs,
+	}
+	trashRestoreCmd = &cobra.Command{
+		Use:   "restore <path>",
+		Short: "Restore trash item to its original location",
+		Long: `Restore trash item to its original location. The item is identified
+either by its trash path (trash:/...) or by its original path. A file deleted
+several times has to be identified by its trash path, see trash ls.`,
+		Args: cobra.ExactArgs(1),
+		Run:  trashRestore,
+	}
+	trashEmptyCmd = &cobra.Command{
+		Use:   "empty",
+		Short: "Permanently delete trash items deleted from the target path",
+		Args:  cobra.NoArgs,
+		Run:   trashEmpty,
+	}
+)
+
+func init() {
+	trashEmptyCmd.Flags().String("older-than", "", "Only delete items deleted earlier than this age, e.g. 30d or 72h")
+
+	trashCmd.AddCommand(trashLsCmd)
+	trashCmd.AddCommand(trashRestoreCmd)
+	trashCmd.AddCommand(trashEmptyCmd)
+	rootCmd.AddCommand(trashCmd)
+}
+
+// listScopedTrash lists trash items originating from folders managed by nextya-sync
+func listScopedTrash(ctx context.Context, yandexClient *clients.YandexDiskClient) []trash.Entry {
+	items, err := yandexClient.ListTrash(ctx)
+	if err != nil {
+		fatal("Failed to list trash", "error", err)
+	}
+
+	decrypt := func(path string) string { return path }
+	if encryptionEnabled() {
+		cryptClient := newCryptClient(yandexClient)
+		decrypt = func(path string) string {
+			if plain, err := cryptClient.DecryptPath(path); err == nil {
+				return plain
+			}
+			return path
+		}
+	}
+
+	var entries []trash.Entry
+	for _, item := range items {
+		if !trash.InRoots(item.OriginPath, destinationRoots()) {
+			continue
+		}
+		entries = append(entries, trash.Entry{
+			TrashItem:   item,
+			DisplayPath: decrypt(item.OriginPath),
+		})
+	}
+	return entries
+}
+
+func trashLs(cmd *cobra.Command, args []string) {
+	ctx := context.Background()
+
+	validateYandex()
+	entries := listScopedTrash(ctx, newYandexClient(ctx))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DELETED\tSIZE\tORIGINAL PATH\tTRASH PATH")
+	for _, entry := range entries {
+		size := strconv.FormatInt(entry.Size, 10)
+		if entry.IsDir {
+			size = "dir"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			entry.Deleted.Local().Format(time.DateTime), size, entry.DisplayPath, entry.Path)
+	}
+	w.Flush()
+}
+
+func trashRestore(cmd *cobra.Command, args []string) {
+	ctx := context.Background()
+
+	validateYandex()
+	yandexClient := newYandexClient(ctx)
+
+	entry, err := trash.Find(listScopedTrash(ctx, yandexClient), args[0])
+	if err != nil {
+		fatal("Failed to find trash item", "error", err)
+	}
+
+	if err := yandexClient.RestoreTrash(ctx, entry.Path); err != nil {
+		fatal("Failed to restore from trash", "path", entry.DisplayPath, "error", err)
+	}
+	slog.Info("Restored from trash", "path", entry.DisplayPath, "trash_path", entry.Path, "action", "restore")
+}
+
+func trashEmpty(cmd *cobra.Command, args []string) {
+	ctx := context.Background()
+
+	validateYandex()
+
+	var deletedBefore time.Time
+	if olderThan, _ := cmd.Flags().GetString("older-than"); olderThan != "" {
+		age, err := trash.ParseAge(olderThan)
+		if err != nil {
+			fatalConfig("Invalid --older-than value", "error", err)
+		}
+		deletedBefore = time.Now().Add(-age)
+	}
+
+	yandexClient := newYandexClient(ctx)
+	deleted, failed := 0, 0
+	for _, entry := range listScopedTrash(ctx, yandexClient) {
+		if !trash.DeletedBefore(entry.TrashItem, deletedBefore) {
+			continue
+		}
+
+		if err := yandexClient.DeleteTrash(ctx, entry.Path); err != nil {
+			slog.Error("Failed to delete from trash", "path", entry.DisplayPath, "error", err)
+			failed++
+			continue
+		}
+		slog.Info("Deleted from trash", "path", entry.DisplayPath, "action", "delete")
+		deleted++
+	}
+
+	slog.Info("Trash cleanup completed", "deleted", deleted, "failed", failed)
+	if failed > 0 {
+		checkErr(fmt.Errorf("%w: %d trash items failed to delete", processor.ErrPartial, failed))
+	}
+}
@@ -0,0 +1,87 @@
+// Package trash decides which Yandex Disk trash items are managed by nextya-sync
+package trash
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"nextya-sync/models"
+)
+
+// InRoots reports whether path is inside one of roots, disk: prefixes are ignored
+func InRoots(path string, roots []string) bool {
+	path = strings.TrimPrefix(path, "disk:")
+	for _, root := range roots {
+		root = strings.TrimSuffix(strings.TrimPrefix(root, "disk:"), "/")
+		if path == root || strings.HasPrefix(path, root+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseAge parses positive duration with additional support of days, e.g. 30d
+func ParseAge(value string) (time.Duration, error) {
+	var age time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days %q", value)
+		}
+		age = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if age, err = time.ParseDuration(value); err != nil {
+			return 0, err
+		}
+	}
+	if age <= 0 {
+		return 0, fmt.Errorf("age %q must be positive", value)
+	}
+	return age, nil
+}
+
+// DeletedBefore reports whether item was deleted before given time, zero time matches every item
+func DeletedBefore(item models.TrashItem, before time.Time) bool {
+	return before.IsZero() || item.Deleted.Before(before)
+}
+
+// Entry trash item with original path readable by the user
+type Entry struct {
+	models.TrashItem
+	DisplayPath string
+}
+
+// ErrNotFound no trash item matches the path
+var ErrNotFound = errors.New("trash item not found")
+
+// Find returns entry identified by its trash path or by its original path. A file deleted several
+// times has several entries with the same original path, then an error listing them is returned,
+// so the copy to restore is picked by its trash path.
+func Find(entries []Entry, path string) (Entry, error) {
+	var matches []Entry
+	for _, entry := range entries {
+		if entry.Path == path {
+			return entry, nil
+		}
+		if entry.OriginPath == path || entry.DisplayPath == path {
+			matches = append(matches, entry)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return Entry{}, fmt.Errorf("%s: %w", path, ErrNotFound)
+	case 1:
+		return matches[0], nil
+	}
+	var candidates []string
+	for _, entry := range matches {
+		candidates = append(candidates, fmt.Sprintf("%s (deleted %s)", entry.Path, entry.Deleted.Local().Format(time.DateTime)))
+	}
+	return Entry{}, fmt.Errorf("%s was deleted %d times, restore one of them by trash path: %s",
+		path, len(matches), strings.Join(candidates, ", "))
+}
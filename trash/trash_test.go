@@ -0,0 +1,109 @@
+package trash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"nextya-sync/models"
+)
+
+func TestInRoots(t *testing.T) {
+	roots := []string{"disk:/backup", "/versions/", "disk:/nextcloud trash"}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"disk:/backup", true},
+		{"disk:/backup/a.txt", true},
+		{"/backup/dir/b.txt", true},
+		{"disk:/versions/2024-01-02/a.txt", true},
+		{"disk:/nextcloud trash/2024-01-02T10-00-00Z/a.txt", true},
+		{"disk:/backups/a.txt", false},
+		{"disk:/backup2", false},
+		{"disk:/versions-old/a.txt", false},
+		{"disk:/nextcloud", false},
+		{"disk:/other/backup/a.txt", false},
+		{"disk:/", false},
+	}
+	for _, tt := range tests {
+		if got := InRoots(tt.path, roots); got != tt.want {
+			t.Errorf("InRoots(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	if !InRoots("/a", []string{"/a"}) || InRoots("/ab", []string{"/a"}) || InRoots("/ab/c", []string{"/a/"}) {
+		t.Error("root /a must match itself only, not /ab")
+	}
+	if InRoots("disk:/backup/a.txt", nil) {
+		t.Error("path matched without roots")
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	valid := map[string]time.Duration{
+		"30d":   30 * 24 * time.Hour,
+		"1d":    24 * time.Hour,
+		"72h":   72 * time.Hour,
+		"90m":   90 * time.Minute,
+		"1h30m": 90 * time.Minute,
+	}
+	for value, want := range valid {
+		if got, err := ParseAge(value); err != nil || got != want {
+			t.Errorf("ParseAge(%q) = %v, %v; want %v", value, got, err, want)
+		}
+	}
+
+	for _, value := range []string{"", "d", "1.5d", "-5d", "0d", "-1h", "0s", "30", "week", "3w"} {
+		if got, err := ParseAge(value); err == nil {
+			t.Errorf("ParseAge(%q) = %v, want error", value, got)
+		}
+	}
+}
+
+func TestDeletedBefore(t *testing.T) {
+	now := time.Now()
+	item := models.TrashItem{Deleted: now.Add(-48 * time.Hour)}
+
+	if !DeletedBefore(item, time.Time{}) {
+		t.Error("item isn't matched without age limit")
+	}
+	if !DeletedBefore(item, now.Add(-24*time.Hour)) {
+		t.Error("item deleted 2 days ago isn't older than a day")
+	}
+	if DeletedBefore(item, now.Add(-72*time.Hour)) {
+		t.Error("item deleted 2 days ago is older than 3 days")
+	}
+	if DeletedBefore(item, item.Deleted) {
+		t.Error("item deleted exactly at the limit is matched")
+	}
+}
+
+func TestFind(t *testing.T) {
+	first := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	entries := []Entry{
+		{TrashItem: models.TrashItem{Path: "trash:/a.txt_1", OriginPath: "disk:/backup/a.txt", Deleted: first}, DisplayPath: "disk:/backup/a.txt"},
+		{TrashItem: models.TrashItem{Path: "trash:/a.txt_2", OriginPath: "disk:/backup/a.txt", Deleted: first.Add(time.Hour)}, DisplayPath: "disk:/backup/a.txt"},
+		{TrashItem: models.TrashItem{Path: "trash:/x9f", OriginPath: "disk:/backup/x9f"}, DisplayPath: "disk:/backup/secret.txt"},
+	}
+
+	for _, path := range []string{"trash:/a.txt_2", "disk:/backup/secret.txt", "disk:/backup/x9f"} {
+		if _, err := Find(entries, path); err != nil {
+			t.Errorf("Find(%q): %v", path, err)
+		}
+	}
+	if entry, _ := Find(entries, "trash:/a.txt_2"); !entry.Deleted.Equal(first.Add(time.Hour)) {
+		t.Errorf("Find by trash path = %+v, want the second copy", entry)
+	}
+
+	// A file deleted twice isn't restored at random, both copies are listed
+	_, err := Find(entries, "disk:/backup/a.txt")
+	if err == nil || !strings.Contains(err.Error(), "trash:/a.txt_1") || !strings.Contains(err.Error(), "trash:/a.txt_2") {
+		t.Errorf("Find of path deleted twice = %v, want error listing both copies", err)
+	}
+
+	if _, err := Find(entries, "disk:/backup/missing.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Find of missing path = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"nextya-sync/clients"
+	"nextya-sync/processor"
+	"nextya-sync/watch"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Synchronize changed folders as soon as they change",
+	Long: `Watch runs full synchronization once and then synchronizes only folders
+changed in source. Nextcloud notify_push app is used for change notifications
+when it's installed, otherwise ETags of folders are polled on --poll-interval.
+Notifications are collected for --debounce before synchronization starts.`,
+	Args: cobra.NoArgs,
+	Run:  watchFolders,
+}
+
+func init() {
+	watchCmd.Flags().Duration("poll-interval", time.Minute, "Interval of checking for changes when notify_push is unavailable")
+	watchCmd.Flags().Duration("debounce", 5*time.Second, "Delay of synchronization after change notification")
+	viper.BindPFlag("watch.poll_interval", watchCmd.Flags().Lookup("poll-interval"))
+	viper.BindPFlag("watch.debounce", watchCmd.Flags().Lookup("debounce"))
+	viper.BindEnv("watch.poll_interval", "WATCH_POLL_INTERVAL")
+	viper.BindEnv("watch.debounce", "WATCH_DEBOUNCE")
+
+	rootCmd.AddCommand(watchCmd)
+}
+
+// newPush returns notify_push listener of Nextcloud source, nil if the app isn't installed
+func newPush(ctx context.Context, nc *clients.NextcloudClient) *watch.Push {
+	endpoint, err := nc.NotifyPushEndpoint(ctx)
+	if err != nil {
+		slog.Warn("Failed to check notify_push, polling for changes", "error", err)
+		return nil
+	}
+	if endpoint == "" {
+		slog.Info("notify_push app isn't installed, polling for changes")
+		return nil
+	}
+	return &watch.Push{Endpoint: endpoint, Username: nc.Username, Password: nc.Password}
+}
+
+func watchFolders(cmd *cobra.Command, args []string) {
+	pollInterval := viper.GetDuration("watch.poll_interval")
+	debounce := viper.GetDuration("watch.debounce")
+	if pollInterval <= 0 || debounce < 0 {
+		fatalConfig("Watch poll interval must be positive and debounce not negative",
+			"poll_interval", pollInterval, "debounce", debounce)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	startMetrics()
+	validation()
+	source := newSource(ctx)
+	proc := processor.NewProcessor(&processor.Dependencies{
+		Source:      source,
+		Destination: newDestination(ctx),
+	})
+
+	w := &watch.Watcher{
+		Source:       source,
+		Paths:        syncPaths(),
+		PollInterval: pollInterval,
+		Debounce:     debounce,
+		Initial: func(ctx context.Context) error {
+			return runSync(ctx, proc)
+		},
+		Sync: func(ctx context.Context, folders []string) error {
+			return runTargeted(ctx, folders, proc.SyncFolders)
+		},
+	}
+	if nc, ok := source.(*clients.NextcloudClient); ok {
+		w.Push = newPush(ctx, nc)
+	}
+
+	if err := w.Run(ctx); err != nil {
+		fatal("Watch failed", "error", err)
+	}
+	slog.Info("Watch stopped")
+}
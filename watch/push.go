@@ -0,0 +1,68 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"nextya-sync/backend"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialTimeout limit of connecting and authenticating to notify_push
+const dialTimeout = 30 * time.Second
+
+// Push listens to file change notifications of Nextcloud notify_push app
+type Push struct {
+	// Endpoint websocket URL reported in notify_push capabilities
+	Endpoint string
+	Username string
+	Password string
+}
+
+// listen subscribes to notifications and calls notify on every file change until connection breaks,
+// connected is called once subscription is authenticated. Wrong credentials fail with backend.ErrUnauthorized.
+func (p *Push) listen(ctx context.Context, connected, notify func()) error {
+	dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	conn, _, err := websocket.DefaultDialer.DialContext(dialCtx, p.Endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to connect to notify_push: %w: %w", backend.ErrNetwork, err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	conn.SetReadDeadline(time.Now().Add(dialTimeout))
+	for _, credential := range []string{p.Username, p.Password} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(credential)); err != nil {
+			return fmt.Errorf("failed to authenticate to notify_push: %w", err)
+		}
+	}
+	_, reply, err := conn.ReadMessage()
+	if err != nil {
+		return fmt.Errorf("failed to authenticate to notify_push: %w", err)
+	}
+	if message := string(reply); message != "authenticated" {
+		return fmt.Errorf("notify_push authentication failed: %s: %w", strings.TrimPrefix(message, "err: "),
+			backend.ErrUnauthorized)
+	}
+	conn.SetReadDeadline(time.Time{})
+	connected()
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("notify_push connection lost: %w", err)
+		}
+		// notify_file carries no paths, changed folders are found by comparing ETags
+		if strings.HasPrefix(string(message), "notify_file") {
+			notify()
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Package watch detects changes in source folders and triggers synchronization of only the changed ones
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/models"
+)
+
+// Limits of reconnection delay to notify_push
+const (
+	minReconnectDelay = 5 * time.Second
+	maxReconnectDelay = 5 * time.Minute
+)
+
+// maxDebounceFactor limits how many debounce intervals a continuous burst of events may delay synchronization
+const maxDebounceFactor = 10
+
+// Watcher watches source folders for changes with notify_push, or by polling ETags when it's unavailable
+type Watcher struct {
+	Source backend.Backend
+	// Paths folders of source to watch
+	Paths []string
+	// PollInterval interval of checking ETags when push notifications are unavailable
+	PollInterval time.Duration
+	// Debounce delay of synchronization after last push notification, collecting bursts of changes into one run
+	Debounce time.Duration
+	// Push notifications listener, polling only if nil
+	Push *Push
+	// Initial runs full synchronization after the first snapshot of folders, may be nil
+	Initial func(ctx context.Context) error
+	// Sync synchronizes changed folders given as paths returned by source listings, WebDAV folders end with slash
+	Sync func(ctx context.Context, folders []string) error
+
+	// state snapshot of folders as of the last successful synchronization
+	state map[string]*folderState
+	// dirty is set when the last check failed and has to be retried on next poll
+	dirty bool
+}
+
+// folderState snapshot of folder content
+type folderState struct {
+	// tag ETag of folder, empty if backend doesn't report it
+	tag string
+	// files fingerprints of files by path
+	files map[string]string
+	// folders snapshots of subfolders by path
+	folders map[string]*folderState
+}
+
+// Run watches folders until context is cancelled, fails only if source rejects credentials
+func (w *Watcher) Run(ctx context.Context) error {
+	state, _, err := w.scan(ctx, nil)
+	if err != nil {
+		return err
+	}
+	w.state = state
+	slog.Info("Watching folders for changes", "paths", w.Paths)
+
+	if w.Initial != nil {
+		if err := w.Initial(ctx); err != nil {
+			if errors.Is(err, backend.ErrUnauthorized) {
+				return err
+			}
+			slog.Error("Initial synchronization failed", "error", err)
+		}
+	}
+
+	events := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case events <- struct{}{}:
+		default:
+		}
+	}
+	var connected atomic.Bool
+	if w.Push != nil {
+		go w.listenPush(ctx, &connected, notify)
+	}
+
+	poll := time.NewTicker(w.PollInterval)
+	defer poll.Stop()
+	debounce := time.NewTimer(w.Debounce)
+	debounce.Stop()
+	var burstStart time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-poll.C:
+			if connected.Load() && !w.dirty {
+				continue
+			}
+		case <-events:
+			if burstStart.IsZero() {
+				burstStart = time.Now()
+			}
+			if time.Since(burstStart) < maxDebounceFactor*w.Debounce {
+				debounce.Reset(w.Debounce)
+			}
+			continue
+		case <-debounce.C:
+			burstStart = time.Time{}
+		}
+
+		if err := w.check(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, backend.ErrUnauthorized) {
+				return err
+			}
+			slog.Error("Synchronization of changes failed, retrying on next poll", "error", err)
+		}
+	}
+}
+
+// listenPush keeps connection to notify_push, reconnecting with growing delay, and stops if credentials are rejected
+func (w *Watcher) listenPush(ctx context.Context, connected *atomic.Bool, notify func()) {
+	delay := minReconnectDelay
+	for {
+		err := w.Push.listen(ctx, func() {
+			slog.Info("Listening to notify_push", "endpoint", w.Push.Endpoint)
+			connected.Store(true)
+			delay = minReconnectDelay
+			// Changes made while disconnected were not notified
+			notify()
+		}, notify)
+		connected.Store(false)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, backend.ErrUnauthorized) {
+			slog.Error("notify_push rejected credentials, polling for changes", "error", err)
+			return
+		}
+		slog.Warn("notify_push is unavailable, polling for changes", "error", err, "retry", delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// check synchronizes folders changed since last snapshot, the new snapshot is kept only if synchronization succeeds
+func (w *Watcher) check(ctx context.Context) error {
+	state, changed, err := w.scan(ctx, w.state)
+	if err != nil {
+		w.dirty = true
+		return err
+	}
+	if len(changed) > 0 {
+		slog.Info("Changes detected", "folders", changed)
+		if err := w.Sync(ctx, changed); err != nil {
+			w.dirty = true
+			return err
+		}
+	}
+	w.state = state
+	w.dirty = false
+	return nil
+}
+
+// scan takes snapshot of watched paths and returns folders changed compared to previous one, nil at start
+func (w *Watcher) scan(ctx context.Context, prev map[string]*folderState) (map[string]*folderState, []string, error) {
+	state := make(map[string]*folderState, len(w.Paths))
+	var changed []string
+	for _, root := range w.Paths {
+		info, err := w.Source.GetFileInfo(ctx, root)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get info of %s: %w", root, err)
+		}
+
+		prevRoot := prev[root]
+		if prevRoot != nil && info.ETag != "" && info.ETag == prevRoot.tag {
+			state[root] = prevRoot
+			continue
+		}
+		if state[root], err = w.scanFolder(ctx, root, info.ETag, prevRoot, prev != nil, &changed); err != nil {
+			return nil, nil, err
+		}
+	}
+	slices.Sort(changed)
+	return state, changed, nil
+}
+
+// scanFolder takes snapshot of folder, descending only into subfolders with changed or unknown ETags.
+// Folders with added, removed or changed entries are reported unless report is false, which is the case
+// for new folders synchronized entirely with their parent.
+func (w *Watcher) scanFolder(ctx context.Context, folderPath, tag string, prev *folderState, report bool, changed *[]string) (*folderState, error) {
+	entries, err := w.Source.ListFiles(ctx, folderPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", folderPath, err)
+	}
+
+	state := &folderState{
+		tag:     tag,
+		files:   make(map[string]string),
+		folders: make(map[string]*folderState),
+	}
+	modified := prev == nil
+	for _, entry := range entries {
+		if !entry.IsDir {
+			state.files[entry.Path] = fingerprint(entry)
+			if prev != nil && prev.files[entry.Path] != state.files[entry.Path] {
+				modified = true
+			}
+			continue
+		}
+
+		var prevFolder *folderState
+		if prev != nil {
+			prevFolder = prev.folders[entry.Path]
+		}
+		if prevFolder == nil {
+			modified = true
+		} else if entry.ETag != "" && entry.ETag == prevFolder.tag {
+			state.folders[entry.Path] = prevFolder
+			continue
+		}
+		if state.folders[entry.Path], err = w.scanFolder(ctx, entry.Path, entry.ETag, prevFolder, report && prevFolder != nil, changed); err != nil {
+			return nil, err
+		}
+	}
+	if prev != nil && (len(prev.files) != len(state.files) || len(prev.folders) != len(state.folders)) {
+		// Entries missing from the listing were removed
+		modified = true
+	}
+
+	if modified && report {
+		*changed = append(*changed, folderPath)
+	}
+	return state, nil
+}
+
+// fingerprint identifies content of file, ETag or modification time and size if backend doesn't report it
+func fingerprint(file models.FileInfo) string {
+	if file.ETag != "" {
+		return file.ETag
+	}
+	return fmt.Sprintf("%d:%d", file.ModTime.UnixNano(), file.Size)
+}
@@ -0,0 +1,209 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/backend/memory"
+	"nextya-sync/clients"
+	"nextya-sync/internal/fakenextcloud"
+
+	"github.com/gorilla/websocket"
+)
+
+var modTime = time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+func TestScanFindsChangedFolders(t *testing.T) {
+	server := fakenextcloud.New("admin", "secret", t.TempDir())
+	defer server.Close()
+	for _, name := range []string{"/Docs/a/one.txt", "/Docs/b/two.txt", "/Docs/b/deep/three.txt", "/Photos/p.jpg"} {
+		if err := server.WriteFile(name, []byte("data"), modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := &Watcher{Source: clients.NewNextcloudClient(server.URL, "admin", "secret"), Paths: []string{"/Docs", "/Photos"}}
+	ctx := context.Background()
+	state, changed, err := w.scan(ctx, nil)
+	if err != nil || len(changed) != 0 {
+		t.Fatalf("initial scan = %v, %v, want no changes", changed, err)
+	}
+
+	// Unchanged snapshot reports nothing
+	if _, changed, err := w.scan(ctx, state); err != nil || len(changed) != 0 {
+		t.Fatalf("scan without changes = %v, %v", changed, err)
+	}
+
+	server.WriteFile("/Docs/b/deep/three.txt", []byte("changed"), modTime.Add(time.Hour))
+	server.WriteFile("/Docs/new/sub/four.txt", []byte("new"), modTime)
+	_, changed, err = w.scan(ctx, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// New folder is synchronized with its parent, its own content isn't reported
+	if want := []string{"/Docs", "/Docs/b/deep/"}; !slices.Equal(changed, want) {
+		t.Errorf("changed = %v, want %v", changed, want)
+	}
+}
+
+func TestScanWithoutETags(t *testing.T) {
+	source := memory.New()
+	source.WriteFile("/Docs/a/one.txt", []byte("one"), modTime)
+	source.WriteFile("/Docs/b/two.txt", []byte("two"), modTime)
+
+	w := &Watcher{Source: source, Paths: []string{"/Docs"}}
+	ctx := context.Background()
+	state, _, err := w.scan(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source.WriteFile("/Docs/a/one.txt", []byte("one!"), modTime.Add(time.Minute))
+	if err := source.Delete(ctx, "/Docs/b/two.txt", true); err != nil {
+		t.Fatal(err)
+	}
+	_, changed, err := w.scan(ctx, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/Docs/a", "/Docs/b"}; !slices.Equal(changed, want) {
+		t.Errorf("changed = %v, want %v", changed, want)
+	}
+}
+
+func TestCheckKeepsSnapshotUntilSyncSucceeds(t *testing.T) {
+	source := memory.New()
+	source.WriteFile("/Docs/one.txt", []byte("one"), modTime)
+
+	var synced [][]string
+	syncErr := errors.New("sync failed")
+	w := &Watcher{Source: source, Paths: []string{"/Docs"}, Sync: func(ctx context.Context, folders []string) error {
+		synced = append(synced, folders)
+		return syncErr
+	}}
+	ctx := context.Background()
+	w.state, _, _ = w.scan(ctx, nil)
+
+	source.WriteFile("/Docs/two.txt", []byte("two"), modTime)
+	if err := w.check(ctx); !errors.Is(err, syncErr) || !w.dirty {
+		t.Fatalf("check = %v, dirty %v, want sync error", err, w.dirty)
+	}
+	syncErr = nil
+	if err := w.check(ctx); err != nil || w.dirty {
+		t.Fatalf("retry = %v, dirty %v", err, w.dirty)
+	}
+	if err := w.check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(synced) != 2 || !slices.Equal(synced[1], []string{"/Docs"}) {
+		t.Errorf("synced = %v, want failed change retried once", synced)
+	}
+}
+
+// pushServer serves notify_push websocket accepting admin:secret and sends notify_file on every value of events
+func pushServer(t *testing.T, events <-chan struct{}) *httptest.Server {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, username, _ := conn.ReadMessage()
+		_, password, _ := conn.ReadMessage()
+		if string(username) != "admin" || string(password) != "secret" {
+			conn.WriteMessage(websocket.TextMessage, []byte("err: Invalid credentials"))
+			return
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte("authenticated"))
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-events:
+				if err := conn.WriteMessage(websocket.TextMessage, []byte("notify_file")); err != nil {
+					return
+				}
+			}
+		}
+	}))
+}
+
+func TestPushAuthentication(t *testing.T) {
+	server := pushServer(t, nil)
+	defer server.Close()
+	endpoint := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	push := &Push{Endpoint: endpoint, Username: "admin", Password: "wrong"}
+	err := push.listen(context.Background(), func() { t.Error("connected with wrong password") }, func() {})
+	if !errors.Is(err, backend.ErrUnauthorized) || !strings.Contains(err.Error(), "Invalid credentials") {
+		t.Errorf("listen with wrong password = %v, want unauthorized", err)
+	}
+}
+
+func TestRunWithPush(t *testing.T) {
+	cloud := fakenextcloud.New("admin", "secret", t.TempDir())
+	defer cloud.Close()
+	cloud.WriteFile("/Docs/a/one.txt", []byte("one"), modTime)
+	cloud.WriteFile("/Docs/b/two.txt", []byte("two"), modTime)
+
+	events := make(chan struct{})
+	push := pushServer(t, events)
+	defer push.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	synced := make(chan []string, 10)
+	initial := make(chan struct{})
+	w := &Watcher{
+		Source:       clients.NewNextcloudClient(cloud.URL, "admin", "secret"),
+		Paths:        []string{"/Docs"},
+		PollInterval: time.Hour,
+		Debounce:     20 * time.Millisecond,
+		Push:         &Push{Endpoint: "ws" + strings.TrimPrefix(push.URL, "http"), Username: "admin", Password: "secret"},
+		Initial: func(ctx context.Context) error {
+			close(initial)
+			return nil
+		},
+		Sync: func(ctx context.Context, folders []string) error {
+			synced <- folders
+			return nil
+		},
+	}
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+	<-initial
+
+	// A burst of notifications results in one synchronization of the changed folder
+	cloud.WriteFile("/Docs/b/three.txt", []byte("three"), modTime)
+	for range 3 {
+		events <- struct{}{}
+	}
+	select {
+	case folders := <-synced:
+		if !slices.Equal(folders, []string{"/Docs/b/"}) {
+			t.Errorf("synced %v, want /Docs/b/", folders)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("changes were not synchronized")
+	}
+	select {
+	case folders := <-synced:
+		t.Errorf("unexpected second synchronization of %v", folders)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run = %v", err)
+	}
+}
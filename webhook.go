@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"nextya-sync/backend"
+	"nextya-sync/clients"
+	"nextya-sync/processor"
+	"nextya-sync/webhook"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// webhookPath URL path webhook calls are accepted on
+const webhookPath = "/webhook"
+
+var webhookCmd = &cobra.Command{
+	Use:   "webhook",
+	Short: "Synchronize files on Nextcloud webhook events",
+	Long: `Webhook listens for file events of Nextcloud webhook_listeners app on
+` + webhookPath + ` and synchronizes created, changed and deleted files one by one.
+Calls must carry "Authorization: Bearer <secret>" header. Full synchronization
+runs at start and every --reconcile-interval to catch missed events, files gone
+from source are removed from destination then.`,
+	Args: cobra.NoArgs,
+	Run:  listenWebhooks,
+}
+
+func init() {
+	webhookCmd.Flags().String("listen", ":8089", "Address webhook calls are accepted on")
+	webhookCmd.Flags().String("secret", "", "Secret expected as bearer token of webhook calls")
+	webhookCmd.Flags().Duration("delay", 2*time.Second, "Delay collecting events into one synchronization")
+	webhookCmd.Flags().Duration("reconcile-interval", time.Hour, "Interval of full synchronization catching missed events")
+	viper.BindPFlag("webhook.listen", webhookCmd.Flags().Lookup("listen"))
+	viper.BindPFlag("webhook.secret", webhookCmd.Flags().Lookup("secret"))
+	viper.BindPFlag("webhook.delay", webhookCmd.Flags().Lookup("delay"))
+	viper.BindPFlag("webhook.reconcile_interval", webhookCmd.Flags().Lookup("reconcile-interval"))
+	viper.BindEnv("webhook.listen", "WEBHOOK_LISTEN")
+	viper.BindEnv("webhook.secret", "WEBHOOK_SECRET")
+	viper.BindEnv("webhook.delay", "WEBHOOK_DELAY")
+	viper.BindEnv("webhook.reconcile_interval", "WEBHOOK_RECONCILE_INTERVAL")
+
+	rootCmd.AddCommand(webhookCmd)
+}
+
+func listenWebhooks(cmd *cobra.Command, args []string) {
+	secret := viper.GetString("webhook.secret")
+	if secret == "" {
+		fatalConfig("Webhook requires --secret")
+	}
+	delay := viper.GetDuration("webhook.delay")
+	reconcileInterval := viper.GetDuration("webhook.reconcile_interval")
+	if delay < 0 || reconcileInterval <= 0 {
+		fatalConfig("Webhook delay must not be negative and reconcile interval must be positive",
+			"delay", delay, "reconcile_interval", reconcileInterval)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	startMetrics()
+	validation()
+	source := newSource(ctx)
+	proc := processor.NewProcessor(&processor.Dependencies{
+		Source:      source,
+		Destination: newDestination(ctx),
+	})
+
+	listener := &webhook.Listener{
+		Secret:            secret,
+		Paths:             syncPaths(),
+		Escaped:           backend.IsEscaped(source),
+		Delay:             delay,
+		ReconcileInterval: reconcileInterval,
+		Sync: func(ctx context.Context, files []string) error {
+			return runTargeted(ctx, files, proc.SyncFiles)
+		},
+		Delete: func(ctx context.Context, files []string) error {
+			return runTargeted(ctx, files, proc.DeleteFiles)
+		},
+		Reconcile: func(ctx context.Context) error {
+			return runSync(ctx, proc)
+		},
+		Missing: func(ctx context.Context) ([]string, error) {
+			return proc.MissingFiles(ctx, processorConfig())
+		},
+	}
+	if nc, ok := source.(*clients.NextcloudClient); ok {
+		listener.Username = nc.Username
+	}
+
+	addr := viper.GetString("webhook.listen")
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal("Failed to listen for webhooks", "address", addr, "error", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(webhookPath, listener)
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Webhook server stopped", "error", err)
+		}
+	}()
+	slog.Info("Listening for webhooks", "address", ln.Addr().String(), "path", webhookPath)
+
+	err = listener.Run(ctx)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+	if err != nil {
+		fatal("Webhook listener failed", "error", err)
+	}
+	slog.Info("Webhook listener stopped")
+}
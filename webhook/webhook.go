@@ -0,0 +1,274 @@
+// Package webhook receives file events of Nextcloud webhook_listeners app and synchronizes affected files
+package webhook
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nextya-sync/backend"
+)
+
+// queueSize number of events waiting for synchronization, further events trigger reconciliation instead
+const queueSize = 1024
+
+// maxBodySize limit of webhook request body
+const maxBodySize = 1 << 20
+
+// Classes of file events handled by listener
+const (
+	nodeCreated = `OCP\Files\Events\Node\NodeCreatedEvent`
+	nodeWritten = `OCP\Files\Events\Node\NodeWrittenEvent`
+	nodeDeleted = `OCP\Files\Events\Node\NodeDeletedEvent`
+)
+
+// payload body of webhook_listeners request
+type payload struct {
+	User struct {
+		UID string `json:"uid"`
+	} `json:"user"`
+	Event struct {
+		Class string `json:"class"`
+		Node  struct {
+			// Path of node in Nextcloud file system, e.g. /admin/files/Documents/a.txt
+			Path string `json:"path"`
+		} `json:"node"`
+	} `json:"event"`
+}
+
+// event change of file waiting for synchronization
+type event struct {
+	// path of file in source
+	path    string
+	deleted bool
+}
+
+// Listener receives webhook calls, queues file events and synchronizes them in batches
+type Listener struct {
+	// Secret expected as bearer token in Authorization header
+	Secret string
+	// Username Nextcloud user whose files are synchronized, events of other users are ignored
+	Username string
+	// Paths sync paths of source, events outside of them are ignored
+	Paths []string
+	// Escaped paths of source are URL-escaped like in WebDAV listings
+	Escaped bool
+	// Delay collects events for this time before synchronizing them
+	Delay time.Duration
+	// ReconcileInterval interval of full synchronization catching missed events
+	ReconcileInterval time.Duration
+	// Sync synchronizes created or changed files and folders
+	Sync func(ctx context.Context, files []string) error
+	// Delete removes deleted files and folders from destination
+	Delete func(ctx context.Context, files []string) error
+	// Reconcile runs full synchronization
+	Reconcile func(ctx context.Context) error
+	// Missing lists source paths of files still in destination but gone from source, they are passed
+	// to Delete after reconciliation so deletions of missed events are caught up too
+	Missing func(ctx context.Context) ([]string, error)
+
+	initQueue sync.Once
+	events    chan event
+	// missed is set when queue overflows
+	missed atomic.Bool
+}
+
+// queue returns channel of events waiting for synchronization
+func (l *Listener) queue() chan event {
+	l.initQueue.Do(func() {
+		l.events = make(chan event, queueSize)
+	})
+	return l.events
+}
+
+// ServeHTTP accepts file event of webhook_listeners
+func (l *Listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(l.Secret)) != 1 {
+		slog.Warn("Rejected webhook with wrong secret", "remote", r.RemoteAddr)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body payload
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&body); err != nil {
+		http.Error(w, "invalid payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	e, ok := l.event(body)
+	if !ok {
+		slog.Debug("Ignoring webhook event", "class", body.Event.Class, "user", body.User.UID, "path", body.Event.Node.Path)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	select {
+	case l.queue() <- e:
+		slog.Debug("Webhook event queued", "class", body.Event.Class, "path", e.path)
+	default:
+		slog.Warn("Webhook queue is full, changes will be synchronized by reconciliation", "path", e.path)
+		l.missed.Store(true)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// event converts webhook payload to event of source file, false if it's not handled
+func (l *Listener) event(body payload) (event, bool) {
+	var deleted bool
+	switch body.Event.Class {
+	case nodeCreated, nodeWritten:
+	case nodeDeleted:
+		deleted = true
+	default:
+		return event{}, false
+	}
+
+	// Node paths start with owner's files folder, /<uid>/files/...
+	uid, filePath, ok := strings.Cut(strings.TrimPrefix(body.Event.Node.Path, "/"), "/")
+	if !ok || (l.Username != "" && uid != l.Username) {
+		return event{}, false
+	}
+	filePath, ok = strings.CutPrefix(filePath, "files/")
+	if !ok || !l.watched(filePath) {
+		return event{}, false
+	}
+
+	segments := strings.Split(filePath, "/")
+	if l.Escaped {
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+	}
+	return event{path: "/" + strings.Join(segments, "/"), deleted: deleted}, true
+}
+
+// watched reports whether file path relative to user's files is inside of a sync path
+func (l *Listener) watched(filePath string) bool {
+	for _, syncPath := range l.Paths {
+		root := strings.Trim(syncPath, "/")
+		if root == "" || filePath == root || strings.HasPrefix(filePath, root+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Run reconciles once, then synchronizes queued events in batches and reconciles periodically
+// until context is cancelled. Fails only if source or destination rejects credentials.
+func (l *Listener) Run(ctx context.Context) error {
+	events := l.queue()
+	if err := l.reconcile(ctx); err != nil {
+		return err
+	}
+
+	reconcile := time.NewTicker(l.ReconcileInterval)
+	defer reconcile.Stop()
+	flush := time.NewTimer(l.Delay)
+	flush.Stop()
+	// pending paths waiting for synchronization, true for deleted ones, the last event wins
+	pending := make(map[string]bool)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e := <-events:
+			if len(pending) == 0 {
+				flush.Reset(l.Delay)
+			}
+			pending[e.path] = e.deleted
+			continue
+		case <-flush.C:
+			if err := l.process(ctx, pending); err != nil {
+				return err
+			}
+			pending = make(map[string]bool)
+		case <-reconcile.C:
+			if err := l.reconcile(ctx); err != nil {
+				return err
+			}
+		}
+
+		if l.missed.Swap(false) {
+			if err := l.reconcile(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// process synchronizes pending events, failed ones are left to reconciliation
+func (l *Listener) process(ctx context.Context, pending map[string]bool) error {
+	var changed, deleted []string
+	for filePath, isDeleted := range pending {
+		if isDeleted {
+			deleted = append(deleted, filePath)
+		} else {
+			changed = append(changed, filePath)
+		}
+	}
+	slices.Sort(deleted)
+	slices.Sort(changed)
+
+	if len(deleted) > 0 {
+		if err := l.Delete(ctx, deleted); err != nil && !l.recoverable(ctx, err) {
+			return err
+		}
+	}
+	if len(changed) > 0 {
+		if err := l.Sync(ctx, changed); err != nil && !l.recoverable(ctx, err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcile runs full synchronization and removes files deleted in source from destination
+func (l *Listener) reconcile(ctx context.Context) error {
+	slog.Info("Reconciling destination with source")
+	if err := l.Reconcile(ctx); err != nil && !l.recoverable(ctx, err) {
+		return err
+	}
+
+	missing, err := l.Missing(ctx)
+	if err != nil {
+		if l.recoverable(ctx, err) {
+			return nil
+		}
+		return err
+	}
+	if len(missing) > 0 {
+		slog.Info("Removing files deleted from source", "files", len(missing))
+		if err := l.Delete(ctx, missing); err != nil && !l.recoverable(ctx, err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverable logs error of synchronization, it's fatal if credentials are rejected
+func (l *Listener) recoverable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	if errors.Is(err, backend.ErrUnauthorized) {
+		return false
+	}
+	slog.Error("Synchronization failed, retrying on next reconciliation", "error", err)
+	return true
+}
@@ -0,0 +1,182 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"nextya-sync/backend"
+)
+
+// post sends webhook with body authenticated by secret
+func post(l *Listener, secret, body string) int {
+	r := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+secret)
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, r)
+	return w.Code
+}
+
+// fileEvent returns webhook body of event of node
+func fileEvent(class, uid, nodePath string) string {
+	return `{"user":{"uid":"` + uid + `","displayName":"Admin"},"time":1726000000,` +
+		`"event":{"class":"OCP\\Files\\Events\\Node\\` + class + `","node":{"id":42,"path":"` + nodePath + `"}}}`
+}
+
+func TestServeHTTP(t *testing.T) {
+	l := &Listener{Secret: "secret", Username: "admin", Paths: []string{"/Documents"}, Escaped: true}
+
+	if code := post(l, "wrong", fileEvent("NodeWrittenEvent", "admin", "/admin/files/Documents/a.txt")); code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: status %d, want 401", code)
+	}
+	if code := post(l, "secret", "{"); code != http.StatusBadRequest {
+		t.Errorf("invalid body: status %d, want 400", code)
+	}
+
+	for _, body := range []string{
+		fileEvent("NodeWrittenEvent", "bob", "/bob/files/Documents/a.txt"),
+		fileEvent("NodeWrittenEvent", "admin", "/admin/files/Photos/a.jpg"),
+		fileEvent("NodeWrittenEvent", "admin", "/admin/files_versions/Documents/a.txt.v1"),
+		fileEvent("NodeTouchedEvent", "admin", "/admin/files/Documents/a.txt"),
+	} {
+		if code := post(l, "secret", body); code != http.StatusOK {
+			t.Errorf("ignored event %s: status %d, want 200", body, code)
+		}
+	}
+	if len(l.queue()) != 0 {
+		t.Fatalf("%d ignored events were queued", len(l.queue()))
+	}
+
+	if code := post(l, "secret", fileEvent("NodeCreatedEvent", "admin", "/admin/files/Documents/Q1 report.txt")); code != http.StatusAccepted {
+		t.Errorf("created event: status %d, want 202", code)
+	}
+	if code := post(l, "secret", fileEvent("NodeDeletedEvent", "admin", "/admin/files/Documents/old")); code != http.StatusAccepted {
+		t.Errorf("deleted event: status %d, want 202", code)
+	}
+	for _, want := range []event{{path: "/Documents/Q1%20report.txt"}, {path: "/Documents/old", deleted: true}} {
+		if got := <-l.queue(); got != want {
+			t.Errorf("queued %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	type call struct {
+		op    string
+		files []string
+	}
+	calls := make(chan call, 10)
+	l := &Listener{
+		Secret:            "secret",
+		Paths:             []string{"/"},
+		Delay:             20 * time.Millisecond,
+		ReconcileInterval: time.Hour,
+		Sync: func(ctx context.Context, files []string) error {
+			calls <- call{"sync", files}
+			return errors.New("upload failed")
+		},
+		Delete: func(ctx context.Context, files []string) error {
+			calls <- call{"delete", files}
+			return nil
+		},
+		Reconcile: func(ctx context.Context) error {
+			calls <- call{op: "reconcile"}
+			return nil
+		},
+		Missing: func(ctx context.Context) ([]string, error) {
+			return nil, nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- l.Run(ctx) }()
+
+	for _, body := range []string{
+		fileEvent("NodeWrittenEvent", "admin", "/admin/files/b.txt"),
+		fileEvent("NodeCreatedEvent", "admin", "/admin/files/a.txt"),
+		fileEvent("NodeWrittenEvent", "admin", "/admin/files/a.txt"),
+		fileEvent("NodeCreatedEvent", "admin", "/admin/files/c.txt"),
+		fileEvent("NodeDeletedEvent", "admin", "/admin/files/c.txt"),
+	} {
+		post(l, "secret", body)
+	}
+
+	// Startup reconciliation, then one batch with the last event of every file
+	var got []call
+	for range 3 {
+		select {
+		case c := <-calls:
+			got = append(got, c)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("calls = %v, want 3", got)
+		}
+	}
+	want := []call{{op: "reconcile"}, {"delete", []string{"/c.txt"}}, {"sync", []string{"/a.txt", "/b.txt"}}}
+	for i := range want {
+		if got[i].op != want[i].op || !slices.Equal(got[i].files, want[i].files) {
+			t.Errorf("call %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// Failed synchronization is left to reconciliation, rejected credentials stop listener
+	l.Sync = func(ctx context.Context, files []string) error {
+		return backend.ErrUnauthorized
+	}
+	post(l, "secret", fileEvent("NodeWrittenEvent", "admin", "/admin/files/d.txt"))
+	select {
+	case err := <-done:
+		if !errors.Is(err, backend.ErrUnauthorized) {
+			t.Errorf("Run = %v, want unauthorized", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't stop on rejected credentials")
+	}
+}
+
+func TestReconcileRemovesMissing(t *testing.T) {
+	var deleted []string
+	missingErr := error(nil)
+	l := &Listener{
+		Reconcile: func(ctx context.Context) error {
+			return errors.New("upload failed")
+		},
+		Missing: func(ctx context.Context) ([]string, error) {
+			return []string{"/gone.txt", "/old"}, missingErr
+		},
+		Delete: func(ctx context.Context, files []string) error {
+			deleted = append(deleted, files...)
+			return nil
+		},
+	}
+	ctx := context.Background()
+
+	// Deletions are caught up even if synchronization partially failed
+	if err := l.reconcile(ctx); err != nil {
+		t.Fatalf("reconcile = %v", err)
+	}
+	if !slices.Equal(deleted, []string{"/gone.txt", "/old"}) {
+		t.Errorf("deleted %v, want /gone.txt and /old", deleted)
+	}
+
+	// Nothing is removed when source couldn't be compared
+	deleted = nil
+	missingErr = errors.New("listing failed")
+	if err := l.reconcile(ctx); err != nil {
+		t.Errorf("reconcile = %v, failed listing must be retried on next reconciliation", err)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("deleted %v after failed listing", deleted)
+	}
+
+	missingErr = backend.ErrUnauthorized
+	if err := l.reconcile(ctx); !errors.Is(err, backend.ErrUnauthorized) {
+		t.Errorf("reconcile = %v, want unauthorized", err)
+	}
+}